@@ -0,0 +1,187 @@
+// Command loadtest replays synthetic FloatingIP AdmissionReviews against a
+// running webhook and reports the latency distribution, for example:
+//
+//	go run ./cmd/loadtest -url https://localhost:8443/validate -concurrency 20 -requests 2000
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type result struct {
+	latency time.Duration
+	allowed bool
+	err     error
+}
+
+func main() {
+	url := flag.String("url", "https://localhost:8443/validate", "URL of the webhook validate endpoint")
+	concurrency := flag.Int("concurrency", 10, "number of concurrent clients")
+	requests := flag.Int("requests", 1000, "total number of requests")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of a single request")
+	insecure := flag.Bool("insecure", true, "skip the verification of the webhook certificate")
+	pool := flag.String("pool", "default", "FloatingIPPool of the generated FloatingIPs")
+	project := flag.String("project", "default", "project-name label of the generated FloatingIPs")
+	namespace := flag.String("namespace", "default", "namespace of the generated FloatingIPs")
+	ipAddr := flag.String("ip", "", "requested IP address, the IP is assigned automatically when empty")
+	flag.Parse()
+
+	if *concurrency <= 0 || *requests <= 0 {
+		fmt.Fprintln(os.Stderr, "concurrency and requests must be greater than 0")
+		os.Exit(2)
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		Transport: &http.Transport{
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: *insecure},
+			MaxIdleConnsPerHost: *concurrency,
+		},
+	}
+
+	jobs := make(chan int)
+	results := make(chan result, *requests)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				body, err := admissionReview(n, *pool, *project, *namespace, *ipAddr)
+				if err != nil {
+					results <- result{err: err}
+					continue
+				}
+				results <- send(client, *url, body)
+			}
+		}()
+	}
+
+	start := time.Now()
+	for n := 0; n < *requests; n++ {
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+	elapsed := time.Since(start)
+
+	report(results, elapsed)
+}
+
+// admissionReview returns a CREATE AdmissionReview of a FloatingIP with a unique name.
+func admissionReview(n int, pool string, project string, namespace string, ipAddr string) ([]byte, error) {
+	fip := &rfmv2.FloatingIP{
+		TypeMeta: metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIP"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("loadtest-%d", n),
+			Namespace: namespace,
+			Labels:    map[string]string{"rancher.k8s.binbash.org/project-name": project},
+		},
+		Spec: rfmv2.FloatingIPSpec{FloatingIPPool: pool},
+	}
+	if ipAddr != "" {
+		fip.Spec.IPAddr = &ipAddr
+	}
+
+	raw, err := json.Marshal(fip)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID(fmt.Sprintf("loadtest-%d", n)),
+			Kind:      metav1.GroupVersionKind{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Kind: "FloatingIP"},
+			Resource:  metav1.GroupVersionResource{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingips"},
+			Name:      fip.Name,
+			Namespace: namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+}
+
+func send(client *http.Client, url string, body []byte) result {
+	start := time.Now()
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return result{latency: time.Since(start), err: err}
+	}
+	defer resp.Body.Close()
+
+	review := &admissionv1.AdmissionReview{}
+	err = json.NewDecoder(resp.Body).Decode(review)
+	latency := time.Since(start)
+	if err != nil {
+		return result{latency: latency, err: fmt.Errorf("cannot decode response: %s", err)}
+	}
+	if resp.StatusCode != http.StatusOK || review.Response == nil {
+		return result{latency: latency, err: fmt.Errorf("unexpected response with status %d", resp.StatusCode)}
+	}
+
+	return result{latency: latency, allowed: review.Response.Allowed}
+}
+
+func report(results chan result, elapsed time.Duration) {
+	var latencies []time.Duration
+	var allowed, denied, failed int
+	errors := make(map[string]int)
+	for r := range results {
+		if r.err != nil {
+			failed++
+			errors[r.err.Error()]++
+			continue
+		}
+		latencies = append(latencies, r.latency)
+		if r.allowed {
+			allowed++
+		} else {
+			denied++
+		}
+	}
+
+	total := allowed + denied + failed
+	fmt.Printf("requests:   %d in %s (%.1f req/s)\n", total, elapsed.Round(time.Millisecond), float64(total)/elapsed.Seconds())
+	fmt.Printf("allowed:    %d\n", allowed)
+	fmt.Printf("denied:     %d\n", denied)
+	fmt.Printf("failed:     %d\n", failed)
+	for msg, count := range errors {
+		fmt.Printf("  %d x %s\n", count, msg)
+	}
+	if len(latencies) == 0 {
+		return
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Printf("latency p50: %s\n", percentile(latencies, 50))
+	fmt.Printf("latency p90: %s\n", percentile(latencies, 90))
+	fmt.Printf("latency p99: %s\n", percentile(latencies, 99))
+	fmt.Printf("latency max: %s\n", latencies[len(latencies)-1])
+}
+
+// percentile returns the p-th percentile of the sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+
+	return sorted[i]
+}
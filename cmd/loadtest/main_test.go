@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	assert.Equal(t, 50*time.Millisecond, percentile(latencies, 50))
+	assert.Equal(t, 99*time.Millisecond, percentile(latencies, 99))
+	assert.Equal(t, 100*time.Millisecond, percentile(latencies, 100))
+	assert.Equal(t, time.Millisecond, percentile(latencies[:1], 99))
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cleanup removes all resources which the webhook created in the cluster. The
+// webhook configuration is removed first, so FloatingIP operations are no
+// longer blocked by a webhook which is being uninstalled.
+func cleanup(cfg *appConfig) {
+	ctx := context.Background()
+
+	clients := newClients(cfg)
+
+	admissionHandler := newAdmissionHandler(ctx, clients, cfg)
+	if err := admissionHandler.DeleteValidatingWebhookConfiguration(); err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+
+	configHandler := newConfigHandler(ctx, clients, cfg)
+	configHandler.Init()
+	if err := configHandler.Cleanup(); err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+
+	log.Infof("%s cleanup finished", progname)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// genCerts makes sure the TLS secret contains a valid serving certificate, so
+// the certificate can be generated out-of-band before the webhook is started.
+func genCerts(cfg *appConfig) {
+	configHandler := newConfigHandler(context.Background(), newClients(cfg), cfg)
+	configHandler.Init()
+	if err := configHandler.Run(renewalPolicy(cfg)); err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+
+	expireDate, err := configHandler.GetCertExpireDate()
+	if err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+	log.Infof("%s serving certificate is valid until %s", progname, expireDate.UTC())
+}
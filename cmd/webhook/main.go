@@ -2,29 +2,135 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net"
 	"os"
-	"os/signal"
 	"path/filepath"
 	"strconv"
-	"syscall"
+	"strings"
 	"time"
+	// the image has no time zone database for MAINTENANCETIMEZONE
+	_ "time/tzdata"
 
 	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/admission"
 	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/config"
-	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/scheduler"
 	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/service"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/version"
 	log "github.com/sirupsen/logrus"
+	admregv1 "k8s.io/api/admissionregistration/v1"
 )
 
 var progname string = "rancher-fip-manager-webhook"
 
-var certRenewalPeriod int64
+// The names of the webhook service, its namespace and the webhook
+// configuration when they are not configured. The TLS secret and the CSRs
+// are named after the webhook service.
+const (
+	defaultWebhookName       = "rancher-fip-manager-webhook"
+	defaultWebhookNamespace  = "rancher-fip-manager"
+	defaultWebhookConfigName = "rancher-fip-manager-validator"
+)
+
+// serviceAccountNamespaceFile contains the namespace of the pod when the
+// serviceaccount token is mounted.
+var serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
 
 type appConfig struct {
 	logLevel          string
+	logFormat         string
+	logCaller         bool
 	certRenewalPeriod int64
+	certRenewalPct    int64
+	certDuration      int64
+	certCheckInterval int64
+	certCheckJitter   int64
 	kubeConfigFile    string
 	kubeConfigContext string
+	auditMode         map[string]bool
+	caBundle          admission.CABundleSource
+	projectFromNs     bool
+	reservations      bool
+	reservationTTL    int64
+	quotaPoolsWarn    bool
+	maxPoolSize       int64
+	approvalPoolSize  int64
+	approvalGroups    []string
+	maxFloatingIPs    int64
+	hierarchicalQuota bool
+	validateCluster   bool
+	validateProject   bool
+	maxRequestBytes   int64
+	readTimeout       int64
+	writeTimeout      int64
+	idleTimeout       int64
+	readHeaderTimeout int64
+	disableHTTP2      bool
+	authenticate      bool
+	previewAPI        bool
+	selfTest          bool
+	conversion        bool
+	apiVersions       []string
+	apiResources      util.APIResources
+	matchConditions   []admregv1.MatchCondition
+	matchPolicy       admregv1.MatchPolicyType
+	ruleScope         admregv1.ScopeType
+	extraMetadata     util.ExtraMetadata
+	externalVWC       bool
+	createService     bool
+	webhookServer     string
+	certDir           string
+	authUsers         []string
+	failurePolicy     admregv1.FailurePolicyType
+	breakerFailures   int64
+	breakerTimeout    int64
+	degradedPolicy    admregv1.FailurePolicyType
+	decisionCacheTTL  int64
+	poolIndex         bool
+	validateServices  bool
+	targetWarnOnly    bool
+	accessLog         bool
+	maxInFlight       int64
+	maxQueued         int64
+	rateLimit         int64
+	rateLimitBurst    int64
+	requestBudget     int64
+	clientQPS         float64
+	clientBurst       int64
+	clientTimeout     int64
+	webhookName       string
+	webhookNamespace  string
+	webhookConfigName string
+	serviceName       string
+	secretName        string
+	forbiddenRanges   []*net.IPNet
+	addressSpace      string
+	projectSpaces     map[string]string
+	clusterCIDRs      []*net.IPNet
+	nodeConflicts     bool
+	lbConflicts       string
+	prober            string
+	probeMode         string
+	probeTimeout      int64
+	utilThresholds    []int
+	missingQuota      string
+	missingQuotaLimit int64
+	overrideGroups    []string
+	nsOverrides       bool
+	requiredLabels    []validator.MetadataRule
+	requiredAnnots    []validator.MetadataRule
+	maxLease          time.Duration
+	projectLeases     map[string]time.Duration
+	blocklist         string
+	breakGlass        string
+	controllerUsers   []string
+	maintWindows      []*validator.MaintenanceWindow
+	maintLocation     *time.Location
+	maintGroups       []string
+	deleteConfirm     bool
+	deleteGroups      []string
 }
 
 func parseAppEnv() *appConfig {
@@ -36,6 +142,17 @@ func parseAppEnv() *appConfig {
 	}
 	cfg.logLevel = logLevel
 
+	logFormat := strings.ToLower(os.Getenv("LOGFORMAT"))
+	if logFormat != "json" {
+		logFormat = "text"
+	}
+	cfg.logFormat = logFormat
+
+	logCaller, err := strconv.ParseBool(os.Getenv("LOGCALLER"))
+	if err == nil {
+		cfg.logCaller = logCaller
+	}
+
 	certRenewal, err := strconv.ParseInt(os.Getenv("CERTRENEWALPERIOD"), 10, 64)
 	if err != nil || certRenewal == 0 {
 		// default the cert renewal expire interval to 30 days
@@ -43,17 +160,838 @@ func parseAppEnv() *appConfig {
 	}
 	cfg.certRenewalPeriod = certRenewal
 
+	certRenewalPct, err := strconv.ParseInt(os.Getenv("CERTRENEWALPERCENTAGE"), 10, 64)
+	if err != nil || certRenewalPct < 0 || certRenewalPct >= 100 {
+		// disabled by default, the certRenewalPeriod is used instead
+		certRenewalPct = 0
+	}
+	cfg.certRenewalPct = certRenewalPct
+
+	certDuration, err := strconv.ParseInt(os.Getenv("CERTDURATION"), 10, 64)
+	if err != nil || certDuration < 0 {
+		// let the signer decide the certificate duration
+		certDuration = 0
+	}
+	if maxCertDuration := int64(config.MaxCertDuration / time.Minute); certDuration > maxCertDuration {
+		log.Warnf("ignoring CERTDURATION %d, the maximum is %d minutes", certDuration, maxCertDuration)
+		certDuration = 0
+	}
+	cfg.certDuration = certDuration
+
+	certCheckInterval, err := strconv.ParseInt(os.Getenv("CERTCHECKINTERVAL"), 10, 64)
+	if err != nil || certCheckInterval <= 0 {
+		// default the cert expiry check interval to 1 hour
+		certCheckInterval = 60
+	}
+	cfg.certCheckInterval = certCheckInterval
+
+	certCheckJitter, err := strconv.ParseInt(os.Getenv("CERTCHECKJITTER"), 10, 64)
+	if err != nil || certCheckJitter < 0 {
+		// default the cert expiry check jitter to 5 minutes
+		certCheckJitter = 5
+	}
+	cfg.certCheckJitter = certCheckJitter
+
 	kubeConfigFile := os.Getenv("KUBECONFIG")
 	cfg.kubeConfigFile = kubeConfigFile
 
 	kubeConfigContext := os.Getenv("KUBECONTEXT")
 	cfg.kubeConfigContext = kubeConfigContext
 
+	clientQPS, err := strconv.ParseFloat(os.Getenv("CLIENTQPS"), 32)
+	if err != nil || clientQPS <= 0 {
+		// default the rate limit of the Kubernetes clients to 20 requests per second
+		clientQPS = util.DefaultClientQPS
+	}
+	cfg.clientQPS = clientQPS
+
+	clientBurst, err := strconv.ParseInt(os.Getenv("CLIENTBURST"), 10, 64)
+	if err != nil || clientBurst <= 0 {
+		// default the burst of the Kubernetes clients to 40 requests
+		clientBurst = util.DefaultClientBurst
+	}
+	cfg.clientBurst = clientBurst
+
+	clientTimeout, err := strconv.ParseInt(os.Getenv("CLIENTTIMEOUT"), 10, 64)
+	if err != nil || clientTimeout <= 0 {
+		// default the timeout of the Kubernetes clients to 30 seconds
+		clientTimeout = 30
+	}
+	cfg.clientTimeout = clientTimeout
+
+	webhookName := os.Getenv("WEBHOOKNAME")
+	if webhookName == "" {
+		webhookName = defaultWebhookName
+	}
+	cfg.webhookName = webhookName
+
+	cfg.webhookNamespace = parseWebhookNamespace()
+
+	serviceName := os.Getenv("SERVICENAME")
+	if serviceName == "" {
+		// the service is named after the webhook by default
+		serviceName = webhookName
+	}
+	cfg.serviceName = serviceName
+
+	secretName := os.Getenv("SECRETNAME")
+	if secretName == "" {
+		// the secret is named after the webhook by default
+		secretName = fmt.Sprintf("%s-tls", webhookName)
+	}
+	cfg.secretName = secretName
+
+	webhookConfigName := os.Getenv("WEBHOOKCONFIGNAME")
+	if webhookConfigName == "" {
+		webhookConfigName = defaultWebhookConfigName
+	}
+	cfg.webhookConfigName = webhookConfigName
+
+	cfg.auditMode = parseAuditMode(os.Getenv("AUDITMODE"))
+
+	cfg.caBundle = parseCABundleSource()
+
+	projectFromNs, err := strconv.ParseBool(os.Getenv("PROJECTFROMNAMESPACE"))
+	if err == nil {
+		cfg.projectFromNs = projectFromNs
+	}
+
+	reservations, err := strconv.ParseBool(os.Getenv("RESERVATIONS"))
+	if err == nil {
+		cfg.reservations = reservations
+	}
+
+	reservationTTL, err := strconv.ParseInt(os.Getenv("RESERVATIONTTL"), 10, 64)
+	if err != nil || reservationTTL <= 0 {
+		// default the reservation ttl to 5 minutes
+		reservationTTL = 5
+	}
+	cfg.reservationTTL = reservationTTL
+
+	quotaPoolsWarn, err := strconv.ParseBool(os.Getenv("QUOTAUNKNOWNPOOLSWARN"))
+	if err == nil {
+		cfg.quotaPoolsWarn = quotaPoolsWarn
+	}
+
+	maxPoolSize, err := strconv.ParseInt(os.Getenv("MAXPOOLSIZE"), 10, 64)
+	if err != nil || maxPoolSize < 0 {
+		// the pool size is not limited by default
+		maxPoolSize = 0
+	}
+	cfg.maxPoolSize = maxPoolSize
+
+	approvalPoolSize, err := strconv.ParseInt(os.Getenv("APPROVALPOOLSIZE"), 10, 64)
+	if err != nil || approvalPoolSize < 0 {
+		// pools don't need approval by default
+		approvalPoolSize = 0
+	}
+	cfg.approvalPoolSize = approvalPoolSize
+
+	for _, group := range strings.Split(os.Getenv("APPROVALGROUPS"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			cfg.approvalGroups = append(cfg.approvalGroups, group)
+		}
+	}
+
+	maxFloatingIPs, err := strconv.ParseInt(os.Getenv("MAXFLOATINGIPS"), 10, 64)
+	if err != nil || maxFloatingIPs < 0 {
+		// the number of FloatingIPs is not limited by default
+		maxFloatingIPs = 0
+	}
+	cfg.maxFloatingIPs = maxFloatingIPs
+
+	hierarchicalQuota, err := strconv.ParseBool(os.Getenv("HIERARCHICALQUOTAS"))
+	if err == nil {
+		cfg.hierarchicalQuota = hierarchicalQuota
+	}
+
+	validateCluster, err := strconv.ParseBool(os.Getenv("VALIDATETARGETCLUSTER"))
+	if err == nil {
+		cfg.validateCluster = validateCluster
+	}
+
+	validateProject, err := strconv.ParseBool(os.Getenv("VALIDATEPROJECT"))
+	if err == nil {
+		cfg.validateProject = validateProject
+	}
+
+	maxRequestBytes, err := strconv.ParseInt(os.Getenv("MAXREQUESTBYTES"), 10, 64)
+	if err != nil || maxRequestBytes <= 0 {
+		// default is 8 MiB
+		maxRequestBytes = service.DefaultMaxRequestBytes
+	}
+	cfg.maxRequestBytes = maxRequestBytes
+
+	readTimeout, err := strconv.ParseInt(os.Getenv("READTIMEOUT"), 10, 64)
+	if err != nil || readTimeout <= 0 {
+		// default the read timeout to 10 seconds
+		readTimeout = 10
+	}
+	cfg.readTimeout = readTimeout
+
+	writeTimeout, err := strconv.ParseInt(os.Getenv("WRITETIMEOUT"), 10, 64)
+	if err != nil || writeTimeout <= 0 {
+		// default the write timeout to 10 seconds
+		writeTimeout = 10
+	}
+	cfg.writeTimeout = writeTimeout
+
+	idleTimeout, err := strconv.ParseInt(os.Getenv("IDLETIMEOUT"), 10, 64)
+	if err != nil || idleTimeout < 0 {
+		// the read timeout is used by default
+		idleTimeout = 0
+	}
+	cfg.idleTimeout = idleTimeout
+
+	readHeaderTimeout, err := strconv.ParseInt(os.Getenv("READHEADERTIMEOUT"), 10, 64)
+	if err != nil || readHeaderTimeout < 0 {
+		// the read timeout is used by default
+		readHeaderTimeout = 0
+	}
+	cfg.readHeaderTimeout = readHeaderTimeout
+
+	disableHTTP2, err := strconv.ParseBool(os.Getenv("DISABLEHTTP2"))
+	if err == nil {
+		cfg.disableHTTP2 = disableHTTP2
+	}
+
+	authenticate, err := strconv.ParseBool(os.Getenv("AUTHENTICATEREQUESTS"))
+	if err == nil {
+		cfg.authenticate = authenticate
+	}
+
+	previewAPI, err := strconv.ParseBool(os.Getenv("PREVIEWAPI"))
+	if err == nil {
+		cfg.previewAPI = previewAPI
+	}
+
+	selfTest, err := strconv.ParseBool(os.Getenv("SELFTEST"))
+	if err == nil {
+		cfg.selfTest = selfTest
+	}
+
+	poolIndex, err := strconv.ParseBool(os.Getenv("POOLINDEX"))
+	if err == nil {
+		cfg.poolIndex = poolIndex
+	}
+
+	validateServices, err := strconv.ParseBool(os.Getenv("VALIDATESERVICES"))
+	if err == nil {
+		cfg.validateServices = validateServices
+	}
+
+	targetWarnOnly, err := strconv.ParseBool(os.Getenv("TARGETWARNONLY"))
+	if err == nil {
+		cfg.targetWarnOnly = targetWarnOnly
+	}
+
+	externalVWC, err := strconv.ParseBool(os.Getenv("EXTERNALWEBHOOKCONFIG"))
+	if err == nil {
+		cfg.externalVWC = externalVWC
+	}
+
+	createService, err := strconv.ParseBool(os.Getenv("CREATESERVICE"))
+	if err == nil {
+		cfg.createService = createService
+	}
+
+	cfg.webhookServer = service.ServerDefault
+	switch webhookServer := strings.ToLower(strings.TrimSpace(os.Getenv("WEBHOOKSERVER"))); webhookServer {
+	case "", service.ServerDefault:
+	case service.ServerControllerRuntime:
+		cfg.webhookServer = webhookServer
+	default:
+		log.Warnf("ignoring unknown WEBHOOKSERVER %q, expected %s or %s", webhookServer, service.ServerDefault, service.ServerControllerRuntime)
+	}
+	cfg.certDir = strings.TrimSpace(os.Getenv("CERTDIR"))
+	// the certificate is no longer written to TLS_DIR, it is served from memory
+	if os.Getenv("TLS_DIR") != "" {
+		log.Warnf("ignoring TLS_DIR, the certificate is served from memory and not written to disk anymore, use CERTDIR with WEBHOOKSERVER=%s to serve a certificate from disk", service.ServerControllerRuntime)
+	}
+
+	conversion, err := strconv.ParseBool(os.Getenv("CONVERSIONWEBHOOK"))
+	if err == nil {
+		cfg.conversion = conversion
+	}
+
+	cfg.apiResources = util.DefaultAPIResources()
+	for env, value := range map[string]*string{
+		"APIGROUP":                       &cfg.apiResources.Group,
+		"APIVERSION":                     &cfg.apiResources.Version,
+		"FLOATINGIPRESOURCE":             &cfg.apiResources.FloatingIPs,
+		"FLOATINGIPPOOLRESOURCE":         &cfg.apiResources.FloatingIPPools,
+		"FLOATINGIPPROJECTQUOTARESOURCE": &cfg.apiResources.FloatingIPProjectQuotas,
+	} {
+		if v := strings.TrimSpace(os.Getenv(env)); v != "" {
+			*value = v
+		}
+	}
+
+	for _, version := range strings.Split(os.Getenv("APIVERSIONS"), ",") {
+		if version = strings.TrimSpace(version); version != "" {
+			cfg.apiVersions = append(cfg.apiVersions, version)
+		}
+	}
+
+	cfg.extraMetadata = util.ExtraMetadata{
+		Labels:      parseKeyValues("MANAGEDLABELS", os.Getenv("MANAGEDLABELS")),
+		Annotations: parseKeyValues("MANAGEDANNOTATIONS", os.Getenv("MANAGEDANNOTATIONS")),
+	}
+
+	cfg.matchConditions = parseMatchConditions(os.Getenv("MATCHCONDITIONS"), os.Getenv("MATCHSKIPUSERS"), os.Getenv("MATCHSKIPLABEL"))
+
+	for _, user := range strings.Split(os.Getenv("AUTHENTICATEDUSERS"), ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			cfg.authUsers = append(cfg.authUsers, user)
+		}
+	}
+
+	maxInFlight, err := strconv.ParseInt(os.Getenv("MAXINFLIGHT"), 10, 64)
+	if err != nil || maxInFlight < 0 {
+		// the number of requests in flight is not limited by default
+		maxInFlight = 0
+	}
+	cfg.maxInFlight = maxInFlight
+
+	maxQueued, err := strconv.ParseInt(os.Getenv("MAXQUEUED"), 10, 64)
+	if err != nil || maxQueued < 0 {
+		// default the queue to 10 requests
+		maxQueued = 10
+	}
+	cfg.maxQueued = maxQueued
+
+	rateLimit, err := strconv.ParseInt(os.Getenv("RATELIMIT"), 10, 64)
+	if err != nil || rateLimit < 0 {
+		// the rate of new FloatingIPs is not limited by default
+		rateLimit = 0
+	}
+	cfg.rateLimit = rateLimit
+
+	rateLimitBurst, err := strconv.ParseInt(os.Getenv("RATELIMITBURST"), 10, 64)
+	if err != nil || rateLimitBurst < 0 {
+		// default the burst to the rate limit
+		rateLimitBurst = 0
+	}
+	cfg.rateLimitBurst = rateLimitBurst
+
+	requestBudget, err := strconv.ParseInt(os.Getenv("REQUESTBUDGET"), 10, 64)
+	if err != nil || requestBudget <= 0 || requestBudget > 100 {
+		// default the budget to 80 percent of the webhook timeout
+		requestBudget = 80
+	}
+	cfg.requestBudget = requestBudget
+
+	cfg.forbiddenRanges = parseForbiddenRanges(os.Getenv("FORBIDDENRANGES"))
+
+	cfg.addressSpace = parseAddressSpace("ADDRESSSPACE", os.Getenv("ADDRESSSPACE"))
+	cfg.projectSpaces = parseProjectAddressSpaces(os.Getenv("PROJECTADDRESSSPACES"))
+
+	cfg.clusterCIDRs = parseCIDRs("CLUSTERCIDRS", os.Getenv("CLUSTERCIDRS"))
+
+	nodeConflicts, err := strconv.ParseBool(os.Getenv("VALIDATENODECONFLICTS"))
+	if err == nil {
+		cfg.nodeConflicts = nodeConflicts
+	}
+
+	switch lbConflicts := strings.ToLower(strings.TrimSpace(os.Getenv("LOADBALANCERCONFLICTS"))); lbConflicts {
+	case service.LoadBalancerConflictsWarn, service.LoadBalancerConflictsDeny:
+		cfg.lbConflicts = lbConflicts
+	case "", "false":
+		// the load balancer address pools are not checked by default
+	default:
+		log.Warnf("ignoring unknown LOADBALANCERCONFLICTS %s, not checking the load balancer address pools", lbConflicts)
+	}
+
+	cfg.prober = strings.TrimSpace(os.Getenv("PROBER"))
+
+	switch probeMode := strings.ToLower(strings.TrimSpace(os.Getenv("PROBEMODE"))); probeMode {
+	case service.ProbeModeWarn, service.ProbeModeDeny:
+		cfg.probeMode = probeMode
+	case "":
+		// live IPs are denied by default
+		cfg.probeMode = service.ProbeModeDeny
+	default:
+		log.Warnf("ignoring unknown PROBEMODE %s, using %s", probeMode, service.ProbeModeDeny)
+		cfg.probeMode = service.ProbeModeDeny
+	}
+
+	probeTimeout, err := strconv.ParseInt(os.Getenv("PROBETIMEOUT"), 10, 64)
+	if err != nil || probeTimeout <= 0 {
+		// default the probe timeout to 1000 milliseconds
+		probeTimeout = 1000
+	}
+	cfg.probeTimeout = probeTimeout
+
+	cfg.utilThresholds = parseUtilizationThresholds(os.Getenv("UTILIZATIONTHRESHOLDS"))
+
+	switch missingQuota := strings.ToLower(strings.TrimSpace(os.Getenv("MISSINGQUOTAPOLICY"))); missingQuota {
+	case service.MissingQuotaDeny, service.MissingQuotaAllow, service.MissingQuotaLimit:
+		cfg.missingQuota = missingQuota
+	case "":
+		// FloatingIPs of projects without a quota are denied by default
+		cfg.missingQuota = service.MissingQuotaDeny
+	default:
+		log.Warnf("ignoring unknown MISSINGQUOTAPOLICY %s, using %s", missingQuota, service.MissingQuotaDeny)
+		cfg.missingQuota = service.MissingQuotaDeny
+	}
+
+	missingQuotaLimit, err := strconv.ParseInt(os.Getenv("MISSINGQUOTALIMIT"), 10, 64)
+	if err != nil || missingQuotaLimit < 0 {
+		// no FloatingIPs are allowed by default
+		missingQuotaLimit = 0
+	}
+	cfg.missingQuotaLimit = missingQuotaLimit
+
+	for _, group := range strings.Split(os.Getenv("QUOTAOVERRIDEGROUPS"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			cfg.overrideGroups = append(cfg.overrideGroups, group)
+		}
+	}
+
+	nsOverrides, err := strconv.ParseBool(os.Getenv("NAMESPACEOVERRIDES"))
+	if err == nil {
+		cfg.nsOverrides = nsOverrides
+	}
+
+	cfg.requiredLabels = parseMetadataRules("REQUIREDLABELS", os.Getenv("REQUIREDLABELS"))
+	cfg.requiredAnnots = parseMetadataRules("REQUIREDANNOTATIONS", os.Getenv("REQUIREDANNOTATIONS"))
+
+	if maxLease := strings.TrimSpace(os.Getenv("MAXLEASE")); maxLease != "" {
+		lease, err := validator.ParseLease(maxLease)
+		if err != nil {
+			// the lease of FloatingIPs is not limited by default
+			log.Warnf("ignoring invalid MAXLEASE: %s", err)
+		} else {
+			cfg.maxLease = lease
+		}
+	}
+	cfg.projectLeases = parseProjectMaxLeases(os.Getenv("PROJECTMAXLEASES"))
+
+	cfg.blocklist = strings.TrimSpace(os.Getenv("BLOCKLISTCONFIGMAP"))
+	cfg.breakGlass = strings.TrimSpace(os.Getenv("BREAKGLASSCONFIGMAP"))
+
+	// the status is not protected by default, the status subresources are
+	// only sent to the webhook when the controller users are configured
+	switch controllerUsers := strings.TrimSpace(os.Getenv("CONTROLLERUSERS")); strings.ToLower(controllerUsers) {
+	case "", "none":
+	default:
+		for _, user := range strings.Split(controllerUsers, ",") {
+			if user = strings.TrimSpace(user); user != "" {
+				cfg.controllerUsers = append(cfg.controllerUsers, user)
+			}
+		}
+	}
+
+	for _, spec := range strings.Split(os.Getenv("MAINTENANCEWINDOWS"), ";") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		window, err := validator.ParseMaintenanceWindow(spec)
+		if err != nil {
+			log.Warnf("ignoring invalid MAINTENANCEWINDOWS entry: %s", err)
+			continue
+		}
+		cfg.maintWindows = append(cfg.maintWindows, window)
+	}
+
+	cfg.maintLocation = time.UTC
+	if timeZone := strings.TrimSpace(os.Getenv("MAINTENANCETIMEZONE")); timeZone != "" {
+		location, err := time.LoadLocation(timeZone)
+		if err != nil {
+			log.Warnf("ignoring unknown MAINTENANCETIMEZONE %s, using UTC", timeZone)
+		} else {
+			cfg.maintLocation = location
+		}
+	}
+
+	for _, group := range strings.Split(os.Getenv("MAINTENANCEGROUPS"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			cfg.maintGroups = append(cfg.maintGroups, group)
+		}
+	}
+
+	deleteConfirm, err := strconv.ParseBool(os.Getenv("POOLDELETECONFIRM"))
+	if err == nil {
+		cfg.deleteConfirm = deleteConfirm
+	}
+
+	for _, group := range strings.Split(os.Getenv("POOLDELETEGROUPS"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			cfg.deleteGroups = append(cfg.deleteGroups, group)
+		}
+	}
+
+	accessLog, err := strconv.ParseBool(os.Getenv("ACCESSLOG"))
+	if err == nil {
+		cfg.accessLog = accessLog
+	}
+
+	switch matchPolicy := strings.ToLower(strings.TrimSpace(os.Getenv("MATCHPOLICY"))); matchPolicy {
+	case "exact":
+		cfg.matchPolicy = admregv1.Exact
+	case "", "equivalent":
+		cfg.matchPolicy = admregv1.Equivalent
+	default:
+		log.Warnf("ignoring unknown MATCHPOLICY %s, using Equivalent", matchPolicy)
+		cfg.matchPolicy = admregv1.Equivalent
+	}
+
+	switch ruleScope := strings.ToLower(strings.TrimSpace(os.Getenv("RULESCOPE"))); ruleScope {
+	case "":
+		// the scope of the resource is used
+	case "all", "*":
+		cfg.ruleScope = admregv1.AllScopes
+	case "cluster":
+		cfg.ruleScope = admregv1.ClusterScope
+	case "namespaced":
+		cfg.ruleScope = admregv1.NamespacedScope
+	default:
+		log.Warnf("ignoring unknown RULESCOPE %s, using the scope of the resources", ruleScope)
+	}
+
+	switch failurePolicy := strings.ToLower(os.Getenv("INTERNALFAILUREPOLICY")); failurePolicy {
+	case "ignore":
+		cfg.failurePolicy = admregv1.Ignore
+	case "", "fail":
+		// requests are denied by default
+		cfg.failurePolicy = admregv1.Fail
+	default:
+		log.Warnf("ignoring unknown INTERNALFAILUREPOLICY %s, using Fail", failurePolicy)
+		cfg.failurePolicy = admregv1.Fail
+	}
+
+	breakerFailures, err := strconv.ParseInt(os.Getenv("BREAKERFAILURES"), 10, 64)
+	if err != nil || breakerFailures < 0 {
+		// the circuit breaker is disabled by default
+		breakerFailures = 0
+	}
+	cfg.breakerFailures = breakerFailures
+
+	decisionCacheTTL, err := strconv.ParseInt(os.Getenv("DECISIONCACHETTL"), 10, 64)
+	if err != nil || decisionCacheTTL < 0 {
+		decisionCacheTTL = 30
+	}
+	cfg.decisionCacheTTL = decisionCacheTTL
+
+	breakerTimeout, err := strconv.ParseInt(os.Getenv("BREAKERTIMEOUT"), 10, 64)
+	if err != nil || breakerTimeout <= 0 {
+		breakerTimeout = 30
+	}
+	cfg.breakerTimeout = breakerTimeout
+
+	switch degradedPolicy := strings.ToLower(os.Getenv("DEGRADEDPOLICY")); degradedPolicy {
+	case "ignore":
+		cfg.degradedPolicy = admregv1.Ignore
+	case "fail":
+		cfg.degradedPolicy = admregv1.Fail
+	case "":
+		// the INTERNALFAILUREPOLICY is used by default
+		cfg.degradedPolicy = cfg.failurePolicy
+	default:
+		log.Warnf("ignoring unknown DEGRADEDPOLICY %s, using the INTERNALFAILUREPOLICY", degradedPolicy)
+		cfg.degradedPolicy = cfg.failurePolicy
+	}
+
 	return cfg
 }
 
+// checkWebhookServer returns an error when settings are configured which the
+// webhook server doesn't apply. The controller-runtime webhook server only
+// replaces the listener, it has no timeouts.
+func checkWebhookServer(cfg *appConfig) error {
+	if cfg.webhookServer != service.ServerControllerRuntime {
+		return nil
+	}
+
+	var unsupported []string
+	for _, env := range []string{"READTIMEOUT", "WRITETIMEOUT", "IDLETIMEOUT", "READHEADERTIMEOUT"} {
+		if strings.TrimSpace(os.Getenv(env)) != "" {
+			unsupported = append(unsupported, env)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("%s cannot be used with WEBHOOKSERVER=%s", strings.Join(unsupported, ", "), cfg.webhookServer)
+	}
+
+	return nil
+}
+
+// parseWebhookNamespace returns the namespace the webhook runs in. It is read
+// from the POD_NAMESPACE setting, which the Downward API sets, or from the
+// namespace of the mounted serviceaccount.
+func parseWebhookNamespace() string {
+	if namespace := strings.TrimSpace(os.Getenv("POD_NAMESPACE")); namespace != "" {
+		return namespace
+	}
+
+	namespace, err := os.ReadFile(serviceAccountNamespaceFile)
+	if err == nil && strings.TrimSpace(string(namespace)) != "" {
+		return strings.TrimSpace(string(namespace))
+	}
+
+	return defaultWebhookNamespace
+}
+
+// parseCABundleSource parses the CABUNDLE* settings. The kube-root-ca.crt
+// ConfigMap in kube-system is used when no source is configured.
+func parseCABundleSource() admission.CABundleSource {
+	source := admission.DefaultCABundleSource()
+
+	sourceType := strings.ToLower(strings.TrimSpace(os.Getenv("CABUNDLESOURCE")))
+	switch sourceType {
+	case "":
+	case admission.CABundleSourceConfigMap, admission.CABundleSourceSecret, admission.CABundleSourceFile, admission.CABundleSourceServiceAccount:
+		source.Type = sourceType
+	default:
+		log.Warnf("ignoring unknown CABUNDLESOURCE %s, using the %s source", sourceType, source.Type)
+	}
+
+	if namespace := os.Getenv("CABUNDLENAMESPACE"); namespace != "" {
+		source.Namespace = namespace
+	}
+	if name := os.Getenv("CABUNDLENAME"); name != "" {
+		source.Name = name
+	}
+	if key := os.Getenv("CABUNDLEKEY"); key != "" {
+		source.Key = key
+	}
+	source.File = os.Getenv("CABUNDLEFILE")
+
+	return source
+}
+
+// parseForbiddenRanges parses the FORBIDDENRANGES setting, which is either
+// "none" to disable the check or a comma separated list of CIDRs. The default
+// special-use ranges are used when it is not set.
+func parseForbiddenRanges(forbiddenRanges string) []*net.IPNet {
+	switch strings.ToLower(strings.TrimSpace(forbiddenRanges)) {
+	case "":
+		return nil
+	case "none":
+		return []*net.IPNet{}
+	}
+
+	return parseCIDRs("FORBIDDENRANGES", forbiddenRanges)
+}
+
+// parseCIDRs parses a comma separated list of CIDRs, invalid CIDRs are
+// skipped with a warning.
+func parseCIDRs(setting string, cidrs string) []*net.IPNet {
+	ranges := []*net.IPNet{}
+	for _, cidr := range strings.Split(cidrs, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Warnf("ignoring invalid range %s in %s", cidr, setting)
+			continue
+		}
+		ranges = append(ranges, ipNet)
+	}
+
+	return ranges
+}
+
+// parseAddressSpace parses an address space of the ADDRESSSPACE or
+// PROJECTADDRESSSPACES setting, pools in any address space are allowed by
+// default.
+func parseAddressSpace(setting string, addressSpace string) string {
+	switch addressSpace = strings.ToLower(strings.TrimSpace(addressSpace)); addressSpace {
+	case "", validator.AddressSpaceAny:
+		return validator.AddressSpaceAny
+	case validator.AddressSpacePrivate, validator.AddressSpacePublic:
+		return addressSpace
+	default:
+		log.Warnf("ignoring unknown address space %s in %s, allowing any address space", addressSpace, setting)
+		return validator.AddressSpaceAny
+	}
+}
+
+// parseKeyValues parses the MANAGEDLABELS or MANAGEDANNOTATIONS setting, which
+// is a comma separated list of key=value pairs.
+func parseKeyValues(setting string, keyValues string) map[string]string {
+	parsed := make(map[string]string)
+
+	for _, pair := range strings.Split(keyValues, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, found := strings.Cut(pair, "=")
+		if key = strings.TrimSpace(key); !found || key == "" {
+			log.Warnf("ignoring invalid entry %s in %s", pair, setting)
+			continue
+		}
+		parsed[key] = strings.TrimSpace(value)
+	}
+
+	return parsed
+}
+
+// parseProjectAddressSpaces parses the PROJECTADDRESSSPACES setting, which is
+// a comma separated list of project=addressspace pairs.
+func parseProjectAddressSpaces(projectAddressSpaces string) map[string]string {
+	addressSpaces := make(map[string]string)
+
+	for _, pair := range strings.Split(projectAddressSpaces, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		project, addressSpace, found := strings.Cut(pair, "=")
+		if project = strings.TrimSpace(project); !found || project == "" {
+			log.Warnf("ignoring invalid entry %s in PROJECTADDRESSSPACES", pair)
+			continue
+		}
+		addressSpaces[project] = parseAddressSpace("PROJECTADDRESSSPACES", addressSpace)
+	}
+
+	return addressSpaces
+}
+
+// parseMetadataRules parses the REQUIREDLABELS or REQUIREDANNOTATIONS setting,
+// which is a semicolon separated list of key=pattern rules. Invalid rules are
+// skipped.
+func parseMetadataRules(setting string, rules string) []validator.MetadataRule {
+	var parsed []validator.MetadataRule
+
+	for _, rule := range strings.Split(rules, ";") {
+		r, err := validator.ParseMetadataRules(rule)
+		if err != nil {
+			log.Warnf("ignoring invalid %s entry: %s", setting, err)
+			continue
+		}
+		parsed = append(parsed, r...)
+	}
+
+	return parsed
+}
+
+// parseProjectMaxLeases parses the PROJECTMAXLEASES setting, which is a comma
+// separated list of project=lease pairs. A lease of 0 doesn't limit the lease
+// of the project.
+func parseProjectMaxLeases(projectMaxLeases string) map[string]time.Duration {
+	leases := make(map[string]time.Duration)
+
+	for _, pair := range strings.Split(projectMaxLeases, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		project, lease, found := strings.Cut(pair, "=")
+		if project = strings.TrimSpace(project); !found || project == "" {
+			log.Warnf("ignoring invalid entry %s in PROJECTMAXLEASES", pair)
+			continue
+		}
+		if lease = strings.TrimSpace(lease); lease == "0" {
+			leases[project] = 0
+			continue
+		}
+		duration, err := validator.ParseLease(lease)
+		if err != nil {
+			log.Warnf("ignoring invalid entry %s in PROJECTMAXLEASES: %s", pair, err)
+			continue
+		}
+		leases[project] = duration
+	}
+
+	return leases
+}
+
+// parseUtilizationThresholds parses the UTILIZATIONTHRESHOLDS setting, which
+// is either "none" to disable the warnings or a comma separated list of
+// percentages. The default thresholds are used when it is not set.
+func parseUtilizationThresholds(utilizationThresholds string) []int {
+	switch strings.ToLower(strings.TrimSpace(utilizationThresholds)) {
+	case "":
+		return service.DefaultUtilizationThresholds()
+	case "none":
+		return []int{}
+	}
+
+	thresholds := []int{}
+	for _, threshold := range strings.Split(utilizationThresholds, ",") {
+		if threshold = strings.TrimSpace(threshold); threshold == "" {
+			continue
+		}
+		percentage, err := strconv.Atoi(strings.TrimSuffix(threshold, "%"))
+		if err != nil || percentage <= 0 || percentage > 100 {
+			log.Warnf("ignoring invalid threshold %s in UTILIZATIONTHRESHOLDS", threshold)
+			continue
+		}
+		thresholds = append(thresholds, percentage)
+	}
+
+	return thresholds
+}
+
+// parseAuditMode parses the AUDITMODE setting, which is either "true" to
+// enable audit mode for all webhooks or a comma separated list of webhooks.
+func parseAuditMode(auditMode string) map[string]bool {
+	webhooks := make(map[string]bool)
+
+	switch strings.ToLower(strings.TrimSpace(auditMode)) {
+	case "", "false":
+		return webhooks
+	case "true", "all":
+		webhooks[service.WebhookFloatingIP] = true
+		webhooks[service.WebhookFloatingIPPool] = true
+		webhooks[service.WebhookFloatingIPProjectQuota] = true
+		webhooks[service.WebhookService] = true
+		return webhooks
+	}
+
+	for _, webhook := range strings.Split(auditMode, ",") {
+		webhook = strings.ToLower(strings.TrimSpace(webhook))
+		switch webhook {
+		case service.WebhookFloatingIP, service.WebhookFloatingIPPool, service.WebhookFloatingIPProjectQuota, service.WebhookService:
+			webhooks[webhook] = true
+		case "":
+		default:
+			log.Warnf("ignoring unknown webhook %s in AUDITMODE", webhook)
+		}
+	}
+
+	return webhooks
+}
+
+// parseMatchConditions parses the matchConditions of the webhooks. The
+// MATCHCONDITIONS setting is a JSON list of matchConditions with a name and a
+// CEL expression, MATCHSKIPUSERS a comma separated list of users and
+// MATCHSKIPLABEL a label whose requests are skipped.
+func parseMatchConditions(matchConditions string, skipUsers string, skipLabel string) []admregv1.MatchCondition {
+	var conditions []admregv1.MatchCondition
+
+	if matchConditions = strings.TrimSpace(matchConditions); matchConditions != "" {
+		var parsed []admregv1.MatchCondition
+		if err := json.Unmarshal([]byte(matchConditions), &parsed); err != nil {
+			log.Warnf("ignoring invalid MATCHCONDITIONS: %s", err)
+		}
+		for _, condition := range parsed {
+			if condition.Name == "" || condition.Expression == "" {
+				log.Warnf("ignoring MATCHCONDITIONS entry without a name or expression: %+v", condition)
+				continue
+			}
+			conditions = append(conditions, condition)
+		}
+	}
+
+	var users []string
+	for _, user := range strings.Split(skipUsers, ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			users = append(users, user)
+		}
+	}
+	if len(users) > 0 {
+		conditions = append(conditions, admission.SkipUsersMatchCondition(users))
+	}
+
+	if skipLabel = strings.TrimSpace(skipLabel); skipLabel != "" {
+		conditions = append(conditions, admission.SkipLabelMatchCondition(skipLabel))
+	}
+
+	return conditions
+}
+
 func init() {
-	// Log as JSON instead of the default ASCII formatter.
 	formatter := &log.TextFormatter{
 		FullTimestamp: true,
 	}
@@ -62,66 +1000,164 @@ func init() {
 	log.SetLevel(log.InfoLevel)
 }
 
-func main() {
-	cfg := parseAppEnv()
-
+func configureLogging(cfg *appConfig) {
 	level, err := log.ParseLevel(cfg.logLevel)
 	if err == nil {
 		log.SetLevel(level)
 	}
 
-	certRenewalPeriod = cfg.certRenewalPeriod
+	if cfg.logFormat == "json" {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+
+	log.SetReportCaller(cfg.logCaller)
+}
+
+const usage = `Usage: %s [command] [arguments]
+
+Commands:
+  serve      run the webhook server (default)
+  gen-certs  generate the serving certificate and store it in the TLS secret
+  cleanup    remove the webhook configuration, the TLS secret and pending CSRs
+  replay     validate a captured AdmissionReview from a file or stdin and print the response
+  version    print the build information
 
-	kubeconfig_file := cfg.kubeConfigFile
-	if kubeconfig_file == "" {
-		homedir := os.Getenv("HOME")
-		kubeconfig_file = filepath.Join(homedir, ".kube", "config")
+The webhook is configured with environment variables, see the README.
+`
+
+// parseCommand returns the subcommand from the command line arguments. The
+// webhook is served when no subcommand is given.
+func parseCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		return "serve", nil
 	}
 
-	kubeconfig_context := cfg.kubeConfigContext
+	switch args[0] {
+	case "serve", "gen-certs", "cleanup", "replay", "version":
+		return args[0], nil
+	case "help", "-h", "-help", "--help":
+		return "help", nil
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	return "", fmt.Errorf("unknown command %s", args[0])
+}
 
-	configHandler := config.Register(
-		ctx,
-		kubeconfig_file,
-		kubeconfig_context,
-		"rancher-fip-manager-webhook",
-		"rancher-fip-manager",
-	)
+func main() {
+	command, err := parseCommand(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n", err.Error())
+		fmt.Fprintf(os.Stderr, usage, progname)
+		os.Exit(2)
+	}
+
+	switch command {
+	case "help":
+		fmt.Printf(usage, progname)
+		return
+	case "version":
+		fmt.Printf("%s %s\n", progname, version.String())
+		return
+	}
+
+	cfg := parseAppEnv()
+
+	configureLogging(cfg)
+
+	switch command {
+	case "serve":
+		serve(cfg)
+	case "gen-certs":
+		genCerts(cfg)
+	case "cleanup":
+		cleanup(cfg)
+	case "replay":
+		replay(cfg, os.Args[2:])
+	}
+}
+
+// kubeConfigFile returns the configured kubeconfig, or the default kubeconfig
+// in the home directory. The in-cluster config is used if it doesn't exist.
+func kubeConfigFile(cfg *appConfig) string {
+	if cfg.kubeConfigFile != "" {
+		return cfg.kubeConfigFile
+	}
+
+	return filepath.Join(os.Getenv("HOME"), ".kube", "config")
+}
+
+func renewalPolicy(cfg *appConfig) config.RenewalPolicy {
+	return config.RenewalPolicy{
+		Period:             cfg.certRenewalPeriod,
+		LifetimePercentage: cfg.certRenewalPct,
+	}
+}
 
-	admissionHandler := admission.Register(
+// newClients creates the Kubernetes clients which are shared by the handlers.
+func newClients(cfg *appConfig) *util.Clients {
+	clients, err := util.NewClients(util.ClientOptions{
+		KubeConfig:  kubeConfigFile(cfg),
+		KubeContext: cfg.kubeConfigContext,
+		QPS:         float32(cfg.clientQPS),
+		Burst:       int(cfg.clientBurst),
+		Timeout:     time.Duration(cfg.clientTimeout) * time.Second,
+		UserAgent:   version.UserAgent(progname),
+	})
+	if err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+
+	return clients
+}
+
+func newConfigHandler(ctx context.Context, clients *util.Clients, cfg *appConfig) *config.Handler {
+	return config.Register(
 		ctx,
-		kubeconfig_file,
-		kubeconfig_context,
-		"rancher-fip-manager-webhook",
-		"rancher-fip-manager",
-		"rancher-fip-manager-validator",
+		clients.Clientset,
+		cfg.webhookName,
+		cfg.webhookNamespace,
+		config.Options{
+			CertDuration:  time.Duration(cfg.certDuration) * time.Minute,
+			ServiceName:   cfg.serviceName,
+			SecretName:    cfg.secretName,
+			ExtraMetadata: cfg.extraMetadata,
+		},
 	)
+}
 
-	serviceHandler := service.Register(
+func newAdmissionHandler(ctx context.Context, clients *util.Clients, cfg *appConfig) *admission.Handler {
+	return admission.Register(
 		ctx,
+		clients.Clientset,
+		clients.Dynamic,
+		cfg.webhookName,
+		cfg.webhookNamespace,
+		cfg.webhookConfigName,
+		admission.Options{
+			CABundle:          cfg.caBundle,
+			ServiceName:       cfg.serviceName,
+			FloatingIPStatus:  len(cfg.controllerUsers) > 0,
+			PoolStatus:        len(cfg.controllerUsers) > 0,
+			PoolDeletes:       len(cfg.maintWindows) > 0 || cfg.deleteConfirm,
+			APIResources:      cfg.apiResources,
+			APIVersions:       cfg.apiVersions,
+			MatchConditions:   cfg.matchConditions,
+			MatchPolicy:       cfg.matchPolicy,
+			RuleScope:         cfg.ruleScope,
+			ExtraMetadata:     cfg.extraMetadata,
+			ExternallyManaged: cfg.externalVWC,
+			Service:           cfg.createService,
+			Conversion:        cfg.conversion,
+			ServicesAnnotated: servicesAnnotated(cfg),
+		},
 	)
-
-	configHandler.Init()
-	configHandler.Run(certRenewalPeriod)
-	admissionHandler.Init()
-	scheduler.StartCertRenewalScheduler(configHandler, serviceHandler, certRenewalPeriod)
-	go serviceHandler.Run()
-	go Run()
-
-	log.Infof("%s is running", progname)
-
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-	<-sig
-	log.Infof("%s received shutdown signal, gracefully shutting down...", progname)
-	cancel()
-	os.Exit(0)
 }
 
-func Run() {
-	for {
-		time.Sleep(time.Second)
+// servicesAnnotated returns the Service annotations which send Services to
+// the webhook, or nil when Services are not validated.
+func servicesAnnotated(cfg *appConfig) []string {
+	if !cfg.validateServices {
+		return nil
 	}
+
+	return service.ServiceAnnotations()
 }
@@ -1,41 +1,360 @@
 package main
 
 import (
+	"context"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/admission"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/service"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
 	"github.com/stretchr/testify/assert"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestParseAppEnv(t *testing.T) {
+	defer func(file string) { serviceAccountNamespaceFile = file }(serviceAccountNamespaceFile)
+	serviceAccountNamespaceFile = filepath.Join(t.TempDir(), "namespace")
+
 	testCases := []struct {
 		name                string
 		envVars             map[string]string
 		expectedLogLevel    string
+		expectedLogFormat   string
+		expectedLogCaller   bool
 		expectedCertRenewal int64
+		expectedCertPct     int64
+		expectedCertDur     int64
+		expectedCertCheck   int64
+		expectedCertJitter  int64
 		expectedKubeConfig  string
 		expectedKubeContext string
+		expectedProjectNs   bool
+		expectedReserve     bool
+		expectedReserveTTL  int64
+		expectedQuotaWarn   bool
+		expectedMaxPool     int64
+		expectedApproveSize int64
+		expectedApprovers   []string
+		expectedMaxFIPs     int64
+		expectedCluster     bool
+		expectedProject     bool
+		expectedHierarchy   bool
+		expectedMaxRequest  int64
+		expectedReadTime    int64
+		expectedWriteTime   int64
+		expectedIdleTime    int64
+		expectedHeaderTime  int64
+		expectedNoHTTP2     bool
+		expectedAuth        bool
+		expectedPreview     bool
+		expectedSelfTest    bool
+		expectedConversion  bool
+		expectedAPIVersions []string
+		expectedResources   util.APIResources
+		expectedMatchPolicy admregv1.MatchPolicyType
+		expectedRuleScope   admregv1.ScopeType
+		expectedExtraMeta   util.ExtraMetadata
+		expectedExternalVWC bool
+		expectedCreateSvc   bool
+		expectedServer      string
+		expectedCertDir     string
+		expectedAuthUsers   []string
+		expectedFailPolicy  admregv1.FailurePolicyType
+		expectedBreakerMax  int64
+		expectedBreakerTime int64
+		expectedDegraded    admregv1.FailurePolicyType
+		expectedDecisionTTL int64
+		expectedPoolIndex   bool
+		expectedServices    bool
+		expectedTargetWarn  bool
+		expectedAccessLog   bool
+		expectedMaxInFlight int64
+		expectedMaxQueued   int64
+		expectedRateLimit   int64
+		expectedRateBurst   int64
+		expectedBudget      int64
+		expectedClientQPS   float64
+		expectedBurst       int64
+		expectedClientTime  int64
+		expectedWebhook     string
+		expectedNamespace   string
+		expectedConfigName  string
+		expectedService     string
+		expectedSecret      string
+		expectedAddrSpace   string
+		expectedProjSpaces  map[string]string
+		expectedClusterNets []*net.IPNet
+		expectedNodeCheck   bool
+		expectedLBConflicts string
+		expectedProber      string
+		expectedProbeMode   string
+		expectedProbeTime   int64
+		expectedThresholds  []int
+		expectedNoQuota     string
+		expectedNoQuotaMax  int64
+		expectedOverride    []string
+		expectedNsOverrides bool
+		expectedReqLabels   []string
+		expectedReqAnnots   []string
+		expectedMaxLease    time.Duration
+		expectedProjLeases  map[string]time.Duration
+		expectedBlocklist   string
+		expectedBreakGlass  string
+		expectedController  []string
+		expectedWindows     []string
+		expectedTimeZone    string
+		expectedMaintGroups []string
+		expectedDelConfirm  bool
+		expectedDelGroups   []string
 	}{
 		{
 			name:                "default values",
 			envVars:             map[string]string{},
+			expectedResources:   util.DefaultAPIResources(),
+			expectedMatchPolicy: admregv1.Equivalent,
+			expectedServer:      "default",
+			expectedExtraMeta:   util.ExtraMetadata{Labels: map[string]string{}, Annotations: map[string]string{}},
 			expectedLogLevel:    "INFO",
+			expectedLogFormat:   "text",
+			expectedLogCaller:   false,
 			expectedCertRenewal: 43200,
+			expectedCertPct:     0,
+			expectedCertDur:     0,
+			expectedCertCheck:   60,
+			expectedCertJitter:  5,
 			expectedKubeConfig:  "",
 			expectedKubeContext: "",
+			expectedReserveTTL:  5,
+			expectedMaxRequest:  8388608,
+			expectedFailPolicy:  admregv1.Fail,
+			expectedBreakerTime: 30,
+			expectedDegraded:    admregv1.Fail,
+			expectedDecisionTTL: 30,
+			expectedMaxQueued:   10,
+			expectedBudget:      80,
+			expectedClientQPS:   20,
+			expectedBurst:       40,
+			expectedClientTime:  30,
+			expectedWebhook:     "rancher-fip-manager-webhook",
+			expectedNamespace:   "rancher-fip-manager",
+			expectedConfigName:  "rancher-fip-manager-validator",
+			expectedService:     "rancher-fip-manager-webhook",
+			expectedSecret:      "rancher-fip-manager-webhook-tls",
+			expectedAddrSpace:   "any",
+			expectedProjSpaces:  map[string]string{},
+			expectedClusterNets: []*net.IPNet{},
+			expectedProbeMode:   "deny",
+			expectedProbeTime:   1000,
+			expectedThresholds:  []int{80, 95},
+			expectedNoQuota:     "deny",
+			expectedProjLeases:  map[string]time.Duration{},
+			expectedReadTime:    10,
+			expectedWriteTime:   10,
+			expectedController:  nil,
+			expectedTimeZone:    "UTC",
 		},
 		{
 			name: "custom values",
 			envVars: map[string]string{
-				"LOGLEVEL":          "DEBUG",
-				"CERTRENEWALPERIOD": "60",
-				"KUBECONFIG":        "/path/to/kubeconfig",
-				"KUBECONTEXT":       "my-context",
+				"LOGLEVEL":              "DEBUG",
+				"LOGFORMAT":             "JSON",
+				"LOGCALLER":             "true",
+				"CERTRENEWALPERIOD":     "60",
+				"CERTRENEWALPERCENTAGE": "66",
+				"CERTDURATION":          "1440",
+				"CERTCHECKINTERVAL":     "10",
+				"CERTCHECKJITTER":       "0",
+				"KUBECONFIG":            "/path/to/kubeconfig",
+				"KUBECONTEXT":           "my-context",
+				"PROJECTFROMNAMESPACE":  "true",
+				"RESERVATIONS":          "true",
+				"RESERVATIONTTL":        "2",
+				"QUOTAUNKNOWNPOOLSWARN": "true",
+				"MAXPOOLSIZE":           "65536",
+				"APPROVALPOOLSIZE":      "1024",
+				"APPROVALGROUPS":        "network-admins, security",
+				"MAXFLOATINGIPS":        "250",
+				"HIERARCHICALQUOTAS":    "true",
+				"VALIDATETARGETCLUSTER": "true",
+				"VALIDATEPROJECT":       "true",
+				"MAXREQUESTBYTES":       "1048576",
+				"READTIMEOUT":           "20",
+				"WRITETIMEOUT":          "30",
+				"IDLETIMEOUT":           "120",
+				"READHEADERTIMEOUT":     "5",
+				"DISABLEHTTP2":          "true",
+				"AUTHENTICATEREQUESTS":  "true",
+				"AUTHENTICATEDUSERS":    "system:kube-apiserver, webhook-client",
+				"PREVIEWAPI":            "true",
+				"SELFTEST":              "true",
+				"CONVERSIONWEBHOOK":     "true",
+				"APIVERSIONS":           "v1, v1beta2",
+				"APIGROUP":              "fip.example.com",
+				"APIVERSION":            "v1",
+				"FLOATINGIPRESOURCE":    "fips",
+				"MATCHPOLICY":           "Exact",
+				"RULESCOPE":             "*",
+				"MANAGEDLABELS":         "team=network, invalid",
+				"EXTERNALWEBHOOKCONFIG": "true",
+				"CREATESERVICE":         "true",
+				"WEBHOOKSERVER":         "Controller-Runtime",
+				"CERTDIR":               "/tmp/k8s-webhook-server/serving-certs",
+				"MANAGEDANNOTATIONS":    "argocd.argoproj.io/compare-options=IgnoreExtraneous",
+				"INTERNALFAILUREPOLICY": "Ignore",
+				"BREAKERFAILURES":       "5",
+				"BREAKERTIMEOUT":        "10",
+				"DEGRADEDPOLICY":        "Fail",
+				"DECISIONCACHETTL":      "0",
+				"POOLINDEX":             "true",
+				"VALIDATESERVICES":      "true",
+				"TARGETWARNONLY":        "true",
+				"ACCESSLOG":             "true",
+				"MAXINFLIGHT":           "50",
+				"MAXQUEUED":             "0",
+				"RATELIMIT":             "30",
+				"RATELIMITBURST":        "60",
+				"REQUESTBUDGET":         "50",
+				"CLIENTQPS":             "50",
+				"CLIENTBURST":           "100",
+				"CLIENTTIMEOUT":         "5",
+				"WEBHOOKNAME":           "fip-webhook",
+				"POD_NAMESPACE":         "fip-system",
+				"WEBHOOKCONFIGNAME":     "fip-validator",
+				"SERVICENAME":           "fip-service",
+				"SECRETNAME":            "fip-serving-cert",
+				"ADDRESSSPACE":          "Private",
+				"PROJECTADDRESSSPACES":  "p-abcde=public, p-fghij=any, invalid",
+				"CLUSTERCIDRS":          "10.42.0.0/16, 10.43.0.0/16",
+				"VALIDATENODECONFLICTS": "true",
+				"LOADBALANCERCONFLICTS": "Warn",
+				"PROBER":                "http://prober.kube-system:8080/probe",
+				"PROBEMODE":             "warn",
+				"PROBETIMEOUT":          "250",
+				"UTILIZATIONTHRESHOLDS": "70%, 90, 101",
+				"MISSINGQUOTAPOLICY":    "Limit",
+				"MISSINGQUOTALIMIT":     "3",
+				"QUOTAOVERRIDEGROUPS":   "system:masters, fip-admins",
+				"NAMESPACEOVERRIDES":    "true",
+				"REQUIREDLABELS":        "cost-center=cc-[0-9]{4,6}; owner; =invalid",
+				"REQUIREDANNOTATIONS":   "ticket=[A-Z]+-[0-9]+;broken=(",
+				"MAXLEASE":              "30d",
+				"PROJECTMAXLEASES":      "p-abcde=12h, p-fghij=0, p-klmno=1w, invalid",
+				"BLOCKLISTCONFIGMAP":    "rancher-fip-manager-blocklist",
+				"BREAKGLASSCONFIGMAP":   "rancher-fip-manager-break-glass",
+				"CONTROLLERUSERS":       "system:serviceaccount:fip-system:fip-controller, admin",
+				"MAINTENANCEWINDOWS":    "0 22 * * 1-5 4h; invalid; 0 8 * * 6 2h",
+				"MAINTENANCETIMEZONE":   "Europe/Amsterdam",
+				"MAINTENANCEGROUPS":     "network-admins",
+				"POOLDELETECONFIRM":     "true",
+				"POOLDELETEGROUPS":      "network-admins, ",
 			},
 			expectedLogLevel:    "DEBUG",
+			expectedLogFormat:   "json",
+			expectedLogCaller:   true,
 			expectedCertRenewal: 60,
+			expectedCertPct:     66,
+			expectedCertDur:     1440,
+			expectedCertCheck:   10,
+			expectedCertJitter:  0,
 			expectedKubeConfig:  "/path/to/kubeconfig",
 			expectedKubeContext: "my-context",
+			expectedProjectNs:   true,
+			expectedReserve:     true,
+			expectedReserveTTL:  2,
+			expectedQuotaWarn:   true,
+			expectedMaxPool:     65536,
+			expectedApproveSize: 1024,
+			expectedApprovers:   []string{"network-admins", "security"},
+			expectedMaxFIPs:     250,
+			expectedCluster:     true,
+			expectedProject:     true,
+			expectedHierarchy:   true,
+			expectedMaxRequest:  1048576,
+			expectedReadTime:    20,
+			expectedWriteTime:   30,
+			expectedIdleTime:    120,
+			expectedHeaderTime:  5,
+			expectedNoHTTP2:     true,
+			expectedAuth:        true,
+			expectedPreview:     true,
+			expectedSelfTest:    true,
+			expectedConversion:  true,
+			expectedAPIVersions: []string{"v1", "v1beta2"},
+			expectedMatchPolicy: admregv1.Exact,
+			expectedRuleScope:   admregv1.AllScopes,
+			expectedExternalVWC: true,
+			expectedCreateSvc:   true,
+			expectedServer:      "controller-runtime",
+			expectedCertDir:     "/tmp/k8s-webhook-server/serving-certs",
+			expectedExtraMeta: util.ExtraMetadata{
+				Labels:      map[string]string{"team": "network"},
+				Annotations: map[string]string{"argocd.argoproj.io/compare-options": "IgnoreExtraneous"},
+			},
+			expectedResources: util.APIResources{
+				Group:                   "fip.example.com",
+				Version:                 "v1",
+				FloatingIPs:             "fips",
+				FloatingIPPools:         "floatingippools",
+				FloatingIPProjectQuotas: "floatingipprojectquotas",
+			},
+			expectedAuthUsers:   []string{"system:kube-apiserver", "webhook-client"},
+			expectedFailPolicy:  admregv1.Ignore,
+			expectedBreakerMax:  5,
+			expectedBreakerTime: 10,
+			expectedDegraded:    admregv1.Fail,
+			expectedPoolIndex:   true,
+			expectedServices:    true,
+			expectedTargetWarn:  true,
+			expectedAccessLog:   true,
+			expectedMaxInFlight: 50,
+			expectedRateLimit:   30,
+			expectedRateBurst:   60,
+			expectedBudget:      50,
+			expectedClientQPS:   50,
+			expectedBurst:       100,
+			expectedClientTime:  5,
+			expectedWebhook:     "fip-webhook",
+			expectedNamespace:   "fip-system",
+			expectedConfigName:  "fip-validator",
+			expectedService:     "fip-service",
+			expectedSecret:      "fip-serving-cert",
+			expectedAddrSpace:   "private",
+			expectedProjSpaces:  map[string]string{"p-abcde": "public", "p-fghij": "any"},
+			expectedClusterNets: []*net.IPNet{
+				{IP: net.IP{10, 42, 0, 0}, Mask: net.CIDRMask(16, 32)},
+				{IP: net.IP{10, 43, 0, 0}, Mask: net.CIDRMask(16, 32)},
+			},
+			expectedNodeCheck:   true,
+			expectedLBConflicts: "warn",
+			expectedProber:      "http://prober.kube-system:8080/probe",
+			expectedProbeMode:   "warn",
+			expectedProbeTime:   250,
+			expectedThresholds:  []int{70, 90},
+			expectedNoQuota:     "limit",
+			expectedNoQuotaMax:  3,
+			expectedOverride:    []string{"system:masters", "fip-admins"},
+			expectedNsOverrides: true,
+			expectedReqLabels:   []string{"cost-center=cc-[0-9]{4,6}", "owner"},
+			expectedReqAnnots:   []string{"ticket=[A-Z]+-[0-9]+"},
+			expectedMaxLease:    30 * 24 * time.Hour,
+			expectedProjLeases:  map[string]time.Duration{"p-abcde": 12 * time.Hour, "p-fghij": 0},
+			expectedBlocklist:   "rancher-fip-manager-blocklist",
+			expectedBreakGlass:  "rancher-fip-manager-break-glass",
+			expectedController:  []string{"system:serviceaccount:fip-system:fip-controller", "admin"},
+			expectedWindows:     []string{"0 22 * * 1-5 4h", "0 8 * * 6 2h"},
+			expectedTimeZone:    "Europe/Amsterdam",
+			expectedMaintGroups: []string{"network-admins"},
+			expectedDelConfirm:  true,
+			expectedDelGroups:   []string{"network-admins"},
 		},
 	}
 
@@ -49,9 +368,405 @@ func TestParseAppEnv(t *testing.T) {
 			cfg := parseAppEnv()
 
 			assert.Equal(t, tc.expectedLogLevel, cfg.logLevel)
+			assert.Equal(t, tc.expectedLogFormat, cfg.logFormat)
+			assert.Equal(t, tc.expectedLogCaller, cfg.logCaller)
 			assert.Equal(t, tc.expectedCertRenewal, cfg.certRenewalPeriod)
+			assert.Equal(t, tc.expectedCertPct, cfg.certRenewalPct)
+			assert.Equal(t, tc.expectedCertDur, cfg.certDuration)
+			assert.Equal(t, tc.expectedCertCheck, cfg.certCheckInterval)
+			assert.Equal(t, tc.expectedCertJitter, cfg.certCheckJitter)
 			assert.Equal(t, tc.expectedKubeConfig, cfg.kubeConfigFile)
 			assert.Equal(t, tc.expectedKubeContext, cfg.kubeConfigContext)
+			assert.Equal(t, tc.expectedProjectNs, cfg.projectFromNs)
+			assert.Equal(t, tc.expectedReserve, cfg.reservations)
+			assert.Equal(t, tc.expectedReserveTTL, cfg.reservationTTL)
+			assert.Equal(t, tc.expectedQuotaWarn, cfg.quotaPoolsWarn)
+			assert.Equal(t, tc.expectedMaxPool, cfg.maxPoolSize)
+			assert.Equal(t, tc.expectedApproveSize, cfg.approvalPoolSize)
+			assert.Equal(t, tc.expectedApprovers, cfg.approvalGroups)
+			assert.Equal(t, tc.expectedMaxFIPs, cfg.maxFloatingIPs)
+			assert.Equal(t, tc.expectedCluster, cfg.validateCluster)
+			assert.Equal(t, tc.expectedProject, cfg.validateProject)
+			assert.Equal(t, tc.expectedHierarchy, cfg.hierarchicalQuota)
+			assert.Equal(t, tc.expectedMaxRequest, cfg.maxRequestBytes)
+			assert.Equal(t, tc.expectedReadTime, cfg.readTimeout)
+			assert.Equal(t, tc.expectedWriteTime, cfg.writeTimeout)
+			assert.Equal(t, tc.expectedIdleTime, cfg.idleTimeout)
+			assert.Equal(t, tc.expectedHeaderTime, cfg.readHeaderTimeout)
+			assert.Equal(t, tc.expectedNoHTTP2, cfg.disableHTTP2)
+			assert.Equal(t, tc.expectedAuth, cfg.authenticate)
+			assert.Equal(t, tc.expectedPreview, cfg.previewAPI)
+			assert.Equal(t, tc.expectedSelfTest, cfg.selfTest)
+			assert.Equal(t, tc.expectedConversion, cfg.conversion)
+			assert.Equal(t, tc.expectedAPIVersions, cfg.apiVersions)
+			assert.Equal(t, tc.expectedResources, cfg.apiResources)
+			assert.Equal(t, tc.expectedMatchPolicy, cfg.matchPolicy)
+			assert.Equal(t, tc.expectedRuleScope, cfg.ruleScope)
+			assert.Equal(t, tc.expectedExtraMeta, cfg.extraMetadata)
+			assert.Equal(t, tc.expectedExternalVWC, cfg.externalVWC)
+			assert.Equal(t, tc.expectedCreateSvc, cfg.createService)
+			assert.Equal(t, tc.expectedServer, cfg.webhookServer)
+			assert.Equal(t, tc.expectedCertDir, cfg.certDir)
+			assert.Equal(t, tc.expectedAuthUsers, cfg.authUsers)
+			assert.Equal(t, tc.expectedFailPolicy, cfg.failurePolicy)
+			assert.Equal(t, tc.expectedBreakerMax, cfg.breakerFailures)
+			assert.Equal(t, tc.expectedBreakerTime, cfg.breakerTimeout)
+			assert.Equal(t, tc.expectedDegraded, cfg.degradedPolicy)
+			assert.Equal(t, tc.expectedDecisionTTL, cfg.decisionCacheTTL)
+			assert.Equal(t, tc.expectedPoolIndex, cfg.poolIndex)
+			assert.Equal(t, tc.expectedServices, cfg.validateServices)
+			assert.Equal(t, tc.expectedTargetWarn, cfg.targetWarnOnly)
+			assert.Equal(t, tc.expectedAccessLog, cfg.accessLog)
+			assert.Equal(t, tc.expectedMaxInFlight, cfg.maxInFlight)
+			assert.Equal(t, tc.expectedMaxQueued, cfg.maxQueued)
+			assert.Equal(t, tc.expectedRateLimit, cfg.rateLimit)
+			assert.Equal(t, tc.expectedRateBurst, cfg.rateLimitBurst)
+			assert.Equal(t, tc.expectedBudget, cfg.requestBudget)
+			assert.Equal(t, tc.expectedClientQPS, cfg.clientQPS)
+			assert.Equal(t, tc.expectedBurst, cfg.clientBurst)
+			assert.Equal(t, tc.expectedClientTime, cfg.clientTimeout)
+			assert.Equal(t, tc.expectedWebhook, cfg.webhookName)
+			assert.Equal(t, tc.expectedNamespace, cfg.webhookNamespace)
+			assert.Equal(t, tc.expectedConfigName, cfg.webhookConfigName)
+			assert.Equal(t, tc.expectedService, cfg.serviceName)
+			assert.Equal(t, tc.expectedSecret, cfg.secretName)
+			assert.Equal(t, tc.expectedAddrSpace, cfg.addressSpace)
+			assert.Equal(t, tc.expectedProjSpaces, cfg.projectSpaces)
+			assert.Equal(t, tc.expectedClusterNets, cfg.clusterCIDRs)
+			assert.Equal(t, tc.expectedNodeCheck, cfg.nodeConflicts)
+			assert.Equal(t, tc.expectedLBConflicts, cfg.lbConflicts)
+			assert.Equal(t, tc.expectedProber, cfg.prober)
+			assert.Equal(t, tc.expectedProbeMode, cfg.probeMode)
+			assert.Equal(t, tc.expectedProbeTime, cfg.probeTimeout)
+			assert.Equal(t, tc.expectedThresholds, cfg.utilThresholds)
+			assert.Equal(t, tc.expectedNoQuota, cfg.missingQuota)
+			assert.Equal(t, tc.expectedNoQuotaMax, cfg.missingQuotaLimit)
+			assert.Equal(t, tc.expectedOverride, cfg.overrideGroups)
+			assert.Equal(t, tc.expectedNsOverrides, cfg.nsOverrides)
+			var requiredLabels, requiredAnnots []string
+			for _, rule := range cfg.requiredLabels {
+				requiredLabels = append(requiredLabels, rule.String())
+			}
+			for _, rule := range cfg.requiredAnnots {
+				requiredAnnots = append(requiredAnnots, rule.String())
+			}
+			assert.Equal(t, tc.expectedReqLabels, requiredLabels)
+			assert.Equal(t, tc.expectedReqAnnots, requiredAnnots)
+			assert.Equal(t, tc.expectedMaxLease, cfg.maxLease)
+			assert.Equal(t, tc.expectedProjLeases, cfg.projectLeases)
+			assert.Equal(t, tc.expectedBlocklist, cfg.blocklist)
+			assert.Equal(t, tc.expectedBreakGlass, cfg.breakGlass)
+			assert.Equal(t, tc.expectedController, cfg.controllerUsers)
+			var windows []string
+			for _, window := range cfg.maintWindows {
+				windows = append(windows, window.String())
+			}
+			assert.Equal(t, tc.expectedWindows, windows)
+			assert.Equal(t, tc.expectedTimeZone, cfg.maintLocation.String())
+			assert.Equal(t, tc.expectedMaintGroups, cfg.maintGroups)
+			assert.Equal(t, tc.expectedDelConfirm, cfg.deleteConfirm)
+			assert.Equal(t, tc.expectedDelGroups, cfg.deleteGroups)
 		})
 	}
 }
+
+func TestParseWebhookNamespace(t *testing.T) {
+	defer func(file string) { serviceAccountNamespaceFile = file }(serviceAccountNamespaceFile)
+	serviceAccountNamespaceFile = filepath.Join(t.TempDir(), "namespace")
+
+	// the default namespace is used when the namespace is not known
+	assert.Equal(t, "rancher-fip-manager", parseWebhookNamespace())
+
+	// the namespace of the serviceaccount
+	assert.NoError(t, os.WriteFile(serviceAccountNamespaceFile, []byte("fip-system\n"), 0600))
+	assert.Equal(t, "fip-system", parseWebhookNamespace())
+
+	// the Downward API takes precedence
+	t.Setenv("POD_NAMESPACE", "fip-webhook")
+	assert.Equal(t, "fip-webhook", parseWebhookNamespace())
+}
+
+func TestParseCertDuration(t *testing.T) {
+	t.Setenv("CERTDURATION", "35791394")
+	assert.Equal(t, int64(35791394), parseAppEnv().certDuration)
+
+	// the expirationSeconds of the CSR would overflow
+	t.Setenv("CERTDURATION", "35791395")
+	assert.Equal(t, int64(0), parseAppEnv().certDuration)
+}
+
+func TestCheckWebhookServer(t *testing.T) {
+	t.Setenv("READTIMEOUT", "30")
+	t.Setenv("IDLETIMEOUT", "60")
+
+	// the timeouts are applied by the default server
+	assert.NoError(t, checkWebhookServer(&appConfig{webhookServer: service.ServerDefault}))
+
+	// the controller-runtime server would ignore them
+	assert.EqualError(t, checkWebhookServer(&appConfig{webhookServer: service.ServerControllerRuntime}),
+		"READTIMEOUT, IDLETIMEOUT cannot be used with WEBHOOKSERVER=controller-runtime")
+}
+
+func TestParseMatchConditions(t *testing.T) {
+	assert.Nil(t, parseMatchConditions("", "", ""))
+	assert.Nil(t, parseMatchConditions("not json", " , ", " "))
+
+	conditions := parseMatchConditions(
+		`[{"name":"skip-dry-run","expression":"!request.dryRun"},{"name":"no-expression"}]`,
+		"system:serviceaccount:rancher-fip-manager:controller, admin",
+		"example.com/unmanaged",
+	)
+	assert.Equal(t, []admregv1.MatchCondition{
+		{Name: "skip-dry-run", Expression: "!request.dryRun"},
+		admission.SkipUsersMatchCondition([]string{"system:serviceaccount:rancher-fip-manager:controller", "admin"}),
+		admission.SkipLabelMatchCondition("example.com/unmanaged"),
+	}, conditions)
+}
+
+func TestParseAuditMode(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected map[string]bool
+	}{
+		{
+			name:     "disabled",
+			value:    "",
+			expected: map[string]bool{},
+		},
+		{
+			name:  "all webhooks",
+			value: "true",
+			expected: map[string]bool{
+				"floatingip":             true,
+				"floatingippool":         true,
+				"floatingipprojectquota": true,
+				"service":                true,
+			},
+		},
+		{
+			name:  "single webhook",
+			value: "FloatingIPPool, unknown",
+			expected: map[string]bool{
+				"floatingippool": true,
+			},
+		},
+		{
+			name:  "service webhook",
+			value: "floatingip,Service",
+			expected: map[string]bool{
+				"floatingip": true,
+				"service":    true,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, parseAuditMode(tc.value))
+		})
+	}
+}
+
+func TestParseForbiddenRanges(t *testing.T) {
+	cidr := func(s string) *net.IPNet {
+		_, ipNet, _ := net.ParseCIDR(s)
+		return ipNet
+	}
+
+	testCases := []struct {
+		name     string
+		value    string
+		expected []*net.IPNet
+	}{
+		{
+			name:     "defaults",
+			value:    "",
+			expected: nil,
+		},
+		{
+			name:     "disabled",
+			value:    "None",
+			expected: []*net.IPNet{},
+		},
+		{
+			name:     "list with an invalid range",
+			value:    "127.0.0.0/8, 10.0.0.1, fe80::/10",
+			expected: []*net.IPNet{cidr("127.0.0.0/8"), cidr("fe80::/10")},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, parseForbiddenRanges(tc.value))
+		})
+	}
+}
+
+func TestNewProber(t *testing.T) {
+	assert.Nil(t, newProber(&appConfig{}))
+	assert.Nil(t, newProber(&appConfig{prober: "arp"}))
+	assert.IsType(t, &service.ICMPProber{}, newProber(&appConfig{prober: "ICMP"}))
+
+	prober, ok := newProber(&appConfig{prober: "https://prober:8443/probe", probeTimeout: 500}).(*service.HTTPProber)
+	assert.True(t, ok)
+	assert.Equal(t, "https://prober:8443/probe", prober.URL)
+	assert.Equal(t, 500*time.Millisecond, prober.Client.Timeout)
+}
+
+func TestParseCABundleSource(t *testing.T) {
+	testCases := []struct {
+		name     string
+		envVars  map[string]string
+		expected admission.CABundleSource
+	}{
+		{
+			name:     "default configmap",
+			envVars:  map[string]string{},
+			expected: admission.DefaultCABundleSource(),
+		},
+		{
+			name: "secret",
+			envVars: map[string]string{
+				"CABUNDLESOURCE":    "Secret",
+				"CABUNDLENAMESPACE": "cert-manager",
+				"CABUNDLENAME":      "webhook-ca",
+			},
+			expected: admission.CABundleSource{
+				Type:      "secret",
+				Namespace: "cert-manager",
+				Name:      "webhook-ca",
+				Key:       "ca.crt",
+			},
+		},
+		{
+			name: "file",
+			envVars: map[string]string{
+				"CABUNDLESOURCE": "file",
+				"CABUNDLEFILE":   "/etc/webhook/ca.crt",
+			},
+			expected: admission.CABundleSource{
+				Type:      "file",
+				Namespace: "kube-system",
+				Name:      "kube-root-ca.crt",
+				Key:       "ca.crt",
+				File:      "/etc/webhook/ca.crt",
+			},
+		},
+		{
+			name: "unknown source",
+			envVars: map[string]string{
+				"CABUNDLESOURCE": "vault",
+			},
+			expected: admission.DefaultCABundleSource(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.envVars {
+				os.Setenv(key, value)
+				defer os.Unsetenv(key)
+			}
+
+			assert.Equal(t, tc.expected, parseCABundleSource())
+		})
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	testCases := []struct {
+		name          string
+		args          []string
+		expected      string
+		expectedError string
+	}{
+		{
+			name:     "serve by default",
+			args:     []string{},
+			expected: "serve",
+		},
+		{
+			name:     "gen-certs",
+			args:     []string{"gen-certs"},
+			expected: "gen-certs",
+		},
+		{
+			name:     "replay with a file",
+			args:     []string{"replay", "review.json"},
+			expected: "replay",
+		},
+		{
+			name:     "help flag",
+			args:     []string{"--help"},
+			expected: "help",
+		},
+		{
+			name:          "unknown command",
+			args:          []string{"install"},
+			expectedError: "unknown command install",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			command, err := parseCommand(tc.args)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, command)
+		})
+	}
+}
+
+func TestReadAdmissionReview(t *testing.T) {
+	review := `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"test-uid","kind":{"kind":"FloatingIP"}}}`
+	file := filepath.Join(t.TempDir(), "review.json")
+	assert.NoError(t, os.WriteFile(file, []byte(review), 0600))
+
+	ar, err := readAdmissionReview([]string{file}, strings.NewReader(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "test-uid", string(ar.Request.UID))
+
+	ar, err = readAdmissionReview([]string{"-"}, strings.NewReader(review))
+	assert.NoError(t, err)
+	assert.Equal(t, "FloatingIP", ar.Request.Kind.Kind)
+
+	_, err = readAdmissionReview(nil, strings.NewReader(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`))
+	assert.EqualError(t, err, "AdmissionReview from stdin contains no request")
+
+	_, err = readAdmissionReview(nil, strings.NewReader("not json"))
+	assert.ErrorContains(t, err, "cannot decode AdmissionReview from stdin")
+
+	_, err = readAdmissionReview([]string{file, file}, strings.NewReader(""))
+	assert.EqualError(t, err, "replay takes a single AdmissionReview file")
+}
+
+func TestCheckPermissions(t *testing.T) {
+	cfg := &appConfig{
+		webhookNamespace:  "rancher-fip-manager",
+		webhookConfigName: "rancher-fip-manager-validator",
+		secretName:        "rancher-fip-manager-webhook-tls",
+		caBundle:          admission.DefaultCABundleSource(),
+		validateCluster:   true,
+	}
+
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = attributes.Resource != "clusters" && attributes.Resource != "configmaps" && attributes.Group != "kubevirt.io"
+		return true, review, nil
+	})
+	clients := &util.Clients{Clientset: clientset}
+
+	err := checkPermissions(context.Background(), clients, cfg)
+	assert.EqualError(t, err, "the webhook is missing the permissions to: get configmaps named kube-root-ca.crt in namespace kube-system, list clusters.management.cattle.io")
+
+	// the missing kubevirt permission is only logged
+	cfg.validateCluster = false
+	cfg.caBundle = admission.CABundleSource{Type: admission.CABundleSourceServiceAccount}
+	assert.NoError(t, checkPermissions(context.Background(), clients, cfg))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/admission"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/service"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// requiredPermissions returns the permissions which the webhook needs with the
+// given configuration, they match the RBAC rules in deployments/deployment.yaml
+// together with the targetPermissions.
+func requiredPermissions(cfg *appConfig) []util.Permission {
+	resources := cfg.apiResources
+	permissions := []util.Permission{
+		// certificate management
+		{Verb: "create", Group: "certificates.k8s.io", Resource: "certificatesigningrequests"},
+		{Verb: "get", Group: "certificates.k8s.io", Resource: "certificatesigningrequests"},
+		{Verb: "delete", Group: "certificates.k8s.io", Resource: "certificatesigningrequests"},
+		{Verb: "update", Group: "certificates.k8s.io", Resource: "certificatesigningrequests", Subresource: "approval"},
+		{Verb: "approve", Group: "certificates.k8s.io", Resource: "signers", Name: "kubernetes.io/kubelet-serving"},
+		{Verb: "create", Resource: "secrets", Namespace: cfg.webhookNamespace},
+		{Verb: "get", Resource: "secrets", Namespace: cfg.webhookNamespace, Name: cfg.secretName},
+		{Verb: "update", Resource: "secrets", Namespace: cfg.webhookNamespace, Name: cfg.secretName},
+		// validation
+		{Verb: "get", Resource: "namespaces"},
+		{Verb: "get", Group: resources.Group, Resource: resources.FloatingIPPools},
+		{Verb: "list", Group: resources.Group, Resource: resources.FloatingIPPools},
+		{Verb: "get", Group: resources.Group, Resource: resources.FloatingIPProjectQuotas},
+		{Verb: "list", Group: resources.Group, Resource: resources.FloatingIPs},
+	}
+
+	// webhook registration, which is skipped when the webhook configuration
+	// is managed externally
+	if !cfg.externalVWC {
+		permissions = append(permissions,
+			util.Permission{Verb: "create", Group: "admissionregistration.k8s.io", Resource: "validatingwebhookconfigurations"},
+			util.Permission{Verb: "get", Group: "admissionregistration.k8s.io", Resource: "validatingwebhookconfigurations", Name: cfg.webhookConfigName},
+			util.Permission{Verb: "update", Group: "admissionregistration.k8s.io", Resource: "validatingwebhookconfigurations", Name: cfg.webhookConfigName},
+		)
+		switch cfg.caBundle.Type {
+		case admission.CABundleSourceConfigMap:
+			permissions = append(permissions, util.Permission{Verb: "get", Resource: "configmaps", Namespace: cfg.caBundle.Namespace, Name: cfg.caBundle.Name})
+		case admission.CABundleSourceSecret:
+			permissions = append(permissions, util.Permission{Verb: "get", Resource: "secrets", Namespace: cfg.caBundle.Namespace, Name: cfg.caBundle.Name})
+		}
+	}
+	if cfg.reservations {
+		permissions = append(permissions, util.Permission{Verb: "update", Group: resources.Group, Resource: resources.FloatingIPPools})
+	}
+	if cfg.poolIndex {
+		permissions = append(permissions, util.Permission{Verb: "watch", Group: resources.Group, Resource: resources.FloatingIPPools})
+	}
+	if cfg.validateServices {
+		permissions = append(permissions, util.Permission{Verb: "get", Group: resources.Group, Resource: resources.FloatingIPs})
+	}
+	if cfg.validateCluster {
+		permissions = append(permissions, util.Permission{Verb: "list", Group: "management.cattle.io", Resource: "clusters"})
+	}
+	if cfg.validateProject {
+		permissions = append(permissions, util.Permission{Verb: "get", Group: "management.cattle.io", Resource: "projects"})
+	}
+	if cfg.nodeConflicts {
+		permissions = append(permissions, util.Permission{Verb: "list", Resource: "nodes"})
+	}
+	if cfg.lbConflicts != "" {
+		permissions = append(permissions,
+			util.Permission{Verb: "list", Group: "metallb.io", Resource: "ipaddresspools"},
+			util.Permission{Verb: "get", Resource: "configmaps", Namespace: service.KubeVIPConfigMapNamespace, Name: service.KubeVIPConfigMapName},
+		)
+	}
+	if len(cfg.utilThresholds) > 0 {
+		permissions = append(permissions, util.Permission{Verb: "create", Resource: "events", Namespace: "default"})
+	}
+	if cfg.authenticate || cfg.previewAPI {
+		permissions = append(permissions, util.Permission{Verb: "create", Group: "authentication.k8s.io", Resource: "tokenreviews"})
+	}
+	if cfg.blocklist != "" {
+		permissions = append(permissions, util.Permission{Verb: "get", Resource: "configmaps", Namespace: cfg.webhookNamespace, Name: cfg.blocklist})
+	}
+	if cfg.breakGlass != "" {
+		permissions = append(permissions, util.Permission{Verb: "get", Resource: "configmaps", Namespace: cfg.webhookNamespace, Name: cfg.breakGlass})
+	}
+	if cfg.previewAPI {
+		permissions = append(permissions, util.Permission{Verb: "create", Group: "authorization.k8s.io", Resource: "subjectaccessreviews"})
+	}
+	if cfg.createService {
+		permissions = append(permissions,
+			util.Permission{Verb: "create", Resource: "services", Namespace: cfg.webhookNamespace},
+			util.Permission{Verb: "get", Resource: "services", Namespace: cfg.webhookNamespace, Name: cfg.serviceName},
+			util.Permission{Verb: "update", Resource: "services", Namespace: cfg.webhookNamespace, Name: cfg.serviceName},
+		)
+	}
+	if cfg.conversion && !cfg.externalVWC {
+		for _, crd := range resources.CRDNames() {
+			permissions = append(permissions, util.Permission{Verb: "patch", Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions", Name: crd})
+		}
+	}
+
+	return permissions
+}
+
+// targetPermissions are the permissions to look up the targets of the
+// target-vm and target annotations. They are only needed by FloatingIPs with
+// these annotations, so the webhook also starts without them, for example in
+// clusters without KubeVirt.
+func targetPermissions() []util.Permission {
+	return []util.Permission{
+		{Verb: "get", Group: "kubevirt.io", Resource: "virtualmachines"},
+		{Verb: "get", Resource: "services"},
+		{Verb: "get", Group: "apps", Resource: "deployments"},
+		{Verb: "get", Group: "apps", Resource: "statefulsets"},
+		{Verb: "get", Group: "apps", Resource: "daemonsets"},
+	}
+}
+
+// checkPermissions returns an error listing all permissions which the webhook
+// is missing, so a broken RBAC setup is reported at startup instead of by the
+// first API call which fails. Missing target permissions are only logged.
+func checkPermissions(ctx context.Context, clients *util.Clients, cfg *appConfig) error {
+	missing, err := util.MissingPermissions(ctx, clients.Clientset, requiredPermissions(cfg))
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the webhook is missing the permissions to: %s", permissionList(missing))
+	}
+
+	missing, err = util.MissingPermissions(ctx, clients.Clientset, targetPermissions())
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		log.Warnf("the webhook is missing the permissions to: %s, FloatingIPs with the %s or %s annotation are denied",
+			permissionList(missing), service.TargetVMAnnotation, service.TargetAnnotation)
+	}
+
+	return nil
+}
+
+func permissionList(permissions []util.Permission) string {
+	var list []string
+	for _, p := range permissions {
+		list = append(list, p.String())
+	}
+
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/service"
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// replay runs a captured AdmissionReview through the validators and prints it
+// with the response, so a denial can be reproduced against the current state
+// of the cluster. The request is handled as a dry-run, so no IP reservations
+// are written, and the audit mode is not applied.
+func replay(cfg *appConfig, args []string) {
+	// the AdmissionReview is printed on stdout
+	log.SetOutput(os.Stderr)
+
+	ar, err := readAdmissionReview(args, os.Stdin)
+	if err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+	dryRun := true
+	ar.Request.DryRun = &dryRun
+
+	ctx := context.Background()
+	serviceHandler := service.Register(ctx, newClients(cfg), serviceOptions(cfg))
+	response, err := serviceHandler.Review(ctx, ar)
+	if err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+	ar.Response = response
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(ar); err != nil {
+		log.Fatalf("cannot encode AdmissionReview: %s", err.Error())
+	}
+}
+
+// readAdmissionReview reads the AdmissionReview from the file in args, or
+// from stdin when no file or "-" is given.
+func readAdmissionReview(args []string, stdin io.Reader) (*admissionv1.AdmissionReview, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("replay takes a single AdmissionReview file")
+	}
+
+	source := "stdin"
+	reader := stdin
+	if len(args) == 1 && args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot open AdmissionReview: %s", err)
+		}
+		defer file.Close()
+		source = args[0]
+		reader = file
+	}
+
+	ar := &admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(reader).Decode(ar); err != nil {
+		return nil, fmt.Errorf("cannot decode AdmissionReview from %s: %s", source, err)
+	}
+	if ar.Request == nil {
+		return nil, fmt.Errorf("AdmissionReview from %s contains no request", source)
+	}
+
+	return ar, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/scheduler"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/service"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/version"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// serve runs the webhook server until it receives a shutdown signal.
+func serve(cfg *appConfig) {
+	log.WithFields(log.Fields{
+		"version":   version.Version,
+		"gitCommit": version.GitCommit,
+		"buildDate": version.BuildDate,
+	}).Infof("starting %s %s", progname, version.String())
+
+	policy := renewalPolicy(cfg)
+	if cfg.certDuration > 0 && cfg.certRenewalPct == 0 && cfg.certDuration <= cfg.certRenewalPeriod {
+		log.Warnf("CERTDURATION (%d) is not longer than CERTRENEWALPERIOD (%d), the certificate will be renewed on every check", cfg.certDuration, cfg.certRenewalPeriod)
+	}
+
+	if err := checkWebhookServer(cfg); err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	clients := newClients(cfg)
+	if err := checkPermissions(ctx, clients, cfg); err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+	configHandler := newConfigHandler(ctx, clients, cfg)
+	admissionHandler := newAdmissionHandler(ctx, clients, cfg)
+	options := serviceOptions(cfg)
+	options.GetCertificate = configHandler.GetCertificate
+	serviceHandler := service.Register(ctx, clients, options)
+
+	configHandler.Init()
+	if err := configHandler.Run(policy); err != nil {
+		log.Errorf("%s", err.Error())
+	}
+	admissionHandler.Init()
+	scheduler.StartCertRenewalScheduler(
+		ctx,
+		configHandler,
+		scheduler.Options{
+			Renewal:       policy,
+			CheckInterval: time.Duration(cfg.certCheckInterval) * time.Minute,
+			Jitter:        time.Duration(cfg.certCheckJitter) * time.Minute,
+		},
+	)
+	serviceHandler.Watch()
+	go serviceHandler.Run()
+
+	for webhook := range cfg.auditMode {
+		log.Warnf("audit mode is enabled for webhook %s, denied requests will be allowed", webhook)
+	}
+
+	log.Infof("%s is running", progname)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	log.Infof("%s received shutdown signal, gracefully shutting down...", progname)
+	cancel()
+	os.Exit(0)
+}
+
+// serviceOptions returns the options of the admission service.
+func serviceOptions(cfg *appConfig) service.Options {
+	return service.Options{
+		AuditMode:             cfg.auditMode,
+		ProjectFromNamespace:  cfg.projectFromNs,
+		Reservations:          cfg.reservations,
+		ReservationTTL:        time.Duration(cfg.reservationTTL) * time.Minute,
+		QuotaUnknownPoolsWarn: cfg.quotaPoolsWarn,
+		MaxPoolSize:           cfg.maxPoolSize,
+		ApprovalPoolSize:      cfg.approvalPoolSize,
+		ApprovalGroups:        cfg.approvalGroups,
+		MaxFloatingIPs:        int(cfg.maxFloatingIPs),
+		HierarchicalQuotas:    cfg.hierarchicalQuota,
+		ValidateTargetCluster: cfg.validateCluster,
+		ValidateProject:       cfg.validateProject,
+		MaxRequestBytes:       cfg.maxRequestBytes,
+		ReadTimeout:           time.Duration(cfg.readTimeout) * time.Second,
+		WriteTimeout:          time.Duration(cfg.writeTimeout) * time.Second,
+		IdleTimeout:           time.Duration(cfg.idleTimeout) * time.Second,
+		ReadHeaderTimeout:     time.Duration(cfg.readHeaderTimeout) * time.Second,
+		DisableHTTP2:          cfg.disableHTTP2,
+		Server:                cfg.webhookServer,
+		CertDir:               cfg.certDir,
+		AuthenticateRequests:  cfg.authenticate,
+		AuthenticatedUsers:    cfg.authUsers,
+		PreviewAPI:            cfg.previewAPI,
+		SelfTest:              cfg.selfTest,
+		APIResources:          cfg.apiResources,
+		Conversion:            cfg.conversion,
+		InternalFailurePolicy: cfg.failurePolicy,
+		BreakerFailures:       int(cfg.breakerFailures),
+		BreakerTimeout:        time.Duration(cfg.breakerTimeout) * time.Second,
+		DegradedPolicy:        cfg.degradedPolicy,
+		DecisionCacheTTL:      time.Duration(cfg.decisionCacheTTL) * time.Second,
+		PoolIndex:             cfg.poolIndex,
+		ValidateServices:      cfg.validateServices,
+		TargetWarnOnly:        cfg.targetWarnOnly,
+		AccessLog:             cfg.accessLog,
+		MaxInFlight:           int(cfg.maxInFlight),
+		MaxQueued:             int(cfg.maxQueued),
+		RateLimit:             int(cfg.rateLimit),
+		RateLimitBurst:        int(cfg.rateLimitBurst),
+		RequestBudget:         int(cfg.requestBudget),
+		ForbiddenRanges:       cfg.forbiddenRanges,
+		AddressSpace:          cfg.addressSpace,
+		ProjectAddressSpaces:  cfg.projectSpaces,
+		ClusterCIDRs:          cfg.clusterCIDRs,
+		ValidateNodeConflicts: cfg.nodeConflicts,
+		LoadBalancerConflicts: cfg.lbConflicts,
+		Prober:                newProber(cfg),
+		ProbeMode:             cfg.probeMode,
+		ProbeTimeout:          time.Duration(cfg.probeTimeout) * time.Millisecond,
+		UtilizationThresholds: cfg.utilThresholds,
+		MissingQuotaPolicy:    cfg.missingQuota,
+		MissingQuotaLimit:     int(cfg.missingQuotaLimit),
+		QuotaOverrideGroups:   cfg.overrideGroups,
+		NamespaceOverrides:    cfg.nsOverrides,
+		RequiredLabels:        cfg.requiredLabels,
+		RequiredAnnotations:   cfg.requiredAnnots,
+		MaxLease:              cfg.maxLease,
+		ProjectMaxLeases:      cfg.projectLeases,
+		BlocklistConfigMap:    types.NamespacedName{Namespace: cfg.webhookNamespace, Name: cfg.blocklist},
+		BreakGlassConfigMap:   types.NamespacedName{Namespace: cfg.webhookNamespace, Name: cfg.breakGlass},
+		ControllerUsers:       cfg.controllerUsers,
+		MaintenanceWindows:    cfg.maintWindows,
+		MaintenanceLocation:   cfg.maintLocation,
+		MaintenanceGroups:     cfg.maintGroups,
+		DeleteConfirmation:    cfg.deleteConfirm,
+		DeleteConfirmGroups:   cfg.deleteGroups,
+	}
+}
+
+// newProber returns the prober of the PROBER setting, which is "icmp" to
+// probe from the webhook or the URL of a prober service. Requested IPs are
+// not probed when it is not set.
+func newProber(cfg *appConfig) service.Prober {
+	switch {
+	case cfg.prober == "":
+		return nil
+	case strings.EqualFold(cfg.prober, "icmp"):
+		return &service.ICMPProber{}
+	case strings.HasPrefix(cfg.prober, "http://"), strings.HasPrefix(cfg.prober, "https://"):
+		return &service.HTTPProber{
+			URL:    cfg.prober,
+			Client: &http.Client{Timeout: time.Duration(cfg.probeTimeout) * time.Millisecond},
+		}
+	default:
+		log.Warnf("ignoring unknown PROBER %s, requested IPs are not probed", cfg.prober)
+		return nil
+	}
+}
@@ -7,46 +7,161 @@ import (
 	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
 	log "github.com/sirupsen/logrus"
 	admregv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
+// DefaultAPIVersions are the API versions of the FloatingIP resources which
+// are validated when the APIVersions option is not set.
+var DefaultAPIVersions = []string{"v1beta2", "v1beta1"}
+
+// Options holds the settings of the webhook configuration.
+type Options struct {
+	// CABundle is the source of the caBundle in the webhook configuration.
+	CABundle CABundleSource
+	// ServiceName is the name of the service the API server sends the
+	// admission requests to, the webhook name is used when it is empty.
+	ServiceName string
+	// FloatingIPStatus sends the updates of the FloatingIP status subresource
+	// to the webhook, to restrict them to the controller.
+	FloatingIPStatus bool
+	// PoolStatus sends the updates of the FloatingIPPool status subresource
+	// to the webhook, to restrict them to the controller.
+	PoolStatus bool
+	// PoolDeletes sends FloatingIPPool DELETE requests to the webhook, for
+	// the validators which check deletes.
+	PoolDeletes bool
+	// APIResources are the API group and resource names of the FloatingIP
+	// CRDs, the DefaultAPIResources are used when the group is empty.
+	APIResources util.APIResources
+	// APIVersions are the API versions of the FloatingIP resources in the
+	// rules of the webhooks, the DefaultAPIVersions are used when it is empty.
+	APIVersions []string
+	// MatchConditions are the CEL matchConditions of the webhooks, requests
+	// which don't match all conditions are not sent to the webhook.
+	MatchConditions []admregv1.MatchCondition
+	// MatchPolicy is the matchPolicy of the webhooks, Equivalent is used when
+	// it is empty so requests of a version which is not in the rules are sent
+	// to the webhook converted to a version which is.
+	MatchPolicy admregv1.MatchPolicyType
+	// RuleScope overrides the scope of the rules of the webhooks, the scope of
+	// the resource is used when it is empty.
+	RuleScope admregv1.ScopeType
+	// ExtraMetadata are the labels and annotations which are set on the
+	// ValidatingWebhookConfiguration.
+	ExtraMetadata util.ExtraMetadata
+	// ExternallyManaged leaves the ValidatingWebhookConfiguration and the
+	// conversion of the CRDs to an external tool like Helm or Argo CD, the
+	// webhook doesn't create, update or delete them.
+	ExternallyManaged bool
+	// Service creates and reconciles the ClusterIP Service of the webhook.
+	Service bool
+	// ServiceTargetPort is the container port of the Service, the
+	// DefaultServiceTargetPort is used when it is 0.
+	ServiceTargetPort int32
+	// Conversion points the conversion of the ConversionCRDs to the
+	// /convert endpoint of the webhook.
+	Conversion bool
+	// ServicesAnnotated sends the CREATE and UPDATE requests of core v1
+	// Services which carry one of the annotations to the webhook, Services
+	// are not sent when it is empty.
+	ServicesAnnotated []string
+}
+
 type Handler struct {
 	ctx                         context.Context
-	kubeConfig                  string
-	kubeContext                 string
 	clientset                   kubernetes.Interface
+	dynamic                     dynamic.Interface
 	webhookNamespace            string
 	webhookName                 string
 	validatingWebhookConfigName string
+	options                     Options
 }
 
-func Register(ctx context.Context, kubeConfig string, kubeContext string, webhookName string, webhookNamespace string, validatingWebhookConfigName string) *Handler {
+func Register(ctx context.Context, clientset kubernetes.Interface, dynamicClient dynamic.Interface, webhookName string, webhookNamespace string, validatingWebhookConfigName string, options Options) *Handler {
+	if options.CABundle.Type == "" {
+		options.CABundle = DefaultCABundleSource()
+	}
+
 	return &Handler{
 		ctx:                         ctx,
-		kubeConfig:                  kubeConfig,
-		kubeContext:                 kubeContext,
+		clientset:                   clientset,
+		dynamic:                     dynamicClient,
 		webhookName:                 webhookName,
 		webhookNamespace:            webhookNamespace,
 		validatingWebhookConfigName: validatingWebhookConfigName,
+		options:                     options,
 	}
 }
 
 func (h *Handler) Init() {
-	config, err := util.GetKubeConfig(h.kubeConfig, h.kubeContext)
-	if err != nil {
+	if h.options.Service {
+		if err := h.ReconcileService(); err != nil {
+			log.Panicf("%s", err.Error())
+		}
+	}
+	if h.options.ExternallyManaged {
+		log.Infof("(Init) validating webhook configuration %s is managed externally, not registering the webhook", h.validatingWebhookConfigName)
+		return
+	}
+	if err := h.AddValidatingWebhookConfiguration(); err != nil {
 		log.Panicf("%s", err.Error())
 	}
+	if h.options.Conversion {
+		if err := h.AddConversionWebhook(); err != nil {
+			log.Panicf("%s", err.Error())
+		}
+	}
+}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Panicf("%s", err.Error())
+// serviceName returns the name of the service the API server sends the
+// admission requests to.
+func (h *Handler) serviceName() string {
+	if h.options.ServiceName != "" {
+		return h.options.ServiceName
 	}
-	h.clientset = clientset
 
-	if err := h.AddValidatingWebhookConfiguration(); err != nil {
-		log.Panicf("%s", err.Error())
+	return h.webhookName
+}
+
+// apiResources returns the API group and resource names of the FloatingIP CRDs.
+func (h *Handler) apiResources() util.APIResources {
+	if h.options.APIResources.Group != "" {
+		return h.options.APIResources
+	}
+
+	return util.DefaultAPIResources()
+}
+
+// matchPolicy returns the matchPolicy of the webhooks.
+func (h *Handler) matchPolicy() *admregv1.MatchPolicyType {
+	policy := admregv1.Equivalent
+	if h.options.MatchPolicy != "" {
+		policy = h.options.MatchPolicy
+	}
+
+	return &policy
+}
+
+// ruleScope returns the scope of a rule for a resource with the given scope.
+func (h *Handler) ruleScope(scope admregv1.ScopeType) *admregv1.ScopeType {
+	if h.options.RuleScope != "" {
+		scope = h.options.RuleScope
+	}
+
+	return &scope
+}
+
+// apiVersions returns the API versions of the FloatingIP resources in the
+// rules of the webhooks.
+func (h *Handler) apiVersions() []string {
+	if len(h.options.APIVersions) > 0 {
+		return h.options.APIVersions
 	}
+
+	return DefaultAPIVersions
 }
 
 func (h *Handler) checkValidatingWebhookConfiguration() bool {
@@ -56,12 +171,12 @@ func (h *Handler) checkValidatingWebhookConfiguration() bool {
 }
 
 func (h *Handler) getRancherFloatingIPWebhook() (webhook admregv1.ValidatingWebhook, err error) {
-	cert, err := h.getCaBundleFromCABundleConfigMap()
+	cert, err := h.getCABundle()
 	if err != nil {
 		return
 	}
 
-	webhook.Name = fmt.Sprintf("floatingip-%s.%s.svc", h.webhookName, h.webhookNamespace)
+	webhook.Name = fmt.Sprintf("floatingip-%s.%s.svc", h.serviceName(), h.webhookNamespace)
 
 	matchLabels := make(map[string]string)
 	matchLabels["admission-webhook"] = "enabled"
@@ -71,23 +186,28 @@ func (h *Handler) getRancherFloatingIPWebhook() (webhook admregv1.ValidatingWebh
 	var rules []admregv1.RuleWithOperations
 
 	rule := admregv1.RuleWithOperations{}
-	rule.APIGroups = []string{"rancher.k8s.binbash.org"}
-	rule.APIVersions = []string{"v1beta2", "v1beta1"}
+	rule.APIGroups = []string{h.apiResources().Group}
+	rule.APIVersions = h.apiVersions()
 	rule.Operations = []admregv1.OperationType{"CREATE", "UPDATE"}
-	rule.Resources = []string{"floatingips"}
-	scope := admregv1.NamespacedScope
-	rule.Scope = &scope
+	rule.Resources = []string{h.apiResources().FloatingIPs}
+	if h.options.FloatingIPStatus {
+		rule.Resources = append(rule.Resources, h.apiResources().FloatingIPs+"/status")
+	}
+	rule.Scope = h.ruleScope(admregv1.NamespacedScope)
 	rules = append(rules, rule)
 	webhook.Rules = rules
+	webhook.MatchConditions = h.options.MatchConditions
+	webhook.MatchPolicy = h.matchPolicy()
 
-	sideeffects := admregv1.SideEffectClassNone
+	// the webhook can write IP reservations, which are skipped for dry-run requests
+	sideeffects := admregv1.SideEffectClassNoneOnDryRun
 	webhook.SideEffects = &sideeffects
 
 	clientconfig := admregv1.WebhookClientConfig{}
 	serviceref := admregv1.ServiceReference{}
 	serviceref.Namespace = h.webhookNamespace
-	serviceref.Name = h.webhookName
-	path := "/validate-floatingip"
+	serviceref.Name = h.serviceName()
+	path := "/validate"
 	serviceref.Path = &path
 	port := int32(8443)
 	serviceref.Port = &port
@@ -101,12 +221,12 @@ func (h *Handler) getRancherFloatingIPWebhook() (webhook admregv1.ValidatingWebh
 }
 
 func (h *Handler) getRancherFloatingIPPoolWebhook() (webhook admregv1.ValidatingWebhook, err error) {
-	cert, err := h.getCaBundleFromCABundleConfigMap()
+	cert, err := h.getCABundle()
 	if err != nil {
 		return
 	}
 
-	webhook.Name = fmt.Sprintf("floatingippool-%s.%s.svc", h.webhookName, h.webhookNamespace)
+	webhook.Name = fmt.Sprintf("floatingippool-%s.%s.svc", h.serviceName(), h.webhookNamespace)
 
 	matchLabels := make(map[string]string)
 	matchLabels["admission-webhook"] = "enabled"
@@ -116,14 +236,112 @@ func (h *Handler) getRancherFloatingIPPoolWebhook() (webhook admregv1.Validating
 	var rules []admregv1.RuleWithOperations
 
 	rule := admregv1.RuleWithOperations{}
-	rule.APIGroups = []string{"rancher.k8s.binbash.org"}
-	rule.APIVersions = []string{"v1beta2", "v1beta1"}
+	rule.APIGroups = []string{h.apiResources().Group}
+	rule.APIVersions = h.apiVersions()
 	rule.Operations = []admregv1.OperationType{"CREATE", "UPDATE"}
-	rule.Resources = []string{"floatingippools"}
-	scope := admregv1.ClusterScope
+	if h.options.PoolDeletes {
+		rule.Operations = append(rule.Operations, "DELETE")
+	}
+	rule.Resources = []string{h.apiResources().FloatingIPPools}
+	if h.options.PoolStatus {
+		rule.Resources = append(rule.Resources, h.apiResources().FloatingIPPools+"/status")
+	}
+	rule.Scope = h.ruleScope(admregv1.ClusterScope)
+	rules = append(rules, rule)
+	webhook.Rules = rules
+	webhook.MatchConditions = h.options.MatchConditions
+	webhook.MatchPolicy = h.matchPolicy()
+
+	sideeffects := admregv1.SideEffectClassNone
+	webhook.SideEffects = &sideeffects
+
+	clientconfig := admregv1.WebhookClientConfig{}
+	serviceref := admregv1.ServiceReference{}
+	serviceref.Namespace = h.webhookNamespace
+	serviceref.Name = h.serviceName()
+	path := "/validate"
+	serviceref.Path = &path
+	port := int32(8443)
+	serviceref.Port = &port
+	clientconfig.Service = &serviceref
+	clientconfig.CABundle = []byte(cert)
+	webhook.ClientConfig = clientconfig
+
+	webhook.AdmissionReviewVersions = []string{"v1"}
+
+	return
+}
+
+func (h *Handler) getRancherFloatingIPProjectQuotaWebhook() (webhook admregv1.ValidatingWebhook, err error) {
+	cert, err := h.getCABundle()
+	if err != nil {
+		return
+	}
+
+	webhook.Name = fmt.Sprintf("floatingipprojectquota-%s.%s.svc", h.serviceName(), h.webhookNamespace)
+
+	nameSpaceSelector := metav1.LabelSelector{}
+	webhook.NamespaceSelector = &nameSpaceSelector
+
+	var rules []admregv1.RuleWithOperations
+
+	rule := admregv1.RuleWithOperations{}
+	rule.APIGroups = []string{h.apiResources().Group}
+	rule.APIVersions = h.apiVersions()
+	rule.Operations = []admregv1.OperationType{"CREATE", "UPDATE", "DELETE"}
+	rule.Resources = []string{h.apiResources().FloatingIPProjectQuotas}
+	rule.Scope = h.ruleScope(admregv1.ClusterScope)
+	rules = append(rules, rule)
+	webhook.Rules = rules
+	webhook.MatchConditions = h.options.MatchConditions
+	webhook.MatchPolicy = h.matchPolicy()
+
+	sideeffects := admregv1.SideEffectClassNone
+	webhook.SideEffects = &sideeffects
+
+	clientconfig := admregv1.WebhookClientConfig{}
+	serviceref := admregv1.ServiceReference{}
+	serviceref.Namespace = h.webhookNamespace
+	serviceref.Name = h.serviceName()
+	path := "/validate"
+	serviceref.Path = &path
+	port := int32(8443)
+	serviceref.Port = &port
+	clientconfig.Service = &serviceref
+	clientconfig.CABundle = []byte(cert)
+	webhook.ClientConfig = clientconfig
+
+	webhook.AdmissionReviewVersions = []string{"v1"}
+
+	return
+}
+
+func (h *Handler) getServiceWebhook() (webhook admregv1.ValidatingWebhook, err error) {
+	cert, err := h.getCABundle()
+	if err != nil {
+		return
+	}
+
+	webhook.Name = fmt.Sprintf("service-%s.%s.svc", h.serviceName(), h.webhookNamespace)
+
+	nameSpaceSelector := metav1.LabelSelector{}
+	webhook.NamespaceSelector = &nameSpaceSelector
+
+	var rules []admregv1.RuleWithOperations
+
+	rule := admregv1.RuleWithOperations{}
+	rule.APIGroups = []string{""}
+	rule.APIVersions = []string{"v1"}
+	rule.Operations = []admregv1.OperationType{"CREATE", "UPDATE"}
+	rule.Resources = []string{"services"}
+	scope := admregv1.NamespacedScope
 	rule.Scope = &scope
 	rules = append(rules, rule)
 	webhook.Rules = rules
+	// only the Services which carry one of the annotations are sent
+	webhook.MatchConditions = append(append([]admregv1.MatchCondition{}, h.options.MatchConditions...),
+		AnnotationsMatchCondition(h.options.ServicesAnnotated))
+	webhook.MatchPolicy = h.matchPolicy()
 
 	sideeffects := admregv1.SideEffectClassNone
 	webhook.SideEffects = &sideeffects
@@ -131,8 +349,8 @@ func (h *Handler) getRancherFloatingIPPoolWebhook() (webhook admregv1.Validating
 	clientconfig := admregv1.WebhookClientConfig{}
 	serviceref := admregv1.ServiceReference{}
 	serviceref.Namespace = h.webhookNamespace
-	serviceref.Name = h.webhookName
-	path := "/validate-floatingippool"
+	serviceref.Name = h.serviceName()
+	path := "/validate"
 	serviceref.Path = &path
 	port := int32(8443)
 	serviceref.Port = &port
@@ -145,27 +363,89 @@ func (h *Handler) getRancherFloatingIPPoolWebhook() (webhook admregv1.Validating
 	return
 }
 
+// AddValidatingWebhookConfiguration creates the webhook configuration. An
+// existing configuration is updated, so webhooks which are added in a new
+// release are registered on upgrade.
 func (h *Handler) AddValidatingWebhookConfiguration() (err error) {
-	if h.checkValidatingWebhookConfiguration() {
+	vwc, err := h.ValidatingWebhookConfiguration()
+	if err != nil {
+		return
+	}
+
+	existing, err := h.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), h.validatingWebhookConfigName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = h.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Create(context.TODO(), vwc, metav1.CreateOptions{})
 		return
 	}
+	if err != nil {
+		return fmt.Errorf("cannot get validating webhook configuration: %s", err.Error())
+	}
 
+	existing.Labels = vwc.Labels
+	h.options.ExtraMetadata.Apply(&existing.ObjectMeta)
+	existing.Webhooks = vwc.Webhooks
+	_, err = h.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Update(context.TODO(), existing, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot update validating webhook configuration: %s", err.Error())
+	}
+
+	return
+}
+
+// ValidatingWebhookConfiguration returns the webhook configuration which
+// sends the FloatingIP, FloatingIPPool and FloatingIPProjectQuota requests,
+// and optionally the requests of annotated Services, to the webhook service.
+func (h *Handler) ValidatingWebhookConfiguration() (*admregv1.ValidatingWebhookConfiguration, error) {
 	vwc := admregv1.ValidatingWebhookConfiguration{}
 	vwc.ObjectMeta.Name = h.validatingWebhookConfigName
+	vwc.ObjectMeta.Labels = util.ManagedLabels(h.webhookName, "webhook-configuration")
+	h.options.ExtraMetadata.Apply(&vwc.ObjectMeta)
 
 	rancherFloatingIPWebhook, err := h.getRancherFloatingIPWebhook()
 	if err != nil {
-		return
+		return nil, err
 	}
 	vwc.Webhooks = append(vwc.Webhooks, rancherFloatingIPWebhook)
 
 	rancherFloatingIPPoolWebhook, err := h.getRancherFloatingIPPoolWebhook()
 	if err != nil {
-		return
+		return nil, err
 	}
 	vwc.Webhooks = append(vwc.Webhooks, rancherFloatingIPPoolWebhook)
 
-	_, err = h.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Create(context.TODO(), &vwc, metav1.CreateOptions{})
+	rancherFloatingIPProjectQuotaWebhook, err := h.getRancherFloatingIPProjectQuotaWebhook()
+	if err != nil {
+		return nil, err
+	}
+	vwc.Webhooks = append(vwc.Webhooks, rancherFloatingIPProjectQuotaWebhook)
+
+	if len(h.options.ServicesAnnotated) > 0 {
+		serviceWebhook, err := h.getServiceWebhook()
+		if err != nil {
+			return nil, err
+		}
+		vwc.Webhooks = append(vwc.Webhooks, serviceWebhook)
+	}
 
-	return
+	return &vwc, nil
+}
+
+// DeleteValidatingWebhookConfiguration removes the webhook configuration, so
+// FloatingIP operations are no longer sent to the webhook.
+func (h *Handler) DeleteValidatingWebhookConfiguration() error {
+	if h.options.ExternallyManaged {
+		log.Infof("(DeleteValidatingWebhookConfiguration) validating webhook configuration %s is managed externally, not removing it", h.validatingWebhookConfigName)
+		return nil
+	}
+
+	err := h.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(context.TODO(), h.validatingWebhookConfigName, metav1.DeleteOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("cannot delete validating webhook configuration: %s", err.Error())
+	}
+	log.Infof("(DeleteValidatingWebhookConfiguration) removed validating webhook configuration %s", h.validatingWebhookConfigName)
+
+	return nil
 }
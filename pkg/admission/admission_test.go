@@ -0,0 +1,275 @@
+package admission
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDeleteValidatingWebhookConfiguration(t *testing.T) {
+	h := &Handler{
+		clientset: fake.NewSimpleClientset(&admregv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-validator"},
+		}),
+		validatingWebhookConfigName: "my-validator",
+	}
+
+	assert.True(t, h.checkValidatingWebhookConfiguration())
+	assert.NoError(t, h.DeleteValidatingWebhookConfiguration())
+	assert.False(t, h.checkValidatingWebhookConfiguration())
+
+	// removing a missing webhook configuration is not an error
+	_, err := h.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "my-validator", metav1.GetOptions{})
+	assert.Error(t, err)
+	assert.NoError(t, h.DeleteValidatingWebhookConfiguration())
+}
+
+func TestExternallyManagedWebhookConfiguration(t *testing.T) {
+	h := &Handler{
+		clientset: fake.NewSimpleClientset(&admregv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-validator"},
+		}),
+		validatingWebhookConfigName: "my-validator",
+		options:                     Options{ExternallyManaged: true, Conversion: true},
+	}
+
+	// the externally managed configuration is neither updated nor removed,
+	// Init would panic when it read the missing caBundle
+	h.Init()
+	vwc, err := h.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "my-validator", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, vwc.Webhooks)
+
+	assert.NoError(t, h.DeleteValidatingWebhookConfiguration())
+	assert.True(t, h.checkValidatingWebhookConfiguration())
+}
+
+func TestAddValidatingWebhookConfiguration(t *testing.T) {
+	h := &Handler{
+		clientset: fake.NewSimpleClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "kube-system"},
+			Data:       map[string]string{"ca.crt": "configmap-ca"},
+		}),
+		webhookName:                 "my-webhook",
+		webhookNamespace:            "my-namespace",
+		validatingWebhookConfigName: "my-validator",
+		options:                     Options{CABundle: DefaultCABundleSource()},
+	}
+	webhookNames := func() []string {
+		vwc, err := h.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "my-validator", metav1.GetOptions{})
+		assert.NoError(t, err)
+		var names []string
+		for _, webhook := range vwc.Webhooks {
+			names = append(names, webhook.Name)
+		}
+		return names
+	}
+	expected := []string{
+		"floatingip-my-webhook.my-namespace.svc",
+		"floatingippool-my-webhook.my-namespace.svc",
+		"floatingipprojectquota-my-webhook.my-namespace.svc",
+	}
+
+	assert.NoError(t, h.AddValidatingWebhookConfiguration())
+	assert.Equal(t, expected, webhookNames())
+
+	// an existing configuration of an older release gets the new webhooks
+	vwc, err := h.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "my-validator", metav1.GetOptions{})
+	assert.NoError(t, err)
+	vwc.Webhooks = vwc.Webhooks[:2]
+	_, err = h.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Update(context.TODO(), vwc, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.AddValidatingWebhookConfiguration())
+	assert.Equal(t, expected, webhookNames())
+}
+
+func TestValidatingWebhookConfigurationServiceName(t *testing.T) {
+	h := &Handler{
+		clientset: fake.NewSimpleClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "kube-system"},
+			Data:       map[string]string{"ca.crt": "configmap-ca"},
+		}),
+		webhookName:                 "my-webhook",
+		webhookNamespace:            "my-namespace",
+		validatingWebhookConfigName: "my-validator",
+		options:                     Options{CABundle: DefaultCABundleSource(), ServiceName: "my-service"},
+	}
+
+	vwc, err := h.ValidatingWebhookConfiguration()
+	assert.NoError(t, err)
+	assert.Equal(t, "my-validator", vwc.Name)
+	for _, webhook := range vwc.Webhooks {
+		assert.Equal(t, "my-service", webhook.ClientConfig.Service.Name)
+		assert.Equal(t, "my-namespace", webhook.ClientConfig.Service.Namespace)
+		assert.Contains(t, webhook.Name, "-my-service.my-namespace.svc")
+	}
+}
+
+func TestValidatingWebhookConfigurationPoolDeletes(t *testing.T) {
+	h := &Handler{
+		clientset: fake.NewSimpleClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "kube-system"},
+			Data:       map[string]string{"ca.crt": "configmap-ca"},
+		}),
+		webhookName:                 "my-webhook",
+		webhookNamespace:            "my-namespace",
+		validatingWebhookConfigName: "my-validator",
+		options:                     Options{CABundle: DefaultCABundleSource()},
+	}
+	poolOperations := func() []admregv1.OperationType {
+		vwc, err := h.ValidatingWebhookConfiguration()
+		assert.NoError(t, err)
+		return vwc.Webhooks[1].Rules[0].Operations
+	}
+
+	assert.Equal(t, []admregv1.OperationType{"CREATE", "UPDATE"}, poolOperations())
+
+	h.options.PoolDeletes = true
+	assert.Equal(t, []admregv1.OperationType{"CREATE", "UPDATE", "DELETE"}, poolOperations())
+}
+
+func TestValidatingWebhookConfigurationFloatingIPStatus(t *testing.T) {
+	h := &Handler{
+		clientset: fake.NewSimpleClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "kube-system"},
+			Data:       map[string]string{"ca.crt": "configmap-ca"},
+		}),
+		webhookName:                 "my-webhook",
+		webhookNamespace:            "my-namespace",
+		validatingWebhookConfigName: "my-validator",
+		options:                     Options{CABundle: DefaultCABundleSource()},
+	}
+	fipResources := func() []string {
+		vwc, err := h.ValidatingWebhookConfiguration()
+		assert.NoError(t, err)
+		return vwc.Webhooks[0].Rules[0].Resources
+	}
+
+	assert.Equal(t, []string{"floatingips"}, fipResources())
+
+	h.options.FloatingIPStatus = true
+	assert.Equal(t, []string{"floatingips", "floatingips/status"}, fipResources())
+}
+
+func TestValidatingWebhookConfigurationPoolStatus(t *testing.T) {
+	h := &Handler{
+		clientset: fake.NewSimpleClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "kube-system"},
+			Data:       map[string]string{"ca.crt": "configmap-ca"},
+		}),
+		webhookName:                 "my-webhook",
+		webhookNamespace:            "my-namespace",
+		validatingWebhookConfigName: "my-validator",
+		options:                     Options{CABundle: DefaultCABundleSource()},
+	}
+	poolResources := func() []string {
+		vwc, err := h.ValidatingWebhookConfiguration()
+		assert.NoError(t, err)
+		return vwc.Webhooks[1].Rules[0].Resources
+	}
+
+	assert.Equal(t, []string{"floatingippools"}, poolResources())
+
+	h.options.PoolStatus = true
+	assert.Equal(t, []string{"floatingippools", "floatingippools/status"}, poolResources())
+}
+
+func TestValidatingWebhookConfigurationServices(t *testing.T) {
+	h := &Handler{
+		clientset: fake.NewSimpleClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "kube-system"},
+			Data:       map[string]string{"ca.crt": "configmap-ca"},
+		}),
+		webhookName:                 "my-webhook",
+		webhookNamespace:            "my-namespace",
+		validatingWebhookConfigName: "my-validator",
+		options:                     Options{CABundle: DefaultCABundleSource()},
+	}
+	vwc, err := h.ValidatingWebhookConfiguration()
+	assert.NoError(t, err)
+	assert.Len(t, vwc.Webhooks, 3)
+
+	h.options.ServicesAnnotated = []string{"example.com/floatingip", "example.com/floatingippool"}
+	h.options.MatchConditions = []admregv1.MatchCondition{SkipUsersMatchCondition([]string{"admin"})}
+	vwc, err = h.ValidatingWebhookConfiguration()
+	assert.NoError(t, err)
+	assert.Len(t, vwc.Webhooks, 4)
+
+	webhook := vwc.Webhooks[3]
+	assert.Equal(t, "service-my-webhook.my-namespace.svc", webhook.Name)
+	assert.Equal(t, []string{""}, webhook.Rules[0].APIGroups)
+	assert.Equal(t, []string{"v1"}, webhook.Rules[0].APIVersions)
+	assert.Equal(t, []string{"services"}, webhook.Rules[0].Resources)
+	assert.Equal(t, []admregv1.OperationType{"CREATE", "UPDATE"}, webhook.Rules[0].Operations)
+	assert.Equal(t, []admregv1.MatchCondition{
+		SkipUsersMatchCondition([]string{"admin"}),
+		AnnotationsMatchCondition(h.options.ServicesAnnotated),
+	}, webhook.MatchConditions)
+	// the conditions of the other webhooks are not changed
+	assert.Len(t, vwc.Webhooks[0].MatchConditions, 1)
+}
+
+func TestValidatingWebhookConfigurationAPIVersions(t *testing.T) {
+	h := &Handler{
+		clientset: fake.NewSimpleClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "kube-system"},
+			Data:       map[string]string{"ca.crt": "configmap-ca"},
+		}),
+		webhookName:                 "my-webhook",
+		webhookNamespace:            "my-namespace",
+		validatingWebhookConfigName: "my-validator",
+		options:                     Options{CABundle: DefaultCABundleSource()},
+	}
+	apiVersions := func() [][]string {
+		vwc, err := h.ValidatingWebhookConfiguration()
+		assert.NoError(t, err)
+		var versions [][]string
+		for _, webhook := range vwc.Webhooks {
+			versions = append(versions, webhook.Rules[0].APIVersions)
+		}
+		return versions
+	}
+
+	assert.Equal(t, [][]string{DefaultAPIVersions, DefaultAPIVersions, DefaultAPIVersions}, apiVersions())
+
+	h.options.APIVersions = []string{"v1", "v1beta2"}
+	assert.Equal(t, [][]string{{"v1", "v1beta2"}, {"v1", "v1beta2"}, {"v1", "v1beta2"}}, apiVersions())
+}
+
+func TestValidatingWebhookConfigurationMatchPolicyAndScope(t *testing.T) {
+	h := &Handler{
+		clientset: fake.NewSimpleClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "kube-system"},
+			Data:       map[string]string{"ca.crt": "configmap-ca"},
+		}),
+		webhookName:                 "my-webhook",
+		webhookNamespace:            "my-namespace",
+		validatingWebhookConfigName: "my-validator",
+		options:                     Options{CABundle: DefaultCABundleSource()},
+	}
+	matchPolicies := func() (policies []admregv1.MatchPolicyType, scopes []admregv1.ScopeType) {
+		vwc, err := h.ValidatingWebhookConfiguration()
+		assert.NoError(t, err)
+		for _, webhook := range vwc.Webhooks {
+			policies = append(policies, *webhook.MatchPolicy)
+			scopes = append(scopes, *webhook.Rules[0].Scope)
+		}
+		return
+	}
+
+	policies, scopes := matchPolicies()
+	assert.Equal(t, []admregv1.MatchPolicyType{admregv1.Equivalent, admregv1.Equivalent, admregv1.Equivalent}, policies)
+	assert.Equal(t, []admregv1.ScopeType{admregv1.NamespacedScope, admregv1.ClusterScope, admregv1.ClusterScope}, scopes)
+
+	h.options.MatchPolicy = admregv1.Exact
+	h.options.RuleScope = admregv1.AllScopes
+	policies, scopes = matchPolicies()
+	assert.Equal(t, []admregv1.MatchPolicyType{admregv1.Exact, admregv1.Exact, admregv1.Exact}, policies)
+	assert.Equal(t, []admregv1.ScopeType{admregv1.AllScopes, admregv1.AllScopes, admregv1.AllScopes}, scopes)
+}
@@ -0,0 +1,82 @@
+package admission
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	// CABundleSourceConfigMap reads the caBundle from a key in a ConfigMap.
+	CABundleSourceConfigMap = "configmap"
+	// CABundleSourceSecret reads the caBundle from a key in a Secret.
+	CABundleSourceSecret = "secret"
+	// CABundleSourceFile reads the caBundle from a mounted file.
+	CABundleSourceFile = "file"
+	// CABundleSourceServiceAccount reads the caBundle from the CA of the in-cluster serviceaccount.
+	CABundleSourceServiceAccount = "serviceaccount"
+)
+
+// serviceAccountCAFile is the CA which is mounted in every pod with a serviceaccount token.
+const serviceAccountCAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+// CABundleSource determines where the caBundle of the webhook configuration is read from.
+type CABundleSource struct {
+	// Type is one of configmap, secret, file or serviceaccount.
+	Type string
+	// Namespace and Name of the ConfigMap or Secret.
+	Namespace string
+	Name      string
+	// Key in the ConfigMap or Secret which contains the CA.
+	Key string
+	// File is the path of the CA when the type is file.
+	File string
+}
+
+// DefaultCABundleSource returns the kube-root-ca.crt ConfigMap in kube-system.
+func DefaultCABundleSource() CABundleSource {
+	return CABundleSource{
+		Type:      CABundleSourceConfigMap,
+		Namespace: "kube-system",
+		Name:      "kube-root-ca.crt",
+		Key:       "ca.crt",
+	}
+}
+
+func (h *Handler) getCABundle() (cert string, err error) {
+	source := h.options.CABundle
+
+	switch source.Type {
+	case CABundleSourceConfigMap:
+		c, err := h.getCABundleConfigMap(source.Namespace, source.Name)
+		if err != nil {
+			return cert, fmt.Errorf("cannot get caBundle configmap %s/%s: %s", source.Namespace, source.Name, err.Error())
+		}
+		cert, exists := c.Data[source.Key]
+		if !exists {
+			return cert, fmt.Errorf("%s not found in configmap %s/%s", source.Key, source.Namespace, source.Name)
+		}
+		return cert, nil
+	case CABundleSourceSecret:
+		s, err := h.getCABundleSecret(source.Namespace, source.Name)
+		if err != nil {
+			return cert, fmt.Errorf("cannot get caBundle secret %s/%s: %s", source.Namespace, source.Name, err.Error())
+		}
+		data, exists := s.Data[source.Key]
+		if !exists {
+			return cert, fmt.Errorf("%s not found in secret %s/%s", source.Key, source.Namespace, source.Name)
+		}
+		return string(data), nil
+	case CABundleSourceFile, CABundleSourceServiceAccount:
+		file := source.File
+		if source.Type == CABundleSourceServiceAccount {
+			file = serviceAccountCAFile
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return cert, fmt.Errorf("cannot read caBundle file: %s", err.Error())
+		}
+		return string(data), nil
+	}
+
+	return cert, fmt.Errorf("unknown caBundle source type %q", source.Type)
+}
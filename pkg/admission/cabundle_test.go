@@ -0,0 +1,78 @@
+package admission
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetCABundle(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	assert.NoError(t, os.WriteFile(caFile, []byte("file-ca"), 0600))
+
+	clientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "kube-system"},
+			Data:       map[string]string{"ca.crt": "configmap-ca"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook-ca", Namespace: "cert-manager"},
+			Data:       map[string][]byte{"ca.crt": []byte("secret-ca")},
+		},
+	)
+
+	testCases := []struct {
+		name          string
+		source        CABundleSource
+		expected      string
+		expectedError string
+	}{
+		{
+			name:     "configmap",
+			source:   DefaultCABundleSource(),
+			expected: "configmap-ca",
+		},
+		{
+			name:     "secret",
+			source:   CABundleSource{Type: CABundleSourceSecret, Namespace: "cert-manager", Name: "webhook-ca", Key: "ca.crt"},
+			expected: "secret-ca",
+		},
+		{
+			name:          "missing key in secret",
+			source:        CABundleSource{Type: CABundleSourceSecret, Namespace: "cert-manager", Name: "webhook-ca", Key: "tls.crt"},
+			expectedError: "tls.crt not found in secret cert-manager/webhook-ca",
+		},
+		{
+			name:     "file",
+			source:   CABundleSource{Type: CABundleSourceFile, File: caFile},
+			expected: "file-ca",
+		},
+		{
+			name:          "unknown source",
+			source:        CABundleSource{Type: "vault"},
+			expectedError: `unknown caBundle source type "vault"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				clientset: clientset,
+				options:   Options{CABundle: tc.source},
+			}
+
+			cert, err := h.getCABundle()
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, cert)
+		})
+	}
+}
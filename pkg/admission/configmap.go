@@ -7,11 +7,6 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func (h *Handler) getCABundleConfigMap() corev1.ConfigMap {
-	configmap, err := h.clientset.CoreV1().ConfigMaps("kube-system").Get(context.TODO(), "kube-root-ca.crt", metav1.GetOptions{})
-	if err != nil {
-		return corev1.ConfigMap{}
-	}
-
-	return *configmap
+func (h *Handler) getCABundleConfigMap(namespace string, name string) (*corev1.ConfigMap, error) {
+	return h.clientset.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 }
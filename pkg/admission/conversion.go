@@ -0,0 +1,74 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// conversion returns the conversion settings of the CRDs, which send the
+// ConversionReviews to the /convert endpoint of the webhook service.
+func (h *Handler) conversion() (*apiextensionsv1.CustomResourceConversion, error) {
+	cert, err := h.getCABundle()
+	if err != nil {
+		return nil, err
+	}
+
+	path := "/convert"
+	port := int32(8443)
+
+	return &apiextensionsv1.CustomResourceConversion{
+		Strategy: apiextensionsv1.WebhookConverter,
+		Webhook: &apiextensionsv1.WebhookConversion{
+			ClientConfig: &apiextensionsv1.WebhookClientConfig{
+				Service: &apiextensionsv1.ServiceReference{
+					Namespace: h.webhookNamespace,
+					Name:      h.serviceName(),
+					Path:      &path,
+					Port:      &port,
+				},
+				CABundle: []byte(cert),
+			},
+			ConversionReviewVersions: []string{"v1"},
+		},
+	}, nil
+}
+
+// AddConversionWebhook points the conversion of the FloatingIP CRDs to the
+// webhook. CRDs which are not installed are skipped, they get the webhook
+// when the webhook is restarted after they are installed.
+func (h *Handler) AddConversionWebhook() error {
+	conversion, err := h.conversion()
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"conversion": conversion},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot marshal conversion patch: %s", err.Error())
+	}
+
+	for _, crd := range h.apiResources().CRDNames() {
+		_, err := h.dynamic.Resource(crdResource).Patch(context.TODO(), crd, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				log.Warnf("(AddConversionWebhook) crd %s is not installed, skipping conversion", crd)
+				continue
+			}
+			return fmt.Errorf("cannot set the conversion webhook of crd %s: %s", crd, err.Error())
+		}
+		log.Infof("(AddConversionWebhook) the conversion of crd %s is served by the webhook", crd)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package admission
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAddConversionWebhook(t *testing.T) {
+	crd := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": "floatingippools.rancher.k8s.binbash.org"},
+		"spec": map[string]interface{}{
+			"group":      "rancher.k8s.binbash.org",
+			"conversion": map[string]interface{}{"strategy": "None"},
+		},
+	}}
+	h := &Handler{
+		clientset: fake.NewSimpleClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "kube-system"},
+			Data:       map[string]string{"ca.crt": "configmap-ca"},
+		}),
+		dynamic:          dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), crd),
+		webhookName:      "my-webhook",
+		webhookNamespace: "my-namespace",
+		options:          Options{CABundle: DefaultCABundleSource()},
+	}
+
+	// the CRDs which are not installed are skipped
+	assert.NoError(t, h.AddConversionWebhook())
+
+	updated, err := h.dynamic.Resource(crdResource).Get(context.TODO(), "floatingippools.rancher.k8s.binbash.org", metav1.GetOptions{})
+	assert.NoError(t, err)
+	strategy, _, _ := unstructured.NestedString(updated.Object, "spec", "conversion", "strategy")
+	assert.Equal(t, "Webhook", strategy)
+	service, _, _ := unstructured.NestedMap(updated.Object, "spec", "conversion", "webhook", "clientConfig", "service")
+	assert.Equal(t, "my-namespace", service["namespace"])
+	assert.Equal(t, "my-webhook", service["name"])
+	assert.Equal(t, "/convert", service["path"])
+	assert.EqualValues(t, 8443, service["port"])
+	caBundle, _, _ := unstructured.NestedString(updated.Object, "spec", "conversion", "webhook", "clientConfig", "caBundle")
+	assert.Equal(t, "Y29uZmlnbWFwLWNh", caBundle)
+	group, _, _ := unstructured.NestedString(updated.Object, "spec", "group")
+	assert.Equal(t, "rancher.k8s.binbash.org", group)
+}
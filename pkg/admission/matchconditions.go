@@ -0,0 +1,51 @@
+package admission
+
+import (
+	"fmt"
+	"strings"
+
+	admregv1 "k8s.io/api/admissionregistration/v1"
+)
+
+// SkipUsersMatchCondition returns the matchCondition which skips the requests
+// of the given users, like the service account of the controller.
+func SkipUsersMatchCondition(users []string) admregv1.MatchCondition {
+	quoted := make([]string, 0, len(users))
+	for _, user := range users {
+		quoted = append(quoted, fmt.Sprintf("%q", user))
+	}
+
+	return admregv1.MatchCondition{
+		Name:       "skip-users",
+		Expression: fmt.Sprintf("!(request.userInfo.username in [%s])", strings.Join(quoted, ", ")),
+	}
+}
+
+// SkipLabelMatchCondition returns the matchCondition which skips the requests
+// of objects carrying the label. The object of a DELETE request is null, so
+// the old object is checked instead.
+func SkipLabelMatchCondition(label string) admregv1.MatchCondition {
+	hasLabel := func(object string) string {
+		return fmt.Sprintf("has(%s.metadata.labels) && %q in %s.metadata.labels", object, label, object)
+	}
+
+	return admregv1.MatchCondition{
+		Name:       "skip-label",
+		Expression: fmt.Sprintf("object != null ? !(%s) : !(%s)", hasLabel("object"), hasLabel("oldObject")),
+	}
+}
+
+// AnnotationsMatchCondition returns the matchCondition which only matches the
+// requests of objects carrying one of the annotations. It is meant for CREATE
+// and UPDATE requests, the object of a DELETE request is null.
+func AnnotationsMatchCondition(annotations []string) admregv1.MatchCondition {
+	conditions := make([]string, 0, len(annotations))
+	for _, annotation := range annotations {
+		conditions = append(conditions, fmt.Sprintf("%q in object.metadata.annotations", annotation))
+	}
+
+	return admregv1.MatchCondition{
+		Name:       "has-annotations",
+		Expression: fmt.Sprintf("has(object.metadata.annotations) && (%s)", strings.Join(conditions, " || ")),
+	}
+}
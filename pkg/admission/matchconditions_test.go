@@ -0,0 +1,52 @@
+package admission
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMatchConditions(t *testing.T) {
+	assert.Equal(t, admregv1.MatchCondition{
+		Name:       "skip-users",
+		Expression: `!(request.userInfo.username in ["system:serviceaccount:rancher-fip-manager:controller", "admin"])`,
+	}, SkipUsersMatchCondition([]string{"system:serviceaccount:rancher-fip-manager:controller", "admin"}))
+
+	assert.Equal(t, admregv1.MatchCondition{
+		Name: "skip-label",
+		Expression: `object != null ? !(has(object.metadata.labels) && "example.com/unmanaged" in object.metadata.labels)` +
+			` : !(has(oldObject.metadata.labels) && "example.com/unmanaged" in oldObject.metadata.labels)`,
+	}, SkipLabelMatchCondition("example.com/unmanaged"))
+
+	assert.Equal(t, admregv1.MatchCondition{
+		Name:       "has-annotations",
+		Expression: `has(object.metadata.annotations) && ("example.com/a" in object.metadata.annotations || "example.com/b" in object.metadata.annotations)`,
+	}, AnnotationsMatchCondition([]string{"example.com/a", "example.com/b"}))
+
+	h := &Handler{
+		clientset: fake.NewSimpleClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "kube-system"},
+			Data:       map[string]string{"ca.crt": "configmap-ca"},
+		}),
+		webhookName:                 "my-webhook",
+		webhookNamespace:            "my-namespace",
+		validatingWebhookConfigName: "my-validator",
+		options:                     Options{CABundle: DefaultCABundleSource()},
+	}
+	vwc, err := h.ValidatingWebhookConfiguration()
+	assert.NoError(t, err)
+	for _, webhook := range vwc.Webhooks {
+		assert.Empty(t, webhook.MatchConditions)
+	}
+
+	h.options.MatchConditions = []admregv1.MatchCondition{SkipLabelMatchCondition("example.com/unmanaged")}
+	vwc, err = h.ValidatingWebhookConfiguration()
+	assert.NoError(t, err)
+	for _, webhook := range vwc.Webhooks {
+		assert.Equal(t, h.options.MatchConditions, webhook.MatchConditions)
+	}
+}
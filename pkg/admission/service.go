@@ -0,0 +1,98 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// DefaultServiceTargetPort is the container port the webhook server listens on.
+const DefaultServiceTargetPort = 8443
+
+// webhookService returns the ClusterIP Service which the webhook configuration
+// points to. It selects the pods of the webhook Deployment, when the
+// Deployment cannot be found the app=<webhook name> label is selected.
+func (h *Handler) webhookService() *corev1.Service {
+	targetPort := h.options.ServiceTargetPort
+	if targetPort == 0 {
+		targetPort = DefaultServiceTargetPort
+	}
+
+	service := &corev1.Service{}
+	service.ObjectMeta.Name = h.serviceName()
+	service.ObjectMeta.Namespace = h.webhookNamespace
+	service.ObjectMeta.Labels = util.ManagedLabels(h.webhookName, "service")
+	h.options.ExtraMetadata.Apply(&service.ObjectMeta)
+	service.Spec.Type = corev1.ServiceTypeClusterIP
+	service.Spec.Selector = map[string]string{"app": h.webhookName}
+	service.Spec.Ports = []corev1.ServicePort{
+		{
+			Name:       "webhook",
+			Port:       8443,
+			Protocol:   corev1.ProtocolTCP,
+			TargetPort: intstr.FromInt32(targetPort),
+		},
+	}
+
+	deployment, err := h.clientset.AppsV1().Deployments(h.webhookNamespace).Get(context.TODO(), h.webhookName, metav1.GetOptions{})
+	if err != nil {
+		log.Debugf("(webhookService) cannot get deployment %s/%s, selecting the app=%s label: %s", h.webhookNamespace, h.webhookName, h.webhookName, err.Error())
+		return service
+	}
+	if deployment.Spec.Selector != nil && len(deployment.Spec.Selector.MatchLabels) > 0 {
+		service.Spec.Selector = deployment.Spec.Selector.MatchLabels
+	}
+	// the service is garbage collected when the webhook is uninstalled
+	service.ObjectMeta.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: appsv1.SchemeGroupVersion.String(),
+		Kind:       "Deployment",
+		Name:       deployment.Name,
+		UID:        deployment.UID,
+	}}
+
+	return service
+}
+
+// ReconcileService creates the Service of the webhook, an existing Service
+// gets the selector, ports and labels of the webhook, so a Deployment-only
+// install doesn't need a separately created Service.
+func (h *Handler) ReconcileService() error {
+	service := h.webhookService()
+
+	existing, err := h.clientset.CoreV1().Services(h.webhookNamespace).Get(context.TODO(), service.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := h.clientset.CoreV1().Services(h.webhookNamespace).Create(context.TODO(), service, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("cannot create webhook service: %s", err.Error())
+		}
+		log.Infof("(ReconcileService) created service %s/%s", h.webhookNamespace, service.Name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot get webhook service: %s", err.Error())
+	}
+
+	if existing.Labels == nil {
+		existing.Labels = make(map[string]string)
+	}
+	for key, value := range service.Labels {
+		existing.Labels[key] = value
+	}
+	h.options.ExtraMetadata.Apply(&existing.ObjectMeta)
+	if len(existing.OwnerReferences) == 0 {
+		existing.OwnerReferences = service.OwnerReferences
+	}
+	existing.Spec.Selector = service.Spec.Selector
+	existing.Spec.Ports = service.Spec.Ports
+	if _, err := h.clientset.CoreV1().Services(h.webhookNamespace).Update(context.TODO(), existing, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("cannot update webhook service: %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package admission
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileService(t *testing.T) {
+	h := &Handler{
+		clientset:        fake.NewSimpleClientset(),
+		webhookName:      "my-webhook",
+		webhookNamespace: "my-namespace",
+		options: Options{
+			ServiceName:   "my-service",
+			ExtraMetadata: util.ExtraMetadata{Annotations: map[string]string{"argocd.argoproj.io/compare-options": "IgnoreExtraneous"}},
+		},
+	}
+	getService := func() *corev1.Service {
+		service, err := h.clientset.CoreV1().Services("my-namespace").Get(context.TODO(), "my-service", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return service
+	}
+
+	// without a Deployment the app label is selected
+	assert.NoError(t, h.ReconcileService())
+	service := getService()
+	assert.Equal(t, corev1.ServiceTypeClusterIP, service.Spec.Type)
+	assert.Equal(t, map[string]string{"app": "my-webhook"}, service.Spec.Selector)
+	assert.Equal(t, []corev1.ServicePort{{Name: "webhook", Port: 8443, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt32(8443)}}, service.Spec.Ports)
+	assert.Equal(t, "my-webhook", service.Labels[util.ManagedByLabel])
+	assert.Equal(t, "IgnoreExtraneous", service.Annotations["argocd.argoproj.io/compare-options"])
+	assert.Empty(t, service.OwnerReferences)
+
+	// the existing Service gets the selector of the Deployment and is owned by it
+	_, err := h.clientset.AppsV1().Deployments("my-namespace").Create(context.TODO(), &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-webhook", Namespace: "my-namespace", UID: "1234"},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/name": "my-webhook"}},
+		},
+	}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	service.Labels["team"] = "network"
+	service.Spec.Ports[0].TargetPort = intstr.FromInt32(9443)
+	_, err = h.clientset.CoreV1().Services("my-namespace").Update(context.TODO(), service, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	h.options.ServiceTargetPort = 10443
+	assert.NoError(t, h.ReconcileService())
+	service = getService()
+	assert.Equal(t, map[string]string{"app.kubernetes.io/name": "my-webhook"}, service.Spec.Selector)
+	assert.Equal(t, intstr.FromInt32(10443), service.Spec.Ports[0].TargetPort)
+	assert.Equal(t, "network", service.Labels["team"])
+	if assert.Len(t, service.OwnerReferences, 1) {
+		assert.Equal(t, "Deployment", service.OwnerReferences[0].Kind)
+		assert.Equal(t, "my-webhook", service.OwnerReferences[0].Name)
+	}
+}
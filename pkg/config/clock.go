@@ -0,0 +1,14 @@
+package config
+
+import "time"
+
+// Clock returns the current time, so the certificate expiry logic can be
+// tested without waiting for real certificates to expire.
+type Clock interface {
+	Now() time.Time
+}
+
+// RealClock is the Clock which returns the system time.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time { return time.Now() }
@@ -2,76 +2,172 @@ package config
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
 
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/metrics"
 	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
 	log "github.com/sirupsen/logrus"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/kubernetes"
 )
 
+// minCertDuration is the shortest certificate duration which can be requested in a CSR.
+const minCertDuration = 10 * time.Minute
+
+// MaxCertDuration is the longest certificate duration which can be requested
+// in a CSR, the expirationSeconds of the CSR is an int32.
+const MaxCertDuration = math.MaxInt32 * time.Second
+
+// Options holds the settings of the certificate management.
+type Options struct {
+	// CertDuration is the requested lifetime of the certificate, the signer
+	// decides the lifetime when it is 0.
+	CertDuration time.Duration
+	// Clock is used to decide if the certificate needs to be renewed. The
+	// system time is used when it is nil.
+	Clock Clock
+	// ServiceName is the name of the service the certificate is issued for,
+	// the webhook name is used when it is empty.
+	ServiceName string
+	// SecretName is the name of the TLS secret, <webhook name>-tls is used
+	// when it is empty.
+	SecretName string
+	// ExtraMetadata are the labels and annotations which are set on the
+	// CertificateSigningRequest and the TLS secret.
+	ExtraMetadata util.ExtraMetadata
+}
+
 type Handler struct {
 	ctx               context.Context
-	kubeConfig        string
-	kubeContext       string
 	clientset         kubernetes.Interface
 	webhookNamespace  string
 	webhookName       string
 	webhookSecretName string
+	serviceName       string
 	csrName           string
+	options           Options
+	certificate       atomic.Pointer[tls.Certificate]
 }
 
-func Register(ctx context.Context, kubeConfig string, kubeContext string, webhookName string, webhookNamespace string) *Handler {
+func Register(ctx context.Context, clientset kubernetes.Interface, webhookName string, webhookNamespace string, options Options) *Handler {
 	return &Handler{
 		ctx:              ctx,
-		kubeConfig:       kubeConfig,
-		kubeContext:      kubeContext,
+		clientset:        clientset,
 		webhookName:      webhookName,
 		webhookNamespace: webhookNamespace,
+		options:          options,
 	}
 }
 
 func (h *Handler) Init() {
-	config, err := util.GetKubeConfig(h.kubeConfig, h.kubeContext)
-	if err != nil {
-		log.Panicf("%s", err.Error())
+	h.webhookSecretName = h.options.SecretName
+	if h.webhookSecretName == "" {
+		h.webhookSecretName = fmt.Sprintf("%s-tls", h.webhookName)
+	}
+	h.serviceName = h.options.ServiceName
+	if h.serviceName == "" {
+		h.serviceName = h.webhookName
+	}
+	h.csrName = fmt.Sprintf("%s.%s.svc", h.serviceName, h.webhookNamespace)
+
+	if h.options.CertDuration > 0 && h.options.CertDuration < minCertDuration {
+		log.Warnf("requested certificate duration %s is shorter than the minimum of %s, using the minimum", h.options.CertDuration, minCertDuration)
+		h.options.CertDuration = minCertDuration
 	}
+	if h.options.CertDuration > MaxCertDuration {
+		log.Warnf("requested certificate duration %s is longer than the maximum of %s, using the maximum", h.options.CertDuration, MaxCertDuration)
+		h.options.CertDuration = MaxCertDuration
+	}
+}
+
+func (h *Handler) now() time.Time {
+	if h.options.Clock == nil {
+		return time.Now()
+	}
+
+	return h.options.Clock.Now()
+}
+
+// RenewalPolicy determines when the webhook certificate is renewed.
+type RenewalPolicy struct {
+	// Period is the number of minutes before the certificate expires in which it is renewed.
+	Period int64
+	// LifetimePercentage renews the certificate when this percentage of its
+	// lifetime has passed. It takes precedence over Period when it is set.
+	LifetimePercentage int64
+}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Panicf("%s", err.Error())
+// RenewalDate returns the date on which a certificate which is valid between
+// notBefore and notAfter needs to be renewed.
+func (p RenewalPolicy) RenewalDate(notBefore time.Time, notAfter time.Time) time.Time {
+	if p.LifetimePercentage > 0 && p.LifetimePercentage < 100 && notAfter.After(notBefore) {
+		lifetime := notAfter.Sub(notBefore)
+		return notBefore.Add(lifetime / 100 * time.Duration(p.LifetimePercentage))
 	}
-	h.clientset = clientset
 
-	h.webhookSecretName = fmt.Sprintf("%s-tls", h.webhookName)
-	h.csrName = fmt.Sprintf("%s.%s.svc", h.webhookName, h.webhookNamespace)
+	return notAfter.Add(-time.Duration(p.Period) * time.Minute)
 }
 
-func (h *Handler) Run(certRenewalPeriod int64) {
+// Run makes sure the secret contains a certificate which is not due for
+// renewal and loads it into memory.
+func (h *Handler) Run(policy RenewalPolicy) error {
 	if h.checkSecret() {
-		if h.checkCertExpireDate(certRenewalPeriod) {
+		if h.checkCertExpireDate(policy) {
 			if err := h.renewTLSPair(); err != nil {
-				log.Errorf("%s", err.Error())
+				return err
 			}
 		}
 	} else {
 		if h.checkCSR() {
 			if err := h.deleteCSR(); err != nil {
-				log.Errorf("%s", err.Error())
+				return err
 			}
 		}
 
 		tlsPair, err := h.generateTLSKeyAndCert()
 		if err != nil {
-			log.Errorf("%s", err.Error())
+			return err
 		}
 
 		if err := h.createSecret(tlsPair); err != nil {
-			log.Errorf("%s", err.Error())
+			return err
+		}
+	}
+
+	if err := h.loadCertificate(); err != nil {
+		return err
+	}
+
+	if cert, err := h.getCertificate(); err == nil {
+		metrics.CertificateExpiry.Set(float64(cert.NotAfter.Unix()))
+	}
+
+	return nil
+}
+
+// Cleanup removes the TLS secret and any pending CSR of the webhook. Resources
+// which don't exist are skipped.
+func (h *Handler) Cleanup() error {
+	if err := h.deleteSecret(); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
 		}
+	} else {
+		log.Infof("(Cleanup) removed secret %s/%s", h.webhookNamespace, h.webhookSecretName)
 	}
 
-	if err := h.writeTLSDataFromSecret(); err != nil {
-		log.Errorf("%s", err.Error())
+	if err := h.deleteCSR(); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("cannot delete signing request: %s", err.Error())
+		}
+	} else {
+		log.Infof("(Cleanup) removed certificate signing request %s", h.csrName)
 	}
+
+	return nil
 }
@@ -2,35 +2,289 @@ package config
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"testing"
+	"time"
 
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
 	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestRegister(t *testing.T) {
 	ctx := context.Background()
-	kubeConfig := "/path/to/kubeconfig"
-	kubeContext := "my-context"
+	clientset := fake.NewSimpleClientset()
 	webhookName := "my-webhook"
 	webhookNamespace := "my-namespace"
 
-	handler := Register(ctx, kubeConfig, kubeContext, webhookName, webhookNamespace)
+	handler := Register(ctx, clientset, webhookName, webhookNamespace, Options{CertDuration: time.Hour})
 
 	assert.NotNil(t, handler)
 	assert.Equal(t, ctx, handler.ctx)
-	assert.Equal(t, kubeConfig, handler.kubeConfig)
-	assert.Equal(t, kubeContext, handler.kubeContext)
+	assert.Equal(t, clientset, handler.clientset)
 	assert.Equal(t, webhookName, handler.webhookName)
 	assert.Equal(t, webhookNamespace, handler.webhookNamespace)
+	assert.Equal(t, time.Hour, handler.options.CertDuration)
 }
 
 func TestInit(t *testing.T) {
+	handler := Register(context.Background(), fake.NewSimpleClientset(), "my-webhook", "my-namespace", Options{CertDuration: time.Minute})
+	handler.Init()
+
+	assert.Equal(t, "my-webhook-tls", handler.webhookSecretName)
+	assert.Equal(t, "my-webhook.my-namespace.svc", handler.csrName)
+	assert.Equal(t, minCertDuration, handler.options.CertDuration)
+
+	handler = Register(context.Background(), fake.NewSimpleClientset(), "my-webhook", "my-namespace", Options{ServiceName: "my-service", SecretName: "my-secret"})
+	handler.Init()
+
+	assert.Equal(t, "my-secret", handler.webhookSecretName)
+	assert.Equal(t, "my-service.my-namespace.svc", handler.csrName)
+
+	handler = Register(context.Background(), fake.NewSimpleClientset(), "my-webhook", "my-namespace", Options{CertDuration: 100 * 365 * 24 * time.Hour})
+	handler.Init()
+
+	assert.Equal(t, MaxCertDuration, handler.options.CertDuration)
+}
+
+func TestRenewalDate(t *testing.T) {
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(90 * 24 * time.Hour)
+
+	testCases := []struct {
+		name     string
+		policy   RenewalPolicy
+		expected time.Time
+	}{
+		{
+			name:     "renewal period in minutes",
+			policy:   RenewalPolicy{Period: 30 * 24 * 60},
+			expected: notBefore.Add(60 * 24 * time.Hour),
+		},
+		{
+			name:     "percentage of the lifetime",
+			policy:   RenewalPolicy{Period: 30 * 24 * 60, LifetimePercentage: 50},
+			expected: notBefore.Add(45 * 24 * time.Hour),
+		},
+		{
+			name:     "invalid percentage falls back to the renewal period",
+			policy:   RenewalPolicy{Period: 30 * 24 * 60, LifetimePercentage: 150},
+			expected: notBefore.Add(60 * 24 * time.Hour),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.policy.RenewalDate(notBefore, notAfter))
+		})
+	}
+}
+
+func testTLSPair(t *testing.T, cn string) tls.Certificate {
+	return testTLSPairWithValidity(t, cn, time.Now(), time.Now().Add(time.Hour))
+}
+
+func testTLSPairWithValidity(t *testing.T, cn string, notBefore time.Time, notAfter time.Time) tls.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	return tls.Certificate{
+		Certificate: [][]byte{pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})},
+		PrivateKey:  key,
+	}
+}
+
+func TestUpdateSecret(t *testing.T) {
 	handler := &Handler{
-		clientset: fake.NewSimpleClientset(),
+		clientset: fake.NewSimpleClientset(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-webhook", Namespace: "my-namespace", UID: "1234"},
+		}),
+		webhookName:       "my-webhook",
+		webhookNamespace:  "my-namespace",
+		webhookSecretName: "my-webhook-tls",
+	}
+
+	// the secret is created when it doesn't exist
+	first := testTLSPair(t, "first")
+	assert.NoError(t, handler.updateSecret(first))
+	secret, err := handler.clientset.CoreV1().Secrets("my-namespace").Get(context.TODO(), "my-webhook-tls", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, first.Certificate[0], secret.Data["tls.crt"])
+	assert.Equal(t, "my-webhook", secret.Labels["app.kubernetes.io/managed-by"])
+	if assert.Len(t, secret.OwnerReferences, 1) {
+		assert.Equal(t, "Deployment", secret.OwnerReferences[0].Kind)
+		assert.Equal(t, "my-webhook", secret.OwnerReferences[0].Name)
 	}
 
-	// handler.Init() should not be called here directly as it tries to get a real kubeconfig
-	// Instead, we just check if the clientset is not nil after being set.
-	assert.NotNil(t, handler.clientset)
+	// the existing secret is updated in place
+	second := testTLSPair(t, "second")
+	assert.NoError(t, handler.updateSecret(second))
+	secret, err = handler.clientset.CoreV1().Secrets("my-namespace").Get(context.TODO(), "my-webhook-tls", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, second.Certificate[0], secret.Data["tls.crt"])
+
+	cert, err := handler.getCertificate()
+	assert.NoError(t, err)
+	assert.Equal(t, "second", cert.Subject.CommonName)
+}
+
+func TestUpdateSecretExtraMetadata(t *testing.T) {
+	handler := &Handler{
+		clientset:         fake.NewSimpleClientset(),
+		webhookName:       "my-webhook",
+		webhookNamespace:  "my-namespace",
+		webhookSecretName: "my-webhook-tls",
+		options: Options{ExtraMetadata: util.ExtraMetadata{
+			Labels:      map[string]string{"team": "network"},
+			Annotations: map[string]string{"argocd.argoproj.io/compare-options": "IgnoreExtraneous"},
+		}},
+	}
+
+	// the extra metadata is set on the created and on the updated secret
+	for _, name := range []string{"first", "second"} {
+		assert.NoError(t, handler.updateSecret(testTLSPair(t, name)))
+		secret, err := handler.clientset.CoreV1().Secrets("my-namespace").Get(context.TODO(), "my-webhook-tls", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "my-webhook", secret.Labels["app.kubernetes.io/managed-by"])
+		assert.Equal(t, "network", secret.Labels["team"])
+		assert.Equal(t, "IgnoreExtraneous", secret.Annotations["argocd.argoproj.io/compare-options"])
+	}
+}
+
+func TestLoadCertificate(t *testing.T) {
+	handler := &Handler{
+		clientset:         fake.NewSimpleClientset(),
+		webhookNamespace:  "my-namespace",
+		webhookSecretName: "my-webhook-tls",
+	}
+
+	_, err := handler.GetCertificate(nil)
+	assert.EqualError(t, err, "no certificate loaded")
+
+	assert.NoError(t, handler.updateSecret(testTLSPair(t, "first")))
+	assert.NoError(t, handler.loadCertificate())
+	first, err := handler.GetCertificate(nil)
+	assert.NoError(t, err)
+
+	// a renewed certificate replaces the loaded certificate
+	assert.NoError(t, handler.updateSecret(testTLSPair(t, "second")))
+	assert.NoError(t, handler.loadCertificate())
+	second, err := handler.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Certificate[0], second.Certificate[0])
+}
+
+func TestReloadCertificate(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	renewing := &Handler{clientset: clientset, webhookNamespace: "my-namespace", webhookSecretName: "my-webhook-tls"}
+	replica := &Handler{clientset: clientset, webhookNamespace: "my-namespace", webhookSecretName: "my-webhook-tls"}
+
+	assert.NoError(t, renewing.createSecret(testTLSPair(t, "first")))
+	assert.NoError(t, renewing.loadCertificate())
+	assert.NoError(t, replica.ReloadCertificate())
+	first, err := replica.GetCertificate(nil)
+	assert.NoError(t, err)
+
+	// an unchanged secret keeps the loaded certificate
+	assert.NoError(t, replica.ReloadCertificate())
+	unchanged, err := replica.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.Same(t, first, unchanged)
+
+	// the other replica loads the certificate which was renewed in the secret
+	assert.NoError(t, renewing.updateSecret(testTLSPair(t, "second")))
+	assert.NoError(t, renewing.loadCertificate())
+	assert.NoError(t, replica.ReloadCertificate())
+	second, err := replica.GetCertificate(nil)
+	assert.NoError(t, err)
+	renewed, err := renewing.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Certificate[0], second.Certificate[0])
+	assert.Equal(t, renewed.Certificate[0], second.Certificate[0])
+}
+
+func TestCleanup(t *testing.T) {
+	handler := &Handler{
+		clientset:         fake.NewSimpleClientset(),
+		webhookNamespace:  "my-namespace",
+		webhookSecretName: "my-webhook-tls",
+		csrName:           "my-webhook.my-namespace.svc",
+	}
+
+	// nothing to remove
+	assert.NoError(t, handler.Cleanup())
+
+	assert.NoError(t, handler.createSecret(testTLSPair(t, "cleanup")))
+	assert.True(t, handler.checkSecret())
+
+	assert.NoError(t, handler.Cleanup())
+	assert.False(t, handler.checkSecret())
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestCheckCertExpireDate(t *testing.T) {
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(90 * 24 * time.Hour)
+	policy := RenewalPolicy{Period: 30 * 24 * 60}
+	renewalDate := notAfter.Add(-30 * 24 * time.Hour)
+
+	clock := &fakeClock{}
+	handler := &Handler{
+		clientset:         fake.NewSimpleClientset(),
+		webhookNamespace:  "my-namespace",
+		webhookSecretName: "my-webhook-tls",
+		options:           Options{Clock: clock},
+	}
+	assert.NoError(t, handler.createSecret(testTLSPairWithValidity(t, "clock", notBefore, notAfter)))
+
+	testCases := []struct {
+		name     string
+		now      time.Time
+		expected bool
+	}{
+		{
+			name:     "before the renewal date",
+			now:      renewalDate.Add(-time.Second),
+			expected: false,
+		},
+		{
+			name:     "at the renewal date",
+			now:      renewalDate,
+			expected: true,
+		},
+		{
+			name:     "after the certificate expired",
+			now:      notAfter.Add(time.Hour),
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock.now = tc.now
+			assert.Equal(t, tc.expected, handler.checkCertExpireDate(policy))
+		})
+	}
 }
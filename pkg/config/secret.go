@@ -7,28 +7,47 @@ import (
 	"encoding/pem"
 	"fmt"
 
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func (h *Handler) createSecret(tlsPair tls.Certificate) (err error) {
+func tlsSecretData(tlsPair tls.Certificate) (map[string][]byte, error) {
 	bKey, err := x509.MarshalPKCS8PrivateKey(tlsPair.PrivateKey)
 	if err != nil {
-		return fmt.Errorf("unable to marshal private key: %s", err.Error())
+		return nil, fmt.Errorf("unable to marshal private key: %s", err.Error())
 
 	}
 	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: bKey})
 	if pemKey == nil {
-		return fmt.Errorf("failed to encode key to PEM")
+		return nil, fmt.Errorf("failed to encode key to PEM")
+	}
+
+	secretData := make(map[string][]byte)
+	secretData["tls.key"] = pemKey
+	secretData["tls.crt"] = tlsPair.Certificate[0]
+
+	return secretData, nil
+}
+
+func (h *Handler) createSecret(tlsPair tls.Certificate) (err error) {
+	secretData, err := tlsSecretData(tlsPair)
+	if err != nil {
+		return
 	}
 
 	newSecret := corev1.Secret{}
 	newSecret.Type = "kubernetes.io/tls"
 	newSecret.ObjectMeta.Name = h.webhookSecretName
 	newSecret.ObjectMeta.Namespace = h.webhookNamespace
-	secretData := make(map[string][]byte)
-	secretData["tls.key"] = pemKey
-	secretData["tls.crt"] = tlsPair.Certificate[0]
+	newSecret.ObjectMeta.Labels = util.ManagedLabels(h.webhookName, "serving-certificate")
+	h.options.ExtraMetadata.Apply(&newSecret.ObjectMeta)
+	if ownerRef := h.deploymentOwnerReference(); ownerRef != nil {
+		newSecret.ObjectMeta.OwnerReferences = []metav1.OwnerReference{*ownerRef}
+	}
 	newSecret.Data = secretData
 
 	_, err = h.clientset.CoreV1().Secrets(h.webhookNamespace).Create(context.TODO(), &newSecret, metav1.CreateOptions{})
@@ -36,6 +55,38 @@ func (h *Handler) createSecret(tlsPair tls.Certificate) (err error) {
 	return
 }
 
+// updateSecret replaces the TLS data of the existing secret, so the secret is
+// never missing during a renewal. The secret is created if it doesn't exist.
+func (h *Handler) updateSecret(tlsPair tls.Certificate) (err error) {
+	secret, err := h.clientset.CoreV1().Secrets(h.webhookNamespace).Get(context.TODO(), h.webhookSecretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return h.createSecret(tlsPair)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot get webhook secret: %s", err.Error())
+	}
+
+	secretData, err := tlsSecretData(tlsPair)
+	if err != nil {
+		return
+	}
+	secret.Data = secretData
+	if secret.Labels == nil {
+		secret.Labels = make(map[string]string)
+	}
+	for key, value := range util.ManagedLabels(h.webhookName, "serving-certificate") {
+		secret.Labels[key] = value
+	}
+	h.options.ExtraMetadata.Apply(&secret.ObjectMeta)
+
+	_, err = h.clientset.CoreV1().Secrets(h.webhookNamespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot update webhook secret: %s", err.Error())
+	}
+
+	return
+}
+
 func (h *Handler) getSecret() corev1.Secret {
 	secret, err := h.clientset.CoreV1().Secrets(h.webhookNamespace).Get(context.TODO(), h.webhookSecretName, metav1.GetOptions{})
 	if err != nil {
@@ -48,7 +99,7 @@ func (h *Handler) getSecret() corev1.Secret {
 func (h *Handler) deleteSecret() (err error) {
 	err = h.clientset.CoreV1().Secrets(h.webhookNamespace).Delete(context.TODO(), h.webhookSecretName, metav1.DeleteOptions{})
 	if err != nil {
-		return fmt.Errorf("cannot delete webhook secret: %s", err.Error())
+		return fmt.Errorf("cannot delete webhook secret: %w", err)
 	}
 
 	return
@@ -59,3 +110,21 @@ func (h *Handler) checkSecret() bool {
 
 	return s.ObjectMeta.Name != ""
 }
+
+// deploymentOwnerReference returns an ownerReference to the webhook Deployment,
+// so the secret is garbage collected when the webhook is uninstalled. It returns
+// nil if the Deployment cannot be found, for example when running out-of-cluster.
+func (h *Handler) deploymentOwnerReference() *metav1.OwnerReference {
+	deployment, err := h.clientset.AppsV1().Deployments(h.webhookNamespace).Get(context.TODO(), h.webhookName, metav1.GetOptions{})
+	if err != nil {
+		log.Debugf("(deploymentOwnerReference) cannot get deployment %s/%s, not setting an owner: %s", h.webhookNamespace, h.webhookName, err.Error())
+		return nil
+	}
+
+	return &metav1.OwnerReference{
+		APIVersion: appsv1.SchemeGroupVersion.String(),
+		Kind:       "Deployment",
+		Name:       deployment.Name,
+		UID:        deployment.UID,
+	}
+}
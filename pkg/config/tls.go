@@ -1,6 +1,7 @@
 package config
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
@@ -9,9 +10,10 @@ import (
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
-	"os"
 	"time"
 
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/metrics"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
 	log "github.com/sirupsen/logrus"
 
 	certsv1 "k8s.io/api/certificates/v1"
@@ -27,11 +29,11 @@ func (h *Handler) generateTLSKeyAndCert() (tlsPair tls.Certificate, err error) {
 		return tlsPair, fmt.Errorf("error while generating key: %s", err.Error())
 	}
 
-	cn := fmt.Sprintf("system:node:%s.%s.svc", h.webhookName, h.webhookNamespace)
-	DNSnames = append(DNSnames, h.webhookName)
-	DNSnames = append(DNSnames, fmt.Sprintf("%s.%s", h.webhookName, h.webhookNamespace))
+	cn := fmt.Sprintf("system:node:%s.%s.svc", h.serviceName, h.webhookNamespace)
+	DNSnames = append(DNSnames, h.serviceName)
+	DNSnames = append(DNSnames, fmt.Sprintf("%s.%s", h.serviceName, h.webhookNamespace))
 	DNSnames = append(DNSnames, h.csrName)
-	DNSnames = append(DNSnames, fmt.Sprintf("%s.%s.cluster.local", h.webhookName, h.webhookNamespace))
+	DNSnames = append(DNSnames, fmt.Sprintf("%s.%s.cluster.local", h.serviceName, h.webhookNamespace))
 
 	template := &x509.CertificateRequest{
 		Subject: pkix.Name{
@@ -80,6 +82,8 @@ func (h *Handler) deleteCSR() error {
 func (h *Handler) createAndSignCSR(pCsr []byte) ([]byte, error) {
 	newCsrObj := certsv1.CertificateSigningRequest{}
 	newCsrObj.ObjectMeta.Name = h.csrName
+	newCsrObj.ObjectMeta.Labels = util.ManagedLabels(h.webhookName, "serving-certificate")
+	h.options.ExtraMetadata.Apply(&newCsrObj.ObjectMeta)
 	newCsrObj.Spec.Groups = []string{"system:authenticated"}
 	newCsrObj.Spec.Request = pCsr
 	newCsrObj.Spec.SignerName = "kubernetes.io/kubelet-serving"
@@ -88,6 +92,10 @@ func (h *Handler) createAndSignCSR(pCsr []byte) ([]byte, error) {
 		certsv1.UsageKeyEncipherment,
 		certsv1.UsageServerAuth,
 	}
+	if h.options.CertDuration > 0 {
+		expirationSeconds := int32(h.options.CertDuration.Seconds())
+		newCsrObj.Spec.ExpirationSeconds = &expirationSeconds
+	}
 	csrObj, err := h.clientset.CertificatesV1().CertificateSigningRequests().Create(context.TODO(), &newCsrObj, metav1.CreateOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("error while creating signing request: %s", err.Error())
@@ -117,9 +125,35 @@ func (h *Handler) createAndSignCSR(pCsr []byte) ([]byte, error) {
 		return nil, fmt.Errorf("error while getting the updated signing request: %s", err.Error())
 	}
 
+	h.logGrantedDuration(updatedCsr.Status.Certificate)
+
 	return updatedCsr.Status.Certificate, nil
 }
 
+// logGrantedDuration logs the lifetime which the signer granted to the certificate,
+// which can be shorter than the requested duration.
+func (h *Handler) logGrantedDuration(pemCert []byte) {
+	b, _ := pem.Decode(pemCert)
+	if b == nil {
+		log.Warnf("signed certificate is not available in signing request %s", h.csrName)
+		return
+	}
+
+	cert, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		log.Warnf("cannot parse the signed certificate: %s", err.Error())
+		return
+	}
+
+	granted := cert.NotAfter.Sub(cert.NotBefore)
+	metrics.CertificateDuration.Set(granted.Seconds())
+	if h.options.CertDuration > 0 && granted < h.options.CertDuration {
+		log.Warnf("signer granted a certificate duration of %s which is shorter than the requested %s", granted, h.options.CertDuration)
+		return
+	}
+	log.Infof("signer granted a certificate duration of %s, the certificate expires at %s", granted, cert.NotAfter.UTC().Format(time.RFC3339))
+}
+
 func (h *Handler) getTLSDataFromSecret() (tlsPair tls.Certificate, err error) {
 	s := h.getSecret()
 
@@ -138,27 +172,60 @@ func (h *Handler) getTLSDataFromSecret() (tlsPair tls.Certificate, err error) {
 	return
 }
 
-func (h *Handler) writeTLSDataFromSecret() (err error) {
-	homedir := os.Getenv("HOME")
-	keyPath := fmt.Sprintf("%s/tls.key", homedir)
-	certPath := fmt.Sprintf("%s/tls.crt", homedir)
-
+// loadCertificate loads the TLS key and certificate from the secret into memory,
+// so the next TLS handshake is served with the new certificate.
+func (h *Handler) loadCertificate() (err error) {
 	tlsPair, err := h.getTLSDataFromSecret()
 	if err != nil {
 		return fmt.Errorf("cannot while fetching TLS data: %s", err.Error())
 	}
 
-	if err = os.WriteFile(keyPath, []byte(fmt.Sprintf("%s", tlsPair.PrivateKey)), 0600); err != nil {
-		return fmt.Errorf("error while writing private key file: %s", err.Error())
+	key, ok := tlsPair.PrivateKey.([]byte)
+	if !ok {
+		return fmt.Errorf("private key in secret is not PEM encoded")
 	}
 
-	if err = os.WriteFile(certPath, tlsPair.Certificate[0], 0644); err != nil {
-		return fmt.Errorf("error while writing certificate file: %s", err.Error())
+	certificate, err := tls.X509KeyPair(tlsPair.Certificate[0], key)
+	if err != nil {
+		return fmt.Errorf("cannot load TLS key pair: %s", err.Error())
 	}
+	h.certificate.Store(&certificate)
 
 	return
 }
 
+// ReloadCertificate loads the certificate of the secret into memory when it
+// differs from the loaded certificate, so a certificate which is renewed by
+// another replica is served as well.
+func (h *Handler) ReloadCertificate() error {
+	cert, err := h.getCertificate()
+	if err != nil {
+		return err
+	}
+	if loaded := h.certificate.Load(); loaded != nil && bytes.Equal(loaded.Certificate[0], cert.Raw) {
+		return nil
+	}
+
+	if err := h.loadCertificate(); err != nil {
+		return err
+	}
+	metrics.CertificateExpiry.Set(float64(cert.NotAfter.Unix()))
+	log.Infof("loaded the certificate of secret %s/%s, it expires at %s", h.webhookNamespace, h.webhookSecretName, cert.NotAfter.UTC().Format(time.RFC3339))
+
+	return nil
+}
+
+// GetCertificate returns the certificate which is loaded in memory. It can be
+// used as tls.Config.GetCertificate.
+func (h *Handler) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificate := h.certificate.Load()
+	if certificate == nil {
+		return nil, fmt.Errorf("no certificate loaded")
+	}
+
+	return certificate, nil
+}
+
 func (h *Handler) renewTLSPair() (err error) {
 	if h.checkCSR() {
 		if err = h.deleteCSR(); err != nil {
@@ -171,44 +238,58 @@ func (h *Handler) renewTLSPair() (err error) {
 		return
 	}
 
-	if err = h.deleteSecret(); err != nil {
-		return
-	}
-
-	return h.createSecret(tlsPair)
+	return h.updateSecret(tlsPair)
 }
 
-func (h *Handler) GetCertExpireDate() (expireDate time.Time, err error) {
+func (h *Handler) getCertificate() (*x509.Certificate, error) {
 	tlsPair, err := h.getTLSDataFromSecret()
 	if err != nil {
-		return time.Time{}, fmt.Errorf("cannot while fetching TLS data: %s", err.Error())
+		return nil, fmt.Errorf("cannot while fetching TLS data: %s", err.Error())
 	}
 
 	if len(tlsPair.Certificate[0]) == 0 {
-		return time.Time{}, fmt.Errorf("certificate is empty")
+		return nil, fmt.Errorf("certificate is empty")
 	}
 	b, _ := pem.Decode(tlsPair.Certificate[0])
 	if b == nil {
-		return time.Time{}, fmt.Errorf("cannot decode TLS PEM data: %s", err.Error())
+		return nil, fmt.Errorf("cannot decode TLS PEM data")
 	}
 
 	cert, err := x509.ParseCertificate(b.Bytes)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("cannot parse TLS PEM data: %s", err.Error())
+		return nil, fmt.Errorf("cannot parse TLS PEM data: %s", err.Error())
+	}
+
+	return cert, nil
+}
+
+func (h *Handler) GetCertExpireDate() (expireDate time.Time, err error) {
+	cert, err := h.getCertificate()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return cert.NotAfter, nil
+}
+
+// GetCertRenewalDate returns the date on which the certificate needs to be renewed according to the policy.
+func (h *Handler) GetCertRenewalDate(policy RenewalPolicy) (renewalDate time.Time, err error) {
+	cert, err := h.getCertificate()
+	if err != nil {
+		return time.Time{}, err
 	}
 
-	return cert.NotAfter, err
+	return policy.RenewalDate(cert.NotBefore, cert.NotAfter), nil
 }
 
-func (h *Handler) checkCertExpireDate(certRenewalPeriod int64) bool {
-	expireDate, err := h.GetCertExpireDate()
+func (h *Handler) checkCertExpireDate(policy RenewalPolicy) bool {
+	renewalDate, err := h.GetCertRenewalDate(policy)
 	if err != nil {
 		log.Errorf("%s", err.Error())
 
 		return false
 	}
 
-	currentDate := time.Now().UTC()
-	difference := expireDate.Sub(currentDate)
-	return int64(difference.Minutes()) < certRenewalPeriod
+	currentDate := h.now().UTC()
+	return !currentDate.Before(renewalDate)
 }
@@ -0,0 +1,78 @@
+// Package health tracks the liveness of the long running components of the
+// webhook, like the certificate renewal scheduler and the HTTP server.
+package health
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type component struct {
+	lastBeat time.Time
+	timeout  time.Duration
+	err      error
+}
+
+var (
+	mu         sync.Mutex
+	components = make(map[string]*component)
+)
+
+// Beat records that the component is alive. The component is considered dead
+// if it doesn't beat again within the timeout.
+func Beat(name string, timeout time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	components[name] = &component{
+		lastBeat: time.Now(),
+		timeout:  timeout,
+	}
+}
+
+// Fail marks the component as failed until it beats again.
+func Fail(name string, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	c, ok := components[name]
+	if !ok {
+		c = &component{}
+		components[name] = c
+	}
+	c.err = err
+}
+
+// Remove stops tracking the component, for example after a graceful shutdown.
+func Remove(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(components, name)
+}
+
+// Check returns an error which lists all failed components and all components
+// which didn't beat within their timeout.
+func Check() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var problems []string
+	now := time.Now()
+	for name, c := range components {
+		if c.err != nil {
+			problems = append(problems, fmt.Sprintf("%s failed: %s", name, c.err))
+		} else if c.timeout > 0 && now.Sub(c.lastBeat) > c.timeout {
+			problems = append(problems, fmt.Sprintf("%s has not reported since %s", name, c.lastBeat.UTC().Format(time.RFC3339)))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+
+	return fmt.Errorf("%s", strings.Join(problems, ", "))
+}
@@ -0,0 +1,28 @@
+package health
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	defer Remove("scheduler")
+	defer Remove("server")
+
+	Beat("scheduler", time.Minute)
+	assert.NoError(t, Check())
+
+	Fail("server", fmt.Errorf("address already in use"))
+	assert.EqualError(t, Check(), "server failed: address already in use")
+
+	Remove("server")
+	Beat("scheduler", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	assert.ErrorContains(t, Check(), "scheduler has not reported since")
+
+	Beat("scheduler", time.Minute)
+	assert.NoError(t, Check())
+}
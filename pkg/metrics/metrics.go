@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/version"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "rancher_fip_manager_webhook"
+
+var (
+	// AdmissionRequests counts the admission decisions per webhook.
+	AdmissionRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "admission_requests_total",
+			Help:      "Total number of admission requests by webhook and decision.",
+		},
+		[]string{"webhook", "decision"},
+	)
+
+	// AuditDenials counts the requests which would have been denied if the webhook was not in audit mode.
+	AuditDenials = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "audit_denials_total",
+			Help:      "Total number of admission requests which would have been denied in enforcing mode.",
+		},
+		[]string{"webhook"},
+	)
+
+	// BreakGlassDenials counts the requests which would have been denied if
+	// the break-glass mode was not enabled.
+	BreakGlassDenials = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "break_glass_denials_total",
+			Help:      "Total number of admission requests which were allowed by the break-glass mode.",
+		},
+		[]string{"webhook"},
+	)
+
+	// BreakGlassMode is 1 while the break-glass mode is enabled.
+	BreakGlassMode = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "break_glass_mode",
+			Help:      "Whether the break-glass mode is enabled, every request is allowed while it is 1.",
+		},
+	)
+
+	// Panics counts the admission requests which panicked per webhook.
+	Panics = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "panics_total",
+			Help:      "Total number of admission requests which panicked by webhook.",
+		},
+		[]string{"webhook"},
+	)
+
+	// InFlightRequests is the number of admission requests which are being validated.
+	InFlightRequests = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "inflight_requests",
+			Help:      "Number of admission requests which are being validated.",
+		},
+	)
+
+	// OverloadRejections counts the admission requests which were rejected
+	// because the maximum number of requests in flight was reached.
+	OverloadRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "overload_rejections_total",
+			Help:      "Total number of admission requests which were rejected because the webhook was overloaded.",
+		},
+		[]string{"webhook"},
+	)
+
+	// PoolUtilizationCrossings counts the FloatingIPs which filled a pool to
+	// one of the utilization thresholds.
+	PoolUtilizationCrossings = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "pool_utilization_threshold_crossings_total",
+			Help:      "Total number of times a FloatingIPPool crossed a utilization threshold by pool and threshold.",
+		},
+		[]string{"pool", "threshold"},
+	)
+
+	// QuotaOverrides counts the FloatingIPs which bypassed the quotas with
+	// the quota-override annotation, by the object which carries it.
+	QuotaOverrides = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "quota_overrides_total",
+			Help:      "Total number of FloatingIPs which bypassed the quotas by the source of the override annotation.",
+		},
+		[]string{"source"},
+	)
+
+	// RateLimitedRequests counts the FloatingIPs which were denied by the
+	// rate limit, by the scope of the exceeded limit.
+	RateLimitedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_requests_total",
+			Help:      "Total number of FloatingIPs which were denied by the rate limit by the scope (user or project) of the limit.",
+		},
+		[]string{"scope"},
+	)
+
+	// CircuitBreakerState is the state of the circuit breaker of the pool and
+	// quota lookups.
+	CircuitBreakerState = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "circuit_breaker_state",
+			Help:      "State of the circuit breaker of the API server lookups (0 closed, 1 open, 2 half-open).",
+		},
+	)
+
+	// DecisionCacheHits counts the retries of admission requests which got
+	// the decision of a previous call.
+	DecisionCacheHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "decision_cache_hits_total",
+			Help:      "Total number of retried admission requests which got the decision of a previous call.",
+		},
+	)
+
+	// ConversionRequests counts the ConversionReviews of the FloatingIP CRDs
+	// by their result.
+	ConversionRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "conversion_requests_total",
+			Help:      "Total number of CRD conversion requests by result (converted or failed).",
+		},
+		[]string{"result"},
+	)
+
+	// CertificateExpiry is the expire date of the serving certificate.
+	CertificateExpiry = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "certificate_expiry_timestamp_seconds",
+			Help:      "Expire date of the serving certificate as unix timestamp.",
+		},
+	)
+
+	// CertificateDuration is the lifetime which the signer granted to the last signed certificate.
+	CertificateDuration = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "certificate_granted_duration_seconds",
+			Help:      "Lifetime which the signer granted to the last signed certificate.",
+		},
+	)
+
+	// BuildInfo is always 1 and carries the build information as labels.
+	BuildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "build_info",
+			Help:      "Build information of the running webhook.",
+		},
+		[]string{"version", "git_commit", "build_date", "go_version"},
+	)
+
+	registry = prometheus.NewRegistry()
+)
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		AdmissionRequests,
+		AuditDenials,
+		BreakGlassDenials,
+		BreakGlassMode,
+		Panics,
+		InFlightRequests,
+		OverloadRejections,
+		PoolUtilizationCrossings,
+		QuotaOverrides,
+		RateLimitedRequests,
+		CircuitBreakerState,
+		DecisionCacheHits,
+		ConversionRequests,
+		CertificateExpiry,
+		CertificateDuration,
+		BuildInfo,
+	)
+
+	info := version.Get()
+	BuildInfo.WithLabelValues(info.Version, info.GitCommit, info.BuildDate, info.GoVersion).Set(1)
+}
+
+// Handler returns the http handler which serves the metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -1,52 +1,133 @@
 package scheduler
 
 import (
+	"context"
+	"math/rand"
 	"time"
 
 	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/config"
-	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/service"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/health"
 	log "github.com/sirupsen/logrus"
 )
 
-var ticker *time.Ticker
+// HealthComponent is the name of the scheduler in the liveness checks.
+const HealthComponent = "cert-renewal-scheduler"
 
-func StartCertRenewalScheduler(cHandler *config.Handler, sHandler *service.Handler, certRenewalPeriod int64) {
-	var sTime int64
+// heartbeatInterval is the interval in which the scheduler reports that it's alive.
+const heartbeatInterval = time.Minute
 
-	expireDate, err := cHandler.GetCertExpireDate()
+// retryInterval is the time to wait before retrying when the expire date of the certificate cannot be determined.
+const retryInterval = time.Minute
+
+// Options holds the timing settings of the certificate renewal scheduler.
+type Options struct {
+	// Renewal determines when the certificate is renewed.
+	Renewal config.RenewalPolicy
+	// CheckInterval is the maximum time between two expiry checks.
+	CheckInterval time.Duration
+	// Jitter is the maximum random delay added to every check, so multiple
+	// replicas don't attempt the renewal at the same time.
+	Jitter time.Duration
+	// Clock is used to calculate the time until the renewal. The system time
+	// is used when it is nil.
+	Clock config.Clock
+}
+
+// certificateManager returns the renewal date of the serving certificate.
+type certificateManager interface {
+	GetCertRenewalDate(policy config.RenewalPolicy) (time.Time, error)
+}
+
+// StartCertRenewalScheduler starts the certificate renewal loop in the background.
+// The renewed certificate is loaded into memory and served from the next TLS
+// handshake on, the webhook server keeps running. A certificate which another
+// replica renewed in the secret is loaded on the next check. The loop stops when the
+// context is cancelled.
+func StartCertRenewalScheduler(ctx context.Context, cHandler *config.Handler, options Options) {
+	if options.Clock == nil {
+		options.Clock = config.RealClock{}
+	}
+	health.Beat(HealthComponent, 3*heartbeatInterval)
+	go run(ctx, cHandler, options)
+}
+
+func run(ctx context.Context, cHandler *config.Handler, options Options) {
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		untilRenewal, err := timeUntilRenewal(cHandler, options.Renewal, options.Clock)
+		if err != nil {
+			log.Errorf("cannot determine the next certificate renewal: %s", err.Error())
+		} else if untilRenewal < 0 {
+			log.Infof("certificate renewal date is reached, renewing certificate and secret")
+			if err := cHandler.Run(options.Renewal); err != nil {
+				log.Errorf("cannot renew the certificate: %s", err.Error())
+			}
+			// don't spin if the renewed certificate is still within the renewal period
+			untilRenewal = 0
+		} else if err := cHandler.ReloadCertificate(); err != nil {
+			// another replica may have renewed the certificate in the secret
+			log.Errorf("cannot reload the certificate: %s", err.Error())
+		}
+
+		wait := nextCheck(untilRenewal, err, options)
+		log.Debugf("next certificate expiry check in %s", wait)
+		if !waitFor(ctx, heartbeat, wait) {
+			health.Remove(HealthComponent)
+			return
+		}
+	}
+}
+
+// nextCheck returns the time to wait before the next expiry check. It waits
+// until the renewal period is reached, but never longer than the check interval.
+func nextCheck(untilRenewal time.Duration, err error, options Options) time.Duration {
+	var wait time.Duration
 	if err != nil {
-		log.Panicf("%s", err.Error())
+		wait = retryInterval
+	} else {
+		// we always need 1 min extra because if the expire time is 0 the cert is still valid
+		wait = untilRenewal + time.Minute
 	}
 
-	currentDate := time.Now().UTC()
-	difference := expireDate.Sub(currentDate)
-	// we always need 1 min extra because if the expire time is 0 the cert is still valid
-	sTime = int64(difference.Minutes()) - certRenewalPeriod + 1
-	if sTime < 1 {
-		// the ticker cannot be 0 or negative
-		sTime = 1
+	if options.CheckInterval > 0 && wait > options.CheckInterval {
+		wait = options.CheckInterval
+	}
+	if options.Jitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(options.Jitter)))
 	}
 
-	ticker = time.NewTicker(time.Duration(sTime) * time.Minute)
-	quit := make(chan struct{})
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				log.Infof("certRenewalPeriod is reached, renewing certificate and secret")
-				cHandler.Run(certRenewalPeriod)
-				if err := sHandler.Stop(); err != nil {
-					log.Errorf("Error stopping service during renewal: %v", err)
-				}
-				// Wait for service to fully stop
-				time.Sleep(2 * time.Second)
-				go sHandler.Run()
-				ticker.Stop()
-				StartCertRenewalScheduler(cHandler, sHandler, certRenewalPeriod)
-			case <-quit:
-				ticker.Stop()
-				return
-			}
+	return wait
+}
+
+// waitFor waits for the given duration while reporting the heartbeat. It returns
+// false if the context is cancelled before the duration has passed.
+func waitFor(ctx context.Context, heartbeat *time.Ticker, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-heartbeat.C:
+			health.Beat(HealthComponent, 3*heartbeatInterval)
+		case <-timer.C:
+			return true
 		}
-	}()
+	}
+}
+
+// timeUntilRenewal returns the time until the certificate needs to be renewed.
+// A negative duration means the certificate needs to be renewed.
+func timeUntilRenewal(certs certificateManager, policy config.RenewalPolicy, clock config.Clock) (time.Duration, error) {
+	renewalDate, err := certs.GetCertRenewalDate(policy)
+	if err != nil {
+		return 0, err
+	}
+
+	currentDate := clock.Now().UTC()
+
+	return renewalDate.Sub(currentDate), nil
 }
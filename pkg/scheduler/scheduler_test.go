@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextCheck(t *testing.T) {
+	testCases := []struct {
+		name         string
+		untilRenewal time.Duration
+		err          error
+		options      Options
+		expectedMin  time.Duration
+		expectedMax  time.Duration
+	}{
+		{
+			name:         "renewal before the check interval",
+			untilRenewal: 10 * time.Minute,
+			options:      Options{CheckInterval: time.Hour},
+			expectedMin:  11 * time.Minute,
+			expectedMax:  11 * time.Minute,
+		},
+		{
+			name:         "renewal after the check interval",
+			untilRenewal: 48 * time.Hour,
+			options:      Options{CheckInterval: time.Hour},
+			expectedMin:  time.Hour,
+			expectedMax:  time.Hour,
+		},
+		{
+			name:         "jitter is added",
+			untilRenewal: 48 * time.Hour,
+			options:      Options{CheckInterval: time.Hour, Jitter: 5 * time.Minute},
+			expectedMin:  time.Hour,
+			expectedMax:  time.Hour + 5*time.Minute,
+		},
+		{
+			name:        "retry on error",
+			err:         fmt.Errorf("certificate is empty"),
+			options:     Options{CheckInterval: time.Hour},
+			expectedMin: retryInterval,
+			expectedMax: retryInterval,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wait := nextCheck(tc.untilRenewal, tc.err, tc.options)
+			assert.GreaterOrEqual(t, wait, tc.expectedMin)
+			assert.LessOrEqual(t, wait, tc.expectedMax)
+		})
+	}
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+type fakeCertificateManager struct {
+	renewalDate time.Time
+	err         error
+}
+
+func (m *fakeCertificateManager) GetCertRenewalDate(policy config.RenewalPolicy) (time.Time, error) {
+	return m.renewalDate, m.err
+}
+
+func TestTimeUntilRenewal(t *testing.T) {
+	renewalDate := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	certs := &fakeCertificateManager{renewalDate: renewalDate}
+	options := Options{CheckInterval: time.Hour}
+
+	testCases := []struct {
+		name            string
+		now             time.Time
+		expectedUntil   time.Duration
+		expectedRenewal bool
+		expectedWait    time.Duration
+	}{
+		{
+			name:          "well before the renewal date",
+			now:           renewalDate.Add(-48 * time.Hour),
+			expectedUntil: 48 * time.Hour,
+			expectedWait:  time.Hour,
+		},
+		{
+			name:          "just before the renewal date",
+			now:           renewalDate.Add(-time.Minute),
+			expectedUntil: time.Minute,
+			expectedWait:  2 * time.Minute,
+		},
+		{
+			name:          "exactly at the renewal date",
+			now:           renewalDate,
+			expectedUntil: 0,
+			expectedWait:  time.Minute,
+		},
+		{
+			name:            "just after the renewal date",
+			now:             renewalDate.Add(time.Nanosecond),
+			expectedUntil:   -time.Nanosecond,
+			expectedRenewal: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			untilRenewal, err := timeUntilRenewal(certs, config.RenewalPolicy{}, &fakeClock{now: tc.now})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedUntil, untilRenewal)
+			assert.Equal(t, tc.expectedRenewal, untilRenewal < 0)
+			if !tc.expectedRenewal {
+				assert.Equal(t, tc.expectedWait, nextCheck(untilRenewal, nil, options))
+			}
+		})
+	}
+
+	certs.err = fmt.Errorf("certificate is empty")
+	_, err := timeUntilRenewal(certs, config.RenewalPolicy{}, &fakeClock{now: renewalDate})
+	assert.EqualError(t, err, "certificate is empty")
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+type accessLogKey struct{}
+
+// accessLogFields are the fields of the access log which are only known after
+// the AdmissionReview is decoded, serveAdmission fills them in.
+type accessLogFields struct {
+	uid      string
+	kind     string
+	user     string
+	decision string
+}
+
+// setAccessLogRequest stores the identity of the admission request for the
+// access log of the request, if it is enabled.
+func setAccessLogRequest(ctx context.Context, req *admissionv1.AdmissionRequest) {
+	fields, ok := ctx.Value(accessLogKey{}).(*accessLogFields)
+	if !ok {
+		return
+	}
+	fields.uid = string(req.UID)
+	fields.kind = req.Kind.Kind
+	fields.user = req.UserInfo.Username
+}
+
+// setAccessLogDecision stores the decision of the admission request for the
+// access log of the request, if it is enabled.
+func setAccessLogDecision(ctx context.Context, response *admissionv1.AdmissionResponse) {
+	fields, ok := ctx.Value(accessLogKey{}).(*accessLogFields)
+	if !ok {
+		return
+	}
+	fields.decision = "denied"
+	if response.Allowed {
+		fields.decision = "allowed"
+	}
+	if decision, ok := response.AuditAnnotations["decision"]; ok {
+		fields.decision = decision
+	}
+}
+
+// responseRecorder records the status and the size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// accessLog logs every request of the handler with its latency and the
+// identity and decision of the admission request when the AccessLog option is
+// set, so slow or failing requests can be traced without packet captures.
+func (h *Handler) accessLog(next http.HandlerFunc) http.HandlerFunc {
+	if !h.options.AccessLog {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		fields := &accessLogFields{}
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next(recorder, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, fields)))
+
+		log.WithFields(log.Fields{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"remote":     r.RemoteAddr,
+			"uid":        fields.uid,
+			"kind":       fields.kind,
+			"user":       fields.user,
+			"decision":   fields.decision,
+			"status":     recorder.status,
+			"size":       recorder.size,
+			"latency_ms": time.Since(start).Milliseconds(),
+		}).Info("(accessLog) admission request served")
+	}
+}
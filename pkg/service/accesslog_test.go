@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestAccessLog(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	h := &Handler{
+		options:           Options{AccessLog: true},
+		fipPoolValidators: DefaultFloatingIPPoolValidators(),
+	}
+	h.RegisterKind("FloatingIPPool", WebhookFloatingIPPool, h.admitFloatingIPPool)
+
+	raw, err := json.Marshal(&rfmv2.FloatingIPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pool"},
+		Spec: rfmv2.FloatingIPPoolSpec{
+			IPConfig: &rfmv2.IPConfig{
+				Subnet: "192.168.1.0/24",
+				Pool:   rfmv2.Pool{Start: "192.168.1.20", End: "192.168.1.10"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:      "test-uid",
+			Kind:     metav1.GroupVersionKind{Kind: "FloatingIPPool"},
+			UserInfo: authenticationv1.UserInfo{Username: "jane"},
+			Object:   runtime.RawExtension{Raw: raw},
+		},
+	})
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	h.accessLog(h.validateAdmission)(w, req)
+
+	entry := hook.LastEntry()
+	assert.Equal(t, log.InfoLevel, entry.Level)
+	assert.Equal(t, "(accessLog) admission request served", entry.Message)
+	assert.Equal(t, http.MethodPost, entry.Data["method"])
+	assert.Equal(t, "/validate", entry.Data["path"])
+	assert.Equal(t, "test-uid", entry.Data["uid"])
+	assert.Equal(t, "FloatingIPPool", entry.Data["kind"])
+	assert.Equal(t, "jane", entry.Data["user"])
+	assert.Equal(t, "denied", entry.Data["decision"])
+	assert.Equal(t, http.StatusOK, entry.Data["status"])
+	assert.Equal(t, w.Body.Len(), entry.Data["size"])
+}
+
+func TestAccessLogDisabled(t *testing.T) {
+	h := &Handler{}
+	called := false
+	next := func(w http.ResponseWriter, r *http.Request) { called = true }
+
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	h.accessLog(next)(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/validate", nil))
+
+	assert.True(t, called)
+	assert.Empty(t, hook.AllEntries())
+}
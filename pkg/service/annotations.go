@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+)
+
+// AnnotationPrefix is the prefix of the annotations of the FloatingIP
+// resources, unknown annotations with the prefix are probably misspelled.
+const AnnotationPrefix = "rancher.k8s.binbash.org/"
+
+const (
+	// StaticARPAnnotation is the FloatingIP annotation which announces the IP
+	// with a static ARP entry, it is "true" or "false".
+	StaticARPAnnotation = "rancher.k8s.binbash.org/static-arp"
+	// DNSNameAnnotation is the FloatingIP annotation with the DNS name which
+	// is published for the IP, for example "web.example.com".
+	DNSNameAnnotation = "rancher.k8s.binbash.org/dns-name"
+	// ReverseDNSAnnotation is the FloatingIP annotation with the name of the
+	// reverse DNS (PTR) record of the IP, for example "web.example.com".
+	ReverseDNSAnnotation = "rancher.k8s.binbash.org/reverse-dns"
+)
+
+// AnnotationSchema describes the value of a known FloatingIP annotation.
+type AnnotationSchema struct {
+	// Validate checks the value, the returned error describes the expected
+	// format. Every value is accepted when it is nil, for annotations which
+	// are checked by their own validator.
+	Validate func(value string) error
+}
+
+// DefaultAnnotationSchemas returns the schemas of the built-in FloatingIP
+// annotations.
+func DefaultAnnotationSchemas() map[string]AnnotationSchema {
+	return map[string]AnnotationSchema{
+		StaticARPAnnotation: {Validate: func(value string) error {
+			return validator.ValidateEnum(value, "true", "false")
+		}},
+		DNSNameAnnotation:       {Validate: validator.ValidateFQDN},
+		ReverseDNSAnnotation:    {Validate: validator.ValidateFQDN},
+		PoolSelectorAnnotation:  {},
+		QuotaOverrideAnnotation: {},
+		ExpiresAfterAnnotation:  {},
+		TargetVMAnnotation:      {Validate: validator.ValidateObjectReference},
+		TargetAnnotation:        {Validate: validateTarget},
+	}
+}
+
+// RegisterAnnotation adds the schema of a FloatingIP annotation, or replaces
+// the schema of a built-in annotation.
+func (h *Handler) RegisterAnnotation(key string, schema AnnotationSchema) {
+	if h.annotations == nil {
+		h.annotations = make(map[string]AnnotationSchema)
+	}
+	h.annotations[key] = schema
+}
+
+// annotationSchemas returns the built-in and the registered annotation schemas.
+func (h *Handler) annotationSchemas() map[string]AnnotationSchema {
+	schemas := DefaultAnnotationSchemas()
+	for key, schema := range h.annotations {
+		schemas[key] = schema
+	}
+
+	return schemas
+}
+
+// AnnotationsValid checks the values of the known FloatingIP annotations, so
+// a typo is reported when the FloatingIP is applied instead of when it is
+// reconciled. Unknown annotations with the AnnotationPrefix are allowed with
+// a warning, which suggests the closest known annotation. On updates only
+// the changed annotations are checked.
+type AnnotationsValid struct{}
+
+func (v *AnnotationsValid) Name() string { return "AnnotationsValid" }
+
+func (v *AnnotationsValid) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	schemas := h.annotationSchemas()
+	known := make([]string, 0, len(schemas))
+	for key := range schemas {
+		known = append(known, key)
+	}
+
+	keys := make([]string, 0, len(req.FIP.Annotations))
+	for key := range req.FIP.Annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, key := range keys {
+		value := req.FIP.Annotations[key]
+		if req.IsUpdate() {
+			if oldValue, ok := req.OldFIP.Annotations[key]; ok && oldValue == value {
+				continue
+			}
+		}
+
+		schema, ok := schemas[key]
+		switch {
+		case ok && schema.Validate != nil:
+			if err := schema.Validate(value); err != nil {
+				problems = append(problems, fmt.Sprintf("invalid annotation %s=%q: %s", key, value, err))
+			}
+		case !ok && strings.HasPrefix(key, AnnotationPrefix):
+			warning := fmt.Sprintf("unknown annotation %s", key)
+			if closest := validator.ClosestKey(key, known); closest != "" {
+				warning += fmt.Sprintf(", did you mean %s?", closest)
+			}
+			req.Warnings = append(req.Warnings, warning)
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAnnotationsValid(t *testing.T) {
+	newFIP := func(annotations map[string]string) *rfmv2.FloatingIP {
+		return &rfmv2.FloatingIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default", Annotations: annotations},
+			Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool"},
+		}
+	}
+
+	testCases := []struct {
+		name             string
+		fip              *rfmv2.FloatingIP
+		oldFIP           *rfmv2.FloatingIP
+		expectedMessage  string
+		expectedWarnings []string
+	}{
+		{
+			name: "no annotations",
+			fip:  newFIP(nil),
+		},
+		{
+			name: "valid annotations",
+			fip: newFIP(map[string]string{
+				StaticARPAnnotation:    "true",
+				DNSNameAnnotation:      "web.example.com",
+				ReverseDNSAnnotation:   "web.example.com.",
+				PoolSelectorAnnotation: "zone=a",
+				"example.com/owner":    "team-a",
+			}),
+		},
+		{
+			name: "invalid annotations",
+			fip: newFIP(map[string]string{
+				StaticARPAnnotation: "yes",
+				DNSNameAnnotation:   "web",
+			}),
+			expectedMessage: `invalid annotation rancher.k8s.binbash.org/dns-name="web": must be a fully qualified domain name like web.example.com; ` +
+				`invalid annotation rancher.k8s.binbash.org/static-arp="yes": must be one of: true, false`,
+		},
+		{
+			name:             "misspelled annotation",
+			fip:              newFIP(map[string]string{"rancher.k8s.binbash.org/expire-after": "30d"}),
+			expectedWarnings: []string{"unknown annotation rancher.k8s.binbash.org/expire-after, did you mean rancher.k8s.binbash.org/expires-after?"},
+		},
+		{
+			name:             "unknown annotation",
+			fip:              newFIP(map[string]string{"rancher.k8s.binbash.org/cost-center": "cc-1234"}),
+			expectedWarnings: []string{"unknown annotation rancher.k8s.binbash.org/cost-center"},
+		},
+		{
+			name:   "update with unchanged invalid annotation",
+			fip:    newFIP(map[string]string{StaticARPAnnotation: "yes"}),
+			oldFIP: newFIP(map[string]string{StaticARPAnnotation: "yes"}),
+		},
+		{
+			name:            "update with changed invalid annotation",
+			fip:             newFIP(map[string]string{StaticARPAnnotation: "no"}),
+			oldFIP:          newFIP(map[string]string{StaticARPAnnotation: "yes"}),
+			expectedMessage: `invalid annotation rancher.k8s.binbash.org/static-arp="no": must be one of: true, false`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP:     tc.fip,
+				OldFIP:  tc.oldFIP,
+			}
+
+			err := (&AnnotationsValid{}).Validate(context.Background(), &Handler{}, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedWarnings, req.Warnings)
+		})
+	}
+}
+
+func TestRegisterAnnotation(t *testing.T) {
+	h := &Handler{}
+	h.RegisterAnnotation("rancher.k8s.binbash.org/cost-center", AnnotationSchema{Validate: func(value string) error {
+		if value == "" {
+			return fmt.Errorf("must not be empty")
+		}
+		return nil
+	}})
+
+	req := &FloatingIPRequest{
+		Request: &admissionv1.AdmissionRequest{},
+		Log:     log.NewEntry(log.StandardLogger()),
+		FIP: &rfmv2.FloatingIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-fip",
+				Annotations: map[string]string{"rancher.k8s.binbash.org/cost-center": ""},
+			},
+		},
+	}
+	assert.EqualError(t, (&AnnotationsValid{}).Validate(context.Background(), h, req),
+		`invalid annotation rancher.k8s.binbash.org/cost-center="": must not be empty`)
+
+	req.FIP.Annotations["rancher.k8s.binbash.org/cost-center"] = "cc-1234"
+	assert.NoError(t, (&AnnotationsValid{}).Validate(context.Background(), h, req))
+	assert.Empty(t, req.Warnings)
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// authenticationCacheTTL is how long a token which is authenticated by a
+// TokenReview is trusted, so not every admission request creates a review.
+const authenticationCacheTTL = time.Minute
+
+type authenticatedToken struct {
+	user    authenticationv1.UserInfo
+	expires time.Time
+}
+
+// tokenCache holds the tokens which were authenticated by a TokenReview,
+// keyed by the hash of the token.
+type tokenCache struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	tokens map[string]authenticatedToken
+}
+
+func newTokenCache(ttl time.Duration) *tokenCache {
+	return &tokenCache{
+		ttl:    ttl,
+		tokens: make(map[string]authenticatedToken),
+	}
+}
+
+// Get returns the user of the token if it was authenticated within the ttl.
+func (c *tokenCache) Get(key string) (authenticationv1.UserInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	token, ok := c.tokens[key]
+	if !ok || time.Now().After(token.expires) {
+		delete(c.tokens, key)
+		return authenticationv1.UserInfo{}, false
+	}
+
+	return token.user, true
+}
+
+// Add stores the user of an authenticated token.
+func (c *tokenCache) Add(key string, user authenticationv1.UserInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, token := range c.tokens {
+		if now.After(token.expires) {
+			delete(c.tokens, k)
+		}
+	}
+	c.tokens[key] = authenticatedToken{
+		user:    user,
+		expires: now.Add(c.ttl),
+	}
+}
+
+// authenticate verifies the bearer token of an admission request with a
+// TokenReview when the AuthenticateRequests option is set. It returns the
+// HTTP status and an error when the caller is rejected.
+func (h *Handler) authenticate(ctx context.Context, r *http.Request) (int, error) {
+	if !h.options.AuthenticateRequests {
+		return http.StatusOK, nil
+	}
+
+	user, status, err := h.reviewToken(ctx, r)
+	if err != nil {
+		return status, err
+	}
+
+	if len(h.options.AuthenticatedUsers) > 0 && !slices.Contains(h.options.AuthenticatedUsers, user.Username) {
+		return http.StatusForbidden, fmt.Errorf("user %s is not allowed to call the webhook", user.Username)
+	}
+
+	return http.StatusOK, nil
+}
+
+// reviewToken returns the user of the bearer token of the request, which is
+// authenticated by a TokenReview. It returns the HTTP status and an error when
+// the token is missing or not authenticated.
+func (h *Handler) reviewToken(ctx context.Context, r *http.Request) (authenticationv1.UserInfo, int, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return authenticationv1.UserInfo{}, http.StatusUnauthorized, fmt.Errorf("no bearer token")
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	user, ok := h.tokens.Get(key)
+	if !ok {
+		review, err := h.clientset.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{Token: token},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return authenticationv1.UserInfo{}, http.StatusInternalServerError, fmt.Errorf("failed to review the token: %s", err)
+		}
+		if !review.Status.Authenticated {
+			return authenticationv1.UserInfo{}, http.StatusUnauthorized, fmt.Errorf("token is not authenticated: %s", review.Status.Error)
+		}
+		user = review.Status.User
+		h.tokens.Add(key, user)
+	}
+
+	return user, http.StatusOK, nil
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	clientset := kubefake.NewSimpleClientset()
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch review.Spec.Token {
+		case "apiserver-token":
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: "system:kube-apiserver"},
+			}
+		case "user-token":
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: "jane"},
+			}
+		default:
+			review.Status = authenticationv1.TokenReviewStatus{Error: "invalid bearer token"}
+		}
+		return true, review, nil
+	})
+
+	testCases := []struct {
+		name            string
+		options         Options
+		authorization   string
+		expectedStatus  int
+		expectedMessage string
+	}{
+		{
+			name:           "authentication disabled",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:            "missing token",
+			options:         Options{AuthenticateRequests: true},
+			expectedStatus:  http.StatusUnauthorized,
+			expectedMessage: "no bearer token",
+		},
+		{
+			name:            "invalid token",
+			options:         Options{AuthenticateRequests: true},
+			authorization:   "Bearer other-token",
+			expectedStatus:  http.StatusUnauthorized,
+			expectedMessage: "token is not authenticated: invalid bearer token",
+		},
+		{
+			name:           "authenticated token",
+			options:        Options{AuthenticateRequests: true},
+			authorization:  "Bearer user-token",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "allowed user",
+			options:        Options{AuthenticateRequests: true, AuthenticatedUsers: []string{"system:kube-apiserver"}},
+			authorization:  "Bearer apiserver-token",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:            "user not allowed",
+			options:         Options{AuthenticateRequests: true, AuthenticatedUsers: []string{"system:kube-apiserver"}},
+			authorization:   "Bearer user-token",
+			expectedStatus:  http.StatusForbidden,
+			expectedMessage: "user jane is not allowed to call the webhook",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				clientset: clientset,
+				options:   tc.options,
+				tokens:    newTokenCache(authenticationCacheTTL),
+			}
+			r := httptest.NewRequest(http.MethodPost, "/validate", nil)
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+
+			status, err := h.authenticate(context.Background(), r)
+			assert.Equal(t, tc.expectedStatus, status)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestAuthenticateCachesTokens(t *testing.T) {
+	clientset := kubefake.NewSimpleClientset()
+	reviews := 0
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		review.Status = authenticationv1.TokenReviewStatus{
+			Authenticated: true,
+			User:          authenticationv1.UserInfo{Username: "system:kube-apiserver"},
+		}
+		return true, review, nil
+	})
+	h := &Handler{
+		clientset: clientset,
+		options:   Options{AuthenticateRequests: true},
+		tokens:    newTokenCache(authenticationCacheTTL),
+	}
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/validate", nil)
+		r.Header.Set("Authorization", "Bearer apiserver-token")
+		status, err := h.authenticate(context.Background(), r)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+	}
+	assert.Equal(t, 1, reviews)
+}
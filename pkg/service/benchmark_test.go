@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+// benchmarkHandler returns a handler with a pool and a project quota which
+// allow the FloatingIP returned by benchmarkFloatingIP.
+func benchmarkHandler(b *testing.B) *Handler {
+	fipPool := &rfmv2.FloatingIPPool{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPPool"},
+		ObjectMeta: metav1.ObjectMeta{Name: "bench-pool"},
+		Spec: rfmv2.FloatingIPPoolSpec{
+			IPConfig: &rfmv2.IPConfig{
+				Subnet: "10.0.0.0/16",
+				Pool: rfmv2.Pool{
+					Start:   "10.0.0.10",
+					End:     "10.0.255.200",
+					Exclude: []string{"10.0.0.11", "10.0.0.12"},
+				},
+			},
+		},
+		Status: rfmv2.FloatingIPPoolStatus{Available: 1000},
+	}
+	plbc := &rfmv2.FloatingIPProjectQuota{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPProjectQuota"},
+		ObjectMeta: metav1.ObjectMeta{Name: "bench-project"},
+		Spec: rfmv2.FloatingIPProjectQuotaSpec{
+			FloatingIPQuota: map[string]int{"bench-pool": 1000},
+		},
+	}
+
+	objects, err := getUnstructuredList([]runtime.Object{fipPool, plbc})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	h := &Handler{
+		dynamic:       fake.NewSimpleDynamicClient(runtime.NewScheme(), objects...),
+		fipValidators: DefaultFloatingIPValidators(),
+	}
+	h.RegisterKind("FloatingIP", WebhookFloatingIP, h.admitFloatingIP)
+
+	return h
+}
+
+func benchmarkFloatingIP() *rfmv2.FloatingIP {
+	ipAddr := "10.0.1.1"
+	return &rfmv2.FloatingIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bench-fip",
+			Namespace: "default",
+			Labels:    map[string]string{ProjectNameLabel: "bench-project"},
+		},
+		Spec: rfmv2.FloatingIPSpec{
+			FloatingIPPool: "bench-pool",
+			IPAddr:         &ipAddr,
+		},
+	}
+}
+
+func benchmarkValidateFloatingIP(b *testing.B, h *Handler) {
+	log.SetLevel(log.WarnLevel)
+	ar := &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{UID: "bench-uid"}}
+	logger := requestLogger(ar.Request)
+	fip := benchmarkFloatingIP()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if response := h.validateFloatingIP(context.Background(), logger, ar, fip, nil); !response.Allowed {
+			b.Fatalf("request denied: %s", response.Result.Message)
+		}
+	}
+}
+
+// BenchmarkValidateFloatingIP runs the full pipeline, including the quota check.
+func BenchmarkValidateFloatingIP(b *testing.B) {
+	benchmarkValidateFloatingIP(b, benchmarkHandler(b))
+}
+
+// BenchmarkValidateFloatingIPWithoutQuota shows the cost of the pipeline
+// without the quota check and its sleep.
+func BenchmarkValidateFloatingIPWithoutQuota(b *testing.B) {
+	h := benchmarkHandler(b)
+	h.DisableValidator("QuotaCheck")
+	benchmarkValidateFloatingIP(b, h)
+}
+
+// BenchmarkServeAdmission measures the HTTP handler including the decoding
+// and encoding of the AdmissionReview.
+func BenchmarkServeAdmission(b *testing.B) {
+	log.SetLevel(log.WarnLevel)
+	h := benchmarkHandler(b)
+	h.DisableValidator("QuotaCheck")
+
+	raw, err := json.Marshal(benchmarkFloatingIP())
+	if err != nil {
+		b.Fatal(err)
+	}
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "bench-uid",
+			Kind:      metav1.GroupVersionKind{Kind: "FloatingIP"},
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		h.validateAdmission(w, req)
+		if w.Code != http.StatusOK {
+			b.Fatalf("unexpected status %d", w.Code)
+		}
+	}
+}
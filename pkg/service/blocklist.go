@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// BlocklistProjectsKey is the key of the blocked projects in the
+	// blocklist ConfigMap.
+	BlocklistProjectsKey = "projects"
+	// BlocklistNamespacesKey is the key of the blocked namespaces in the
+	// blocklist ConfigMap.
+	BlocklistNamespacesKey = "namespaces"
+)
+
+// blocklistRefreshInterval is how long the blocklist ConfigMap is cached, so
+// changes are picked up without a restart and not every admission request
+// gets the ConfigMap.
+const blocklistRefreshInterval = 10 * time.Second
+
+// blocklist holds the projects and namespaces of the blocklist ConfigMap.
+type blocklist struct {
+	projects   map[string]bool
+	namespaces map[string]bool
+}
+
+// parseBlocklistEntries returns the entries of a blocklist key, which are
+// separated by commas or newlines. Everything after a # is a comment, so the
+// reason of a block can be recorded next to the entry.
+func parseBlocklistEntries(value string) map[string]bool {
+	entries := make(map[string]bool)
+	for _, line := range strings.Split(value, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		for _, entry := range strings.Split(line, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries[entry] = true
+			}
+		}
+	}
+
+	return entries
+}
+
+// blocklistCache holds the blocklist which was read last and when it has to
+// be read again.
+type blocklistCache struct {
+	mu       sync.Mutex
+	list     *blocklist
+	expires  time.Time
+	interval time.Duration
+}
+
+func newBlocklistCache(interval time.Duration) *blocklistCache {
+	return &blocklistCache{interval: interval}
+}
+
+// getBlocklist returns the blocklist of the BlocklistConfigMap option. A
+// missing ConfigMap blocks nothing, when the ConfigMap cannot be read the
+// blocklist which was read last is used.
+func (h *Handler) getBlocklist(ctx context.Context, req *FloatingIPRequest) (*blocklist, error) {
+	cache := h.blocklist
+	if cache == nil {
+		cache = newBlocklistCache(0)
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.list != nil && time.Now().Before(cache.expires) {
+		return cache.list, nil
+	}
+
+	name := h.options.BlocklistConfigMap
+	cm, err := h.clientset.CoreV1().ConfigMaps(name.Namespace).Get(ctx, name.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		cache.list = &blocklist{}
+	case err != nil:
+		req.Log.Errorf("failed to get the blocklist configmap %s: %s", name, err)
+		if cache.list == nil {
+			return nil, fmt.Errorf("internal server error: failed to get the blocklist")
+		}
+		return cache.list, nil
+	default:
+		cache.list = &blocklist{
+			projects:   parseBlocklistEntries(cm.Data[BlocklistProjectsKey]),
+			namespaces: parseBlocklistEntries(cm.Data[BlocklistNamespacesKey]),
+		}
+	}
+	cache.expires = time.Now().Add(cache.interval)
+
+	return cache.list, nil
+}
+
+// NotBlocked denies new FloatingIPs in the namespaces and projects of the
+// blocklist ConfigMap, for off-boarding tenants or suspending projects without
+// deleting their quotas. Existing FloatingIPs can still be updated and deleted.
+type NotBlocked struct{}
+
+func (v *NotBlocked) Name() string { return "NotBlocked" }
+
+func (v *NotBlocked) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if h.options.BlocklistConfigMap.Name == "" || h.clientset == nil || req.IsUpdate() {
+		return nil
+	}
+
+	list, err := h.getBlocklist(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if list.namespaces[req.FIP.Namespace] {
+		return fmt.Errorf("namespace %s is blocked from creating FloatingIPs", req.FIP.Namespace)
+	}
+	if projectID := req.projectID(); projectID != "" && list.projects[projectID] {
+		return fmt.Errorf("project %s is blocked from creating FloatingIPs", projectID)
+	}
+
+	return nil
+}
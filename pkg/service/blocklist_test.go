@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseBlocklistEntries(t *testing.T) {
+	assert.Equal(t, map[string]bool{"p-abc12": true, "p-def34": true, "p-ghi56": true},
+		parseBlocklistEntries("p-abc12 # off-boarded\n\np-def34, p-ghi56\n# p-jkl78\n"))
+	assert.Empty(t, parseBlocklistEntries(""))
+}
+
+func TestNotBlocked(t *testing.T) {
+	blocklistName := types.NamespacedName{Namespace: "rancher-fip-manager", Name: "blocklist"}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: blocklistName.Namespace, Name: blocklistName.Name},
+		Data: map[string]string{
+			BlocklistProjectsKey:   "p-blocked # suspended",
+			BlocklistNamespacesKey: "sandbox",
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		blocklist       types.NamespacedName
+		objects         []runtime.Object
+		namespace       string
+		project         string
+		update          bool
+		expectedMessage string
+	}{
+		{
+			name:      "not blocked",
+			blocklist: blocklistName,
+			objects:   []runtime.Object{configMap},
+			namespace: "default",
+			project:   "p-allowed",
+		},
+		{
+			name:            "blocked namespace",
+			blocklist:       blocklistName,
+			objects:         []runtime.Object{configMap},
+			namespace:       "sandbox",
+			project:         "p-allowed",
+			expectedMessage: "namespace sandbox is blocked from creating FloatingIPs",
+		},
+		{
+			name:            "blocked project",
+			blocklist:       blocklistName,
+			objects:         []runtime.Object{configMap},
+			namespace:       "default",
+			project:         "p-blocked",
+			expectedMessage: "project p-blocked is blocked from creating FloatingIPs",
+		},
+		{
+			name:      "update of a blocked project",
+			blocklist: blocklistName,
+			objects:   []runtime.Object{configMap},
+			namespace: "default",
+			project:   "p-blocked",
+			update:    true,
+		},
+		{
+			name:      "missing configmap",
+			blocklist: blocklistName,
+			namespace: "sandbox",
+			project:   "p-blocked",
+		},
+		{
+			name:      "disabled",
+			objects:   []runtime.Object{configMap},
+			namespace: "sandbox",
+			project:   "p-blocked",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				clientset: kubefake.NewSimpleClientset(tc.objects...),
+				options:   Options{BlocklistConfigMap: tc.blocklist},
+			}
+			fip := &rfmv2.FloatingIP{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-fip",
+					Namespace: tc.namespace,
+					Labels:    map[string]string{ProjectNameLabel: tc.project},
+				},
+			}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP:     fip,
+			}
+			if tc.update {
+				req.OldFIP = fip
+			}
+
+			err := (&NotBlocked{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestBlocklistCache(t *testing.T) {
+	blocklistName := types.NamespacedName{Namespace: "rancher-fip-manager", Name: "blocklist"}
+	clientset := kubefake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: blocklistName.Namespace, Name: blocklistName.Name},
+		Data:       map[string]string{BlocklistNamespacesKey: "sandbox"},
+	})
+	gets := 0
+	failing := false
+	clientset.PrependReactor("get", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if failing {
+			return true, nil, errors.New("connection refused")
+		}
+		return false, nil, nil
+	})
+	h := &Handler{
+		clientset: clientset,
+		options:   Options{BlocklistConfigMap: blocklistName},
+		blocklist: newBlocklistCache(time.Hour),
+	}
+	req := &FloatingIPRequest{Log: log.NewEntry(log.StandardLogger())}
+
+	for i := 0; i < 3; i++ {
+		list, err := h.getBlocklist(context.Background(), req)
+		assert.NoError(t, err)
+		assert.True(t, list.namespaces["sandbox"])
+	}
+	assert.Equal(t, 1, gets)
+
+	// the last blocklist is used when the ConfigMap cannot be read
+	h.blocklist.expires = time.Time{}
+	failing = true
+	list, err := h.getBlocklist(context.Background(), req)
+	assert.NoError(t, err)
+	assert.True(t, list.namespaces["sandbox"])
+	assert.Equal(t, 2, gets)
+
+	h.blocklist = newBlocklistCache(time.Hour)
+	_, err = h.getBlocklist(context.Background(), req)
+	assert.EqualError(t, err, "internal server error: failed to get the blocklist")
+}
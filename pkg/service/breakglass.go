@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// BreakGlassEnabledKey is the key of the break-glass ConfigMap which
+	// enables the break-glass mode when it is true.
+	BreakGlassEnabledKey = "enabled"
+	// BreakGlassReasonKey is the key of the break-glass ConfigMap with the
+	// reason of the break-glass mode, it is logged.
+	BreakGlassReasonKey = "reason"
+)
+
+const (
+	// breakGlassRefreshInterval is how often the break-glass ConfigMap is read.
+	breakGlassRefreshInterval = 10 * time.Second
+	// breakGlassReminderInterval is how often is logged that the break-glass
+	// mode is still enabled, so it isn't left on accidentally.
+	breakGlassReminderInterval = 5 * time.Minute
+)
+
+// breakGlassState is the state of the enabled break-glass mode.
+type breakGlassState struct {
+	reason string
+	since  time.Time
+}
+
+// refreshBreakGlass reads the break-glass ConfigMap and enables or disables
+// the break-glass mode. A missing ConfigMap disables the mode, when the
+// ConfigMap cannot be read the mode is left unchanged.
+func (h *Handler) refreshBreakGlass(ctx context.Context) error {
+	name := h.options.BreakGlassConfigMap
+	enabled, reason := false, ""
+	cm, err := h.clientset.CoreV1().ConfigMaps(name.Namespace).Get(ctx, name.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		enabled, _ = strconv.ParseBool(cm.Data[BreakGlassEnabledKey])
+		reason = cm.Data[BreakGlassReasonKey]
+	}
+
+	current := h.breakGlass.Load()
+	switch {
+	case enabled && current == nil:
+		h.breakGlass.Store(&breakGlassState{reason: reason, since: time.Now()})
+		log.Warnf("break-glass mode is enabled by configmap %s, every request is allowed: %s", name, reason)
+	case enabled && current.reason != reason:
+		h.breakGlass.Store(&breakGlassState{reason: reason, since: current.since})
+		log.Warnf("break-glass mode reason changed: %s", reason)
+	case !enabled && current != nil:
+		h.breakGlass.Store(nil)
+		log.Warnf("break-glass mode is disabled after %s", time.Since(current.since).Round(time.Second))
+	}
+	if enabled {
+		metrics.BreakGlassMode.Set(1)
+	} else {
+		metrics.BreakGlassMode.Set(0)
+	}
+
+	return nil
+}
+
+// watchBreakGlass reads the break-glass ConfigMap every interval until the
+// context is done, and logs a reminder while the break-glass mode is enabled.
+func (h *Handler) watchBreakGlass(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var reminded time.Time
+	for {
+		if err := h.refreshBreakGlass(ctx); err != nil {
+			log.Errorf("failed to get the break-glass configmap %s: %s", h.options.BreakGlassConfigMap, err)
+		}
+		// the mode is logged when it is enabled, the reminder starts after that
+		switch state := h.breakGlass.Load(); {
+		case state == nil:
+			reminded = time.Time{}
+		case reminded.IsZero():
+			reminded = time.Now()
+		case time.Since(reminded) >= breakGlassReminderInterval:
+			log.Warnf("break-glass mode is still enabled since %s, every request is allowed: %s",
+				state.since.Format(time.RFC3339), state.reason)
+			reminded = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
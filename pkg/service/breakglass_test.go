@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRefreshBreakGlass(t *testing.T) {
+	name := types.NamespacedName{Namespace: "rancher-fip-manager", Name: "break-glass"}
+	clientset := kubefake.NewSimpleClientset()
+	h := &Handler{
+		clientset: clientset,
+		options:   Options{BreakGlassConfigMap: name},
+	}
+	ctx := context.Background()
+
+	// no configmap
+	assert.NoError(t, h.refreshBreakGlass(ctx))
+	assert.Nil(t, h.breakGlass.Load())
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name},
+		Data:       map[string]string{BreakGlassEnabledKey: "true", BreakGlassReasonKey: "INC-1234"},
+	}
+	_, err := clientset.CoreV1().ConfigMaps(name.Namespace).Create(ctx, cm, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, h.refreshBreakGlass(ctx))
+	state := h.breakGlass.Load()
+	assert.NotNil(t, state)
+	assert.Equal(t, "INC-1234", state.reason)
+
+	// a failed read leaves the mode enabled
+	clientset.PrependReactor("get", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	assert.Error(t, h.refreshBreakGlass(ctx))
+	assert.Equal(t, state, h.breakGlass.Load())
+	clientset.ReactionChain = clientset.ReactionChain[1:]
+
+	cm.Data[BreakGlassEnabledKey] = "false"
+	_, err = clientset.CoreV1().ConfigMaps(name.Namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, h.refreshBreakGlass(ctx))
+	assert.Nil(t, h.breakGlass.Load())
+}
+
+func TestRecordDecisionBreakGlass(t *testing.T) {
+	h := &Handler{}
+	h.breakGlass.Store(&breakGlassState{reason: "INC-1234"})
+
+	response := &admissionv1.AdmissionResponse{
+		UID:              "test-uid",
+		Allowed:          false,
+		Result:           &metav1.Status{Message: "quota exceeded"},
+		AuditAnnotations: map[string]string{},
+	}
+	h.recordDecision(WebhookFloatingIP, log.NewEntry(log.StandardLogger()), response)
+	assert.True(t, response.Allowed)
+	assert.Nil(t, response.Result)
+	assert.Equal(t, "break-glass", response.AuditAnnotations["decision"])
+	assert.Equal(t, []string{"break-glass mode: request would have been denied: quota exceeded"}, response.Warnings)
+}
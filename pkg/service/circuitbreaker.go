@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/metrics"
+)
+
+// DefaultCircuitBreakerTimeout is the time the circuit breaker stays open
+// before a lookup is let through to probe the API server.
+const DefaultCircuitBreakerTimeout = 30 * time.Second
+
+// ErrCircuitOpen is returned by the pool and quota lookups while the circuit
+// breaker is open. It is a transient error, the requests are handled by the
+// DegradedPolicy.
+var ErrCircuitOpen = errors.New("the API server is degraded, the lookups are suspended")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker stops the pool and quota lookups after threshold consecutive
+// transient failures, so a degraded API server doesn't stall every admission
+// request with slow failing lookups. After the timeout a single lookup is let
+// through, the breaker closes again when it succeeds. A nil breaker lets
+// every lookup through.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	timeout   time.Duration
+	failures  int
+	state     circuitState
+	openedAt  time.Time
+	probing   bool
+}
+
+// newCircuitBreaker returns a breaker which opens after threshold consecutive
+// transient failures, the breaker is disabled when it is 0.
+func newCircuitBreaker(threshold int, timeout time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = DefaultCircuitBreakerTimeout
+	}
+	metrics.CircuitBreakerState.Set(float64(circuitClosed))
+
+	return &circuitBreaker{
+		threshold: threshold,
+		timeout:   timeout,
+	}
+}
+
+// Allow returns true if a lookup may be sent to the API server.
+func (b *circuitBreaker) Allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if now.Sub(b.openedAt) < b.timeout {
+			return false
+		}
+		b.setState(circuitHalfOpen)
+		b.probing = true
+		return true
+	case circuitHalfOpen:
+		// only the probe is let through
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+
+	return true
+}
+
+// Record records the result of a lookup which was allowed. Transient errors
+// and lookups which ran out of time are failures, every answer of the API
+// server, like not found, is a success. Cancelled lookups are not counted.
+func (b *circuitBreaker) Record(err error, now time.Time) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if !isTransient(err) && !errors.Is(err, context.DeadlineExceeded) {
+		b.failures = 0
+		b.setState(circuitClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		b.openedAt = now
+		b.setState(circuitOpen)
+	}
+}
+
+// Degraded returns true if the breaker is open or probing the API server.
+func (b *circuitBreaker) Degraded() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state != circuitClosed
+}
+
+func (b *circuitBreaker) setState(state circuitState) {
+	b.state = state
+	metrics.CircuitBreakerState.Set(float64(state))
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	unavailable := apierrors.NewServiceUnavailable("etcd is unavailable")
+	notFound := apierrors.NewNotFound(rfmv2.Resource("floatingippools"), "test-pool")
+
+	// a nil breaker lets every lookup through
+	var disabled *circuitBreaker
+	assert.True(t, disabled.Allow(now))
+	disabled.Record(unavailable, now)
+	assert.False(t, disabled.Degraded())
+	assert.Nil(t, newCircuitBreaker(0, time.Minute))
+
+	b := newCircuitBreaker(2, time.Minute)
+	assert.True(t, b.Allow(now))
+	b.Record(unavailable, now)
+	// an answer of the API server resets the failures
+	b.Record(notFound, now)
+	b.Record(unavailable, now)
+	b.Record(context.Canceled, now)
+	assert.False(t, b.Degraded())
+
+	b.Record(context.DeadlineExceeded, now)
+	assert.True(t, b.Degraded())
+	assert.False(t, b.Allow(now.Add(30*time.Second)))
+
+	// a single lookup probes the API server after the timeout
+	assert.True(t, b.Allow(now.Add(time.Minute)))
+	assert.False(t, b.Allow(now.Add(time.Minute)))
+	b.Record(unavailable, now.Add(time.Minute))
+	assert.False(t, b.Allow(now.Add(90*time.Second)))
+
+	assert.True(t, b.Allow(now.Add(2*time.Minute)))
+	b.Record(nil, now.Add(2*time.Minute))
+	assert.False(t, b.Degraded())
+	assert.True(t, b.Allow(now.Add(2*time.Minute)))
+}
+
+func TestValidateFloatingIPCircuitOpen(t *testing.T) {
+	testCases := []struct {
+		name            string
+		failurePolicy   admregv1.FailurePolicyType
+		degradedPolicy  admregv1.FailurePolicyType
+		expectedAllowed bool
+	}{
+		{
+			name: "denied with a retryable status",
+		},
+		{
+			name:            "allowed by the degraded policy",
+			degradedPolicy:  admregv1.Ignore,
+			expectedAllowed: true,
+		},
+		{
+			name:           "denied by the degraded policy",
+			failurePolicy:  admregv1.Ignore,
+			degradedPolicy: admregv1.Fail,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dynamicClient := fake.NewSimpleDynamicClient(runtime.NewScheme())
+			calls := 0
+			dynamicClient.PrependReactor("get", "floatingippools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				calls++
+				return true, nil, apierrors.NewServiceUnavailable("etcd is unavailable")
+			})
+			h := &Handler{
+				dynamic:       dynamicClient,
+				options:       Options{InternalFailurePolicy: tc.failurePolicy, DegradedPolicy: tc.degradedPolicy},
+				fipValidators: []FloatingIPValidator{&PoolExists{}},
+				breaker:       newCircuitBreaker(1, time.Minute),
+			}
+			ar := &admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{UID: "test-uid"},
+			}
+			fip := &rfmv2.FloatingIP{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default"},
+				Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool"},
+			}
+
+			// the first request opens the breaker
+			h.validateFloatingIP(context.Background(), log.NewEntry(log.StandardLogger()), ar, fip, nil)
+			assert.Equal(t, 3, calls)
+
+			_, err := h.getFloatingIPPool(context.Background(), "test-pool")
+			assert.True(t, errors.Is(err, ErrCircuitOpen))
+
+			response := h.validateFloatingIP(context.Background(), log.NewEntry(log.StandardLogger()), ar, fip, nil)
+			assert.Equal(t, 3, calls)
+			assert.Equal(t, tc.expectedAllowed, response.Allowed)
+			assert.Equal(t, "degraded", response.AuditAnnotations["failure"])
+			if tc.expectedAllowed {
+				assert.Len(t, response.Warnings, 1)
+				return
+			}
+			assert.Equal(t, "PoolExists", response.AuditAnnotations["denied-by"])
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultClaimTTL is how long an IP claimed by an allowed admission request is
+// protected against other requests, which gives the controller time to
+// allocate the IP in the pool status.
+const DefaultClaimTTL = 30 * time.Second
+
+type claim struct {
+	owner   string
+	expires time.Time
+}
+
+// claimTable holds the IPs which are requested by admission requests that are
+// still in flight or not yet reconciled. It only covers the requests which
+// are handled by this replica.
+type claimTable struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	claims map[string]claim
+}
+
+func newClaimTable(ttl time.Duration) *claimTable {
+	return &claimTable{
+		ttl:    ttl,
+		claims: make(map[string]claim),
+	}
+}
+
+func claimKey(pool string, ip string) string {
+	return pool + "/" + ip
+}
+
+// Claim claims the key for the owner. It returns the current owner and false
+// if the key is already claimed by another owner.
+func (c *claimTable) Claim(key string, owner string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.expire(now)
+
+	if existing, ok := c.claims[key]; ok && existing.owner != owner {
+		return existing.owner, false
+	}
+	c.claims[key] = claim{
+		owner:   owner,
+		expires: now.Add(c.ttl),
+	}
+
+	return owner, true
+}
+
+// Release removes the claim if it is held by the owner.
+func (c *claimTable) Release(key string, owner string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if existing, ok := c.claims[key]; ok && existing.owner == owner {
+		delete(c.claims, key)
+	}
+}
+
+func (c *claimTable) expire(now time.Time) {
+	for key, existing := range c.claims {
+		if now.After(existing.expires) {
+			delete(c.claims, key)
+		}
+	}
+}
+
+type deniedClaimsKey struct{}
+
+// deniedClaim is an IP which was claimed by an admission request that was not
+// allowed by the validators.
+type deniedClaim struct {
+	key   string
+	owner string
+}
+
+// withDeniedClaims returns a context which collects the claims of the
+// requests that are not allowed by the validators, so serveAdmission can
+// release them after the audit mode and the break-glass mode are applied.
+func withDeniedClaims(ctx context.Context) (context.Context, *[]deniedClaim) {
+	claims := &[]deniedClaim{}
+	return context.WithValue(ctx, deniedClaimsKey{}, claims), claims
+}
+
+// releaseClaim releases the claim of a request which is not allowed by the
+// validators. If the context collects the denied claims, the claim is only
+// released when the final response is a denial, otherwise it is released
+// right away.
+func (h *Handler) releaseClaim(ctx context.Context, key string, owner string) {
+	if claims, ok := ctx.Value(deniedClaimsKey{}).(*[]deniedClaim); ok {
+		*claims = append(*claims, deniedClaim{key: key, owner: owner})
+		return
+	}
+	h.claims.Release(key, owner)
+}
+
+// releaseDeniedClaims releases the collected claims of a request.
+func (h *Handler) releaseDeniedClaims(claims []deniedClaim) {
+	for _, c := range claims {
+		h.claims.Release(c.key, c.owner)
+	}
+}
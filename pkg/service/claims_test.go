@@ -0,0 +1,167 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestClaimTable(t *testing.T) {
+	claims := newClaimTable(time.Minute)
+
+	_, ok := claims.Claim("pool/10.0.0.1", "default/fip-a")
+	assert.True(t, ok)
+
+	// the same FloatingIP can retry its request
+	_, ok = claims.Claim("pool/10.0.0.1", "default/fip-a")
+	assert.True(t, ok)
+
+	owner, ok := claims.Claim("pool/10.0.0.1", "default/fip-b")
+	assert.False(t, ok)
+	assert.Equal(t, "default/fip-a", owner)
+
+	// only the owner can release the claim
+	claims.Release("pool/10.0.0.1", "default/fip-b")
+	_, ok = claims.Claim("pool/10.0.0.1", "default/fip-b")
+	assert.False(t, ok)
+
+	claims.Release("pool/10.0.0.1", "default/fip-a")
+	_, ok = claims.Claim("pool/10.0.0.1", "default/fip-b")
+	assert.True(t, ok)
+
+	expiring := newClaimTable(time.Nanosecond)
+	_, ok = expiring.Claim("pool/10.0.0.1", "default/fip-a")
+	assert.True(t, ok)
+	time.Sleep(time.Millisecond)
+	_, ok = expiring.Claim("pool/10.0.0.1", "default/fip-b")
+	assert.True(t, ok)
+}
+
+type denyFloatingIPValidator struct{}
+
+func (v *denyFloatingIPValidator) Name() string { return "DenyFloatingIP" }
+
+func (v *denyFloatingIPValidator) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	return fmt.Errorf("denied by custom validator")
+}
+
+func TestNotClaimed(t *testing.T) {
+	h := &Handler{
+		fipValidators: []FloatingIPValidator{&NotClaimed{}, &denyFloatingIPValidator{}},
+		claims:        newClaimTable(time.Minute),
+	}
+	ipAddr := "10.0.0.1"
+	fip := func(name string) *rfmv2.FloatingIP {
+		return &rfmv2.FloatingIP{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "pool", IPAddr: &ipAddr},
+		}
+	}
+	ar := &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{UID: "test-uid"}}
+
+	// a request which is denied by a later validator releases its claim
+	response := h.validateFloatingIP(context.Background(), requestLogger(ar.Request), ar, fip("fip-a"), nil)
+	assert.Equal(t, "DenyFloatingIP", response.AuditAnnotations["denied-by"])
+	_, ok := h.claims.Claim(claimKey("pool", ipAddr), "default/fip-b")
+	assert.True(t, ok)
+	h.claims.Release(claimKey("pool", ipAddr), "default/fip-b")
+
+	h.DisableValidator("DenyFloatingIP")
+	response = h.validateFloatingIP(context.Background(), requestLogger(ar.Request), ar, fip("fip-a"), nil)
+	assert.True(t, response.Allowed)
+
+	response = h.validateFloatingIP(context.Background(), requestLogger(ar.Request), ar, fip("fip-b"), nil)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "requested IP 10.0.0.1 is already requested by FloatingIP default/fip-a", response.Result.Message)
+}
+
+type transientFloatingIPValidator struct{}
+
+func (v *transientFloatingIPValidator) Name() string { return "TransientFloatingIP" }
+
+func (v *transientFloatingIPValidator) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	return &TransientError{Err: fmt.Errorf("the pool could not be read")}
+}
+
+func TestNotClaimedAllowedDenial(t *testing.T) {
+	ipAddr := "10.0.0.1"
+	raw, err := json.Marshal(&rfmv2.FloatingIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "fip-a", Namespace: "default"},
+		Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "pool", IPAddr: &ipAddr},
+	})
+	assert.NoError(t, err)
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "test-uid",
+			Kind:      metav1.GroupVersionKind{Kind: "FloatingIP"},
+			Namespace: "default",
+			Name:      "fip-a",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	assert.NoError(t, err)
+	validate := func(h *Handler) *admissionv1.AdmissionResponse {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		h.validateAdmission(w, req)
+		response := &admissionv1.AdmissionReview{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(response))
+		return response.Response
+	}
+
+	testCases := []struct {
+		name      string
+		options   Options
+		validator FloatingIPValidator
+		allowed   bool
+	}{
+		{
+			name:      "denied request",
+			validator: &denyFloatingIPValidator{},
+		},
+		{
+			name:      "denial allowed by the audit mode",
+			options:   Options{AuditMode: map[string]bool{WebhookFloatingIP: true}},
+			validator: &denyFloatingIPValidator{},
+			allowed:   true,
+		},
+		{
+			name:      "transient error allowed by the internal failure policy",
+			options:   Options{InternalFailurePolicy: admregv1.Ignore},
+			validator: &transientFloatingIPValidator{},
+			allowed:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				options:       tc.options,
+				fipValidators: []FloatingIPValidator{&NotClaimed{}, tc.validator},
+				claims:        newClaimTable(time.Minute),
+			}
+			h.RegisterKind("FloatingIP", WebhookFloatingIP, h.admitFloatingIP)
+
+			response := validate(h)
+			assert.Equal(t, tc.allowed, response.Allowed)
+
+			// the IP of an allowed request stays claimed
+			_, ok := h.claims.Claim(claimKey("pool", ipAddr), "default/fip-b")
+			assert.Equal(t, !tc.allowed, ok)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterLimitCheck enforces the MaxFloatingIPs option, which limits the total
+// number of FloatingIPs in the cluster. Only new FloatingIPs are counted.
+type ClusterLimitCheck struct{}
+
+func (v *ClusterLimitCheck) Name() string { return "ClusterLimitCheck" }
+
+func (v *ClusterLimitCheck) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if h.options.MaxFloatingIPs <= 0 || req.IsUpdate() {
+		return nil
+	}
+
+	list, err := h.dynamic.Resource(h.apiResources().FloatingIPGVR()).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		req.Log.Errorf("failed to list floatingips: %s", err)
+		return fmt.Errorf("internal server error: failed to list floatingips")
+	}
+
+	used := 0
+	for _, item := range list.Items {
+		if item.GetDeletionTimestamp() == nil {
+			used++
+		}
+	}
+
+	return h.checkQuotaLimit(req, QuotaLimit{
+		Scope: QuotaScopeCluster,
+		Limit: h.options.MaxFloatingIPs,
+		Used:  used,
+		Err:   fmt.Errorf("cluster limit exceeded, the cluster has %d FloatingIPs and allows at most %d", used, h.options.MaxFloatingIPs),
+	})
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestClusterLimitCheck(t *testing.T) {
+	fip := func(namespace string, name string) *rfmv2.FloatingIP {
+		return &rfmv2.FloatingIP{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIP"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "public"},
+		}
+	}
+	objects, err := getUnstructuredList([]runtime.Object{
+		fip("team-a", "fip-1"),
+		fip("team-b", "fip-2"),
+	})
+	assert.NoError(t, err)
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingips"}: "FloatingIPList",
+	}, objects...)
+
+	testCases := []struct {
+		name           string
+		maxFloatingIPs int
+		update         bool
+		expectedError  string
+	}{
+		{
+			name: "not limited",
+		},
+		{
+			name:           "within the limit",
+			maxFloatingIPs: 3,
+		},
+		{
+			name:           "limit reached",
+			maxFloatingIPs: 2,
+			expectedError:  "cluster limit exceeded, the cluster has 2 FloatingIPs and allows at most 2",
+		},
+		{
+			name:           "existing FloatingIP is updated",
+			maxFloatingIPs: 2,
+			update:         true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				dynamic: dynamicClient,
+				options: Options{MaxFloatingIPs: tc.maxFloatingIPs},
+			}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP:     fip("team-c", "new-fip"),
+			}
+			if tc.update {
+				req.OldFIP = req.FIP.DeepCopy()
+			}
+
+			err := (&ClusterLimitCheck{}).Validate(context.Background(), h, req)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
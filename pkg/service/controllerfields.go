@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// DefaultControllerUser is the service account of the rancher-fip-manager
+// controller, which allocates the IPs, in the default install. The
+// ControllerUsers option has to be set to protect the status.
+const DefaultControllerUser = "system:serviceaccount:rancher-fip-manager:rancher-fip-manager"
+
+// isController returns true if the user is one of the ControllerUsers.
+func (h *Handler) isController(user string) bool {
+	for _, controller := range h.options.ControllerUsers {
+		if user == controller {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PoolStatusProtected only allows the ControllerUsers to change the status of
+// FloatingIPPools, which holds the allocations, so users cannot hand-edit a
+// pool into a state the controller never assigned. The check is disabled when
+// the ControllerUsers option is empty.
+type PoolStatusProtected struct{}
+
+func (v *PoolStatusProtected) Name() string { return "PoolStatusProtected" }
+
+// ValidatesStatus marks PoolStatusProtected as a FloatingIPPoolStatusValidator.
+func (v *PoolStatusProtected) ValidatesStatus() {}
+
+func (v *PoolStatusProtected) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	if len(h.options.ControllerUsers) == 0 || !req.IsUpdate() || req.IsDelete() {
+		return nil
+	}
+	if equality.Semantic.DeepEqual(req.OldPool.Status, req.Pool.Status) || h.isController(req.Request.UserInfo.Username) {
+		return nil
+	}
+
+	return fmt.Errorf("the status of floatingippool %s can only be changed by the rancher-fip-manager controller", req.Pool.Name)
+}
+
+// validateFloatingIPStatus validates an UPDATE of the status subresource of a
+// FloatingIP, which holds the allocated IP. Only the ControllerUsers may
+// change it, the FloatingIP validators don't run for status updates.
+func (h *Handler) validateFloatingIPStatus(logger *log.Entry, ar *admissionv1.AdmissionReview, fip *rfmv2.FloatingIP, oldFIP *rfmv2.FloatingIP) *admissionv1.AdmissionResponse {
+	req := &FloatingIPRequest{
+		Request: ar.Request,
+		Log:     logger,
+		FIP:     fip,
+		OldFIP:  oldFIP,
+	}
+
+	if len(h.options.ControllerUsers) == 0 || oldFIP == nil ||
+		equality.Semantic.DeepEqual(oldFIP.Status, fip.Status) || h.isController(ar.Request.UserInfo.Username) {
+		response := allowed(ar)
+		response.AuditAnnotations = req.auditAnnotations("allowed", "")
+		return response
+	}
+
+	logger.Warnf("(validateFloatingIPStatus) user %s is not allowed to change the status of floatingip %s/%s",
+		ar.Request.UserInfo.Username, fip.Namespace, fip.Name)
+	response := denied(ar, fmt.Sprintf("the status of floatingip %s/%s can only be changed by the rancher-fip-manager controller", fip.Namespace, fip.Name))
+	response.AuditAnnotations = req.auditAnnotations("denied", "FloatingIPStatusProtected")
+	return response
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestPoolStatusProtected(t *testing.T) {
+	pool := &rfmv2.FloatingIPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pool"},
+		Status: rfmv2.FloatingIPPoolStatus{
+			Allocated: map[string]string{"192.168.10.10": "default/fip-1"},
+			Used:      1,
+			Available: 10,
+		},
+	}
+	allocatedPool := pool.DeepCopy()
+	allocatedPool.Status.Allocated["192.168.10.11"] = "default/fip-2"
+	allocatedPool.Status.Used = 2
+	allocatedPool.Status.Available = 9
+	labeledPool := pool.DeepCopy()
+	labeledPool.Labels = map[string]string{"zone": "a"}
+
+	testCases := []struct {
+		name            string
+		controllers     []string
+		user            string
+		operation       admissionv1.Operation
+		pool            *rfmv2.FloatingIPPool
+		oldPool         *rfmv2.FloatingIPPool
+		expectedMessage string
+	}{
+		{
+			name:      "check disabled",
+			user:      "alice",
+			operation: admissionv1.Update,
+			pool:      allocatedPool,
+			oldPool:   pool,
+		},
+		{
+			name:        "create",
+			controllers: []string{DefaultControllerUser},
+			user:        "alice",
+			operation:   admissionv1.Create,
+			pool:        pool,
+		},
+		{
+			name:        "status changed by the controller",
+			controllers: []string{DefaultControllerUser},
+			user:        DefaultControllerUser,
+			operation:   admissionv1.Update,
+			pool:        allocatedPool,
+			oldPool:     pool,
+		},
+		{
+			name:            "status changed by a user",
+			controllers:     []string{DefaultControllerUser},
+			user:            "alice",
+			operation:       admissionv1.Update,
+			pool:            allocatedPool,
+			oldPool:         pool,
+			expectedMessage: "the status of floatingippool test-pool can only be changed by the rancher-fip-manager controller",
+		},
+		{
+			name:        "metadata changed by a user",
+			controllers: []string{DefaultControllerUser},
+			user:        "alice",
+			operation:   admissionv1.Update,
+			pool:        labeledPool,
+			oldPool:     pool,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{options: Options{ControllerUsers: tc.controllers}}
+			req := &FloatingIPPoolRequest{
+				Request: &admissionv1.AdmissionRequest{
+					Operation: tc.operation,
+					UserInfo:  authenticationv1.UserInfo{Username: tc.user},
+				},
+				Log:     log.NewEntry(log.StandardLogger()),
+				Pool:    tc.pool,
+				OldPool: tc.oldPool,
+			}
+
+			err := (&PoolStatusProtected{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestFloatingIPStatusProtected(t *testing.T) {
+	fip := &rfmv2.FloatingIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default"},
+		Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool"},
+		Status:     rfmv2.FloatingIPStatus{IPAddr: "192.168.10.10", State: "Assigned"},
+	}
+	editedFIP := fip.DeepCopy()
+	editedFIP.Status.IPAddr = "192.168.10.20"
+
+	// the FloatingIP validators are not run for status updates
+	h := &Handler{
+		options:       Options{ControllerUsers: []string{DefaultControllerUser}},
+		fipValidators: []FloatingIPValidator{&denyFloatingIPValidator{}},
+	}
+	review := func(user string, fip *rfmv2.FloatingIP, oldFIP *rfmv2.FloatingIP) *admissionv1.AdmissionReview {
+		raw, err := json.Marshal(fip)
+		assert.NoError(t, err)
+		oldRaw, err := json.Marshal(oldFIP)
+		assert.NoError(t, err)
+		return &admissionv1.AdmissionReview{
+			Request: &admissionv1.AdmissionRequest{
+				UID:         "test-uid",
+				Kind:        metav1.GroupVersionKind{Kind: "FloatingIP"},
+				Operation:   admissionv1.Update,
+				SubResource: "status",
+				UserInfo:    authenticationv1.UserInfo{Username: user},
+				Object:      runtime.RawExtension{Raw: raw},
+				OldObject:   runtime.RawExtension{Raw: oldRaw},
+			},
+		}
+	}
+	logger := log.NewEntry(log.StandardLogger())
+
+	response, err := h.admitFloatingIP(context.Background(), logger, review(DefaultControllerUser, editedFIP, fip))
+	assert.NoError(t, err)
+	assert.True(t, response.Allowed)
+
+	response, err = h.admitFloatingIP(context.Background(), logger, review("alice", editedFIP, fip))
+	assert.NoError(t, err)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "the status of floatingip default/test-fip can only be changed by the rancher-fip-manager controller", response.Result.Message)
+	assert.Equal(t, "FloatingIPStatusProtected", response.AuditAnnotations["denied-by"])
+
+	// an unchanged status is allowed
+	response, err = h.admitFloatingIP(context.Background(), logger, review("alice", fip, fip))
+	assert.NoError(t, err)
+	assert.True(t, response.Allowed)
+
+	// every user may change the status when the check is disabled
+	h.options.ControllerUsers = nil
+	response, err = h.admitFloatingIP(context.Background(), logger, review("alice", editedFIP, fip))
+	assert.NoError(t, err)
+	assert.True(t, response.Allowed)
+}
+
+func TestValidateStatusSubresource(t *testing.T) {
+	h := &Handler{
+		options:           Options{ControllerUsers: []string{DefaultControllerUser}},
+		fipPoolValidators: DefaultFloatingIPPoolValidators(),
+		quotaValidators:   DefaultFloatingIPProjectQuotaValidators(),
+	}
+	// the spec of the pool and the quota would be denied by the validators
+	pool := &rfmv2.FloatingIPPool{ObjectMeta: metav1.ObjectMeta{Name: "test-pool"}}
+	allocatedPool := pool.DeepCopy()
+	allocatedPool.Status.Allocated = map[string]string{"192.168.10.10": "default/fip-1"}
+	quota := &rfmv2.FloatingIPProjectQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-project"},
+		Spec:       rfmv2.FloatingIPProjectQuotaSpec{FloatingIPQuota: map[string]int{"test-pool": -1}},
+	}
+	review := func(user string, subResource string) *admissionv1.AdmissionReview {
+		return &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+			UID:         "test-uid",
+			Operation:   admissionv1.Update,
+			SubResource: subResource,
+			UserInfo:    authenticationv1.UserInfo{Username: user},
+		}}
+	}
+	logger := log.NewEntry(log.StandardLogger())
+
+	response := h.validateFloatingIPPool(context.Background(), logger, review(DefaultControllerUser, ""), allocatedPool, pool)
+	assert.False(t, response.Allowed)
+
+	response = h.validateFloatingIPPool(context.Background(), logger, review(DefaultControllerUser, "status"), allocatedPool, pool)
+	assert.True(t, response.Allowed)
+
+	response = h.validateFloatingIPPool(context.Background(), logger, review("alice", "status"), allocatedPool, pool)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "PoolStatusProtected", response.AuditAnnotations["denied-by"])
+
+	response = h.validateFloatingIPProjectQuota(context.Background(), logger, review("alice", ""), quota, quota)
+	assert.False(t, response.Allowed)
+
+	response = h.validateFloatingIPProjectQuota(context.Background(), logger, review(DefaultControllerUser, "status"), quota, quota)
+	assert.True(t, response.Allowed)
+}
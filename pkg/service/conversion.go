@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Converter converts an object of a FloatingIP resource in place to the API
+// version toVersion, like v1. The apiVersion of the object is set by the
+// caller after the conversion.
+type Converter func(obj *unstructured.Unstructured, toVersion string) error
+
+// RegisterConverter sets the converter of a kind. Objects of kinds without a
+// converter only get their apiVersion rewritten, which is right as long as
+// the API versions of the kind share the same schema.
+func (h *Handler) RegisterConverter(kind string, converter Converter) {
+	if h.converters == nil {
+		h.converters = make(map[string]Converter)
+	}
+	h.converters[kind] = converter
+}
+
+// convertObject converts an object of a ConversionReview to the desired API
+// version of the request.
+func (h *Handler) convertObject(raw runtime.RawExtension, desiredAPIVersion string) (runtime.RawExtension, error) {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(raw.Raw); err != nil {
+		return runtime.RawExtension{}, fmt.Errorf("cannot unmarshal object: %s", err)
+	}
+
+	from, err := schema.ParseGroupVersion(obj.GetAPIVersion())
+	if err != nil {
+		return runtime.RawExtension{}, fmt.Errorf("invalid apiVersion of %s %s: %s", obj.GetKind(), obj.GetName(), err)
+	}
+	to, err := schema.ParseGroupVersion(desiredAPIVersion)
+	if err != nil {
+		return runtime.RawExtension{}, fmt.Errorf("invalid desired apiVersion: %s", err)
+	}
+	group := h.apiResources().Group
+	if from.Group != group || to.Group != group {
+		return runtime.RawExtension{}, fmt.Errorf("cannot convert %s %s from %s to %s, only the %s group is served",
+			obj.GetKind(), obj.GetName(), from, to, group)
+	}
+
+	if from.Version != to.Version {
+		if converter, ok := h.converters[obj.GetKind()]; ok {
+			if err := converter(obj, to.Version); err != nil {
+				return runtime.RawExtension{}, fmt.Errorf("cannot convert %s %s from %s to %s: %s", obj.GetKind(), obj.GetName(), from.Version, to.Version, err)
+			}
+		}
+		obj.SetAPIVersion(desiredAPIVersion)
+	}
+
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return runtime.RawExtension{}, fmt.Errorf("cannot marshal %s %s: %s", obj.GetKind(), obj.GetName(), err)
+	}
+
+	return runtime.RawExtension{Raw: data}, nil
+}
+
+// convert converts all objects of the request, the request fails when one of
+// the objects cannot be converted.
+func (h *Handler) convert(req *apiextensionsv1.ConversionRequest) *apiextensionsv1.ConversionResponse {
+	response := &apiextensionsv1.ConversionResponse{UID: req.UID}
+
+	converted := make([]runtime.RawExtension, 0, len(req.Objects))
+	for _, raw := range req.Objects {
+		object, err := h.convertObject(raw, req.DesiredAPIVersion)
+		if err != nil {
+			log.Errorf("(convert) conversion request %s failed: %s", req.UID, err)
+			metrics.ConversionRequests.WithLabelValues("failed").Inc()
+			response.Result = metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+			return response
+		}
+		converted = append(converted, object)
+	}
+	response.ConvertedObjects = converted
+
+	metrics.ConversionRequests.WithLabelValues("converted").Inc()
+	response.Result = metav1.Status{Status: metav1.StatusSuccess}
+	return response
+}
+
+// serveConversion serves the ConversionReviews of the FloatingIP CRDs, when
+// their conversion strategy points to the /convert endpoint.
+func (h *Handler) serveConversion(w http.ResponseWriter, r *http.Request) {
+	review := &apiextensionsv1.ConversionReview{}
+	if !h.decodeReview(w, r, "serveConversion", "ConversionReview", review) {
+		return
+	}
+	if review.Request == nil {
+		log.Errorf("(serveConversion) ConversionReview contains no request")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "ConversionReview contains no request")
+		return
+	}
+
+	review.Response = h.convert(review.Request)
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&review)
+}
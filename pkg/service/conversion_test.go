@@ -0,0 +1,94 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestServeConversion(t *testing.T) {
+	h := &Handler{}
+	// the v1 FloatingIPPool renames the targetCluster field
+	h.RegisterConverter("FloatingIPPool", func(obj *unstructured.Unstructured, toVersion string) error {
+		if toVersion != "v1" {
+			return fmt.Errorf("unsupported version %s", toVersion)
+		}
+		cluster, _, _ := unstructured.NestedString(obj.Object, "spec", "targetCluster")
+		unstructured.RemoveNestedField(obj.Object, "spec", "targetCluster")
+		return unstructured.SetNestedField(obj.Object, cluster, "spec", "cluster")
+	})
+
+	object := func(apiVersion string, kind string) runtime.RawExtension {
+		return runtime.RawExtension{Raw: []byte(fmt.Sprintf(
+			`{"apiVersion":%q,"kind":%q,"metadata":{"name":"test"},"spec":{"targetCluster":"c-abcde"}}`, apiVersion, kind))}
+	}
+	convert := func(desiredAPIVersion string, objects ...runtime.RawExtension) *apiextensionsv1.ConversionResponse {
+		body, err := json.Marshal(&apiextensionsv1.ConversionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1", Kind: "ConversionReview"},
+			Request: &apiextensionsv1.ConversionRequest{
+				UID:               "test-uid",
+				DesiredAPIVersion: desiredAPIVersion,
+				Objects:           objects,
+			},
+		})
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		h.serveConversion(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		review := &apiextensionsv1.ConversionReview{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(review))
+		assert.Equal(t, "ConversionReview", review.Kind)
+		assert.Nil(t, review.Request)
+		assert.Equal(t, "test-uid", string(review.Response.UID))
+		return review.Response
+	}
+
+	// kinds without a converter only get their apiVersion rewritten
+	response := convert("rancher.k8s.binbash.org/v1",
+		object("rancher.k8s.binbash.org/v1beta1", "FloatingIP"),
+		object("rancher.k8s.binbash.org/v1beta1", "FloatingIPPool"))
+	assert.Equal(t, metav1.StatusSuccess, response.Result.Status)
+	assert.Len(t, response.ConvertedObjects, 2)
+	assert.JSONEq(t, `{"apiVersion":"rancher.k8s.binbash.org/v1","kind":"FloatingIP","metadata":{"name":"test"},"spec":{"targetCluster":"c-abcde"}}`,
+		string(response.ConvertedObjects[0].Raw))
+	assert.JSONEq(t, `{"apiVersion":"rancher.k8s.binbash.org/v1","kind":"FloatingIPPool","metadata":{"name":"test"},"spec":{"cluster":"c-abcde"}}`,
+		string(response.ConvertedObjects[1].Raw))
+
+	// objects of the desired version are not converted
+	response = convert("rancher.k8s.binbash.org/v1beta1", object("rancher.k8s.binbash.org/v1beta1", "FloatingIPPool"))
+	assert.Equal(t, metav1.StatusSuccess, response.Result.Status)
+	assert.JSONEq(t, string(object("rancher.k8s.binbash.org/v1beta1", "FloatingIPPool").Raw), string(response.ConvertedObjects[0].Raw))
+
+	// a failed object fails the whole request
+	response = convert("rancher.k8s.binbash.org/v1beta2",
+		object("rancher.k8s.binbash.org/v1beta1", "FloatingIP"),
+		object("rancher.k8s.binbash.org/v1beta1", "FloatingIPPool"))
+	assert.Equal(t, metav1.StatusFailure, response.Result.Status)
+	assert.Equal(t, "cannot convert FloatingIPPool test from v1beta1 to v1beta2: unsupported version v1beta2", response.Result.Message)
+	assert.Empty(t, response.ConvertedObjects)
+
+	response = convert("example.com/v1", object("rancher.k8s.binbash.org/v1beta1", "FloatingIP"))
+	assert.Equal(t, metav1.StatusFailure, response.Result.Status)
+	assert.Equal(t, "cannot convert FloatingIP test from rancher.k8s.binbash.org/v1beta1 to example.com/v1, only the rancher.k8s.binbash.org group is served", response.Result.Message)
+
+	// a review without request is rejected
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("Content-Type", "application/json")
+	h.serveConversion(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "ConversionReview contains no request", w.Body.String())
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NotAllocatedElsewhere checks that the requested IP is not allocated in
+// another FloatingIPPool whose range contains it, which happens when pools
+// overlap or an IP was migrated between pools. The pools are taken from the
+// pool index when it is enabled, otherwise they are listed. Updates which
+// keep the IP are not checked.
+type NotAllocatedElsewhere struct{}
+
+func (v *NotAllocatedElsewhere) Name() string { return "NotAllocatedElsewhere" }
+
+func (v *NotAllocatedElsewhere) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if req.FIP.Spec.IPAddr == nil || req.IPUnchanged() {
+		return nil
+	}
+	requestedIP := net.ParseIP(*req.FIP.Spec.IPAddr)
+	if requestedIP == nil {
+		return nil
+	}
+
+	pools, ok := h.pools.list()
+	if !ok {
+		var err error
+		pools, err = h.selectPools(ctx, labels.Everything())
+		if err != nil {
+			req.Log.Errorf("failed to list floatingippools: %s", err)
+			return fmt.Errorf("internal server error: failed to list floatingippools")
+		}
+	}
+
+	for _, pool := range pools {
+		if pool.Name == req.PoolName() || !poolContains(pool, requestedIP) {
+			continue
+		}
+		if owner, allocated := poolAllocation(pool, requestedIP); allocated {
+			return fmt.Errorf("requested IP %s is already allocated to %s in floatingippool %s", *req.FIP.Spec.IPAddr, owner, pool.Name)
+		}
+	}
+
+	return nil
+}
+
+// poolContains returns true if the range of one of the blocks of the pool
+// contains the IP.
+func poolContains(pool *rfmv2.FloatingIPPool, ip net.IP) bool {
+	for _, block := range poolBlocks(pool) {
+		startIP := net.ParseIP(block.Pool.Start)
+		endIP := net.ParseIP(block.Pool.End)
+		if startIP != nil && endIP != nil && validator.InRange(ip, startIP, endIP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// poolAllocation returns the owner of the IP in the allocations of the pool.
+// The allocations are keyed by the textual IP, so other notations of the same
+// IP are compared as well.
+func poolAllocation(pool *rfmv2.FloatingIPPool, ip net.IP) (string, bool) {
+	if owner, ok := pool.Status.Allocated[ip.String()]; ok {
+		return owner, true
+	}
+	for allocatedIP, owner := range pool.Status.Allocated {
+		if ip.Equal(net.ParseIP(allocatedIP)) {
+			return owner, true
+		}
+	}
+
+	return "", false
+}
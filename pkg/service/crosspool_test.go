@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestNotAllocatedElsewhere(t *testing.T) {
+	pool := func(name string, start string, end string, allocated map[string]string) *rfmv2.FloatingIPPool {
+		return &rfmv2.FloatingIPPool{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPPool"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, ResourceVersion: "1"},
+			Spec: rfmv2.FloatingIPPoolSpec{
+				IPConfig: &rfmv2.IPConfig{
+					Subnet: "192.168.1.0/24",
+					Pool:   rfmv2.Pool{Start: start, End: end},
+				},
+			},
+			Status: rfmv2.FloatingIPPoolStatus{Allocated: allocated},
+		}
+	}
+	pools := []*rfmv2.FloatingIPPool{
+		pool("pool-a", "192.168.1.10", "192.168.1.20", map[string]string{"192.168.1.11": "default/fip-a"}),
+		pool("pool-b", "192.168.1.15", "192.168.1.30", map[string]string{"192.168.1.16": "default/fip-b"}),
+		// the allocation is outside of the range of the pool
+		pool("pool-c", "192.168.1.40", "192.168.1.50", map[string]string{"192.168.1.12": "default/fip-c"}),
+	}
+	objects, err := getUnstructuredList([]runtime.Object{pools[0], pools[1], pools[2]})
+	assert.NoError(t, err)
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingippools"}: "FloatingIPPoolList",
+	}, objects...)
+
+	index := newPoolIndex(true)
+	for _, pool := range pools {
+		index.set(pool)
+	}
+	index.markSynced()
+
+	testCases := []struct {
+		name          string
+		poolName      string
+		ipAddr        string
+		oldIPAddr     string
+		expectedError string
+	}{
+		{
+			name:     "IP is free in all pools",
+			poolName: "pool-a",
+			ipAddr:   "192.168.1.17",
+		},
+		{
+			name:          "IP is allocated in an overlapping pool",
+			poolName:      "pool-a",
+			ipAddr:        "192.168.1.16",
+			expectedError: "requested IP 192.168.1.16 is already allocated to default/fip-b in floatingippool pool-b",
+		},
+		{
+			name:          "IPv4-mapped IP is allocated in an overlapping pool",
+			poolName:      "pool-b",
+			ipAddr:        "::ffff:192.168.1.11",
+			expectedError: "requested IP ::ffff:192.168.1.11 is already allocated to default/fip-a in floatingippool pool-a",
+		},
+		{
+			name:     "allocation in the requested pool is left to NotAllocated",
+			poolName: "pool-b",
+			ipAddr:   "192.168.1.16",
+		},
+		{
+			name:     "allocation outside of the range of the pool is ignored",
+			poolName: "pool-a",
+			ipAddr:   "192.168.1.12",
+		},
+		{
+			name:      "update which keeps the IP",
+			poolName:  "pool-a",
+			ipAddr:    "192.168.1.16",
+			oldIPAddr: "192.168.1.16",
+		},
+	}
+
+	for _, tc := range testCases {
+		for _, h := range []*Handler{{dynamic: dynamicClient}, {dynamic: dynamicClient, pools: index}} {
+			t.Run(tc.name, func(t *testing.T) {
+				ipAddr := tc.ipAddr
+				req := &FloatingIPRequest{
+					Request: &admissionv1.AdmissionRequest{Operation: admissionv1.Create},
+					Log:     log.NewEntry(log.StandardLogger()),
+					FIP: &rfmv2.FloatingIP{
+						ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default"},
+						Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: tc.poolName, IPAddr: &ipAddr},
+					},
+				}
+				if tc.oldIPAddr != "" {
+					req.OldFIP = req.FIP.DeepCopy()
+					req.OldFIP.Status.IPAddr = tc.oldIPAddr
+				}
+
+				err := (&NotAllocatedElsewhere{}).Validate(context.Background(), h, req)
+				if tc.expectedError != "" {
+					assert.EqualError(t, err, tc.expectedError)
+				} else {
+					assert.NoError(t, err)
+				}
+			})
+		}
+	}
+}
+
+func TestPoolIndexList(t *testing.T) {
+	var disabled *poolIndex
+	_, ok := disabled.list()
+	assert.False(t, ok)
+
+	index := newPoolIndex(true)
+	pool := &rfmv2.FloatingIPPool{ObjectMeta: metav1.ObjectMeta{Name: "too-large"}, Spec: rfmv2.FloatingIPPoolSpec{
+		IPConfig: &rfmv2.IPConfig{Subnet: "10.0.0.0/8", Pool: rfmv2.Pool{Start: "10.0.0.1", End: "10.255.255.254"}},
+	}}
+	index.set(pool)
+	_, ok = index.list()
+	assert.False(t, ok, "the pool set is not complete before the initial list")
+
+	index.markSynced()
+	pools, ok := index.list()
+	assert.True(t, ok)
+	assert.Equal(t, []*rfmv2.FloatingIPPool{pool}, pools, "pools which are not indexed are cached as well")
+
+	index.remove("too-large")
+	pools, _ = index.list()
+	assert.Empty(t, pools)
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// DefaultRequestTimeout is the timeout of an admission request when the API
+// server doesn't send one, it is the default timeoutSeconds of a webhook.
+const DefaultRequestTimeout = 10 * time.Second
+
+// DefaultRequestBudget is the percentage of the timeout of an admission
+// request which is spent on validating it.
+const DefaultRequestBudget = 80
+
+// requestContext returns the context of an admission request, which expires
+// when the budget of the timeout is spent. The API server sends the timeout
+// of the webhook in the timeout parameter, the remaining time is left to
+// return a decision before the API server gives up on the webhook.
+func (h *Handler) requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout, err := time.ParseDuration(r.URL.Query().Get("timeout"))
+	if err != nil || timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	budget := h.options.RequestBudget
+	if budget <= 0 || budget > 100 {
+		budget = DefaultRequestBudget
+	}
+
+	return context.WithTimeout(r.Context(), timeout*time.Duration(budget)/100)
+}
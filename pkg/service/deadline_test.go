@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestRequestContext(t *testing.T) {
+	testCases := []struct {
+		name             string
+		target           string
+		budget           int
+		expectedDeadline time.Duration
+	}{
+		{
+			name:             "timeout of the API server",
+			target:           "/validate?timeout=30s",
+			expectedDeadline: 24 * time.Second,
+		},
+		{
+			name:             "custom budget",
+			target:           "/validate?timeout=30s",
+			budget:           50,
+			expectedDeadline: 15 * time.Second,
+		},
+		{
+			name:             "no timeout",
+			target:           "/validate",
+			expectedDeadline: 8 * time.Second,
+		},
+		{
+			name:             "invalid timeout",
+			target:           "/validate?timeout=soon",
+			expectedDeadline: 8 * time.Second,
+		},
+		{
+			name:             "invalid budget",
+			target:           "/validate?timeout=10s",
+			budget:           150,
+			expectedDeadline: 8 * time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{options: Options{RequestBudget: tc.budget}}
+
+			start := time.Now()
+			ctx, cancel := h.requestContext(httptest.NewRequest(http.MethodPost, tc.target, nil))
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			assert.True(t, ok)
+			assert.WithinDuration(t, start.Add(tc.expectedDeadline), deadline, time.Second)
+		})
+	}
+}
+
+func TestValidateFloatingIPDeadlineExceeded(t *testing.T) {
+	h := &Handler{
+		dynamic:       fake.NewSimpleDynamicClient(runtime.NewScheme()),
+		fipValidators: []FloatingIPValidator{&PoolExists{}},
+	}
+	ar := &admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{UID: "test-uid"},
+	}
+	fip := &rfmv2.FloatingIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default"},
+		Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	response := h.validateFloatingIP(ctx, log.NewEntry(log.StandardLogger()), ar, fip, nil)
+
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "the request could not be validated in time, try again later", response.Result.Message)
+	assert.Equal(t, int32(http.StatusServiceUnavailable), response.Result.Code)
+	assert.Equal(t, "transient", response.AuditAnnotations["failure"])
+}
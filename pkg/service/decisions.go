@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/metrics"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// decisionSweepInterval is how often the expired decisions are removed.
+const decisionSweepInterval = time.Minute
+
+type decision struct {
+	// done is closed when the response of the first request is known
+	done     chan struct{}
+	response *admissionv1.AdmissionResponse
+	expires  time.Time
+}
+
+// decisionCache holds the responses of the admission requests by their UID.
+// The API server retries a call when the webhook times out, a retry gets the
+// response of the first call instead of repeating the lookups, or waits for
+// it while the first call is still validating. It only covers the requests
+// which are handled by this replica. A nil cache doesn't cache.
+type decisionCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	decisions map[types.UID]*decision
+	lastSweep time.Time
+}
+
+// newDecisionCache returns a cache which keeps the responses for ttl, the
+// responses are not cached when it is 0.
+func newDecisionCache(ttl time.Duration) *decisionCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &decisionCache{
+		ttl:       ttl,
+		decisions: make(map[types.UID]*decision),
+	}
+}
+
+// Acquire returns the cached response of the request with the uid, it waits
+// while another request with the uid is validated. Without a response the
+// caller validates the request and passes its response to the returned
+// function, or nil when it must not be cached.
+func (c *decisionCache) Acquire(ctx context.Context, uid types.UID) (*admissionv1.AdmissionResponse, func(*admissionv1.AdmissionResponse)) {
+	if c == nil || uid == "" {
+		return nil, func(*admissionv1.AdmissionResponse) {}
+	}
+
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		c.sweep(now)
+
+		d, ok := c.decisions[uid]
+		if !ok || (d.response != nil && now.After(d.expires)) {
+			d = &decision{done: make(chan struct{})}
+			c.decisions[uid] = d
+			c.mu.Unlock()
+			return nil, func(response *admissionv1.AdmissionResponse) { c.finish(uid, d, response) }
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			// the request is validated without waiting for the first one
+			return nil, func(*admissionv1.AdmissionResponse) {}
+		}
+		if d.response != nil {
+			metrics.DecisionCacheHits.Inc()
+			return d.response, func(*admissionv1.AdmissionResponse) {}
+		}
+		// the first request got no cacheable response, the next waiting
+		// request takes over
+	}
+}
+
+// finish stores the response of the decision and wakes up the requests which
+// wait for it. A decision without a response is removed.
+func (c *decisionCache) finish(uid types.UID, d *decision, response *admissionv1.AdmissionResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if response == nil {
+		if c.decisions[uid] == d {
+			delete(c.decisions, uid)
+		}
+	} else {
+		d.response = response
+		d.expires = time.Now().Add(c.ttl)
+	}
+	close(d.done)
+}
+
+// sweep removes the expired decisions. The lock must be held.
+func (c *decisionCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < decisionSweepInterval {
+		return
+	}
+	c.lastSweep = now
+
+	for uid, d := range c.decisions {
+		if d.response != nil && now.After(d.expires) {
+			delete(c.decisions, uid)
+		}
+	}
+}
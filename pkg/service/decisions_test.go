@@ -0,0 +1,121 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDecisionCache(t *testing.T) {
+	// a nil cache doesn't cache
+	var disabled *decisionCache
+	response, release := disabled.Acquire(context.Background(), "uid-1")
+	assert.Nil(t, response)
+	release(&admissionv1.AdmissionResponse{UID: "uid-1"})
+	assert.Nil(t, newDecisionCache(0))
+
+	c := newDecisionCache(time.Minute)
+	response, release = c.Acquire(context.Background(), "uid-1")
+	assert.Nil(t, response)
+
+	// a retry waits for the first call
+	retried := make(chan *admissionv1.AdmissionResponse)
+	go func() {
+		response, _ := c.Acquire(context.Background(), "uid-1")
+		retried <- response
+	}()
+	first := &admissionv1.AdmissionResponse{UID: "uid-1", Allowed: true}
+	release(first)
+	assert.Equal(t, first, <-retried)
+
+	// a failed call is not cached
+	response, release = c.Acquire(context.Background(), "uid-2")
+	assert.Nil(t, response)
+	release(nil)
+	response, release = c.Acquire(context.Background(), "uid-2")
+	assert.Nil(t, response)
+
+	// a retry which runs out of time validates the request itself
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	response, _ = c.Acquire(ctx, "uid-2")
+	assert.Nil(t, response)
+	release(nil)
+
+	// expired decisions are validated again
+	c.decisions["uid-1"].expires = time.Now().Add(-time.Second)
+	response, _ = c.Acquire(context.Background(), "uid-1")
+	assert.Nil(t, response)
+}
+
+func TestServeAdmissionDecisionCache(t *testing.T) {
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:  "test-uid",
+			Kind: metav1.GroupVersionKind{Kind: "FloatingIP"},
+		},
+	})
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		name          string
+		failure       string
+		expectedCalls int32
+	}{
+		{
+			name:          "retries get the decision of the first call",
+			expectedCalls: 1,
+		},
+		{
+			name:          "retries of a failed call are validated again",
+			failure:       "transient",
+			expectedCalls: 3,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{decisions: newDecisionCache(time.Minute)}
+			var calls atomic.Int32
+			h.RegisterKind("FloatingIP", WebhookFloatingIP, func(ctx context.Context, logger *log.Entry, ar *admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+				calls.Add(1)
+				// the API server retries while the first call is validating
+				time.Sleep(50 * time.Millisecond)
+				response := denied(ar, "the specified floatingippool test-pool does not exist")
+				if tc.failure != "" {
+					response.AuditAnnotations = map[string]string{"failure": tc.failure}
+				}
+				return response, nil
+			})
+
+			var wg sync.WaitGroup
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					w := httptest.NewRecorder()
+					req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+					req.Header.Set("Content-Type", "application/json")
+					h.validateAdmission(w, req)
+
+					response := &admissionv1.AdmissionReview{}
+					assert.NoError(t, json.NewDecoder(w.Body).Decode(response))
+					assert.False(t, response.Response.Allowed)
+					assert.Equal(t, "the specified floatingippool test-pool does not exist", response.Response.Result.Message)
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, tc.expectedCalls, calls.Load())
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+// ConfirmDeleteAnnotation is the FloatingIPPool annotation which confirms the
+// delete of the pool, its value must be the name of the pool.
+const ConfirmDeleteAnnotation = "rancher.k8s.binbash.org/confirm-delete"
+
+// PoolDeleteConfirmed only allows FloatingIPPools to be deleted when the
+// ConfirmDeleteAnnotation is set to the name of the pool, which prevents
+// deleting a production pool by accident, even when it has no allocations.
+// Members of the DeleteConfirmGroups may delete pools without confirmation.
+type PoolDeleteConfirmed struct{}
+
+func (v *PoolDeleteConfirmed) Name() string { return "PoolDeleteConfirmed" }
+
+// ValidatesDelete marks PoolDeleteConfirmed as a FloatingIPPoolDeleteValidator.
+func (v *PoolDeleteConfirmed) ValidatesDelete() {}
+
+func (v *PoolDeleteConfirmed) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	if !h.options.DeleteConfirmation || !req.IsDelete() {
+		return nil
+	}
+	if req.Pool.Annotations[ConfirmDeleteAnnotation] == req.Pool.Name {
+		return nil
+	}
+
+	user := req.Request.UserInfo
+	if memberOfGroups(h.options.DeleteConfirmGroups, user.Groups) {
+		req.Log.Infof("user %s deletes floatingippool %s without confirmation", user.Username, req.Pool.Name)
+		return nil
+	}
+
+	return fmt.Errorf("floatingippool %s can only be deleted after setting the %s annotation to %s",
+		req.Pool.Name, ConfirmDeleteAnnotation, req.Pool.Name)
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPoolDeleteConfirmed(t *testing.T) {
+	testCases := []struct {
+		name            string
+		disabled        bool
+		operation       admissionv1.Operation
+		annotations     map[string]string
+		groups          []string
+		expectedMessage string
+	}{
+		{
+			name:      "check disabled",
+			disabled:  true,
+			operation: admissionv1.Delete,
+		},
+		{
+			name:      "create",
+			operation: admissionv1.Create,
+		},
+		{
+			name:            "delete without confirmation",
+			operation:       admissionv1.Delete,
+			expectedMessage: "floatingippool test-pool can only be deleted after setting the rancher.k8s.binbash.org/confirm-delete annotation to test-pool",
+		},
+		{
+			name:            "delete confirmed with the wrong name",
+			operation:       admissionv1.Delete,
+			annotations:     map[string]string{ConfirmDeleteAnnotation: "true"},
+			expectedMessage: "floatingippool test-pool can only be deleted after setting the rancher.k8s.binbash.org/confirm-delete annotation to test-pool",
+		},
+		{
+			name:        "delete confirmed",
+			operation:   admissionv1.Delete,
+			annotations: map[string]string{ConfirmDeleteAnnotation: "test-pool"},
+		},
+		{
+			name:      "delete by a member of the confirm groups",
+			operation: admissionv1.Delete,
+			groups:    []string{"system:authenticated", "network-admins"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				options: Options{
+					DeleteConfirmation:  !tc.disabled,
+					DeleteConfirmGroups: []string{"network-admins"},
+				},
+				fipPoolValidators: []FloatingIPPoolValidator{&PoolDeleteConfirmed{}},
+			}
+			ar := &admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					UID:       "test-uid",
+					Operation: tc.operation,
+					UserInfo:  authenticationv1.UserInfo{Username: "alice", Groups: tc.groups},
+				},
+			}
+			fipPool := &rfmv2.FloatingIPPool{ObjectMeta: metav1.ObjectMeta{Name: "test-pool", Annotations: tc.annotations}}
+
+			response := h.validateFloatingIPPool(context.Background(), requestLogger(ar.Request), ar, fipPool, nil)
+			if tc.expectedMessage != "" {
+				assert.False(t, response.Allowed)
+				assert.Equal(t, tc.expectedMessage, response.Result.Message)
+				assert.Equal(t, "PoolDeleteConfirmed", response.AuditAnnotations["denied-by"])
+				return
+			}
+			assert.True(t, response.Allowed)
+		})
+	}
+}
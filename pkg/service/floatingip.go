@@ -0,0 +1,607 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// ProjectNameLabel is the label which links a FloatingIP to its Rancher project.
+	ProjectNameLabel = "rancher.k8s.binbash.org/project-name"
+	// ProjectIDAnnotation is the annotation Rancher sets on namespaces which
+	// are part of a project, for example "c-abcde:p-fghij".
+	ProjectIDAnnotation = "field.cattle.io/projectId"
+)
+
+// IPImmutable checks that the requested IP of an existing FloatingIP is not
+// changed. The controller only allocates the IP when the FloatingIP is
+// created, so a changed IP would never be allocated while the old one stays
+// allocated in the pool.
+type IPImmutable struct{}
+
+func (v *IPImmutable) Name() string { return "IPImmutable" }
+
+func (v *IPImmutable) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if !req.IsUpdate() || req.OldFIP.Spec.IPAddr == nil {
+		return nil
+	}
+
+	oldIP := *req.OldFIP.Spec.IPAddr
+	if req.FIP.Spec.IPAddr == nil {
+		return fmt.Errorf("spec.ipAddr of FloatingIP %s/%s cannot be removed, delete and recreate the FloatingIP to release IP %s",
+			req.FIP.Namespace, req.FIP.Name, oldIP)
+	}
+
+	newIP := *req.FIP.Spec.IPAddr
+	if newIP == oldIP {
+		return nil
+	}
+	// the same address in another notation is not a change
+	if parsedIP := net.ParseIP(newIP); parsedIP != nil && parsedIP.Equal(net.ParseIP(oldIP)) {
+		return nil
+	}
+
+	return fmt.Errorf("spec.ipAddr of FloatingIP %s/%s cannot be changed from %s to %s, delete and recreate the FloatingIP to use another IP",
+		req.FIP.Namespace, req.FIP.Name, oldIP, newIP)
+}
+
+// PoolExists checks if the specified FloatingIPPool exists and stores it in the request.
+// It is skipped when the pool was already resolved by the PoolSelector validator.
+type PoolExists struct{}
+
+func (v *PoolExists) Name() string { return "PoolExists" }
+
+func (v *PoolExists) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	req.waitPool()
+	if req.Pool != nil {
+		return nil
+	}
+
+	fipPool, err := h.getFloatingIPPool(ctx, req.FIP.Spec.FloatingIPPool)
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("the specified floatingippool %s does not exist", req.FIP.Spec.FloatingIPPool)
+	}
+	if isTransient(err) {
+		req.Log.Errorf("failed to get floatingippool %s: %s", req.FIP.Spec.FloatingIPPool, err)
+		return &TransientError{Err: fmt.Errorf("failed to get floatingippool %s", req.FIP.Spec.FloatingIPPool)}
+	}
+	if err != nil {
+		req.Log.Errorf("failed to get floatingippool %s: %s", req.FIP.Spec.FloatingIPPool, err)
+		return fmt.Errorf("internal server error: failed to process floatingippool")
+	}
+	req.Pool = fipPool
+
+	return nil
+}
+
+// PoolNotTerminating checks if the pool is not being deleted before a new
+// FloatingIP is created in it.
+type PoolNotTerminating struct{}
+
+func (v *PoolNotTerminating) Name() string { return "PoolNotTerminating" }
+
+func (v *PoolNotTerminating) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if req.IsUpdate() || req.Pool.DeletionTimestamp == nil {
+		return nil
+	}
+
+	return fmt.Errorf("floatingippool %s is being decommissioned and doesn't accept new FloatingIPs", req.Pool.Name)
+}
+
+// IPInRange checks if the requested IP is valid, of the IP family of the pool
+// and within the subnet and range of the pool. For pools with several blocks
+// the IP is checked against the block whose subnet contains it.
+type IPInRange struct{}
+
+func (v *IPInRange) Name() string { return "IPInRange" }
+
+func (v *IPInRange) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if req.FIP.Spec.IPAddr == nil {
+		return nil
+	}
+	fip := req.FIP
+	blocks := poolBlocks(req.Pool)
+
+	requestedIP := net.ParseIP(*fip.Spec.IPAddr)
+	if requestedIP == nil {
+		return fmt.Errorf("invalid IP address format: %s", *fip.Spec.IPAddr)
+	}
+	if len(blocks) == 0 {
+		req.Log.Errorf("floatingippool %s has no ipConfig", req.PoolName())
+		return fmt.Errorf("internal server error: invalid subnet configuration in floatingippool")
+	}
+
+	// Check if the IP has a family of the pool, the subnet check alone
+	// doesn't explain why an IPv6 address is denied by an IPv4 pool
+	var families []string
+	for _, block := range blocks {
+		if family := validator.PoolFamily(block); family != "" && !slices.Contains(families, family) {
+			families = append(families, family)
+		}
+	}
+	if requestedFamily := validator.IPFamily(requestedIP); len(families) > 0 && !slices.Contains(families, requestedFamily) {
+		return fmt.Errorf("floatingippool %s is %s, requested address %s is %s",
+			req.PoolName(), strings.Join(families, "/"), *fip.Spec.IPAddr, requestedFamily)
+	}
+
+	// Check if the IP is within the subnet
+	ipConfig := validator.BlockOf(requestedIP, blocks)
+	if ipConfig == nil {
+		if len(blocks) > 1 {
+			subnets := make([]string, 0, len(blocks))
+			for _, block := range blocks {
+				subnets = append(subnets, block.Subnet)
+			}
+			return fmt.Errorf("requested IP %s is not in one of the subnet ranges %s", *fip.Spec.IPAddr, strings.Join(subnets, ", "))
+		}
+		ipConfig = req.Pool.Spec.IPConfig
+		if _, _, err := net.ParseCIDR(ipConfig.Subnet); err != nil {
+			req.Log.Errorf("failed to parse subnet %s: %s", ipConfig.Subnet, err)
+			return fmt.Errorf("internal server error: invalid subnet configuration in floatingippool")
+		}
+		return fmt.Errorf("requested IP %s is not in the subnet range %s", *fip.Spec.IPAddr, ipConfig.Subnet)
+	}
+
+	// Check if the IP is within the start and end address of the block
+	startIP := net.ParseIP(ipConfig.Pool.Start)
+	if startIP == nil {
+		req.Log.Errorf("failed to parse start IP %s from floatingippool %s", ipConfig.Pool.Start, req.PoolName())
+		return fmt.Errorf("internal server error: invalid start ip configuration in floatingippool %s", req.PoolName())
+	}
+
+	endIP := net.ParseIP(ipConfig.Pool.End)
+	if endIP == nil {
+		req.Log.Errorf("failed to parse end IP %s from floatingippool %s", ipConfig.Pool.End, req.PoolName())
+		return fmt.Errorf("internal server error: invalid end ip configuration in floatingippool %s", req.PoolName())
+	}
+
+	if !validator.InRange(requestedIP, startIP, endIP) {
+		return fmt.Errorf("requested IP %s is not in the pool range [%s, %s]",
+			*fip.Spec.IPAddr, ipConfig.Pool.Start, ipConfig.Pool.End)
+	}
+
+	return nil
+}
+
+// NotExcluded checks if the requested IP is not in the exclude list of the pool.
+type NotExcluded struct{}
+
+func (v *NotExcluded) Name() string { return "NotExcluded" }
+
+func (v *NotExcluded) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if req.FIP.Spec.IPAddr == nil {
+		return nil
+	}
+
+	var excluded bool
+	if block := h.pools.lookup(req.Pool).block(net.ParseIP(*req.FIP.Spec.IPAddr)); block != nil {
+		excluded = block.IsExcluded(net.ParseIP(*req.FIP.Spec.IPAddr))
+	} else {
+		excluded = validator.IsExcluded(*req.FIP.Spec.IPAddr, req.Pool.Spec.IPConfig.Pool.Exclude)
+	}
+	if excluded {
+		return fmt.Errorf("requested IP %s is in the exclude list", *req.FIP.Spec.IPAddr)
+	}
+
+	return nil
+}
+
+// IPNotForbidden checks that the requested IP is not in one of the forbidden
+// special-use ranges, like loopback or documentation addresses. Updates which
+// keep the IP are not checked, so existing FloatingIPs stay editable.
+type IPNotForbidden struct{}
+
+func (v *IPNotForbidden) Name() string { return "IPNotForbidden" }
+
+func (v *IPNotForbidden) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if req.FIP.Spec.IPAddr == nil || req.IPUnchanged() {
+		return nil
+	}
+
+	if ipNet := validator.ForbiddenRange(net.ParseIP(*req.FIP.Spec.IPAddr), h.options.ForbiddenRanges); ipNet != nil {
+		return fmt.Errorf("requested IP %s is in the special-use range %s", *req.FIP.Spec.IPAddr, ipNet)
+	}
+
+	return nil
+}
+
+// NotAllocated checks if the requested IP is not already allocated in the pool.
+type NotAllocated struct{}
+
+func (v *NotAllocated) Name() string { return "NotAllocated" }
+
+func (v *NotAllocated) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if req.FIP.Spec.IPAddr == nil {
+		return nil
+	}
+
+	// For UPDATE operations, skip this check if the IP is the same as the old one
+	if req.IPUnchanged() {
+		return nil
+	}
+
+	if _, ok := req.Pool.Status.Allocated[*req.FIP.Spec.IPAddr]; ok {
+		return fmt.Errorf("requested IP %s is already allocated", *req.FIP.Spec.IPAddr)
+	}
+
+	return nil
+}
+
+// NotClaimed checks if the requested IP is not requested by another FloatingIP
+// which was admitted recently but is not yet allocated in the pool status.
+type NotClaimed struct{}
+
+func (v *NotClaimed) Name() string { return "NotClaimed" }
+
+func (v *NotClaimed) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if req.FIP.Spec.IPAddr == nil || req.IPUnchanged() || h.claims == nil {
+		return nil
+	}
+
+	key := claimKey(req.PoolName(), *req.FIP.Spec.IPAddr)
+	owner := req.FIP.Namespace + "/" + req.FIP.Name
+	if current, ok := h.claims.Claim(key, owner); !ok {
+		return fmt.Errorf("requested IP %s is already requested by FloatingIP %s", *req.FIP.Spec.IPAddr, current)
+	}
+	req.claim = key
+	req.claimOwner = owner
+
+	return nil
+}
+
+// PoolHasCapacity checks if there are available IPs in the pool when no specific IP is requested.
+type PoolHasCapacity struct{}
+
+func (v *PoolHasCapacity) Name() string { return "PoolHasCapacity" }
+
+func (v *PoolHasCapacity) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if req.FIP.Spec.IPAddr != nil {
+		return nil
+	}
+
+	available := req.Pool.Status.Available
+	if indexed := h.pools.lookup(req.Pool); indexed != nil {
+		available = indexed.Free()
+	}
+	if available <= 0 {
+		return fmt.Errorf("no available IPs in floatingippool %s", req.PoolName())
+	}
+
+	return nil
+}
+
+// ProjectLabel checks if the FloatingIP carries the project-name label which
+// is needed to find the project quota, and stores the project in the request.
+// With the ProjectFromNamespace option the project of the namespace is used
+// when the label is missing.
+type ProjectLabel struct{}
+
+func (v *ProjectLabel) Name() string { return "ProjectLabel" }
+
+func (v *ProjectLabel) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if req.IPUnchanged() {
+		return nil
+	}
+
+	projectID := req.FIP.ObjectMeta.Labels[ProjectNameLabel]
+	if projectID != "" {
+		req.ProjectID = projectID
+		return nil
+	}
+
+	expected, err := h.requestNamespaceProject(ctx, req, req.FIP.Namespace)
+	if err == nil && expected != "" && h.options.ProjectFromNamespace {
+		req.Log.Debugf("project-name label is missing, using project %s of namespace %s", expected, req.FIP.Namespace)
+		req.ProjectID = expected
+		return nil
+	}
+
+	msg := fmt.Sprintf("FloatingIP must carry the %s label", ProjectNameLabel)
+	if err == nil && expected != "" {
+		msg = fmt.Sprintf("%s, the project of namespace %s is %s", msg, req.FIP.Namespace, expected)
+	} else if err != nil {
+		req.Log.Debugf("cannot determine the project of namespace %s: %s", req.FIP.Namespace, err)
+	}
+
+	return fmt.Errorf("%s", msg)
+}
+
+// namespaceProject returns the Rancher project of the namespace, or an empty
+// string if the namespace is not part of a project.
+func (h *Handler) namespaceProject(ctx context.Context, namespace string) (string, error) {
+	_, projectID, err := h.namespaceClusterProject(ctx, namespace)
+	return projectID, err
+}
+
+// namespaceClusterProject returns the Rancher cluster and project of the
+// namespace, or empty strings if the namespace is not part of a project.
+func (h *Handler) namespaceClusterProject(ctx context.Context, namespace string) (string, string, error) {
+	ns, err := h.getNamespace(ctx, namespace)
+	if err != nil {
+		return "", "", err
+	}
+	clusterID, projectID := clusterProject(ns)
+
+	return clusterID, projectID, nil
+}
+
+// requestNamespaceProject returns the Rancher project of a namespace of a
+// FloatingIP request, see requestNamespaceClusterProject.
+func (h *Handler) requestNamespaceProject(ctx context.Context, req *FloatingIPRequest, namespace string) (string, error) {
+	_, projectID, err := h.requestNamespaceClusterProject(ctx, req, namespace)
+	return projectID, err
+}
+
+// requestNamespaceClusterProject returns the Rancher cluster and project of a
+// namespace of a FloatingIP request. The namespaces are cached in the request,
+// since several validators look up the project of the same namespace.
+func (h *Handler) requestNamespaceClusterProject(ctx context.Context, req *FloatingIPRequest, namespace string) (string, string, error) {
+	ns, ok := req.namespaces[namespace]
+	if !ok {
+		var err error
+		ns, err = h.getNamespace(ctx, namespace)
+		if err != nil {
+			return "", "", err
+		}
+		if req.namespaces == nil {
+			req.namespaces = make(map[string]*corev1.Namespace)
+		}
+		req.namespaces[namespace] = ns
+	}
+	clusterID, projectID := clusterProject(ns)
+
+	return clusterID, projectID, nil
+}
+
+// getNamespace fetches a namespace with the clientset of the handler.
+func (h *Handler) getNamespace(ctx context.Context, namespace string) (*corev1.Namespace, error) {
+	if h.clientset == nil {
+		return nil, fmt.Errorf("no clientset available")
+	}
+
+	return h.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+}
+
+// clusterProject returns the Rancher cluster and project of the
+// ProjectIDAnnotation of a namespace.
+func clusterProject(ns *corev1.Namespace) (string, string) {
+	// the annotation contains the cluster and the project, separated by a colon
+	projectID := ns.Annotations[ProjectIDAnnotation]
+	clusterID := ""
+	if i := strings.LastIndex(projectID, ":"); i >= 0 {
+		clusterID, projectID = projectID[:i], projectID[i+1:]
+	}
+
+	return clusterID, projectID
+}
+
+// QuotaCheck enforces the project quota of the FloatingIPPool. Projects
+// without a FloatingIPProjectQuota are handled by the MissingQuotaPolicy option.
+type QuotaCheck struct{}
+
+func (v *QuotaCheck) Name() string { return "QuotaCheck" }
+
+func (v *QuotaCheck) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	// Skip quota check if the IP address hasn't changed during an update
+	// For auto-assignment (IPAddr is nil), we still need to check quota
+	if req.IPUnchanged() || req.QuotaOverride != "" {
+		return nil
+	}
+	projectID := req.projectID()
+	req.ProjectID = projectID
+
+	// the quota is usually fetched by lookupPoolAndQuota, unless the project
+	// is not taken from the label
+	req.waitQuota()
+	plbc := req.ProjectQuota
+	if plbc == nil || plbc.Name != projectID {
+		var looked bool
+		var err error
+		plbc, looked, err = req.quotaLookup(projectID)
+		if !looked {
+			// This sleep prevents Quota usage race conditions when creating multiple FloatingIPs in a short period of time
+			select {
+			case <-time.After(quotaSettleDelay):
+			case <-ctx.Done():
+				return &TransientError{Err: fmt.Errorf("failed to get floatingipprojectquota for project %s", projectID)}
+			}
+			plbc, err = h.getProjectQuota(ctx, projectID)
+		}
+		if apierrors.IsNotFound(err) {
+			return h.missingQuota(ctx, req, projectID)
+		}
+		if err != nil {
+			req.Log.Errorf("failed to get floatingipprojectquota for project %s: %s", projectID, err)
+			if isTransient(err) || ctx.Err() != nil {
+				return &TransientError{Err: fmt.Errorf("failed to get floatingipprojectquota for project %s", projectID)}
+			}
+			return fmt.Errorf("failed to get floatingipprojectquota for project %s", projectID)
+		}
+		req.ProjectQuota = plbc
+	}
+
+	// Check the quota and the current usage for the specified FloatingIPPool
+	quota, usage, ok := validator.QuotaUsage(plbc, req.PoolName())
+	if !ok {
+		return fmt.Errorf("no quota defined for floatingippool %s in project %s", req.PoolName(), projectID)
+	}
+	req.Quota = quota
+	req.QuotaUsed = usage
+	req.quotaKnown = true
+
+	return h.checkQuotaLimit(req, QuotaLimit{
+		Scope: QuotaScopeProject,
+		Limit: quota,
+		Used:  usage,
+		Err:   fmt.Errorf("quota exceeded for floatingippool %s in project %s. Quota: %d, Used: %d", req.PoolName(), projectID, quota, usage),
+	})
+}
+
+// quotaSettleDelay is the time to wait before the FloatingIPProjectQuota is
+// fetched, so its usage includes FloatingIPs which were just created.
+const quotaSettleDelay = 2 * time.Second
+
+// lookups holds the results of the lookups started by lookupPoolAndQuota.
+type lookups struct {
+	group    *errgroup.Group
+	poolDone chan struct{}
+	pool     *rfmv2.FloatingIPPool
+	quota    *rfmv2.FloatingIPProjectQuota
+	// quotaProject is the project whose quota is looked up and quotaErr the
+	// error of the lookup.
+	quotaProject string
+	quotaErr     error
+}
+
+// lookupPoolAndQuota starts fetching the FloatingIPPool and the
+// FloatingIPProjectQuota of the request concurrently, so the settle delay of
+// the quota overlaps with the pool lookup and the validators before them.
+// Validators which use the pool or the quota call waitPool or waitQuota first,
+// so a request which is denied by the pool validators doesn't wait for the
+// quota. A failed pool lookup is left to the PoolExists validator, which
+// fetches the pool again and reports the error. The error of the quota lookup
+// is kept for the QuotaCheck validator, see quotaLookup.
+func (h *Handler) lookupPoolAndQuota(ctx context.Context, req *FloatingIPRequest) {
+	if h.dynamic == nil {
+		return
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	l := &lookups{group: g, poolDone: make(chan struct{})}
+
+	if pool := req.FIP.Spec.FloatingIPPool; pool != "" {
+		g.Go(func() error {
+			defer close(l.poolDone)
+			fipPool, err := h.getFloatingIPPool(gctx, pool)
+			if err != nil {
+				// the quota lookup must not be cancelled
+				req.Log.Debugf("failed to look up floatingippool %s: %s", pool, err)
+				return nil
+			}
+			l.pool = fipPool
+			return nil
+		})
+	} else {
+		close(l.poolDone)
+	}
+
+	if projectID := req.FIP.ObjectMeta.Labels[ProjectNameLabel]; projectID != "" && !req.IPUnchanged() {
+		l.quotaProject = projectID
+		g.Go(func() error {
+			// This sleep prevents Quota usage race conditions when creating multiple FloatingIPs in a short period of time
+			select {
+			case <-time.After(quotaSettleDelay):
+			case <-gctx.Done():
+				l.quotaErr = gctx.Err()
+				return nil
+			}
+
+			l.quota, l.quotaErr = h.getProjectQuota(gctx, projectID)
+			return nil
+		})
+	}
+
+	req.lookups = l
+}
+
+// waitPool waits for the pool lookup started by lookupPoolAndQuota and
+// stores the pool in the request.
+func (r *FloatingIPRequest) waitPool() {
+	if r.lookups == nil {
+		return
+	}
+	<-r.lookups.poolDone
+	if r.Pool == nil {
+		r.Pool = r.lookups.pool
+	}
+}
+
+// waitQuota waits for all lookups started by lookupPoolAndQuota and stores
+// the quota in the request.
+func (r *FloatingIPRequest) waitQuota() {
+	if r.lookups == nil {
+		return
+	}
+	_ = r.lookups.group.Wait()
+	if r.ProjectQuota == nil {
+		r.ProjectQuota = r.lookups.quota
+	}
+}
+
+// quotaLookup returns the quota and the error of the quota lookup started by
+// lookupPoolAndQuota, or false when the quota of the project was not looked
+// up. It must be called after waitQuota.
+func (r *FloatingIPRequest) quotaLookup(projectID string) (*rfmv2.FloatingIPProjectQuota, bool, error) {
+	if r.lookups == nil || r.lookups.quotaProject != projectID {
+		return nil, false, nil
+	}
+
+	return r.lookups.quota, true, r.lookups.quotaErr
+}
+
+// getFloatingIPPool returns the FloatingIPPool with the given name. Transient
+// errors are retried with the lookupBackoff, ErrCircuitOpen is returned while
+// the circuit breaker is open.
+func (h *Handler) getFloatingIPPool(ctx context.Context, name string) (*rfmv2.FloatingIPPool, error) {
+	if !h.breaker.Allow(time.Now()) {
+		return nil, ErrCircuitOpen
+	}
+
+	var unstructuredPool *unstructured.Unstructured
+	err := retry.OnError(lookupBackoff, isTransient, func() (err error) {
+		unstructuredPool, err = h.dynamic.Resource(h.apiResources().FloatingIPPoolGVR()).Get(ctx, name, metav1.GetOptions{})
+		return err
+	})
+	h.breaker.Record(err, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var fipPool rfmv2.FloatingIPPool
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredPool.Object, &fipPool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert unstructured FloatingIPPool to typed: %s", err)
+	}
+
+	return &fipPool, nil
+}
+
+// getProjectQuota returns the FloatingIPProjectQuota of the project. Transient
+// errors are retried with the lookupBackoff, ErrCircuitOpen is returned while
+// the circuit breaker is open.
+func (h *Handler) getProjectQuota(ctx context.Context, projectID string) (*rfmv2.FloatingIPProjectQuota, error) {
+	if !h.breaker.Allow(time.Now()) {
+		return nil, ErrCircuitOpen
+	}
+
+	var unstructuredQuota *unstructured.Unstructured
+	err := retry.OnError(lookupBackoff, isTransient, func() (err error) {
+		unstructuredQuota, err = h.dynamic.Resource(h.apiResources().FloatingIPProjectQuotaGVR()).Get(ctx, projectID, metav1.GetOptions{})
+		return err
+	})
+	h.breaker.Record(err, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var quota rfmv2.FloatingIPProjectQuota
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredQuota.Object, &quota)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert unstructured FloatingIPProjectQuota to typed: %s", err)
+	}
+
+	return &quota, nil
+}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+)
+
+// poolBlocks returns the subnet, start and end blocks of the pool. The
+// FloatingIPPool CRD defines a single block in spec.ipConfig for now, the
+// validators which accept several blocks get them from here.
+func poolBlocks(pool *rfmv2.FloatingIPPool) []*rfmv2.IPConfig {
+	if pool.Spec.IPConfig == nil {
+		return nil
+	}
+
+	return []*rfmv2.IPConfig{pool.Spec.IPConfig}
+}
+
+// PoolRangeValid checks if the subnet, start and end addresses of every block
+// of the pool are valid and that the ranges of the blocks don't overlap.
+type PoolRangeValid struct{}
+
+func (v *PoolRangeValid) Name() string { return "PoolRangeValid" }
+
+func (v *PoolRangeValid) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	return validator.ValidatePoolBlocks(poolBlocks(req.Pool))
+}
+
+// PoolNotForbidden checks that the pool range doesn't overlap with one of the
+// forbidden special-use ranges, like loopback or documentation addresses.
+// Updates which keep the range are not checked, so existing pools stay
+// editable. It expects the pool range to be validated by PoolRangeValid first.
+type PoolNotForbidden struct{}
+
+func (v *PoolNotForbidden) Name() string { return "PoolNotForbidden" }
+
+func (v *PoolNotForbidden) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	pool := req.Pool.Spec.IPConfig.Pool
+	if req.IsUpdate() && req.OldPool.Spec.IPConfig != nil &&
+		req.OldPool.Spec.IPConfig.Pool.Start == pool.Start && req.OldPool.Spec.IPConfig.Pool.End == pool.End {
+		return nil
+	}
+
+	return validator.ValidateNotForbidden(req.Pool.Spec.IPConfig, h.options.ForbiddenRanges)
+}
+
+// PoolAddressSpace checks that the pool range is in the address space of the
+// AddressSpace option, or of the ProjectAddressSpaces option when the pool
+// carries the project-name label. Updates which keep the range and the label
+// are not checked, so existing pools stay editable when the policy changes.
+type PoolAddressSpace struct{}
+
+func (v *PoolAddressSpace) Name() string { return "PoolAddressSpace" }
+
+func (v *PoolAddressSpace) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	pool := req.Pool.Spec.IPConfig.Pool
+	projectID := req.Pool.ObjectMeta.Labels[ProjectNameLabel]
+	if req.IsUpdate() && req.OldPool.Spec.IPConfig != nil &&
+		req.OldPool.Spec.IPConfig.Pool.Start == pool.Start && req.OldPool.Spec.IPConfig.Pool.End == pool.End &&
+		req.OldPool.ObjectMeta.Labels[ProjectNameLabel] == projectID {
+		return nil
+	}
+
+	addressSpace := h.options.AddressSpace
+	if projectAddressSpace, ok := h.options.ProjectAddressSpaces[projectID]; ok && projectID != "" {
+		addressSpace = projectAddressSpace
+	}
+
+	return validator.ValidateAddressSpace(req.Pool.Spec.IPConfig, addressSpace)
+}
+
+// PoolSizeLimit checks that the pool range doesn't exceed the MaxPoolSize
+// option, so a typo in the start or end address doesn't make the controller
+// enumerate millions of IPs. Updates which keep the range are not checked,
+// so existing pools stay editable. It expects the pool range to be validated
+// by PoolRangeValid first.
+type PoolSizeLimit struct{}
+
+func (v *PoolSizeLimit) Name() string { return "PoolSizeLimit" }
+
+func (v *PoolSizeLimit) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	if h.options.MaxPoolSize <= 0 {
+		return nil
+	}
+	pool := req.Pool.Spec.IPConfig.Pool
+	if req.IsUpdate() && req.OldPool.Spec.IPConfig != nil &&
+		req.OldPool.Spec.IPConfig.Pool.Start == pool.Start && req.OldPool.Spec.IPConfig.Pool.End == pool.End {
+		return nil
+	}
+
+	size := validator.RangeSize(net.ParseIP(pool.Start), net.ParseIP(pool.End))
+	if size.Cmp(big.NewInt(h.options.MaxPoolSize)) > 0 {
+		return fmt.Errorf("pool range [%s, %s] contains %s IP addresses, which exceeds the maximum pool size of %d",
+			pool.Start, pool.End, size, h.options.MaxPoolSize)
+	}
+
+	return nil
+}
+
+// ExcludesValid checks if the exclude IPs are valid, within the subnet and between the start and end IP.
+// It expects the pool range to be validated by PoolRangeValid first.
+type ExcludesValid struct{}
+
+func (v *ExcludesValid) Name() string { return "ExcludesValid" }
+
+func (v *ExcludesValid) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	return validator.ValidateExcludes(req.Pool.Spec.IPConfig)
+}
+
+// GatewayAnnotation is the FloatingIPPool annotation which contains the
+// gateway of the network of the pool. The pool spec has no gateway field.
+const GatewayAnnotation = "rancher.k8s.binbash.org/gateway"
+
+// GatewayValid checks the gateway of the GatewayAnnotation, so the gateway
+// of the network can never be allocated as a FloatingIP. Pools without the
+// annotation are not checked. It expects the pool range to be validated by
+// PoolRangeValid first.
+type GatewayValid struct{}
+
+func (v *GatewayValid) Name() string { return "GatewayValid" }
+
+func (v *GatewayValid) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	gateway, ok := req.Pool.Annotations[GatewayAnnotation]
+	if !ok {
+		return nil
+	}
+
+	return validator.ValidateGateway(req.Pool.Spec.IPConfig, gateway)
+}
+
+// TargetClusterExists checks that the target cluster of the pool is a Rancher
+// cluster, matched by its ID or its display name, when the
+// ValidateTargetCluster option is set. The target network is defined in the
+// downstream cluster and cannot be checked. Updates which keep the target
+// cluster are not checked, so existing pools stay editable.
+type TargetClusterExists struct{}
+
+func (v *TargetClusterExists) Name() string { return "TargetClusterExists" }
+
+func (v *TargetClusterExists) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	targetCluster := req.Pool.Spec.TargetCluster
+	if !h.options.ValidateTargetCluster || targetCluster == "" {
+		return nil
+	}
+	if req.IsUpdate() && req.OldPool.Spec.TargetCluster == targetCluster {
+		return nil
+	}
+
+	clusterGVR := schema.GroupVersionResource{
+		Group:    "management.cattle.io",
+		Version:  "v3",
+		Resource: "clusters",
+	}
+	list, err := h.dynamic.Resource(clusterGVR).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		req.Log.Errorf("failed to list rancher clusters: %s", err)
+		return fmt.Errorf("internal server error: failed to list clusters")
+	}
+
+	for _, item := range list.Items {
+		displayName, _, _ := unstructured.NestedString(item.Object, "spec", "displayName")
+		if item.GetName() == targetCluster || displayName == targetCluster {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("target cluster %s of floatingippool %s does not exist", targetCluster, req.Pool.Name)
+}
+
+// PoolClusterConflict checks that the pool range doesn't overlap with the
+// networks of the cluster, overlapping FloatingIPs cause routing failures
+// which are very hard to debug. The ClusterCIDRs option is always checked,
+// the Pod networks and addresses of the nodes are looked up when the
+// ValidateNodeConflicts option is set. Excluded node addresses are allowed.
+// Updates which keep the range are not checked. It expects the pool range to
+// be validated by PoolRangeValid first.
+type PoolClusterConflict struct{}
+
+func (v *PoolClusterConflict) Name() string { return "PoolClusterConflict" }
+
+func (v *PoolClusterConflict) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	ipConfig := req.Pool.Spec.IPConfig
+	if len(h.options.ClusterCIDRs) == 0 && !h.options.ValidateNodeConflicts {
+		return nil
+	}
+	if req.IsUpdate() && req.OldPool.Spec.IPConfig != nil &&
+		req.OldPool.Spec.IPConfig.Pool.Start == ipConfig.Pool.Start && req.OldPool.Spec.IPConfig.Pool.End == ipConfig.Pool.End {
+		return nil
+	}
+
+	if err := validator.ValidateNoClusterOverlap(ipConfig, h.options.ClusterCIDRs); err != nil {
+		return err
+	}
+	if !h.options.ValidateNodeConflicts {
+		return nil
+	}
+
+	var nodes *corev1.NodeList
+	err := retry.OnError(lookupBackoff, isTransient, func() (err error) {
+		nodes, err = h.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		req.Log.Errorf("failed to list nodes: %s", err)
+		return fmt.Errorf("internal server error: failed to list nodes")
+	}
+
+	start := net.ParseIP(ipConfig.Pool.Start)
+	end := net.ParseIP(ipConfig.Pool.End)
+	for _, node := range nodes.Items {
+		for _, podCIDR := range node.Spec.PodCIDRs {
+			_, ipNet, err := net.ParseCIDR(podCIDR)
+			if err != nil {
+				continue
+			}
+			if validator.ValidateNoClusterOverlap(ipConfig, []*net.IPNet{ipNet}) != nil {
+				return fmt.Errorf("pool range [%s, %s] overlaps with the Pod network %s of node %s", ipConfig.Pool.Start, ipConfig.Pool.End, ipNet, node.Name)
+			}
+		}
+
+		for _, address := range node.Status.Addresses {
+			if address.Type != corev1.NodeInternalIP && address.Type != corev1.NodeExternalIP {
+				continue
+			}
+			ip := net.ParseIP(address.Address)
+			if ip != nil && validator.InRange(ip, start, end) && !validator.IsExcluded(address.Address, ipConfig.Pool.Exclude) {
+				return fmt.Errorf("pool range [%s, %s] contains the address %s of node %s", ipConfig.Pool.Start, ipConfig.Pool.End, address.Address, node.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// allocatedExcluded is the value of the excluded IPs in the allocated status
+// of a FloatingIPPool.
+const allocatedExcluded = "excluded"
+
+// PoolCapacityConsistent checks an UPDATE of a FloatingIPPool against its
+// allocations. Changes which would leave allocated IPs outside the pool range
+// or excluded are denied. A status which reports more available IPs than the
+// pool can hold is allowed with a warning, the controller recomputes it.
+// It expects the pool range to be validated by PoolRangeValid first.
+type PoolCapacityConsistent struct{}
+
+func (v *PoolCapacityConsistent) Name() string { return "PoolCapacityConsistent" }
+
+func (v *PoolCapacityConsistent) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	if !req.IsUpdate() || req.OldPool.Spec.IPConfig == nil {
+		return nil
+	}
+	ipConfig := req.Pool.Spec.IPConfig
+	status := req.OldPool.Status
+
+	startIP := net.ParseIP(ipConfig.Pool.Start)
+	endIP := net.ParseIP(ipConfig.Pool.End)
+
+	allocated := make([]string, 0, len(status.Allocated))
+	for ip, owner := range status.Allocated {
+		if owner != allocatedExcluded {
+			allocated = append(allocated, ip)
+		}
+	}
+	sort.Strings(allocated)
+
+	for _, ip := range allocated {
+		allocatedIP := net.ParseIP(ip)
+		if allocatedIP == nil {
+			continue
+		}
+		if !validator.InRange(allocatedIP, startIP, endIP) {
+			return fmt.Errorf("allocated IP %s (%s) would not be within the pool range [%s, %s], release it first",
+				ip, status.Allocated[ip], ipConfig.Pool.Start, ipConfig.Pool.End)
+		}
+		if validator.IsExcluded(ip, ipConfig.Pool.Exclude) {
+			return fmt.Errorf("allocated IP %s (%s) cannot be excluded, release it first", ip, status.Allocated[ip])
+		}
+	}
+
+	// the status describes the pool before the update
+	capacity := validator.PoolCapacity(req.OldPool.Spec.IPConfig)
+	if free := capacity - len(allocated); status.Available > free {
+		req.Warnings = append(req.Warnings, fmt.Sprintf(
+			"status of floatingippool %s reports %d available IPs, but only %d of %d IPs can be free with %d allocated IPs",
+			req.Pool.Name, status.Available, free, capacity, len(allocated)))
+		req.Log.Warnf("floatingippool %s reports %d available IPs, expected at most %d", req.Pool.Name, status.Available, free)
+	}
+
+	return nil
+}
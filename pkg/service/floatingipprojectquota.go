@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// maxListedFloatingIPs is the number of FloatingIPs which are listed in the
+// message when the deletion of a quota is denied.
+const maxListedFloatingIPs = 5
+
+// QuotaNotNegative checks that none of the pool quotas is negative.
+type QuotaNotNegative struct{}
+
+func (v *QuotaNotNegative) Name() string { return "QuotaNotNegative" }
+
+func (v *QuotaNotNegative) Validate(ctx context.Context, h *Handler, req *FloatingIPProjectQuotaRequest) error {
+	if req.IsDelete() {
+		return nil
+	}
+
+	for _, pool := range quotaPools(req.Quota) {
+		if quota := req.Quota.Spec.FloatingIPQuota[pool]; quota < 0 {
+			return fmt.Errorf("quota for floatingippool %s must not be negative: %d", pool, quota)
+		}
+	}
+
+	return nil
+}
+
+// QuotaPoolsExist checks that the FloatingIPPools of the quota exist and
+// stores them in the request. With the QuotaUnknownPoolsWarn option unknown
+// pools are allowed with a warning. The wildcard quota is not checked.
+type QuotaPoolsExist struct{}
+
+func (v *QuotaPoolsExist) Name() string { return "QuotaPoolsExist" }
+
+func (v *QuotaPoolsExist) Validate(ctx context.Context, h *Handler, req *FloatingIPProjectQuotaRequest) error {
+	if req.IsDelete() {
+		return nil
+	}
+
+	req.Pools = make(map[string]*rfmv2.FloatingIPPool)
+	for _, pool := range quotaPools(req.Quota) {
+		if pool == validator.WildcardPool {
+			continue
+		}
+		unstructuredPool, err := h.dynamic.Resource(h.apiResources().FloatingIPPoolGVR()).Get(ctx, pool, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			if h.options.QuotaUnknownPoolsWarn {
+				req.Warnings = append(req.Warnings, fmt.Sprintf("floatingippool %s does not exist", pool))
+				continue
+			}
+			return fmt.Errorf("quota references floatingippool %s which does not exist", pool)
+		}
+		if err != nil {
+			req.Log.Errorf("failed to get floatingippool %s: %s", pool, err)
+			return fmt.Errorf("internal server error: failed to get floatingippool %s", pool)
+		}
+
+		var fipPool rfmv2.FloatingIPPool
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredPool.Object, &fipPool)
+		if err != nil {
+			req.Log.Errorf("failed to convert unstructured FloatingIPPool to typed: %s", err)
+			return fmt.Errorf("internal server error: failed to process floatingippool")
+		}
+		req.Pools[pool] = &fipPool
+	}
+
+	return nil
+}
+
+// QuotaWithinCapacity checks that no pool quota exceeds the number of IPs
+// which can be allocated in the pool.
+type QuotaWithinCapacity struct{}
+
+func (v *QuotaWithinCapacity) Name() string { return "QuotaWithinCapacity" }
+
+func (v *QuotaWithinCapacity) Validate(ctx context.Context, h *Handler, req *FloatingIPProjectQuotaRequest) error {
+	if req.IsDelete() {
+		return nil
+	}
+
+	for _, pool := range quotaPools(req.Quota) {
+		fipPool, ok := req.Pools[pool]
+		if !ok || fipPool.Spec.IPConfig == nil {
+			continue
+		}
+
+		quota := req.Quota.Spec.FloatingIPQuota[pool]
+		if capacity := validator.PoolCapacity(fipPool.Spec.IPConfig); quota > capacity {
+			return fmt.Errorf("quota %d for floatingippool %s exceeds the capacity of the pool of %d IPs", quota, pool, capacity)
+		}
+	}
+
+	return nil
+}
+
+// QuotaNotInUse denies the deletion of a FloatingIPProjectQuota while
+// FloatingIPs of the project exist, which would leave them without a quota.
+type QuotaNotInUse struct{}
+
+func (v *QuotaNotInUse) Name() string { return "QuotaNotInUse" }
+
+func (v *QuotaNotInUse) Validate(ctx context.Context, h *Handler, req *FloatingIPProjectQuotaRequest) error {
+	if !req.IsDelete() {
+		return nil
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{ProjectNameLabel: req.Quota.Name})
+	list, err := h.dynamic.Resource(h.apiResources().FloatingIPGVR()).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		req.Log.Errorf("failed to list floatingips of project %s: %s", req.Quota.Name, err)
+		return fmt.Errorf("internal server error: failed to list floatingips")
+	}
+	if len(list.Items) == 0 {
+		return nil
+	}
+
+	fips := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		fips = append(fips, item.GetNamespace()+"/"+item.GetName())
+	}
+	sort.Strings(fips)
+	if len(fips) > maxListedFloatingIPs {
+		fips = append(fips[:maxListedFloatingIPs], "...")
+	}
+
+	return fmt.Errorf("floatingipprojectquota %s cannot be deleted while project %s has %d FloatingIPs: %s",
+		req.Quota.Name, req.Quota.Name, len(list.Items), strings.Join(fips, ", "))
+}
+
+// quotaPools returns the pools of the quota sorted by name, so the validation
+// errors are deterministic.
+func quotaPools(quota *rfmv2.FloatingIPProjectQuota) []string {
+	pools := make([]string, 0, len(quota.Spec.FloatingIPQuota))
+	for pool := range quota.Spec.FloatingIPQuota {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+
+	return pools
+}
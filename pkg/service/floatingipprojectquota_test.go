@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestValidateFloatingIPProjectQuota(t *testing.T) {
+	fipPool := &rfmv2.FloatingIPPool{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPPool"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pool"},
+		Spec: rfmv2.FloatingIPPoolSpec{
+			IPConfig: &rfmv2.IPConfig{
+				Subnet: "192.168.1.0/24",
+				Pool: rfmv2.Pool{
+					Start:   "192.168.1.10",
+					End:     "192.168.1.20",
+					Exclude: []string{"192.168.1.15"},
+				},
+			},
+		},
+	}
+	objects, err := getUnstructuredList([]runtime.Object{fipPool})
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name             string
+		quota            map[string]int
+		unknownPoolsWarn bool
+		expectedAllowed  bool
+		expectedMessage  string
+		expectedWarnings []string
+	}{
+		{
+			name:            "valid quota",
+			quota:           map[string]int{"test-pool": 10},
+			expectedAllowed: true,
+		},
+		{
+			name:            "negative quota",
+			quota:           map[string]int{"test-pool": -1},
+			expectedMessage: "quota for floatingippool test-pool must not be negative: -1",
+		},
+		{
+			name:            "quota exceeds the pool capacity",
+			quota:           map[string]int{"test-pool": 11},
+			expectedMessage: "quota 11 for floatingippool test-pool exceeds the capacity of the pool of 10 IPs",
+		},
+		{
+			name:            "unknown pool",
+			quota:           map[string]int{"test-pool": 1, "unknown-pool": 1},
+			expectedMessage: "quota references floatingippool unknown-pool which does not exist",
+		},
+		{
+			name:             "unknown pool with a warning",
+			quota:            map[string]int{"test-pool": 1, "unknown-pool": 1},
+			unknownPoolsWarn: true,
+			expectedAllowed:  true,
+			expectedWarnings: []string{"floatingippool unknown-pool does not exist"},
+		},
+		{
+			name:            "wildcard quota",
+			quota:           map[string]int{"test-pool": 1, "*": 100},
+			expectedAllowed: true,
+		},
+		{
+			name:            "negative wildcard quota",
+			quota:           map[string]int{"*": -1},
+			expectedMessage: "quota for floatingippool * must not be negative: -1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				dynamic:         fake.NewSimpleDynamicClient(runtime.NewScheme(), objects...),
+				options:         Options{QuotaUnknownPoolsWarn: tc.unknownPoolsWarn},
+				quotaValidators: DefaultFloatingIPProjectQuotaValidators(),
+			}
+			ar := &admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					UID: "test-uid",
+				},
+			}
+			quota := &rfmv2.FloatingIPProjectQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-project"},
+				Spec:       rfmv2.FloatingIPProjectQuotaSpec{FloatingIPQuota: tc.quota},
+			}
+
+			response := h.validateFloatingIPProjectQuota(context.Background(), requestLogger(ar.Request), ar, quota, nil)
+
+			assert.Equal(t, tc.expectedAllowed, response.Allowed)
+			assert.Equal(t, "test-project", response.AuditAnnotations["project"])
+			if !tc.expectedAllowed {
+				assert.Equal(t, tc.expectedMessage, response.Result.Message)
+				return
+			}
+			assert.Equal(t, tc.expectedWarnings, response.Warnings)
+		})
+	}
+}
+
+func TestQuotaNotInUse(t *testing.T) {
+	fip := func(namespace string, name string, project string) *rfmv2.FloatingIP {
+		return &rfmv2.FloatingIP{
+			TypeMeta: metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIP"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{ProjectNameLabel: project},
+			},
+			Spec: rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool"},
+		}
+	}
+	objects, err := getUnstructuredList([]runtime.Object{
+		fip("team-b", "fip-b", "p-used"),
+		fip("team-a", "fip-a", "p-used"),
+		fip("team-c", "fip-c", "p-other"),
+	})
+	assert.NoError(t, err)
+	h := &Handler{
+		dynamic: fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingips"}: "FloatingIPList",
+		}, objects...),
+		quotaValidators: DefaultFloatingIPProjectQuotaValidators(),
+	}
+
+	testCases := []struct {
+		name            string
+		project         string
+		expectedAllowed bool
+		expectedMessage string
+	}{
+		{
+			name:            "project without FloatingIPs",
+			project:         "p-unused",
+			expectedAllowed: true,
+		},
+		{
+			name:            "project with FloatingIPs",
+			project:         "p-used",
+			expectedMessage: "floatingipprojectquota p-used cannot be deleted while project p-used has 2 FloatingIPs: team-a/fip-a, team-b/fip-b",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// a negative quota must not block the deletion
+			raw, err := json.Marshal(&rfmv2.FloatingIPProjectQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: tc.project},
+				Spec:       rfmv2.FloatingIPProjectQuotaSpec{FloatingIPQuota: map[string]int{"test-pool": -1}},
+			})
+			assert.NoError(t, err)
+			ar := &admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					UID:       "test-uid",
+					Operation: admissionv1.Delete,
+					OldObject: runtime.RawExtension{Raw: raw},
+				},
+			}
+
+			response, err := h.admitFloatingIPProjectQuota(context.Background(), requestLogger(ar.Request), ar)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedAllowed, response.Allowed)
+			if !tc.expectedAllowed {
+				assert.Equal(t, tc.expectedMessage, response.Result.Message)
+				assert.Equal(t, "QuotaNotInUse", response.AuditAnnotations["denied-by"])
+			}
+		})
+	}
+}
+
+func TestQuotaCheckWildcard(t *testing.T) {
+	ip := "192.168.1.10"
+	quota := &rfmv2.FloatingIPProjectQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-project"},
+		Spec: rfmv2.FloatingIPProjectQuotaSpec{
+			FloatingIPQuota: map[string]int{"listed-pool": 1, "*": 2},
+		},
+		Status: rfmv2.FloatingIPProjectQuotaStatus{
+			FloatingIPs: map[string]*rfmv2.FipInfo{
+				"listed-pool": {Used: 1},
+				"full-pool":   {Used: 2},
+			},
+		},
+	}
+
+	testCases := []struct {
+		pool            string
+		expectedMessage string
+	}{
+		{pool: "listed-pool", expectedMessage: "quota exceeded for floatingippool listed-pool in project test-project. Quota: 1, Used: 1"},
+		{pool: "other-pool"},
+		{pool: "full-pool", expectedMessage: "quota exceeded for floatingippool full-pool in project test-project. Quota: 2, Used: 2"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.pool, func(t *testing.T) {
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP: &rfmv2.FloatingIP{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default", Labels: map[string]string{ProjectNameLabel: "test-project"}},
+					Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: tc.pool, IPAddr: &ip},
+				},
+				ProjectQuota: quota,
+			}
+
+			err := (&QuotaCheck{}).Validate(context.Background(), &Handler{}, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
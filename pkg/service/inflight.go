@@ -0,0 +1,62 @@
+package service
+
+import "context"
+
+// inflightLimiter limits the number of admission requests which are validated
+// concurrently. Requests beyond the limit wait in a small queue, requests
+// beyond the queue are rejected right away, so a burst of requests doesn't
+// pile up uncached GETs on the API server. A nil limiter doesn't limit.
+type inflightLimiter struct {
+	slots chan struct{}
+	queue chan struct{}
+}
+
+func newInflightLimiter(maxInFlight int, maxQueued int) *inflightLimiter {
+	if maxInFlight <= 0 {
+		return nil
+	}
+	if maxQueued < 0 {
+		maxQueued = 0
+	}
+
+	return &inflightLimiter{
+		slots: make(chan struct{}, maxInFlight),
+		queue: make(chan struct{}, maxQueued),
+	}
+}
+
+// Acquire takes a slot, waiting in the queue while all slots are taken. It
+// returns false if the queue is full or the context is done while waiting.
+func (l *inflightLimiter) Acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-l.queue }()
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Release returns a slot which was taken by Acquire.
+func (l *inflightLimiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
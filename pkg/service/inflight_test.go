@@ -0,0 +1,74 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestInflightLimiter(t *testing.T) {
+	var unlimited *inflightLimiter
+	assert.True(t, unlimited.Acquire(context.Background()))
+	unlimited.Release()
+	assert.Nil(t, newInflightLimiter(0, 10))
+
+	l := newInflightLimiter(1, 1)
+	assert.True(t, l.Acquire(context.Background()))
+
+	// the second request waits in the queue until the slot is released
+	acquired := make(chan bool)
+	go func() {
+		acquired <- l.Acquire(context.Background())
+	}()
+	assert.Eventually(t, func() bool { return len(l.queue) == 1 }, time.Second, time.Millisecond)
+
+	// the queue is full
+	assert.False(t, l.Acquire(context.Background()))
+
+	l.Release()
+	assert.True(t, <-acquired)
+	assert.Empty(t, l.queue)
+
+	// a request which waits in the queue gives up when its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.False(t, l.Acquire(ctx))
+	assert.Empty(t, l.queue)
+
+	l.Release()
+	assert.True(t, l.Acquire(context.Background()))
+}
+
+func TestServeAdmissionOverloaded(t *testing.T) {
+	h := &Handler{inflight: newInflightLimiter(1, 0)}
+	h.RegisterKind("FloatingIPPool", WebhookFloatingIPPool, h.admitFloatingIPPool)
+	assert.True(t, h.inflight.Acquire(context.Background()))
+
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:  "test-uid",
+			Kind: metav1.GroupVersionKind{Kind: "FloatingIPPool"},
+		},
+	})
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	h.validateAdmission(w, req)
+
+	response := &admissionv1.AdmissionReview{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(response))
+	assert.False(t, response.Response.Allowed)
+	assert.Equal(t, int32(http.StatusTooManyRequests), response.Response.Result.Code)
+	assert.Equal(t, metav1.StatusReasonTooManyRequests, response.Response.Result.Reason)
+	assert.Equal(t, "the webhook is overloaded, try again later", response.Response.Result.Message)
+}
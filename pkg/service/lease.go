@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+)
+
+// ExpiresAfterAnnotation is the FloatingIP annotation with the lease of the
+// FloatingIP, counted from its creation, for example:
+//
+//	30d
+//
+// The lease is a Go duration or a number of days.
+const ExpiresAfterAnnotation = "rancher.k8s.binbash.org/expires-after"
+
+// EphemeralPoolLabel is the FloatingIPPool label which marks the pool as
+// ephemeral when it is true, FloatingIPs in ephemeral pools require the
+// ExpiresAfterAnnotation.
+const EphemeralPoolLabel = "rancher.k8s.binbash.org/ephemeral"
+
+// LeaseValid validates the ExpiresAfterAnnotation of FloatingIPs. When the
+// project has a maximum lease, by the MaxLease or ProjectMaxLeases options,
+// the annotation is required and the lease may not exceed the maximum.
+// Updates which don't change the annotation are not checked, so existing
+// FloatingIPs keep working when a maximum lease is configured.
+type LeaseValid struct{}
+
+func (v *LeaseValid) Name() string { return "LeaseValid" }
+
+func (v *LeaseValid) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	value, ok := req.FIP.Annotations[ExpiresAfterAnnotation]
+	if req.IsUpdate() {
+		oldValue, oldOk := req.OldFIP.Annotations[ExpiresAfterAnnotation]
+		if ok == oldOk && value == oldValue {
+			return nil
+		}
+	}
+
+	projectID := req.projectID()
+	maxLease := h.maxLease(projectID)
+
+	if !ok {
+		if ephemeral, _ := strconv.ParseBool(req.Pool.Labels[EphemeralPoolLabel]); ephemeral {
+			return fmt.Errorf("floatingippool %s is ephemeral, FloatingIPs in the pool require the %s annotation",
+				req.Pool.Name, ExpiresAfterAnnotation)
+		}
+		if maxLease > 0 {
+			return fmt.Errorf("project %s has a maximum lease of %s, FloatingIPs require the %s annotation",
+				projectID, validator.FormatLease(maxLease), ExpiresAfterAnnotation)
+		}
+		return nil
+	}
+
+	lease, err := validator.ParseLease(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s annotation: %s", ExpiresAfterAnnotation, err)
+	}
+	if maxLease > 0 && lease > maxLease {
+		return fmt.Errorf("lease %s of the %s annotation exceeds the maximum lease of %s of project %s",
+			validator.FormatLease(lease), ExpiresAfterAnnotation, validator.FormatLease(maxLease), projectID)
+	}
+
+	return nil
+}
+
+// maxLease returns the maximum lease of FloatingIPs of the project, the lease
+// is not limited when it is 0.
+func (h *Handler) maxLease(projectID string) time.Duration {
+	if lease, ok := h.options.ProjectMaxLeases[projectID]; ok {
+		return lease
+	}
+
+	return h.options.MaxLease
+}
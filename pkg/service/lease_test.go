@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLeaseValid(t *testing.T) {
+	newFIP := func(lease string) *rfmv2.FloatingIP {
+		fip := &rfmv2.FloatingIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-fip",
+				Namespace: "default",
+				Labels:    map[string]string{ProjectNameLabel: "p-abcde"},
+			},
+			Spec: rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool"},
+		}
+		if lease != "" {
+			fip.Annotations = map[string]string{ExpiresAfterAnnotation: lease}
+		}
+		return fip
+	}
+	pool := &rfmv2.FloatingIPPool{ObjectMeta: metav1.ObjectMeta{Name: "test-pool"}}
+	ephemeralPool := &rfmv2.FloatingIPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pool", Labels: map[string]string{EphemeralPoolLabel: "true"}},
+	}
+
+	testCases := []struct {
+		name            string
+		options         Options
+		fip             *rfmv2.FloatingIP
+		oldFIP          *rfmv2.FloatingIP
+		pool            *rfmv2.FloatingIPPool
+		expectedMessage string
+	}{
+		{
+			name: "no annotation and no maximum lease",
+			fip:  newFIP(""),
+			pool: pool,
+		},
+		{
+			name: "valid annotation and no maximum lease",
+			fip:  newFIP("365d"),
+			pool: pool,
+		},
+		{
+			name:            "invalid annotation",
+			fip:             newFIP("tomorrow"),
+			pool:            pool,
+			expectedMessage: `invalid rancher.k8s.binbash.org/expires-after annotation: invalid lease "tomorrow", expected a duration like 12h or 30d`,
+		},
+		{
+			name:    "lease within the maximum lease",
+			options: Options{MaxLease: 30 * 24 * time.Hour},
+			fip:     newFIP("72h"),
+			pool:    pool,
+		},
+		{
+			name:            "lease exceeds the maximum lease",
+			options:         Options{MaxLease: 30 * 24 * time.Hour},
+			fip:             newFIP("31d"),
+			pool:            pool,
+			expectedMessage: "lease 31d of the rancher.k8s.binbash.org/expires-after annotation exceeds the maximum lease of 30d of project p-abcde",
+		},
+		{
+			name:            "missing annotation with a maximum lease",
+			options:         Options{MaxLease: 30 * 24 * time.Hour},
+			fip:             newFIP(""),
+			pool:            pool,
+			expectedMessage: "project p-abcde has a maximum lease of 30d, FloatingIPs require the rancher.k8s.binbash.org/expires-after annotation",
+		},
+		{
+			name:            "project maximum lease overrides the maximum lease",
+			options:         Options{MaxLease: 30 * 24 * time.Hour, ProjectMaxLeases: map[string]time.Duration{"p-abcde": 12 * time.Hour}},
+			fip:             newFIP("1d"),
+			pool:            pool,
+			expectedMessage: "lease 1d of the rancher.k8s.binbash.org/expires-after annotation exceeds the maximum lease of 12h0m0s of project p-abcde",
+		},
+		{
+			name:    "project without a maximum lease",
+			options: Options{MaxLease: 30 * 24 * time.Hour, ProjectMaxLeases: map[string]time.Duration{"p-abcde": 0}},
+			fip:     newFIP(""),
+			pool:    pool,
+		},
+		{
+			name:            "missing annotation in an ephemeral pool",
+			fip:             newFIP(""),
+			pool:            ephemeralPool,
+			expectedMessage: "floatingippool test-pool is ephemeral, FloatingIPs in the pool require the rancher.k8s.binbash.org/expires-after annotation",
+		},
+		{
+			name: "annotation in an ephemeral pool",
+			fip:  newFIP("8h"),
+			pool: ephemeralPool,
+		},
+		{
+			name:    "update without annotation change",
+			options: Options{MaxLease: 24 * time.Hour},
+			fip:     newFIP(""),
+			oldFIP:  newFIP(""),
+			pool:    ephemeralPool,
+		},
+		{
+			name:            "update which extends the lease",
+			options:         Options{MaxLease: 24 * time.Hour},
+			fip:             newFIP("48h"),
+			oldFIP:          newFIP("24h"),
+			pool:            pool,
+			expectedMessage: "lease 2d of the rancher.k8s.binbash.org/expires-after annotation exceeds the maximum lease of 1d of project p-abcde",
+		},
+		{
+			name:            "update which removes the annotation",
+			options:         Options{MaxLease: 24 * time.Hour},
+			fip:             newFIP(""),
+			oldFIP:          newFIP("24h"),
+			pool:            pool,
+			expectedMessage: "project p-abcde has a maximum lease of 1d, FloatingIPs require the rancher.k8s.binbash.org/expires-after annotation",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{options: tc.options}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP:     tc.fip,
+				OldFIP:  tc.oldFIP,
+				Pool:    tc.pool,
+			}
+
+			err := (&LeaseValid{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
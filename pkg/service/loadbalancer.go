@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// LoadBalancerConflictsWarn allows FloatingIPPools which overlap with a
+	// load balancer address pool with a warning.
+	LoadBalancerConflictsWarn = "warn"
+	// LoadBalancerConflictsDeny denies FloatingIPPools which overlap with a
+	// load balancer address pool.
+	LoadBalancerConflictsDeny = "deny"
+
+	// KubeVIPConfigMapNamespace and KubeVIPConfigMapName are the ConfigMap
+	// with the address ranges of the kube-vip cloud provider.
+	KubeVIPConfigMapNamespace = "kube-system"
+	KubeVIPConfigMapName      = "kubevip"
+)
+
+var metalLBPoolGVR = schema.GroupVersionResource{
+	Group:    "metallb.io",
+	Version:  "v1beta1",
+	Resource: "ipaddresspools",
+}
+
+// loadBalancerRange is an address range which is handed out by a load
+// balancer, like a MetalLB IPAddressPool or a kube-vip range.
+type loadBalancerRange struct {
+	source    string
+	addresses string
+	start     net.IP
+	end       net.IP
+}
+
+func (r loadBalancerRange) String() string {
+	return fmt.Sprintf("%s (%s)", r.source, r.addresses)
+}
+
+// metalLBRanges returns the address ranges of the MetalLB IPAddressPools. No
+// ranges are returned when MetalLB is not installed.
+func (h *Handler) metalLBRanges(ctx context.Context, req *FloatingIPPoolRequest) ([]loadBalancerRange, error) {
+	var list *unstructured.UnstructuredList
+	err := retry.OnError(lookupBackoff, isTransient, func() (err error) {
+		list, err = h.dynamic.Resource(metalLBPoolGVR).List(ctx, metav1.ListOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []loadBalancerRange
+	for _, item := range list.Items {
+		addresses, _, _ := unstructured.NestedStringSlice(item.Object, "spec", "addresses")
+		for _, a := range addresses {
+			start, end, err := validator.ParseAddressRange(a)
+			if err != nil {
+				req.Log.Warnf("skipping address range of MetalLB IPAddressPool %s/%s: %s", item.GetNamespace(), item.GetName(), err)
+				continue
+			}
+			ranges = append(ranges, loadBalancerRange{
+				source:    fmt.Sprintf("MetalLB IPAddressPool %s/%s", item.GetNamespace(), item.GetName()),
+				addresses: a,
+				start:     start,
+				end:       end,
+			})
+		}
+	}
+
+	return ranges, nil
+}
+
+// kubeVIPRanges returns the address ranges of the cidr-* and range-* keys of
+// the kube-vip cloud provider ConfigMap, which contain comma separated CIDRs
+// and ranges. No ranges are returned when the ConfigMap doesn't exist.
+func (h *Handler) kubeVIPRanges(ctx context.Context, req *FloatingIPPoolRequest) ([]loadBalancerRange, error) {
+	var data map[string]string
+	err := retry.OnError(lookupBackoff, isTransient, func() error {
+		configMap, err := h.clientset.CoreV1().ConfigMaps(KubeVIPConfigMapNamespace).Get(ctx, KubeVIPConfigMapName, metav1.GetOptions{})
+		if err == nil {
+			data = configMap.Data
+		}
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		if strings.HasPrefix(key, "cidr-") || strings.HasPrefix(key, "range-") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var ranges []loadBalancerRange
+	for _, key := range keys {
+		for _, a := range strings.Split(data[key], ",") {
+			if a = strings.TrimSpace(a); a == "" {
+				continue
+			}
+			start, end, err := validator.ParseAddressRange(a)
+			if err != nil {
+				req.Log.Warnf("skipping address range %s of kube-vip: %s", key, err)
+				continue
+			}
+			ranges = append(ranges, loadBalancerRange{
+				source:    fmt.Sprintf("kube-vip range %s", key),
+				addresses: a,
+				start:     start,
+				end:       end,
+			})
+		}
+	}
+
+	return ranges, nil
+}
+
+// PoolLoadBalancerConflict checks that the pool range doesn't overlap with the
+// MetalLB IPAddressPools or the kube-vip ranges of the cluster, which would
+// assign the same address twice. Depending on the LoadBalancerConflicts
+// option the pool is denied or allowed with a warning. Updates which keep the
+// range are not checked. It expects the pool range to be validated by
+// PoolRangeValid first.
+type PoolLoadBalancerConflict struct{}
+
+func (v *PoolLoadBalancerConflict) Name() string { return "PoolLoadBalancerConflict" }
+
+func (v *PoolLoadBalancerConflict) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	mode := h.options.LoadBalancerConflicts
+	if mode != LoadBalancerConflictsWarn && mode != LoadBalancerConflictsDeny {
+		return nil
+	}
+	pool := req.Pool.Spec.IPConfig.Pool
+	if req.IsUpdate() && req.OldPool.Spec.IPConfig != nil &&
+		req.OldPool.Spec.IPConfig.Pool.Start == pool.Start && req.OldPool.Spec.IPConfig.Pool.End == pool.End {
+		return nil
+	}
+
+	var ranges []loadBalancerRange
+	for _, lookup := range []func(context.Context, *FloatingIPPoolRequest) ([]loadBalancerRange, error){h.metalLBRanges, h.kubeVIPRanges} {
+		r, err := lookup(ctx, req)
+		if err != nil {
+			req.Log.Errorf("failed to look up the load balancer address pools: %s", err)
+			if mode == LoadBalancerConflictsWarn {
+				req.Warnings = append(req.Warnings, "the load balancer address pools could not be checked for conflicts")
+				return nil
+			}
+			return fmt.Errorf("internal server error: failed to look up the load balancer address pools")
+		}
+		ranges = append(ranges, r...)
+	}
+
+	start := net.ParseIP(pool.Start)
+	end := net.ParseIP(pool.End)
+	for _, r := range ranges {
+		if !validator.RangesOverlap(start, end, r.start, r.end) {
+			continue
+		}
+		msg := fmt.Sprintf("pool range [%s, %s] overlaps with the %s", pool.Start, pool.End, r)
+		if mode == LoadBalancerConflictsDeny {
+			return fmt.Errorf("%s", msg)
+		}
+		req.Warnings = append(req.Warnings, msg)
+	}
+
+	return nil
+}
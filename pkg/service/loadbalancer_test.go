@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestPoolLoadBalancerConflict(t *testing.T) {
+	metalLBPool := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "metallb.io/v1beta1",
+		"kind":       "IPAddressPool",
+		"metadata":   map[string]interface{}{"name": "default", "namespace": "metallb-system"},
+		"spec": map[string]interface{}{
+			"addresses": []interface{}{"192.168.10.0/24", "192.168.20.10-192.168.20.20"},
+		},
+	}}
+	kubeVIP := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: KubeVIPConfigMapName, Namespace: KubeVIPConfigMapNamespace},
+		Data: map[string]string{
+			"range-global": "192.168.30.10-192.168.30.20",
+			"cidr-default": "invalid, 192.168.40.0/28",
+			"allow-share":  "true",
+		},
+	}
+	pool := func(start string, end string) *rfmv2.FloatingIPPool {
+		return &rfmv2.FloatingIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pool"},
+			Spec: rfmv2.FloatingIPPoolSpec{
+				IPConfig: &rfmv2.IPConfig{
+					Subnet: "192.168.0.0/16",
+					Pool:   rfmv2.Pool{Start: start, End: end},
+				},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name             string
+		mode             string
+		pool             *rfmv2.FloatingIPPool
+		oldPool          *rfmv2.FloatingIPPool
+		expectedMessage  string
+		expectedWarnings []string
+	}{
+		{
+			name: "check disabled",
+			pool: pool("192.168.10.10", "192.168.10.20"),
+		},
+		{
+			name: "no conflicts",
+			mode: LoadBalancerConflictsDeny,
+			pool: pool("192.168.50.10", "192.168.50.20"),
+		},
+		{
+			name:            "MetalLB CIDR",
+			mode:            LoadBalancerConflictsDeny,
+			pool:            pool("192.168.10.10", "192.168.10.20"),
+			expectedMessage: "pool range [192.168.10.10, 192.168.10.20] overlaps with the MetalLB IPAddressPool metallb-system/default (192.168.10.0/24)",
+		},
+		{
+			name:            "MetalLB range",
+			mode:            LoadBalancerConflictsDeny,
+			pool:            pool("192.168.20.20", "192.168.20.30"),
+			expectedMessage: "pool range [192.168.20.20, 192.168.20.30] overlaps with the MetalLB IPAddressPool metallb-system/default (192.168.20.10-192.168.20.20)",
+		},
+		{
+			name:            "kube-vip CIDR",
+			mode:            LoadBalancerConflictsDeny,
+			pool:            pool("192.168.40.10", "192.168.40.20"),
+			expectedMessage: "pool range [192.168.40.10, 192.168.40.20] overlaps with the kube-vip range cidr-default (192.168.40.0/28)",
+		},
+		{
+			name: "warning",
+			mode: LoadBalancerConflictsWarn,
+			pool: pool("192.168.20.0", "192.168.40.255"),
+			expectedWarnings: []string{
+				"pool range [192.168.20.0, 192.168.40.255] overlaps with the MetalLB IPAddressPool metallb-system/default (192.168.20.10-192.168.20.20)",
+				"pool range [192.168.20.0, 192.168.40.255] overlaps with the kube-vip range cidr-default (192.168.40.0/28)",
+				"pool range [192.168.20.0, 192.168.40.255] overlaps with the kube-vip range range-global (192.168.30.10-192.168.30.20)",
+			},
+		},
+		{
+			name:    "existing range is unchanged",
+			mode:    LoadBalancerConflictsDeny,
+			pool:    pool("192.168.10.10", "192.168.10.20"),
+			oldPool: pool("192.168.10.10", "192.168.10.20"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				clientset: kubefake.NewSimpleClientset(kubeVIP),
+				dynamic: fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+					metalLBPoolGVR: "IPAddressPoolList",
+				}, metalLBPool),
+				options: Options{LoadBalancerConflicts: tc.mode},
+			}
+			req := &FloatingIPPoolRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				Pool:    tc.pool,
+				OldPool: tc.oldPool,
+			}
+
+			err := (&PoolLoadBalancerConflict{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedWarnings, req.Warnings)
+		})
+	}
+}
+
+func TestPoolLoadBalancerConflictNotInstalled(t *testing.T) {
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		metalLBPoolGVR: "IPAddressPoolList",
+	})
+	dynamicClient.PrependReactor("list", "ipaddresspools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(metalLBPoolGVR.GroupResource(), "")
+	})
+	h := &Handler{
+		clientset: kubefake.NewSimpleClientset(),
+		dynamic:   dynamicClient,
+		options:   Options{LoadBalancerConflicts: LoadBalancerConflictsDeny},
+	}
+	req := &FloatingIPPoolRequest{
+		Request: &admissionv1.AdmissionRequest{},
+		Log:     log.NewEntry(log.StandardLogger()),
+		Pool: &rfmv2.FloatingIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pool"},
+			Spec: rfmv2.FloatingIPPoolSpec{
+				IPConfig: &rfmv2.IPConfig{
+					Subnet: "192.168.10.0/24",
+					Pool:   rfmv2.Pool{Start: "192.168.10.10", End: "192.168.10.20"},
+				},
+			},
+		},
+	}
+
+	assert.NoError(t, (&PoolLoadBalancerConflict{}).Validate(context.Background(), h, req))
+	assert.Empty(t, req.Warnings)
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// PoolMaintenanceWindow only allows FloatingIPPools to be created, deleted or
+// to have their spec changed during one of the MaintenanceWindows, because pool
+// changes outside maintenance can cause outages. Members of the
+// MaintenanceGroups may change pools at any time. Updates which leave
+// the spec unchanged, like status or reservation updates, are always allowed.
+type PoolMaintenanceWindow struct{}
+
+func (v *PoolMaintenanceWindow) Name() string { return "PoolMaintenanceWindow" }
+
+// ValidatesDelete marks PoolMaintenanceWindow as a FloatingIPPoolDeleteValidator.
+func (v *PoolMaintenanceWindow) ValidatesDelete() {}
+
+func (v *PoolMaintenanceWindow) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	if len(h.options.MaintenanceWindows) == 0 {
+		return nil
+	}
+	if req.IsUpdate() && equality.Semantic.DeepEqual(req.OldPool.Spec, req.Pool.Spec) {
+		return nil
+	}
+
+	location := h.options.MaintenanceLocation
+	if location == nil {
+		location = time.UTC
+	}
+	now := time.Now().In(location)
+	windows := make([]string, 0, len(h.options.MaintenanceWindows))
+	for _, window := range h.options.MaintenanceWindows {
+		if window.Active(now) {
+			return nil
+		}
+		windows = append(windows, window.String())
+	}
+
+	user := req.Request.UserInfo
+	if memberOfGroups(h.options.MaintenanceGroups, user.Groups) {
+		req.Log.Warnf("user %s changes floatingippool %s outside the maintenance windows", user.Username, req.Pool.Name)
+		req.Warnings = append(req.Warnings, fmt.Sprintf("floatingippool %s is changed outside the maintenance windows", req.Pool.Name))
+		return nil
+	}
+
+	return fmt.Errorf("floatingippools can only be %s during the maintenance windows (%s, %s)",
+		poolOperation(req), strings.Join(windows, "; "), location)
+}
+
+// poolOperation returns the past participle of the operation of the request.
+func poolOperation(req *FloatingIPPoolRequest) string {
+	switch {
+	case req.IsDelete():
+		return "deleted"
+	case req.IsUpdate():
+		return "changed"
+	default:
+		return "created"
+	}
+}
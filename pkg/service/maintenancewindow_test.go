@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPoolMaintenanceWindow(t *testing.T) {
+	mustParse := func(spec string) *validator.MaintenanceWindow {
+		w, err := validator.ParseMaintenanceWindow(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	always := mustParse("* * * * * 1m")
+	// a window which starts tomorrow is never active now
+	tomorrow := time.Now().UTC().Add(24 * time.Hour)
+	inactive := mustParse(fmt.Sprintf("0 0 %d %d * 1h", tomorrow.Day(), tomorrow.Month()))
+
+	pool := &rfmv2.FloatingIPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pool"},
+		Spec: rfmv2.FloatingIPPoolSpec{
+			IPConfig: &rfmv2.IPConfig{
+				Subnet: "192.168.10.0/24",
+				Pool:   rfmv2.Pool{Start: "192.168.10.10", End: "192.168.10.20"},
+			},
+		},
+	}
+	changedPool := pool.DeepCopy()
+	changedPool.Spec.IPConfig.Pool.End = "192.168.10.30"
+	statusPool := pool.DeepCopy()
+	statusPool.Status.Available = 5
+
+	testCases := []struct {
+		name             string
+		windows          []*validator.MaintenanceWindow
+		groups           []string
+		userGroups       []string
+		operation        admissionv1.Operation
+		pool             *rfmv2.FloatingIPPool
+		oldPool          *rfmv2.FloatingIPPool
+		expectedMessage  string
+		expectedWarnings int
+	}{
+		{
+			name:      "no maintenance windows",
+			operation: admissionv1.Create,
+			pool:      pool,
+		},
+		{
+			name:      "create during maintenance window",
+			windows:   []*validator.MaintenanceWindow{inactive, always},
+			operation: admissionv1.Create,
+			pool:      pool,
+		},
+		{
+			name:            "create outside maintenance window",
+			windows:         []*validator.MaintenanceWindow{inactive},
+			operation:       admissionv1.Create,
+			pool:            pool,
+			expectedMessage: "floatingippools can only be created during the maintenance windows (" + inactive.String() + ", UTC)",
+		},
+		{
+			name:            "spec change outside maintenance window",
+			windows:         []*validator.MaintenanceWindow{inactive},
+			operation:       admissionv1.Update,
+			pool:            changedPool,
+			oldPool:         pool,
+			expectedMessage: "floatingippools can only be changed during the maintenance windows (" + inactive.String() + ", UTC)",
+		},
+		{
+			name:      "status change outside maintenance window",
+			windows:   []*validator.MaintenanceWindow{inactive},
+			operation: admissionv1.Update,
+			pool:      statusPool,
+			oldPool:   pool,
+		},
+		{
+			name:            "delete outside maintenance window",
+			windows:         []*validator.MaintenanceWindow{inactive},
+			operation:       admissionv1.Delete,
+			pool:            pool,
+			expectedMessage: "floatingippools can only be deleted during the maintenance windows (" + inactive.String() + ", UTC)",
+		},
+		{
+			name:             "override group outside maintenance window",
+			windows:          []*validator.MaintenanceWindow{inactive},
+			groups:           []string{"network-admins"},
+			userGroups:       []string{"system:authenticated", "network-admins"},
+			operation:        admissionv1.Create,
+			pool:             pool,
+			expectedWarnings: 1,
+		},
+		{
+			name:            "other group outside maintenance window",
+			windows:         []*validator.MaintenanceWindow{inactive},
+			groups:          []string{"network-admins"},
+			userGroups:      []string{"system:authenticated"},
+			operation:       admissionv1.Create,
+			pool:            pool,
+			expectedMessage: "floatingippools can only be created during the maintenance windows (" + inactive.String() + ", UTC)",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				options: Options{
+					MaintenanceWindows: tc.windows,
+					MaintenanceGroups:  tc.groups,
+				},
+			}
+			req := &FloatingIPPoolRequest{
+				Request: &admissionv1.AdmissionRequest{
+					Operation: tc.operation,
+					UserInfo:  authenticationv1.UserInfo{Username: "test-user", Groups: tc.userGroups},
+				},
+				Log:     log.NewEntry(log.StandardLogger()),
+				Pool:    tc.pool,
+				OldPool: tc.oldPool,
+			}
+
+			err := (&PoolMaintenanceWindow{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, req.Warnings, tc.expectedWarnings)
+		})
+	}
+}
+
+func TestValidateFloatingIPPoolDelete(t *testing.T) {
+	h := &Handler{
+		fipPoolValidators: []FloatingIPPoolValidator{&PoolMaintenanceWindow{}, &denyAllValidator{}},
+	}
+	ar := &admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "test-uid",
+			Operation: admissionv1.Delete,
+		},
+	}
+	fipPool := &rfmv2.FloatingIPPool{ObjectMeta: metav1.ObjectMeta{Name: "test-pool"}}
+
+	// only delete validators run on a delete
+	response := h.validateFloatingIPPool(context.Background(), requestLogger(ar.Request), ar, fipPool, nil)
+	assert.True(t, response.Allowed)
+
+	ar.Request.Operation = admissionv1.Create
+	response = h.validateFloatingIPPool(context.Background(), requestLogger(ar.Request), ar, fipPool, nil)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "DenyAll", response.AuditAnnotations["denied-by"])
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// MissingQuotaDeny denies FloatingIPs of projects without a
+	// FloatingIPProjectQuota.
+	MissingQuotaDeny = "deny"
+	// MissingQuotaAllow doesn't limit the FloatingIPs of projects without a
+	// FloatingIPProjectQuota.
+	MissingQuotaAllow = "allow"
+	// MissingQuotaLimit limits the FloatingIPs of projects without a
+	// FloatingIPProjectQuota to the MissingQuotaLimit option per pool.
+	MissingQuotaLimit = "limit"
+)
+
+// missingQuota handles a FloatingIP of a project without a
+// FloatingIPProjectQuota according to the MissingQuotaPolicy option, so
+// clusters which don't use quotas can still use the other checks.
+func (h *Handler) missingQuota(ctx context.Context, req *FloatingIPRequest, projectID string) error {
+	switch h.options.MissingQuotaPolicy {
+	case MissingQuotaAllow:
+		req.Log.Debugf("no floatingipprojectquota exists for project %s, allowing the FloatingIP", projectID)
+		return nil
+	case MissingQuotaLimit:
+	default:
+		return fmt.Errorf("no floatingipprojectquota exists for project %s", projectID)
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{ProjectNameLabel: projectID})
+	list, err := h.dynamic.Resource(h.apiResources().FloatingIPGVR()).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		req.Log.Errorf("failed to list floatingips of project %s: %s", projectID, err)
+		if isTransient(err) {
+			return &TransientError{Err: fmt.Errorf("failed to list floatingips of project %s", projectID)}
+		}
+		return fmt.Errorf("internal server error: failed to list floatingips")
+	}
+
+	// the FloatingIP itself already exists when its IP is changed, it is not counted
+	pool := req.PoolName()
+	used := 0
+	for _, item := range list.Items {
+		fipPool, _, _ := unstructured.NestedString(item.Object, "spec", "floatingIPPool")
+		if fipPool == pool && item.GetDeletionTimestamp() == nil &&
+			(item.GetNamespace() != req.FIP.Namespace || item.GetName() != req.FIP.Name) {
+			used++
+		}
+	}
+
+	req.Quota = h.options.MissingQuotaLimit
+	req.QuotaUsed = used
+	req.quotaKnown = true
+	return h.checkQuotaLimit(req, QuotaLimit{
+		Scope: QuotaScopeProject,
+		Limit: req.Quota,
+		Used:  used,
+		Err:   fmt.Errorf("default quota exceeded for floatingippool %s in project %s, which has no floatingipprojectquota. Quota: %d, Used: %d", pool, projectID, req.Quota, used),
+	})
+}
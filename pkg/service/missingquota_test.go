@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestMissingQuota(t *testing.T) {
+	fip := func(namespace string, name string, pool string) *rfmv2.FloatingIP {
+		return &rfmv2.FloatingIP{
+			TypeMeta: metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIP"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{ProjectNameLabel: "test-project"},
+			},
+			Spec: rfmv2.FloatingIPSpec{FloatingIPPool: pool},
+		}
+	}
+	objects, err := getUnstructuredList([]runtime.Object{
+		fip("default", "fip-1", "test-pool"),
+		fip("other", "fip-1", "test-pool"),
+		fip("default", "fip-2", "other-pool"),
+	})
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name            string
+		policy          string
+		limit           int
+		fip             *rfmv2.FloatingIP
+		expectedMessage string
+	}{
+		{
+			name:            "denied by default",
+			fip:             fip("default", "new-fip", "test-pool"),
+			expectedMessage: "no floatingipprojectquota exists for project test-project",
+		},
+		{
+			name:   "allowed",
+			policy: MissingQuotaAllow,
+			fip:    fip("default", "new-fip", "test-pool"),
+		},
+		{
+			name:   "within the default limit",
+			policy: MissingQuotaLimit,
+			limit:  3,
+			fip:    fip("default", "new-fip", "test-pool"),
+		},
+		{
+			name:            "default limit exceeded",
+			policy:          MissingQuotaLimit,
+			limit:           2,
+			fip:             fip("default", "new-fip", "test-pool"),
+			expectedMessage: "default quota exceeded for floatingippool test-pool in project test-project, which has no floatingipprojectquota. Quota: 2, Used: 2",
+		},
+		{
+			name:   "existing FloatingIP is not counted",
+			policy: MissingQuotaLimit,
+			limit:  2,
+			fip:    fip("default", "fip-1", "test-pool"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				dynamic: fake.NewSimpleDynamicClient(runtime.NewScheme(), objects...),
+				options: Options{MissingQuotaPolicy: tc.policy, MissingQuotaLimit: tc.limit},
+			}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP:     tc.fip,
+			}
+
+			err := h.missingQuota(context.Background(), req, "test-project")
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
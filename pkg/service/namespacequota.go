@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// NamespaceQuotaAnnotation is the namespace annotation which limits the number
+// of FloatingIPs per FloatingIPPool in the namespace, so a project quota can be
+// divided between the namespaces of the project.
+//
+// The value is a JSON object keyed by FloatingIPPool name, for example:
+//
+//	{"public":2,"private":5}
+const NamespaceQuotaAnnotation = "rancher.k8s.binbash.org/floatingip-quota"
+
+// namespaceQuotas returns the quotas from the annotations of the namespace.
+func namespaceQuotas(namespace string, annotations map[string]string) (map[string]int, error) {
+	quotas := make(map[string]int)
+
+	value, ok := annotations[NamespaceQuotaAnnotation]
+	if !ok || value == "" {
+		return quotas, nil
+	}
+	if err := json.Unmarshal([]byte(value), &quotas); err != nil {
+		return nil, fmt.Errorf("cannot parse the %s annotation of namespace %s: %s", NamespaceQuotaAnnotation, namespace, err)
+	}
+
+	return quotas, nil
+}
+
+// NamespaceQuotaCheck enforces the quota of the namespace annotation in addition
+// to the project quota, so the stricter of both limits applies. Pools without
+// a namespace quota are only limited by the project quota.
+type NamespaceQuotaCheck struct{}
+
+func (v *NamespaceQuotaCheck) Name() string { return "NamespaceQuotaCheck" }
+
+func (v *NamespaceQuotaCheck) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if req.IPUnchanged() || req.QuotaOverride != "" || h.clientset == nil {
+		return nil
+	}
+	namespace := req.FIP.Namespace
+	pool := req.PoolName()
+
+	ns, err := h.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		req.Log.Errorf("failed to get namespace %s: %s", namespace, err)
+		return fmt.Errorf("internal server error: failed to get namespace %s", namespace)
+	}
+
+	quotas, err := namespaceQuotas(namespace, ns.Annotations)
+	if err != nil {
+		req.Log.Errorf("%s", err)
+		return fmt.Errorf("invalid %s annotation on namespace %s", NamespaceQuotaAnnotation, namespace)
+	}
+	quota, ok := quotas[pool]
+	if !ok {
+		return nil
+	}
+
+	list, err := h.dynamic.Resource(h.apiResources().FloatingIPGVR()).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		req.Log.Errorf("failed to list floatingips in namespace %s: %s", namespace, err)
+		return fmt.Errorf("internal server error: failed to list floatingips")
+	}
+
+	// the FloatingIP itself already exists when its IP is changed, it is not counted
+	used := 0
+	for _, item := range list.Items {
+		fipPool, _, _ := unstructured.NestedString(item.Object, "spec", "floatingIPPool")
+		if fipPool == pool && item.GetName() != req.FIP.Name && item.GetDeletionTimestamp() == nil {
+			used++
+		}
+	}
+
+	return h.checkQuotaLimit(req, QuotaLimit{
+		Scope: QuotaScopeNamespace,
+		Limit: quota,
+		Used:  used,
+		Err:   fmt.Errorf("namespace quota exceeded for floatingippool %s in namespace %s. Quota: %d, Used: %d", pool, namespace, quota, used),
+	})
+}
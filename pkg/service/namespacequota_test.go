@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceQuotaCheck(t *testing.T) {
+	namespace := func(name string, quota string) *corev1.Namespace {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if quota != "" {
+			ns.Annotations = map[string]string{NamespaceQuotaAnnotation: quota}
+		}
+		return ns
+	}
+	fip := func(namespace string, name string, pool string) *rfmv2.FloatingIP {
+		return &rfmv2.FloatingIP{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIP"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: pool},
+		}
+	}
+	objects, err := getUnstructuredList([]runtime.Object{
+		fip("team-a", "fip-1", "public"),
+		fip("team-a", "fip-2", "private"),
+		fip("team-b", "fip-3", "public"),
+	})
+	assert.NoError(t, err)
+	h := &Handler{
+		clientset: kubefake.NewSimpleClientset(
+			namespace("team-a", `{"public":1,"private":5}`),
+			namespace("team-b", ""),
+			namespace("team-c", "public=1"),
+		),
+		dynamic: fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingips"}: "FloatingIPList",
+		}, objects...),
+	}
+
+	ipAddr := "192.168.1.10"
+	oldIPAddr := "192.168.1.11"
+	testCases := []struct {
+		name          string
+		fip           *rfmv2.FloatingIP
+		update        bool
+		expectedError string
+	}{
+		{
+			name:          "namespace quota exceeded",
+			fip:           fip("team-a", "new-fip", "public"),
+			expectedError: "namespace quota exceeded for floatingippool public in namespace team-a. Quota: 1, Used: 1",
+		},
+		{
+			name: "within the namespace quota",
+			fip:  fip("team-a", "new-fip", "private"),
+		},
+		{
+			name:   "IP of an existing FloatingIP is changed",
+			fip:    fip("team-a", "fip-1", "public"),
+			update: true,
+		},
+		{
+			name: "pool without namespace quota",
+			fip:  fip("team-a", "new-fip", "other"),
+		},
+		{
+			name: "namespace without quota",
+			fip:  fip("team-b", "new-fip", "public"),
+		},
+		{
+			name:          "invalid annotation",
+			fip:           fip("team-c", "new-fip", "public"),
+			expectedError: "invalid rancher.k8s.binbash.org/floatingip-quota annotation on namespace team-c",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fip.Spec.IPAddr = &ipAddr
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP:     tc.fip,
+			}
+			if tc.update {
+				req.OldFIP = tc.fip.DeepCopy()
+				req.OldFIP.Status.IPAddr = oldIPAddr
+			}
+
+			err := (&NamespaceQuotaCheck{}).Validate(context.Background(), h, req)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
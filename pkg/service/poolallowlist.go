@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// AllowedPoolsAnnotation is the FloatingIPProjectQuota annotation which
+// restricts the FloatingIPPools a project may use to a comma separated list
+// of pool names, for example:
+//
+//	public,private
+//
+// Without the annotation a project may use every pool it has a quota for.
+const AllowedPoolsAnnotation = "rancher.k8s.binbash.org/allowed-pools"
+
+// allowedPools returns the pools of the AllowedPoolsAnnotation of the quota
+// and false when the quota doesn't restrict the pools.
+func allowedPools(quota *rfmv2.FloatingIPProjectQuota) (map[string]bool, bool) {
+	value, ok := quota.Annotations[AllowedPoolsAnnotation]
+	if !ok {
+		return nil, false
+	}
+
+	pools := make(map[string]bool)
+	for _, pool := range strings.Split(value, ",") {
+		if pool = strings.TrimSpace(pool); pool != "" {
+			pools[pool] = true
+		}
+	}
+
+	return pools, true
+}
+
+// PoolAllowed denies FloatingIPs in pools which are not in the allowlist of
+// the project, even when the project has quota left for the pool.
+type PoolAllowed struct{}
+
+func (v *PoolAllowed) Name() string { return "PoolAllowed" }
+
+func (v *PoolAllowed) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if req.IPUnchanged() {
+		return nil
+	}
+
+	projectID := req.projectID()
+	req.ProjectID = projectID
+
+	// a missing quota is handled by the QuotaCheck validator
+	req.waitQuota()
+	quota := req.ProjectQuota
+	if quota == nil || quota.Name != projectID {
+		var looked bool
+		var err error
+		quota, looked, err = req.quotaLookup(projectID)
+		if !looked {
+			quota, err = h.getProjectQuota(ctx, projectID)
+		}
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			req.Log.Errorf("failed to get floatingipprojectquota for project %s: %s", projectID, err)
+			if isTransient(err) {
+				return &TransientError{Err: fmt.Errorf("failed to get floatingipprojectquota for project %s", projectID)}
+			}
+			return fmt.Errorf("failed to get floatingipprojectquota for project %s", projectID)
+		}
+	}
+
+	pools, ok := allowedPools(quota)
+	if !ok || pools[req.PoolName()] {
+		return nil
+	}
+
+	return fmt.Errorf("floatingippool %s is not allowed for project %s, allowed pools: %s", req.PoolName(), projectID, sortedPools(pools))
+}
+
+// QuotaPoolsAllowed warns about pool quotas which have no effect because the
+// pool is not in the allowlist of the project.
+type QuotaPoolsAllowed struct{}
+
+func (v *QuotaPoolsAllowed) Name() string { return "QuotaPoolsAllowed" }
+
+func (v *QuotaPoolsAllowed) Validate(ctx context.Context, h *Handler, req *FloatingIPProjectQuotaRequest) error {
+	if req.IsDelete() {
+		return nil
+	}
+
+	pools, ok := allowedPools(req.Quota)
+	if !ok {
+		return nil
+	}
+	for _, pool := range quotaPools(req.Quota) {
+		if !pools[pool] && pool != validator.WildcardPool {
+			req.Warnings = append(req.Warnings, fmt.Sprintf("quota for floatingippool %s has no effect, the pool is not listed in the %s annotation", pool, AllowedPoolsAnnotation))
+		}
+	}
+
+	return nil
+}
+
+// sortedPools returns the pools as a sorted, comma separated list.
+func sortedPools(pools map[string]bool) string {
+	if len(pools) == 0 {
+		return "none"
+	}
+
+	names := make([]string, 0, len(pools))
+	for pool := range pools {
+		names = append(names, pool)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
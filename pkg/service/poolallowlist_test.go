@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestPoolAllowed(t *testing.T) {
+	quota := func(name string, annotations map[string]string) *rfmv2.FloatingIPProjectQuota {
+		return &rfmv2.FloatingIPProjectQuota{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPProjectQuota"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+			Spec: rfmv2.FloatingIPProjectQuotaSpec{
+				FloatingIPQuota: map[string]int{"public": 5, "private": 5},
+			},
+		}
+	}
+	objects, err := getUnstructuredList([]runtime.Object{
+		quota("p-unrestricted", nil),
+		quota("p-restricted", map[string]string{AllowedPoolsAnnotation: "private, internal"}),
+		quota("p-none", map[string]string{AllowedPoolsAnnotation: ""}),
+	})
+	assert.NoError(t, err)
+	h := &Handler{dynamic: fake.NewSimpleDynamicClient(runtime.NewScheme(), objects...)}
+
+	testCases := []struct {
+		name          string
+		project       string
+		pool          string
+		expectedError string
+	}{
+		{
+			name:    "project without an allowlist",
+			project: "p-unrestricted",
+			pool:    "public",
+		},
+		{
+			name:    "allowed pool",
+			project: "p-restricted",
+			pool:    "private",
+		},
+		{
+			name:          "pool not in the allowlist",
+			project:       "p-restricted",
+			pool:          "public",
+			expectedError: "floatingippool public is not allowed for project p-restricted, allowed pools: internal, private",
+		},
+		{
+			name:          "empty allowlist",
+			project:       "p-none",
+			pool:          "public",
+			expectedError: "floatingippool public is not allowed for project p-none, allowed pools: none",
+		},
+		{
+			name:    "project without a quota",
+			project: "p-unknown",
+			pool:    "public",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP: &rfmv2.FloatingIP{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "fip",
+						Namespace: "default",
+						Labels:    map[string]string{ProjectNameLabel: tc.project},
+					},
+					Spec: rfmv2.FloatingIPSpec{FloatingIPPool: tc.pool},
+				},
+			}
+
+			err := (&PoolAllowed{}).Validate(context.Background(), h, req)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestQuotaPoolsAllowed(t *testing.T) {
+	req := &FloatingIPProjectQuotaRequest{
+		Request: &admissionv1.AdmissionRequest{Operation: admissionv1.Create},
+		Log:     log.NewEntry(log.StandardLogger()),
+		Quota: &rfmv2.FloatingIPProjectQuota{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "p-restricted",
+				Annotations: map[string]string{AllowedPoolsAnnotation: "private"},
+			},
+			Spec: rfmv2.FloatingIPProjectQuotaSpec{
+				FloatingIPQuota: map[string]int{"public": 5, "private": 5},
+			},
+		},
+	}
+
+	assert.NoError(t, (&QuotaPoolsAllowed{}).Validate(context.Background(), &Handler{}, req))
+	assert.Equal(t, []string{
+		"quota for floatingippool public has no effect, the pool is not listed in the rancher.k8s.binbash.org/allowed-pools annotation",
+	}, req.Warnings)
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+const (
+	// RequestedByAnnotation is the FloatingIPPool annotation with the username
+	// of the user who requested a pool which needs approval.
+	RequestedByAnnotation = "rancher.k8s.binbash.org/requested-by"
+	// ApprovedByAnnotation is the FloatingIPPool annotation with the username
+	// of the user who approved a pool which needs approval.
+	ApprovedByAnnotation = "rancher.k8s.binbash.org/approved-by"
+)
+
+// needsApproval returns true if the range of the pool contains more IP
+// addresses than the ApprovalPoolSize option allows without an approval.
+func (h *Handler) needsApproval(pool *rfmv2.FloatingIPPool) bool {
+	if h.options.ApprovalPoolSize <= 0 || pool.Spec.IPConfig == nil {
+		return false
+	}
+
+	size := validator.RangeSize(net.ParseIP(pool.Spec.IPConfig.Pool.Start), net.ParseIP(pool.Spec.IPConfig.Pool.End))
+	return size.Cmp(big.NewInt(h.options.ApprovalPoolSize)) > 0
+}
+
+// PoolApproval enforces the two-person approval of FloatingIPPools whose range
+// exceeds the ApprovalPoolSize option, so an enormous public range is never
+// used by accident. The RequestedByAnnotation must be set to the username of
+// the user who creates the pool. Another user, who is a member of the
+// ApprovalGroups, approves the pool by setting the ApprovedByAnnotation to
+// their username in a follow-up update. A spec change of an approved pool is
+// an approval as well, otherwise the ApprovedByAnnotation has to be removed.
+// Updates which change neither the spec nor the annotations are allowed. It
+// expects the pool range to be validated by PoolRangeValid first.
+type PoolApproval struct{}
+
+func (v *PoolApproval) Name() string { return "PoolApproval" }
+
+func (v *PoolApproval) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	if !h.needsApproval(req.Pool) {
+		return nil
+	}
+
+	user := req.Request.UserInfo.Username
+	requestedBy := req.Pool.Annotations[RequestedByAnnotation]
+	approvedBy := req.Pool.Annotations[ApprovedByAnnotation]
+	var oldRequestedBy, oldApprovedBy string
+	if req.IsUpdate() {
+		oldRequestedBy = req.OldPool.Annotations[RequestedByAnnotation]
+		oldApprovedBy = req.OldPool.Annotations[ApprovedByAnnotation]
+	}
+	specUnchanged := req.IsUpdate() && equality.Semantic.DeepEqual(req.OldPool.Spec, req.Pool.Spec)
+	// status and metadata updates keep working for pools which existed before
+	if specUnchanged && requestedBy == oldRequestedBy && approvedBy == oldApprovedBy {
+		return nil
+	}
+
+	if requestedBy == "" || (requestedBy != oldRequestedBy && requestedBy != user) {
+		return fmt.Errorf("floatingippool %s contains more than %d IP addresses and needs approval, set the %s annotation to your username %s",
+			req.Pool.Name, h.options.ApprovalPoolSize, RequestedByAnnotation, user)
+	}
+
+	if approvedBy == "" {
+		return nil
+	}
+	if !req.IsUpdate() {
+		return fmt.Errorf("floatingippool %s can only be approved by a follow-up update, remove the %s annotation",
+			req.Pool.Name, ApprovedByAnnotation)
+	}
+	if approvedBy == oldApprovedBy && specUnchanged {
+		return nil
+	}
+
+	switch {
+	case approvedBy != user:
+		return fmt.Errorf("the %s annotation of floatingippool %s must be set to the username of the approver, remove it when the pool needs to be approved again",
+			ApprovedByAnnotation, req.Pool.Name)
+	case user == requestedBy:
+		return fmt.Errorf("floatingippool %s must be approved by another user than %s who requested it", req.Pool.Name, requestedBy)
+	case !memberOfGroups(h.options.ApprovalGroups, req.Request.UserInfo.Groups):
+		return fmt.Errorf("floatingippool %s can only be approved by members of the groups: %s",
+			req.Pool.Name, strings.Join(h.options.ApprovalGroups, ", "))
+	}
+
+	req.Log.Infof("floatingippool %s requested by %s is approved by %s", req.Pool.Name, requestedBy, user)
+	return nil
+}
+
+// PoolApproved denies new FloatingIPs in FloatingIPPools which need approval
+// and are not approved yet.
+type PoolApproved struct{}
+
+func (v *PoolApproved) Name() string { return "PoolApproved" }
+
+func (v *PoolApproved) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if req.IsUpdate() || !h.needsApproval(req.Pool) || req.Pool.Annotations[ApprovedByAnnotation] != "" {
+		return nil
+	}
+
+	return fmt.Errorf("floatingippool %s is awaiting approval and doesn't accept new FloatingIPs", req.Pool.Name)
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPoolApproval(t *testing.T) {
+	newPool := func(end string, annotations map[string]string) *rfmv2.FloatingIPPool {
+		return &rfmv2.FloatingIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pool", Annotations: annotations},
+			Spec: rfmv2.FloatingIPPoolSpec{
+				IPConfig: &rfmv2.IPConfig{
+					Subnet: "203.0.113.0/24",
+					Pool:   rfmv2.Pool{Start: "203.0.113.1", End: end},
+				},
+			},
+		}
+	}
+	requested := map[string]string{RequestedByAnnotation: "alice"}
+	approved := map[string]string{RequestedByAnnotation: "alice", ApprovedByAnnotation: "bob"}
+	admins := []string{"system:authenticated", "network-admins"}
+
+	testCases := []struct {
+		name            string
+		user            string
+		groups          []string
+		pool            *rfmv2.FloatingIPPool
+		oldPool         *rfmv2.FloatingIPPool
+		expectedMessage string
+	}{
+		{
+			name: "small pool",
+			user: "alice",
+			pool: newPool("203.0.113.10", nil),
+		},
+		{
+			name:            "large pool without requested-by",
+			user:            "alice",
+			pool:            newPool("203.0.113.200", nil),
+			expectedMessage: "floatingippool test-pool contains more than 100 IP addresses and needs approval, set the rancher.k8s.binbash.org/requested-by annotation to your username alice",
+		},
+		{
+			name:            "large pool requested for another user",
+			user:            "mallory",
+			pool:            newPool("203.0.113.200", requested),
+			expectedMessage: "floatingippool test-pool contains more than 100 IP addresses and needs approval, set the rancher.k8s.binbash.org/requested-by annotation to your username mallory",
+		},
+		{
+			name: "large pool requested",
+			user: "alice",
+			pool: newPool("203.0.113.200", requested),
+		},
+		{
+			name:            "large pool approved on create",
+			user:            "alice",
+			groups:          admins,
+			pool:            newPool("203.0.113.200", map[string]string{RequestedByAnnotation: "alice", ApprovedByAnnotation: "alice"}),
+			expectedMessage: "floatingippool test-pool can only be approved by a follow-up update, remove the rancher.k8s.binbash.org/approved-by annotation",
+		},
+		{
+			name:    "approved by an admin",
+			user:    "bob",
+			groups:  admins,
+			pool:    newPool("203.0.113.200", approved),
+			oldPool: newPool("203.0.113.200", requested),
+		},
+		{
+			name:            "approved by the requester",
+			user:            "alice",
+			groups:          admins,
+			pool:            newPool("203.0.113.200", map[string]string{RequestedByAnnotation: "alice", ApprovedByAnnotation: "alice"}),
+			oldPool:         newPool("203.0.113.200", requested),
+			expectedMessage: "floatingippool test-pool must be approved by another user than alice who requested it",
+		},
+		{
+			name:            "approved in the name of another user",
+			user:            "alice",
+			groups:          admins,
+			pool:            newPool("203.0.113.200", approved),
+			oldPool:         newPool("203.0.113.200", requested),
+			expectedMessage: "the rancher.k8s.binbash.org/approved-by annotation of floatingippool test-pool must be set to the username of the approver, remove it when the pool needs to be approved again",
+		},
+		{
+			name:            "approved by a user who is not an admin",
+			user:            "bob",
+			groups:          []string{"system:authenticated"},
+			pool:            newPool("203.0.113.200", approved),
+			oldPool:         newPool("203.0.113.200", requested),
+			expectedMessage: "floatingippool test-pool can only be approved by members of the groups: network-admins",
+		},
+		{
+			name:            "requester changes the range of an approved pool",
+			user:            "alice",
+			pool:            newPool("203.0.113.250", approved),
+			oldPool:         newPool("203.0.113.200", approved),
+			expectedMessage: "the rancher.k8s.binbash.org/approved-by annotation of floatingippool test-pool must be set to the username of the approver, remove it when the pool needs to be approved again",
+		},
+		{
+			name:    "requester changes the range and removes the approval",
+			user:    "alice",
+			pool:    newPool("203.0.113.250", requested),
+			oldPool: newPool("203.0.113.200", approved),
+		},
+		{
+			name:    "approver changes the range of an approved pool",
+			user:    "bob",
+			groups:  admins,
+			pool:    newPool("203.0.113.250", approved),
+			oldPool: newPool("203.0.113.200", approved),
+		},
+		{
+			name:    "status update of a large pool which existed before",
+			user:    DefaultControllerUser,
+			pool:    newPool("203.0.113.200", nil),
+			oldPool: newPool("203.0.113.200", nil),
+		},
+		{
+			name:            "small pool grows into a large pool",
+			user:            "alice",
+			pool:            newPool("203.0.113.200", nil),
+			oldPool:         newPool("203.0.113.10", nil),
+			expectedMessage: "floatingippool test-pool contains more than 100 IP addresses and needs approval, set the rancher.k8s.binbash.org/requested-by annotation to your username alice",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{options: Options{ApprovalPoolSize: 100, ApprovalGroups: []string{"network-admins"}}}
+			operation := admissionv1.Create
+			if tc.oldPool != nil {
+				operation = admissionv1.Update
+			}
+			req := &FloatingIPPoolRequest{
+				Request: &admissionv1.AdmissionRequest{
+					Operation: operation,
+					UserInfo:  authenticationv1.UserInfo{Username: tc.user, Groups: tc.groups},
+				},
+				Log:     log.NewEntry(log.StandardLogger()),
+				Pool:    tc.pool,
+				OldPool: tc.oldPool,
+			}
+
+			err := (&PoolApproval{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPoolApproved(t *testing.T) {
+	h := &Handler{options: Options{ApprovalPoolSize: 100}}
+	pool := &rfmv2.FloatingIPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pool", Annotations: map[string]string{RequestedByAnnotation: "alice"}},
+		Spec: rfmv2.FloatingIPPoolSpec{
+			IPConfig: &rfmv2.IPConfig{
+				Subnet: "203.0.113.0/24",
+				Pool:   rfmv2.Pool{Start: "203.0.113.1", End: "203.0.113.200"},
+			},
+		},
+	}
+	fip := &rfmv2.FloatingIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default"},
+		Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool"},
+	}
+	req := &FloatingIPRequest{
+		Request: &admissionv1.AdmissionRequest{},
+		Log:     log.NewEntry(log.StandardLogger()),
+		FIP:     fip,
+		Pool:    pool,
+	}
+
+	assert.EqualError(t, (&PoolApproved{}).Validate(context.Background(), h, req),
+		"floatingippool test-pool is awaiting approval and doesn't accept new FloatingIPs")
+
+	// existing FloatingIPs are not affected
+	req.OldFIP = fip
+	assert.NoError(t, (&PoolApproved{}).Validate(context.Background(), h, req))
+	req.OldFIP = nil
+
+	pool.Annotations[ApprovedByAnnotation] = "bob"
+	assert.NoError(t, (&PoolApproved{}).Validate(context.Background(), h, req))
+
+	// pools don't need approval when the option is not set
+	delete(pool.Annotations, ApprovedByAnnotation)
+	assert.NoError(t, (&PoolApproved{}).Validate(context.Background(), &Handler{}, req))
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// indexedPool holds the allocations of the blocks of a FloatingIPPool in the
+// version which was seen last.
+type indexedPool struct {
+	resourceVersion string
+	blocks          []*validator.PoolAllocations
+}
+
+// block returns the allocations of the block whose range contains the IP, or
+// nil when the pool is not indexed.
+func (p *indexedPool) block(ip net.IP) *validator.PoolAllocations {
+	if p == nil {
+		return nil
+	}
+	for _, block := range p.blocks {
+		if block.Contains(ip) {
+			return block
+		}
+	}
+
+	return nil
+}
+
+// Free returns the number of free IPs in the ranges of the pool.
+func (p *indexedPool) Free() int {
+	free := 0
+	for _, block := range p.blocks {
+		free += block.Free()
+	}
+
+	return free
+}
+
+// NextFree returns the lowest free IP of the first block which has one, or
+// nil when the pool is full or not indexed.
+func (p *indexedPool) NextFree() net.IP {
+	if p == nil {
+		return nil
+	}
+	for _, block := range p.blocks {
+		if ip := block.NextFree(); ip != nil {
+			return ip
+		}
+	}
+
+	return nil
+}
+
+// poolIndex holds the allocation bitmaps of the FloatingIPPools, which are
+// rebuilt from the watch events of the pools. Pools with a range which is
+// larger than the validator.MaxIndexedPoolSize are not indexed. A nil index
+// doesn't index any pool.
+type poolIndex struct {
+	mu    sync.RWMutex
+	pools map[string]*indexedPool
+
+	// objects holds the last seen version of every pool, including the
+	// pools which are not indexed. It is complete once synced is set.
+	objects map[string]*rfmv2.FloatingIPPool
+	synced  bool
+}
+
+func newPoolIndex(enabled bool) *poolIndex {
+	if !enabled {
+		return nil
+	}
+
+	return &poolIndex{
+		pools:   make(map[string]*indexedPool),
+		objects: make(map[string]*rfmv2.FloatingIPPool),
+	}
+}
+
+// set rebuilds the allocations of the pool.
+func (i *poolIndex) set(pool *rfmv2.FloatingIPPool) {
+	indexed := &indexedPool{resourceVersion: pool.ResourceVersion}
+	for _, block := range poolBlocks(pool) {
+		allocations, err := validator.NewPoolAllocations(block, pool.Status.Allocated)
+		if err != nil {
+			log.Debugf("(poolIndex) not indexing floatingippool %s: %s", pool.Name, err)
+			indexed = nil
+			break
+		}
+		indexed.blocks = append(indexed.blocks, allocations)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.objects[pool.Name] = pool
+	if indexed == nil || len(indexed.blocks) == 0 {
+		delete(i.pools, pool.Name)
+		return
+	}
+	i.pools[pool.Name] = indexed
+}
+
+// remove removes the allocations of the pool.
+func (i *poolIndex) remove(name string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.pools, name)
+	delete(i.objects, name)
+}
+
+// markSynced marks the pool set as complete after the initial list.
+func (i *poolIndex) markSynced() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.synced = true
+}
+
+// list returns the cached pools sorted by name. It returns false when the
+// index is disabled or the initial list is not done yet.
+func (i *poolIndex) list() ([]*rfmv2.FloatingIPPool, bool) {
+	if i == nil {
+		return nil, false
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if !i.synced {
+		return nil, false
+	}
+	pools := make([]*rfmv2.FloatingIPPool, 0, len(i.objects))
+	for _, pool := range i.objects {
+		pools = append(pools, pool)
+	}
+	sort.Slice(pools, func(a, b int) bool { return pools[a].Name < pools[b].Name })
+
+	return pools, true
+}
+
+// lookup returns the allocations of the pool when they were built from the
+// same version of the pool, so the index never disagrees with the pool which
+// the validators got.
+func (i *poolIndex) lookup(pool *rfmv2.FloatingIPPool) *indexedPool {
+	if i == nil || pool == nil {
+		return nil
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	indexed, ok := i.pools[pool.Name]
+	if !ok || indexed.resourceVersion != pool.ResourceVersion {
+		return nil
+	}
+
+	return indexed
+}
+
+// watchPools keeps the pool index up to date with the watch events of the
+// FloatingIPPools until the context is done.
+func (h *Handler) watchPools(ctx context.Context) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(h.dynamic, 0)
+	informer := factory.ForResource(h.apiResources().FloatingIPPoolGVR()).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    h.indexPool,
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) { h.indexPool(newObj) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if u, ok := obj.(*unstructured.Unstructured); ok {
+				h.pools.remove(u.GetName())
+			}
+		},
+	})
+	if err != nil {
+		log.Errorf("(watchPools) cannot watch the floatingippools: %s", err)
+		return
+	}
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return
+	}
+	h.pools.markSynced()
+	log.Infof("(watchPools) indexed the allocations of the floatingippools")
+}
+
+// indexPool rebuilds the allocations of a pool of a watch event.
+func (h *Handler) indexPool(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	var pool rfmv2.FloatingIPPool
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &pool); err != nil {
+		log.Errorf("(indexPool) failed to convert floatingippool %s: %s", u.GetName(), err)
+		h.pools.remove(u.GetName())
+		return
+	}
+	h.pools.set(&pool)
+}
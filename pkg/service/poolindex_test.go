@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestPoolIndex(t *testing.T) {
+	pool := &rfmv2.FloatingIPPool{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPPool"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pool", ResourceVersion: "1"},
+		Spec: rfmv2.FloatingIPPoolSpec{
+			IPConfig: &rfmv2.IPConfig{
+				Subnet: "192.168.1.0/24",
+				Pool:   rfmv2.Pool{Start: "192.168.1.10", End: "192.168.1.12", Exclude: []string{"192.168.1.10"}},
+			},
+		},
+		Status: rfmv2.FloatingIPPoolStatus{Allocated: map[string]string{"192.168.1.11": "default/fip-1"}},
+	}
+	objects, err := getUnstructuredList([]runtime.Object{pool})
+	assert.NoError(t, err)
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingippools"}: "FloatingIPPoolList",
+	}, objects...)
+	h := &Handler{dynamic: dynamicClient, pools: newPoolIndex(true)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.watchPools(ctx)
+	assert.Eventually(t, func() bool { return h.pools.lookup(pool) != nil }, 5*time.Second, 10*time.Millisecond)
+
+	indexed := h.pools.lookup(pool)
+	assert.Equal(t, 1, indexed.Free())
+	assert.Equal(t, "192.168.1.12", indexed.NextFree().String())
+	// another version of the pool is not answered by the index
+	stale := pool.DeepCopy()
+	stale.ResourceVersion = "2"
+	assert.Nil(t, h.pools.lookup(stale))
+
+	excludedIP, freeIP, allocatedIP := "::ffff:192.168.1.10", "192.168.1.12", "192.168.1.11"
+	req := &FloatingIPRequest{
+		Request: &admissionv1.AdmissionRequest{Operation: admissionv1.Create},
+		Log:     log.NewEntry(log.StandardLogger()),
+		FIP:     &rfmv2.FloatingIP{Spec: rfmv2.FloatingIPSpec{IPAddr: &excludedIP}},
+		Pool:    pool,
+	}
+	assert.EqualError(t, (&NotExcluded{}).Validate(ctx, h, req), "requested IP ::ffff:192.168.1.10 is in the exclude list")
+	req.FIP.Spec.IPAddr = nil
+	assert.NoError(t, (&PoolHasCapacity{}).Validate(ctx, h, req))
+	assert.True(t, h.poolHasCapacity(pool, &freeIP))
+	assert.False(t, h.poolHasCapacity(pool, &allocatedIP))
+
+	// the allocations follow the watch events
+	full := pool.DeepCopy()
+	full.ResourceVersion = "3"
+	full.Status.Allocated["192.168.1.12"] = "default/fip-2"
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(full)
+	assert.NoError(t, err)
+	_, err = dynamicClient.Resource(h.apiResources().FloatingIPPoolGVR()).Update(ctx, &unstructured.Unstructured{Object: u}, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return h.pools.lookup(full) != nil }, 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, h.pools.lookup(full).NextFree())
+	req.Pool = full
+	assert.EqualError(t, (&PoolHasCapacity{}).Validate(ctx, h, req), "no available IPs in floatingippool test-pool")
+
+	assert.NoError(t, dynamicClient.Resource(h.apiResources().FloatingIPPoolGVR()).Delete(ctx, "test-pool", metav1.DeleteOptions{}))
+	assert.Eventually(t, func() bool { return h.pools.lookup(full) == nil }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPoolIndexDisabled(t *testing.T) {
+	var index *poolIndex
+	assert.Nil(t, newPoolIndex(false))
+	assert.Nil(t, index.lookup(&rfmv2.FloatingIPPool{}))
+	assert.Nil(t, index.lookup(&rfmv2.FloatingIPPool{}).block(nil))
+	assert.Nil(t, index.lookup(&rfmv2.FloatingIPPool{}).NextFree())
+}
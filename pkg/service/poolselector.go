@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// PoolSelectorAnnotation is the annotation which selects the FloatingIPPool
+// of a FloatingIP by a label selector, for example "zone=a,tier!=internal".
+const PoolSelectorAnnotation = "rancher.k8s.binbash.org/pool-selector"
+
+// PoolSelector resolves the pool selector annotation of a FloatingIP. When
+// spec.floatingIPPool is empty exactly one matching pool must have free
+// capacity, it is stored in the request. An existing FloatingIP keeps the
+// pool which allocated its IP. When spec.floatingIPPool is set it must be one
+// of the matching pools.
+type PoolSelector struct{}
+
+func (v *PoolSelector) Name() string { return "PoolSelector" }
+
+func (v *PoolSelector) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	selectorStr, ok := req.FIP.Annotations[PoolSelectorAnnotation]
+	if !ok {
+		return nil
+	}
+
+	selector, err := labels.Parse(selectorStr)
+	if err != nil {
+		return fmt.Errorf("invalid pool selector %q: %s", selectorStr, err)
+	}
+
+	pools, err := h.selectPools(ctx, selector)
+	if err != nil {
+		req.Log.Errorf("failed to list floatingippools with selector %s: %s", selectorStr, err)
+		return fmt.Errorf("internal server error: failed to list floatingippools")
+	}
+	if len(pools) == 0 {
+		return fmt.Errorf("no floatingippool matches the pool selector %q", selectorStr)
+	}
+
+	if req.FIP.Spec.FloatingIPPool != "" {
+		for _, pool := range pools {
+			if pool.Name == req.FIP.Spec.FloatingIPPool {
+				return nil
+			}
+		}
+		return fmt.Errorf("floatingippool %s does not match the pool selector %q, matching pools: %s",
+			req.FIP.Spec.FloatingIPPool, selectorStr, poolNames(pools))
+	}
+
+	// an existing FloatingIP keeps the pool which allocated its IP
+	if req.IsUpdate() && req.OldFIP.Status.IPAddr != "" {
+		owner := req.FIP.Namespace + "/" + req.FIP.Name
+		for _, pool := range pools {
+			if pool.Status.Allocated[req.OldFIP.Status.IPAddr] == owner {
+				req.Pool = pool
+				return nil
+			}
+		}
+	}
+
+	var candidates []*rfmv2.FloatingIPPool
+	for _, pool := range pools {
+		if pool.DeletionTimestamp == nil && h.poolHasCapacity(pool, req.FIP.Spec.IPAddr) {
+			candidates = append(candidates, pool)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return fmt.Errorf("none of the floatingippools matching the pool selector %q has free capacity: %s", selectorStr, poolNames(pools))
+	case 1:
+		req.Pool = candidates[0]
+		return nil
+	}
+
+	return fmt.Errorf("the pool selector %q is ambiguous, it matches multiple floatingippools with free capacity: %s",
+		selectorStr, poolNames(candidates))
+}
+
+// selectPools returns the FloatingIPPools which match the selector, sorted by name.
+func (h *Handler) selectPools(ctx context.Context, selector labels.Selector) ([]*rfmv2.FloatingIPPool, error) {
+	list, err := h.dynamic.Resource(h.apiResources().FloatingIPPoolGVR()).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	pools := make([]*rfmv2.FloatingIPPool, 0, len(list.Items))
+	for _, item := range list.Items {
+		var pool rfmv2.FloatingIPPool
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &pool); err != nil {
+			return nil, err
+		}
+		pools = append(pools, &pool)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+
+	return pools, nil
+}
+
+// poolHasCapacity returns true if the pool can allocate the requested IP, or
+// any IP when no IP is requested. The pool index is used when it has the
+// version of the pool.
+func (h *Handler) poolHasCapacity(pool *rfmv2.FloatingIPPool, ipAddr *string) bool {
+	indexed := h.pools.lookup(pool)
+	if ipAddr == nil {
+		if indexed != nil {
+			return indexed.Free() > 0
+		}
+		return pool.Status.Available > 0
+	}
+	if block := indexed.block(net.ParseIP(*ipAddr)); block != nil {
+		return block.IsFree(net.ParseIP(*ipAddr))
+	}
+
+	ipConfig := pool.Spec.IPConfig
+	if ipConfig == nil {
+		return false
+	}
+	requestedIP := net.ParseIP(*ipAddr)
+	startIP := net.ParseIP(ipConfig.Pool.Start)
+	endIP := net.ParseIP(ipConfig.Pool.End)
+	if requestedIP == nil || startIP == nil || endIP == nil || !validator.InRange(requestedIP, startIP, endIP) {
+		return false
+	}
+	if validator.IsExcluded(*ipAddr, ipConfig.Pool.Exclude) {
+		return false
+	}
+	_, allocated := pool.Status.Allocated[*ipAddr]
+
+	return !allocated
+}
+
+func poolNames(pools []*rfmv2.FloatingIPPool) string {
+	names := make([]string, 0, len(pools))
+	for _, pool := range pools {
+		names = append(names, pool.Name)
+	}
+
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestPoolSelector(t *testing.T) {
+	pool := func(name string, zone string, available int, allocated map[string]string) *rfmv2.FloatingIPPool {
+		return &rfmv2.FloatingIPPool{
+			TypeMeta: metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPPool"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{"zone": zone},
+			},
+			Spec: rfmv2.FloatingIPPoolSpec{
+				IPConfig: &rfmv2.IPConfig{
+					Subnet: "192.168.1.0/24",
+					Pool:   rfmv2.Pool{Start: "192.168.1.10", End: "192.168.1.20"},
+				},
+			},
+			Status: rfmv2.FloatingIPPoolStatus{Available: available, Allocated: allocated},
+		}
+	}
+	terminating := pool("pool-d", "c", 5, nil)
+	terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	terminating.Finalizers = []string{"rancher.k8s.binbash.org/finalizer"}
+
+	objects, err := getUnstructuredList([]runtime.Object{
+		pool("pool-a", "a", 0, map[string]string{"192.168.1.10": "default/test-fip"}),
+		pool("pool-b", "b", 5, nil),
+		pool("pool-c", "b", 5, map[string]string{"192.168.1.11": "default/another-fip"}),
+		terminating,
+		pool("pool-e", "e", 5, nil),
+	})
+	assert.NoError(t, err)
+	h := &Handler{
+		dynamic: fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingippools"}: "FloatingIPPoolList",
+		}, objects...),
+	}
+
+	ipAddr := "192.168.1.11"
+	testCases := []struct {
+		name          string
+		selector      string
+		poolName      string
+		ipAddr        *string
+		oldIPAddr     string
+		expectedPool  string
+		expectedError string
+	}{
+		{
+			name:          "invalid selector",
+			selector:      "zone in (a",
+			expectedError: `invalid pool selector "zone in (a": unable to parse requirement: found '', expected: ',' or ')'`,
+		},
+		{
+			name:          "no matching pool",
+			selector:      "zone=unknown",
+			expectedError: `no floatingippool matches the pool selector "zone=unknown"`,
+		},
+		{
+			name:          "no pool with capacity",
+			selector:      "zone in (a,c)",
+			expectedError: `none of the floatingippools matching the pool selector "zone in (a,c)" has free capacity: pool-a, pool-d`,
+		},
+		{
+			name:          "ambiguous selector",
+			selector:      "zone=b",
+			expectedError: `the pool selector "zone=b" is ambiguous, it matches multiple floatingippools with free capacity: pool-b, pool-c`,
+		},
+		{
+			name:         "requested IP resolves the pool",
+			selector:     "zone=b",
+			ipAddr:       &ipAddr,
+			expectedPool: "pool-b",
+		},
+		{
+			name:         "single pool with capacity",
+			selector:     "zone in (a,d,e)",
+			expectedPool: "pool-e",
+		},
+		{
+			name:         "update keeps the allocating pool",
+			selector:     "zone in (a,e)",
+			oldIPAddr:    "192.168.1.10",
+			expectedPool: "pool-a",
+		},
+		{
+			name:     "specified pool matches",
+			selector: "zone=b",
+			poolName: "pool-c",
+		},
+		{
+			name:          "specified pool does not match",
+			selector:      "zone=b",
+			poolName:      "pool-a",
+			expectedError: `floatingippool pool-a does not match the pool selector "zone=b", matching pools: pool-b, pool-c`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP: &rfmv2.FloatingIP{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "test-fip",
+						Namespace:   "default",
+						Annotations: map[string]string{PoolSelectorAnnotation: tc.selector},
+					},
+					Spec: rfmv2.FloatingIPSpec{FloatingIPPool: tc.poolName, IPAddr: tc.ipAddr},
+				},
+			}
+			if tc.oldIPAddr != "" {
+				req.OldFIP = req.FIP.DeepCopy()
+				req.OldFIP.Status.IPAddr = tc.oldIPAddr
+			}
+
+			err := (&PoolSelector{}).Validate(context.Background(), h, req)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			if tc.expectedPool != "" {
+				assert.Equal(t, tc.expectedPool, req.Pool.Name)
+				assert.Equal(t, tc.expectedPool, req.PoolName())
+			} else {
+				assert.Nil(t, req.Pool)
+				assert.Equal(t, tc.poolName, req.PoolName())
+			}
+		})
+	}
+}
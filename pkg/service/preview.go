@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	log "github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// QuotaPreview is the usage of the FloatingIPProjectQuota of a project, which
+// is served on /preview/quota/{project}.
+type QuotaPreview struct {
+	Project string             `json:"project"`
+	Pools   []QuotaPoolPreview `json:"pools"`
+}
+
+// QuotaPoolPreview is the usage of the quota of a project for a pool. A new
+// FloatingIP in the pool passes the quota check when Free is above 0.
+type QuotaPoolPreview struct {
+	Pool  string `json:"pool"`
+	Quota int    `json:"quota"`
+	Used  int    `json:"used"`
+	// Free is the number of FloatingIPs which can still be created, it is
+	// also limited by the available IPs of the pool.
+	Free int `json:"free"`
+}
+
+// PoolPreview is the usage of a FloatingIPPool, which is served on
+// /preview/pool/{pool}.
+type PoolPreview struct {
+	Pool        string `json:"pool"`
+	Capacity    int    `json:"capacity"`
+	Used        int    `json:"used"`
+	Available   int    `json:"available"`
+	Utilization int    `json:"utilization"`
+	// NextFreeIP is the lowest free IP of the pool, it is only set when the
+	// pool is indexed.
+	NextFreeIP string `json:"nextFreeIP,omitempty"`
+}
+
+// PreviewQuota returns the usage of the FloatingIPProjectQuota of the project,
+// computed like the QuotaCheck validator.
+func (h *Handler) PreviewQuota(ctx context.Context, project string) (*QuotaPreview, error) {
+	quota, err := h.getProjectQuota(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &QuotaPreview{Project: project, Pools: []QuotaPoolPreview{}}
+	for _, pool := range quotaPools(quota) {
+		limit, used, _ := validator.QuotaUsage(quota, pool)
+		free := max(limit-used, 0)
+		if pool != validator.WildcardPool {
+			fipPool, err := h.getFloatingIPPool(ctx, pool)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return nil, err
+			}
+			if fipPool != nil {
+				free = min(free, fipPool.Status.Available)
+			} else {
+				free = 0
+			}
+		}
+		preview.Pools = append(preview.Pools, QuotaPoolPreview{Pool: pool, Quota: limit, Used: used, Free: free})
+	}
+
+	return preview, nil
+}
+
+// PreviewPool returns the usage of the FloatingIPPool, computed like the
+// PoolUtilization validator.
+func (h *Handler) PreviewPool(ctx context.Context, pool string) (*PoolPreview, error) {
+	fipPool, err := h.getFloatingIPPool(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity, used := poolUsage(fipPool)
+	preview := &PoolPreview{
+		Pool:      pool,
+		Capacity:  capacity,
+		Used:      used,
+		Available: fipPool.Status.Available,
+	}
+	if capacity > 0 {
+		preview.Utilization = int(int64(used) * 100 / int64(capacity))
+	}
+	if ip := h.pools.lookup(fipPool).NextFree(); ip != nil {
+		preview.NextFreeIP = ip.String()
+	}
+
+	return preview, nil
+}
+
+// authorizePreview authenticates the bearer token of a preview request and
+// checks with a SubjectAccessReview that the user may get the object, so the
+// preview doesn't reveal more than the Kubernetes API. It returns the HTTP
+// status and an error when the caller is rejected.
+func (h *Handler) authorizePreview(ctx context.Context, r *http.Request, resource string, name string) (int, error) {
+	user, status, err := h.reviewToken(ctx, r)
+	if err != nil {
+		return status, err
+	}
+
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	review, err := h.clientset.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb:     "get",
+				Group:    h.apiResources().Group,
+				Resource: resource,
+				Name:     name,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to review the access of user %s: %s", user.Username, err)
+	}
+	if !review.Status.Allowed {
+		return http.StatusForbidden, fmt.Errorf("user %s cannot get %s %s", user.Username, resource, name)
+	}
+
+	return http.StatusOK, nil
+}
+
+// previewQuota serves the QuotaPreview of the project in the path.
+func (h *Handler) previewQuota(w http.ResponseWriter, r *http.Request) {
+	project := r.PathValue("project")
+	h.servePreview(w, r, h.apiResources().FloatingIPProjectQuotas, project, func(ctx context.Context) (any, error) {
+		return h.PreviewQuota(ctx, project)
+	})
+}
+
+// previewPool serves the PoolPreview of the pool in the path.
+func (h *Handler) previewPool(w http.ResponseWriter, r *http.Request) {
+	pool := r.PathValue("pool")
+	h.servePreview(w, r, h.apiResources().FloatingIPPools, pool, func(ctx context.Context) (any, error) {
+		return h.PreviewPool(ctx, pool)
+	})
+}
+
+// servePreview authorizes the request and writes the preview as JSON.
+func (h *Handler) servePreview(w http.ResponseWriter, r *http.Request, resource string, name string, preview func(ctx context.Context) (any, error)) {
+	if status, err := h.authorizePreview(r.Context(), r, resource, name); err != nil {
+		log.Warnf("(servePreview) rejected request from %s: %s", r.RemoteAddr, err)
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+
+	result, err := preview(r.Context())
+	if apierrors.IsNotFound(err) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "%s %s not found", resource, name)
+		return
+	}
+	if err != nil {
+		log.Errorf("(servePreview) cannot preview %s %s: %s", resource, name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "failed to preview %s %s", resource, name)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Errorf("(servePreview) cannot encode the preview of %s %s: %s", resource, name, err)
+	}
+}
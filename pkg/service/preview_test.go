@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func previewHandler(t *testing.T) *Handler {
+	pool := func(name string, available int) *rfmv2.FloatingIPPool {
+		return &rfmv2.FloatingIPPool{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPPool"},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: rfmv2.FloatingIPPoolSpec{
+				IPConfig: &rfmv2.IPConfig{
+					Subnet: "10.0.0.0/24",
+					Pool:   rfmv2.Pool{Start: "10.0.0.1", End: "10.0.0.100"},
+				},
+			},
+			Status: rfmv2.FloatingIPPoolStatus{Available: available},
+		}
+	}
+	quota := &rfmv2.FloatingIPProjectQuota{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPProjectQuota"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test-project"},
+		Spec: rfmv2.FloatingIPProjectQuotaSpec{
+			FloatingIPQuota: map[string]int{"test-pool": 5, "full-pool": 5, "unknown-pool": 5, "*": 2},
+		},
+		Status: rfmv2.FloatingIPProjectQuotaStatus{
+			FloatingIPs: map[string]*rfmv2.FipInfo{
+				"test-pool": {Used: 2},
+				"full-pool": {Used: 1},
+			},
+		},
+	}
+	objects, err := getUnstructuredList([]runtime.Object{pool("test-pool", 75), pool("full-pool", 1), quota})
+	assert.NoError(t, err)
+
+	clientset := kubefake.NewSimpleClientset()
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "user-token" {
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: "jane", Groups: []string{"team-a"}},
+			}
+		}
+		return true, review, nil
+	})
+	clientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = review.Spec.User == "jane" && attributes.Verb == "get" && attributes.Name != "other-project"
+		return true, review, nil
+	})
+
+	return &Handler{
+		clientset: clientset,
+		dynamic:   fake.NewSimpleDynamicClient(runtime.NewScheme(), objects...),
+		tokens:    newTokenCache(authenticationCacheTTL),
+	}
+}
+
+func TestPreviewQuota(t *testing.T) {
+	h := previewHandler(t)
+
+	preview, err := h.PreviewQuota(context.Background(), "test-project")
+	assert.NoError(t, err)
+	assert.Equal(t, &QuotaPreview{
+		Project: "test-project",
+		Pools: []QuotaPoolPreview{
+			{Pool: "*", Quota: 2, Used: 0, Free: 2},
+			{Pool: "full-pool", Quota: 5, Used: 1, Free: 1},
+			{Pool: "test-pool", Quota: 5, Used: 2, Free: 3},
+			{Pool: "unknown-pool", Quota: 5, Used: 0, Free: 0},
+		},
+	}, preview)
+}
+
+func TestPreviewPool(t *testing.T) {
+	h := previewHandler(t)
+
+	preview, err := h.PreviewPool(context.Background(), "test-pool")
+	assert.NoError(t, err)
+	assert.Equal(t, &PoolPreview{Pool: "test-pool", Capacity: 100, Used: 25, Available: 75, Utilization: 25}, preview)
+}
+
+func TestServePreview(t *testing.T) {
+	testCases := []struct {
+		name           string
+		path           string
+		authorization  string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "quota",
+			path:           "/preview/quota/test-project",
+			authorization:  "Bearer user-token",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"project":"test-project","pools":[{"pool":"*","quota":2,"used":0,"free":2},{"pool":"full-pool","quota":5,"used":1,"free":1},{"pool":"test-pool","quota":5,"used":2,"free":3},{"pool":"unknown-pool","quota":5,"used":0,"free":0}]}` + "\n",
+		},
+		{
+			name:           "pool",
+			path:           "/preview/pool/test-pool",
+			authorization:  "Bearer user-token",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"pool":"test-pool","capacity":100,"used":25,"available":75,"utilization":25}` + "\n",
+		},
+		{
+			name:           "unknown pool",
+			path:           "/preview/pool/unknown-pool",
+			authorization:  "Bearer user-token",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "floatingippools unknown-pool not found",
+		},
+		{
+			name:           "missing token",
+			path:           "/preview/pool/test-pool",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   "no bearer token",
+		},
+		{
+			name:           "invalid token",
+			path:           "/preview/pool/test-pool",
+			authorization:  "Bearer other-token",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   "token is not authenticated: ",
+		},
+		{
+			name:           "not authorized",
+			path:           "/preview/quota/other-project",
+			authorization:  "Bearer user-token",
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "user jane cannot get floatingipprojectquotas other-project",
+		},
+	}
+
+	h := previewHandler(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /preview/quota/{project}", h.previewQuota)
+	mux.HandleFunc("GET /preview/pool/{pool}", h.previewPool)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			assert.Equal(t, tc.expectedBody, w.Body.String())
+			if tc.expectedStatus == http.StatusOK {
+				assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+				assert.True(t, json.Valid(w.Body.Bytes()))
+			}
+		})
+	}
+}
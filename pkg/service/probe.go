@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// ProbeModeWarn allows FloatingIPs whose requested IP responds on the
+	// network with a warning.
+	ProbeModeWarn = "warn"
+	// ProbeModeDeny denies FloatingIPs whose requested IP responds on the
+	// network.
+	ProbeModeDeny = "deny"
+
+	// DefaultProbeTimeout is the time a probe waits for a response.
+	DefaultProbeTimeout = time.Second
+)
+
+// Prober checks if an IP address is already in use on the network.
+type Prober interface {
+	// Probe returns true if the IP address responds.
+	Probe(ctx context.Context, ip net.IP) (bool, error)
+}
+
+// HTTPProber asks a prober service if the IP address responds, like a
+// DaemonSet on the host network of the nodes which sends ARP or ICMP
+// requests. It sends GET <URL>?ip=<address> and expects a JSON response with
+// an "alive" field.
+type HTTPProber struct {
+	URL    string
+	Client *http.Client
+}
+
+func (p *HTTPProber) Probe(ctx context.Context, ip net.IP) (bool, error) {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return false, fmt.Errorf("invalid prober URL %s: %v", p.URL, err)
+	}
+	query := u.Query()
+	query.Set("ip", ip.String())
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("prober returned status %d", resp.StatusCode)
+	}
+	var result struct {
+		Alive bool `json:"alive"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("cannot decode the prober response: %v", err)
+	}
+
+	return result.Alive, nil
+}
+
+// ICMPProber sends an ICMP echo request from the webhook itself. It uses an
+// unprivileged ICMP socket, the group of the webhook must be allowed by the
+// net.ipv4.ping_group_range sysctl. The webhook only reaches the addresses
+// which are routed from its pod, use a HTTPProber on the host network of the
+// nodes otherwise.
+type ICMPProber struct{}
+
+func (p *ICMPProber) Probe(ctx context.Context, ip net.IP) (bool, error) {
+	network, address, protocol := "udp4", "0.0.0.0", 1
+	var request icmp.Type = ipv4.ICMPTypeEcho
+	var reply icmp.Type = ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		network, address, protocol = "udp6", "::", 58
+		request, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return false, fmt.Errorf("cannot open ICMP socket: %v", err)
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultProbeTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return false, err
+	}
+
+	msg := icmp.Message{
+		Type: request,
+		Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: 1, Data: []byte("rancher-fip-manager-webhook")},
+	}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return false, err
+	}
+	if _, err := conn.WriteTo(data, &net.UDPAddr{IP: ip}); err != nil {
+		return false, fmt.Errorf("cannot send ICMP echo request: %v", err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return false, nil
+			}
+			return false, err
+		}
+		if udpAddr, ok := peer.(*net.UDPAddr); !ok || !udpAddr.IP.Equal(ip) {
+			continue
+		}
+		response, err := icmp.ParseMessage(protocol, buf[:n])
+		if err == nil && response.Type == reply {
+			return true, nil
+		}
+	}
+}
+
+// IPNotLive probes the requested IP with the Prober option and denies the
+// FloatingIP, or allows it with a warning in ProbeModeWarn, when the address
+// already responds on the network. A failed probe is allowed with a warning.
+// Updates which keep the IP are not probed. It runs last, so only requests
+// which pass all other checks are probed.
+type IPNotLive struct{}
+
+func (v *IPNotLive) Name() string { return "IPNotLive" }
+
+func (v *IPNotLive) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if h.options.Prober == nil || req.FIP.Spec.IPAddr == nil || req.IPUnchanged() {
+		return nil
+	}
+
+	ip := net.ParseIP(*req.FIP.Spec.IPAddr)
+	timeout := h.options.ProbeTimeout
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	alive, err := h.options.Prober.Probe(probeCtx, ip)
+	if err != nil {
+		req.Log.Warnf("failed to probe requested IP %s: %s", ip, err)
+		req.Warnings = append(req.Warnings, fmt.Sprintf("requested IP %s could not be probed", ip))
+		return nil
+	}
+	if !alive {
+		return nil
+	}
+
+	if h.options.ProbeMode == ProbeModeWarn {
+		req.Warnings = append(req.Warnings, fmt.Sprintf("requested IP %s already responds on the network", ip))
+		return nil
+	}
+
+	return fmt.Errorf("requested IP %s already responds on the network", ip)
+}
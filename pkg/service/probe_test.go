@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeProber reports the addresses in alive as responding.
+type fakeProber struct {
+	alive  map[string]bool
+	err    error
+	probed []string
+}
+
+func (p *fakeProber) Probe(ctx context.Context, ip net.IP) (bool, error) {
+	p.probed = append(p.probed, ip.String())
+	return p.alive[ip.String()], p.err
+}
+
+func TestHTTPProber(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("ip") {
+		case "10.0.0.10":
+			fmt.Fprint(w, `{"alive": true}`)
+		case "10.0.0.11":
+			fmt.Fprint(w, `{"alive": false}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	prober := &HTTPProber{URL: server.URL + "/probe"}
+
+	alive, err := prober.Probe(context.Background(), net.ParseIP("10.0.0.10"))
+	assert.NoError(t, err)
+	assert.True(t, alive)
+
+	alive, err = prober.Probe(context.Background(), net.ParseIP("10.0.0.11"))
+	assert.NoError(t, err)
+	assert.False(t, alive)
+
+	_, err = prober.Probe(context.Background(), net.ParseIP("10.0.0.12"))
+	assert.EqualError(t, err, "prober returned status 400")
+}
+
+func TestIPNotLive(t *testing.T) {
+	fip := func(ip string) *rfmv2.FloatingIP {
+		return &rfmv2.FloatingIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default"},
+			Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool", IPAddr: &ip},
+			Status:     rfmv2.FloatingIPStatus{IPAddr: ip},
+		}
+	}
+
+	testCases := []struct {
+		name             string
+		mode             string
+		probeErr         error
+		fip              *rfmv2.FloatingIP
+		oldFIP           *rfmv2.FloatingIP
+		expectedMessage  string
+		expectedWarnings []string
+		expectedProbed   []string
+	}{
+		{
+			name:           "free IP",
+			fip:            fip("10.0.0.11"),
+			expectedProbed: []string{"10.0.0.11"},
+		},
+		{
+			name:            "live IP",
+			fip:             fip("10.0.0.10"),
+			expectedMessage: "requested IP 10.0.0.10 already responds on the network",
+			expectedProbed:  []string{"10.0.0.10"},
+		},
+		{
+			name:             "live IP in warn mode",
+			mode:             ProbeModeWarn,
+			fip:              fip("10.0.0.10"),
+			expectedWarnings: []string{"requested IP 10.0.0.10 already responds on the network"},
+			expectedProbed:   []string{"10.0.0.10"},
+		},
+		{
+			name:             "probe failed",
+			probeErr:         errors.New("connection refused"),
+			fip:              fip("10.0.0.10"),
+			expectedWarnings: []string{"requested IP 10.0.0.10 could not be probed"},
+			expectedProbed:   []string{"10.0.0.10"},
+		},
+		{
+			name:   "existing IP is unchanged",
+			fip:    fip("10.0.0.10"),
+			oldFIP: fip("10.0.0.10"),
+		},
+		{
+			name: "no requested IP",
+			fip:  &rfmv2.FloatingIP{Spec: rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			prober := &fakeProber{alive: map[string]bool{"10.0.0.10": true}, err: tc.probeErr}
+			h := &Handler{
+				options: Options{Prober: prober, ProbeMode: tc.mode},
+			}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP:     tc.fip,
+				OldFIP:  tc.oldFIP,
+			}
+
+			err := (&IPNotLive{}).Validate(context.Background(), h, req)
+			assert.Equal(t, tc.expectedProbed, prober.probed)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedWarnings, req.Warnings)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var rancherProjectGVR = schema.GroupVersionResource{
+	Group:    "management.cattle.io",
+	Version:  "v3",
+	Resource: "projects",
+}
+
+// ProjectExists checks that the namespace of the FloatingIP belongs to the
+// project of its project-name label and that the project is a Rancher project
+// when the ValidateProject option is set, so stale or spoofed project labels
+// don't consume the quota of another project. The Rancher projects are
+// namespaced in the namespace of their cluster. Updates which keep the IP are
+// not checked, like in the ProjectLabel validator.
+type ProjectExists struct{}
+
+func (v *ProjectExists) Name() string { return "ProjectExists" }
+
+func (v *ProjectExists) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if !h.options.ValidateProject || req.IPUnchanged() {
+		return nil
+	}
+
+	projectID := req.projectID()
+	if projectID == "" {
+		return nil
+	}
+
+	clusterID, namespaceProject, err := h.requestNamespaceClusterProject(ctx, req, req.FIP.Namespace)
+	if err != nil {
+		req.Log.Errorf("failed to get the project of namespace %s: %s", req.FIP.Namespace, err)
+		if isTransient(err) {
+			return &TransientError{Err: fmt.Errorf("failed to get the project of namespace %s", req.FIP.Namespace)}
+		}
+		return fmt.Errorf("internal server error: failed to get the project of namespace %s", req.FIP.Namespace)
+	}
+	if namespaceProject == "" {
+		return fmt.Errorf("namespace %s is not in a rancher project, but the floatingip is labeled with project %s", req.FIP.Namespace, projectID)
+	}
+	if namespaceProject != projectID {
+		return fmt.Errorf("namespace %s belongs to project %s, but the floatingip is labeled with project %s", req.FIP.Namespace, namespaceProject, projectID)
+	}
+	if clusterID == "" {
+		return fmt.Errorf("the %s annotation of namespace %s has no cluster", ProjectIDAnnotation, req.FIP.Namespace)
+	}
+
+	_, err = h.dynamic.Resource(rancherProjectGVR).Namespace(clusterID).Get(ctx, projectID, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("rancher project %s does not exist in cluster %s", projectID, clusterID)
+	}
+	if err != nil {
+		req.Log.Errorf("failed to get rancher project %s/%s: %s", clusterID, projectID, err)
+		if isTransient(err) {
+			return &TransientError{Err: fmt.Errorf("failed to get rancher project %s", projectID)}
+		}
+		return fmt.Errorf("internal server error: failed to get rancher project %s", projectID)
+	}
+
+	return nil
+}
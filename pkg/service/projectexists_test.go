@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestProjectExists(t *testing.T) {
+	project := &unstructured.Unstructured{}
+	project.SetAPIVersion("management.cattle.io/v3")
+	project.SetKind("Project")
+	project.SetNamespace("c-abcde")
+	project.SetName("p-fghij")
+
+	clientset := kubefake.NewSimpleClientset(
+		testNamespace("team-a", "c-abcde:p-fghij"),
+		testNamespace("team-stale", "c-abcde:p-stale"),
+		testNamespace("team-nocluster", "p-fghij"),
+		testNamespace("unassigned", ""),
+	)
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		rancherProjectGVR: "ProjectList",
+	}, project)
+
+	testCases := []struct {
+		name          string
+		disabled      bool
+		namespace     string
+		projectID     string
+		oldIPAddr     string
+		expectedError string
+	}{
+		{
+			name:      "label matches an existing project of the namespace",
+			namespace: "team-a",
+			projectID: "p-fghij",
+		},
+		{
+			name:          "label of another project",
+			namespace:     "team-a",
+			projectID:     "p-other",
+			expectedError: "namespace team-a belongs to project p-fghij, but the floatingip is labeled with project p-other",
+		},
+		{
+			name:          "namespace without project",
+			namespace:     "unassigned",
+			projectID:     "p-fghij",
+			expectedError: "namespace unassigned is not in a rancher project, but the floatingip is labeled with project p-fghij",
+		},
+		{
+			name:          "project annotation without cluster",
+			namespace:     "team-nocluster",
+			projectID:     "p-fghij",
+			expectedError: "the field.cattle.io/projectId annotation of namespace team-nocluster has no cluster",
+		},
+		{
+			name:          "stale project",
+			namespace:     "team-stale",
+			projectID:     "p-stale",
+			expectedError: "rancher project p-stale does not exist in cluster c-abcde",
+		},
+		{
+			name:      "disabled",
+			disabled:  true,
+			namespace: "team-a",
+			projectID: "p-other",
+		},
+		{
+			name:      "update which keeps the IP",
+			namespace: "team-stale",
+			projectID: "p-stale",
+			oldIPAddr: "192.168.1.10",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{clientset: clientset, dynamic: dynamicClient, options: Options{ValidateProject: !tc.disabled}}
+			ipAddr := "192.168.1.10"
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{Operation: admissionv1.Create},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP: &rfmv2.FloatingIP{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test-fip",
+						Namespace: tc.namespace,
+						Labels:    map[string]string{ProjectNameLabel: tc.projectID},
+					},
+					Spec: rfmv2.FloatingIPSpec{IPAddr: &ipAddr},
+				},
+			}
+			if tc.oldIPAddr != "" {
+				req.OldFIP = req.FIP.DeepCopy()
+				req.OldFIP.Status.IPAddr = tc.oldIPAddr
+			}
+
+			err := (&ProjectExists{}).Validate(context.Background(), h, req)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestProjectExistsNamespaceLookup(t *testing.T) {
+	project := &unstructured.Unstructured{}
+	project.SetAPIVersion("management.cattle.io/v3")
+	project.SetKind("Project")
+	project.SetNamespace("c-abcde")
+	project.SetName("p-fghij")
+	clientset := kubefake.NewSimpleClientset(testNamespace("team-a", "c-abcde:p-fghij"))
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		rancherProjectGVR: "ProjectList",
+	}, project)
+	h := &Handler{clientset: clientset, dynamic: dynamicClient, options: Options{ProjectFromNamespace: true, ValidateProject: true}}
+	ipAddr := "192.168.1.10"
+	req := &FloatingIPRequest{
+		Request: &admissionv1.AdmissionRequest{Operation: admissionv1.Create},
+		Log:     log.NewEntry(log.StandardLogger()),
+		FIP: &rfmv2.FloatingIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "team-a"},
+			Spec:       rfmv2.FloatingIPSpec{IPAddr: &ipAddr},
+		},
+	}
+
+	assert.NoError(t, (&ProjectLabel{}).Validate(context.Background(), h, req))
+	assert.Equal(t, "p-fghij", req.ProjectID)
+	assert.NoError(t, (&ProjectExists{}).Validate(context.Background(), h, req))
+
+	// the namespace is fetched once per request
+	assert.Len(t, clientset.Actions(), 1)
+}
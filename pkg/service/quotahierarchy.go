@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+)
+
+const (
+	// QuotaScopeCluster is the scope of the MaxFloatingIPs option.
+	QuotaScopeCluster = "cluster"
+	// QuotaScopeProject is the scope of the FloatingIPProjectQuota.
+	QuotaScopeProject = "project"
+	// QuotaScopeNamespace is the scope of the NamespaceQuotaAnnotation.
+	QuotaScopeNamespace = "namespace"
+)
+
+// quotaScopes are the quota scopes from the least to the most specific.
+var quotaScopes = []string{QuotaScopeCluster, QuotaScopeProject, QuotaScopeNamespace}
+
+// QuotaLimit is a limit of the quota hierarchy which applies to a FloatingIP.
+type QuotaLimit struct {
+	Scope string
+	Limit int
+	Used  int
+	// Err is the denial when the limit is exceeded.
+	Err error
+}
+
+// specificity returns the position of the scope of the limit in the hierarchy.
+func (l QuotaLimit) specificity() int {
+	for i, scope := range quotaScopes {
+		if scope == l.Scope {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// checkQuotaLimit returns the denial of an exceeded limit. With the
+// HierarchicalQuotas option the limit is recorded in the request instead, so
+// the QuotaHierarchy validator can deny with the tightest exceeded limit.
+func (h *Handler) checkQuotaLimit(req *FloatingIPRequest, limit QuotaLimit) error {
+	if h.options.HierarchicalQuotas {
+		req.QuotaLimits = append(req.QuotaLimits, limit)
+		return nil
+	}
+	if validator.QuotaExceeded(limit.Limit, limit.Used) {
+		return limit.Err
+	}
+
+	return nil
+}
+
+// QuotaHierarchy evaluates the limits recorded by the QuotaCheck,
+// NamespaceQuotaCheck and ClusterLimitCheck validators when the
+// HierarchicalQuotas option is set. A namespace quota which exceeds the project
+// quota, or a project quota which exceeds the cluster limit, is allowed with a
+// warning because the higher limit applies. When several limits are exceeded
+// the FloatingIP is denied with the tightest one, which is the lowest limit or
+// the most specific scope when the limits are equal.
+type QuotaHierarchy struct{}
+
+func (v *QuotaHierarchy) Name() string { return "QuotaHierarchy" }
+
+func (v *QuotaHierarchy) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if !h.options.HierarchicalQuotas || len(req.QuotaLimits) == 0 {
+		return nil
+	}
+
+	limits := make(map[string]QuotaLimit)
+	for _, limit := range req.QuotaLimits {
+		limits[limit.Scope] = limit
+	}
+	for i := len(quotaScopes) - 1; i > 0; i-- {
+		lower, ok := limits[quotaScopes[i]]
+		if !ok {
+			continue
+		}
+		for _, scope := range quotaScopes[:i] {
+			if higher, ok := limits[scope]; ok && lower.Limit > higher.Limit {
+				req.Warnings = append(req.Warnings, fmt.Sprintf("%s quota %d for floatingippool %s exceeds the %s quota %d, the %s quota applies",
+					lower.Scope, lower.Limit, req.PoolName(), higher.Scope, higher.Limit, higher.Scope))
+				break
+			}
+		}
+	}
+
+	var tightest *QuotaLimit
+	for i, limit := range req.QuotaLimits {
+		if !validator.QuotaExceeded(limit.Limit, limit.Used) {
+			continue
+		}
+		if tightest == nil || limit.Limit < tightest.Limit ||
+			(limit.Limit == tightest.Limit && limit.specificity() > tightest.specificity()) {
+			tightest = &req.QuotaLimits[i]
+		}
+	}
+	if tightest != nil {
+		return tightest.Err
+	}
+
+	return nil
+}
+
+// QuotaWithinClusterLimit checks that no pool quota of a
+// FloatingIPProjectQuota exceeds the MaxFloatingIPs option when the
+// HierarchicalQuotas option is set.
+type QuotaWithinClusterLimit struct{}
+
+func (v *QuotaWithinClusterLimit) Name() string { return "QuotaWithinClusterLimit" }
+
+func (v *QuotaWithinClusterLimit) Validate(ctx context.Context, h *Handler, req *FloatingIPProjectQuotaRequest) error {
+	if !h.options.HierarchicalQuotas || h.options.MaxFloatingIPs <= 0 || req.IsDelete() {
+		return nil
+	}
+
+	for _, pool := range quotaPools(req.Quota) {
+		if quota := req.Quota.Spec.FloatingIPQuota[pool]; quota > h.options.MaxFloatingIPs {
+			return fmt.Errorf("quota %d for floatingippool %s exceeds the cluster limit of %d FloatingIPs", quota, pool, h.options.MaxFloatingIPs)
+		}
+	}
+
+	return nil
+}
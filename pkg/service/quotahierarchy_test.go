@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestQuotaHierarchy(t *testing.T) {
+	limit := func(scope string, quota int, used int) QuotaLimit {
+		return QuotaLimit{Scope: scope, Limit: quota, Used: used, Err: errors.New(scope + " limit exceeded")}
+	}
+
+	testCases := []struct {
+		name             string
+		disabled         bool
+		limits           []QuotaLimit
+		expectedMessage  string
+		expectedWarnings []string
+	}{
+		{
+			name: "within the limits",
+			limits: []QuotaLimit{
+				limit(QuotaScopeProject, 5, 2),
+				limit(QuotaScopeNamespace, 3, 2),
+				limit(QuotaScopeCluster, 100, 50),
+			},
+		},
+		{
+			name: "namespace quota exceeded",
+			limits: []QuotaLimit{
+				limit(QuotaScopeProject, 5, 2),
+				limit(QuotaScopeNamespace, 2, 2),
+			},
+			expectedMessage: "namespace limit exceeded",
+		},
+		{
+			name: "lowest exceeded limit",
+			limits: []QuotaLimit{
+				limit(QuotaScopeProject, 3, 3),
+				limit(QuotaScopeNamespace, 2, 2),
+				limit(QuotaScopeCluster, 100, 100),
+			},
+			expectedMessage: "namespace limit exceeded",
+		},
+		{
+			name: "most specific of equal limits",
+			limits: []QuotaLimit{
+				limit(QuotaScopeProject, 3, 3),
+				limit(QuotaScopeCluster, 3, 3),
+			},
+			expectedMessage: "project limit exceeded",
+		},
+		{
+			name: "namespace quota above the project quota",
+			limits: []QuotaLimit{
+				limit(QuotaScopeProject, 3, 3),
+				limit(QuotaScopeNamespace, 5, 3),
+			},
+			expectedMessage:  "project limit exceeded",
+			expectedWarnings: []string{"namespace quota 5 for floatingippool test-pool exceeds the project quota 3, the project quota applies"},
+		},
+		{
+			name: "project quota above the cluster limit",
+			limits: []QuotaLimit{
+				limit(QuotaScopeProject, 20, 1),
+				limit(QuotaScopeCluster, 10, 5),
+			},
+			expectedWarnings: []string{"project quota 20 for floatingippool test-pool exceeds the cluster quota 10, the cluster quota applies"},
+		},
+		{
+			name:     "disabled",
+			disabled: true,
+			limits:   []QuotaLimit{limit(QuotaScopeProject, 1, 1)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{options: Options{HierarchicalQuotas: !tc.disabled}}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP: &rfmv2.FloatingIP{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default"},
+					Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool"},
+				},
+				QuotaLimits: tc.limits,
+			}
+
+			err := (&QuotaHierarchy{}).Validate(context.Background(), h, req)
+			assert.Equal(t, tc.expectedWarnings, req.Warnings)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckQuotaLimit(t *testing.T) {
+	exceeded := QuotaLimit{Scope: QuotaScopeProject, Limit: 1, Used: 1, Err: errors.New("quota exceeded")}
+
+	req := &FloatingIPRequest{}
+	assert.EqualError(t, (&Handler{}).checkQuotaLimit(req, exceeded), "quota exceeded")
+	assert.Empty(t, req.QuotaLimits)
+
+	h := &Handler{options: Options{HierarchicalQuotas: true}}
+	assert.NoError(t, h.checkQuotaLimit(req, exceeded))
+	assert.Equal(t, []QuotaLimit{exceeded}, req.QuotaLimits)
+}
+
+func TestQuotaWithinClusterLimit(t *testing.T) {
+	testCases := []struct {
+		name            string
+		hierarchical    bool
+		maxFloatingIPs  int
+		quota           map[string]int
+		expectedMessage string
+	}{
+		{
+			name:           "within the cluster limit",
+			hierarchical:   true,
+			maxFloatingIPs: 10,
+			quota:          map[string]int{"test-pool": 10, "*": 5},
+		},
+		{
+			name:            "exceeds the cluster limit",
+			hierarchical:    true,
+			maxFloatingIPs:  10,
+			quota:           map[string]int{"test-pool": 11},
+			expectedMessage: "quota 11 for floatingippool test-pool exceeds the cluster limit of 10 FloatingIPs",
+		},
+		{
+			name:         "no cluster limit",
+			hierarchical: true,
+			quota:        map[string]int{"test-pool": 11},
+		},
+		{
+			name:           "disabled",
+			maxFloatingIPs: 10,
+			quota:          map[string]int{"test-pool": 11},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{options: Options{HierarchicalQuotas: tc.hierarchical, MaxFloatingIPs: tc.maxFloatingIPs}}
+			req := &FloatingIPProjectQuotaRequest{
+				Request: &admissionv1.AdmissionRequest{Operation: admissionv1.Create},
+				Log:     log.NewEntry(log.StandardLogger()),
+				Quota: &rfmv2.FloatingIPProjectQuota{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-project"},
+					Spec:       rfmv2.FloatingIPProjectQuotaSpec{FloatingIPQuota: tc.quota},
+				},
+			}
+
+			err := (&QuotaWithinClusterLimit{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
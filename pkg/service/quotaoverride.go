@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/metrics"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// QuotaOverrideAnnotation is the FloatingIP or namespace annotation which
+// lets a FloatingIP bypass the project and namespace quotas, for emergency
+// allocations. The value is the reason of the override, for example:
+//
+//	INC-1234 failover of the ingress
+const QuotaOverrideAnnotation = "rancher.k8s.binbash.org/quota-override"
+
+// QuotaOverride honors the QuotaOverrideAnnotation when the QuotaOverrideGroups
+// option is set. The annotation on a FloatingIP is only honored when the user
+// who sends the request is a member of one of the groups, otherwise the
+// FloatingIP is denied. The annotation on a namespace is only honored with the
+// NamespaceOverrides option, because it trusts everyone who may edit the
+// namespace. The override is stored in the
+// request, so the QuotaCheck and NamespaceQuotaCheck validators are skipped
+// and the override is recorded in the audit annotations.
+type QuotaOverride struct{}
+
+func (v *QuotaOverride) Name() string { return "QuotaOverride" }
+
+func (v *QuotaOverride) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if len(h.options.QuotaOverrideGroups) == 0 || req.IPUnchanged() {
+		return nil
+	}
+
+	source := "floatingip"
+	reason := strings.TrimSpace(req.FIP.Annotations[QuotaOverrideAnnotation])
+	if reason != "" && !memberOfGroups(h.options.QuotaOverrideGroups, req.Request.UserInfo.Groups) {
+		return fmt.Errorf("the %s annotation may only be set by members of the groups: %s",
+			QuotaOverrideAnnotation, strings.Join(h.options.QuotaOverrideGroups, ", "))
+	}
+	if reason == "" && h.options.NamespaceOverrides && h.clientset != nil {
+		ns, err := h.clientset.CoreV1().Namespaces().Get(ctx, req.FIP.Namespace, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			req.Log.Errorf("failed to get namespace %s: %s", req.FIP.Namespace, err)
+			return fmt.Errorf("internal server error: failed to get namespace %s", req.FIP.Namespace)
+		}
+		if err == nil {
+			source = "namespace"
+			reason = strings.TrimSpace(ns.Annotations[QuotaOverrideAnnotation])
+		}
+	}
+	if reason == "" {
+		return nil
+	}
+
+	req.QuotaOverride = reason
+	req.Log.Infof("quota of the FloatingIP is overridden by the %s annotation of the %s: %s", QuotaOverrideAnnotation, source, reason)
+	if req.Request.DryRun == nil || !*req.Request.DryRun {
+		metrics.QuotaOverrides.WithLabelValues(source).Inc()
+	}
+
+	return nil
+}
+
+// memberOfGroups returns true if one of the groups of the user is one of the
+// allowed groups.
+func memberOfGroups(allowedGroups []string, groups []string) bool {
+	for _, allowed := range allowedGroups {
+		for _, group := range groups {
+			if group == allowed {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestQuotaOverride(t *testing.T) {
+	annotated := map[string]string{QuotaOverrideAnnotation: "INC-1234"}
+
+	testCases := []struct {
+		name                 string
+		groups               []string
+		userGroups           []string
+		fipAnnotations       map[string]string
+		namespaceAnnotations map[string]string
+		namespaceOverrides   bool
+		update               bool
+		expectedOverride     string
+		expectedMessage      string
+	}{
+		{
+			name:           "disabled",
+			userGroups:     []string{"fip-admins"},
+			fipAnnotations: annotated,
+		},
+		{
+			name:             "annotation of an admin",
+			groups:           []string{"system:masters", "fip-admins"},
+			userGroups:       []string{"system:authenticated", "fip-admins"},
+			fipAnnotations:   annotated,
+			expectedOverride: "INC-1234",
+		},
+		{
+			name:            "annotation of a user",
+			groups:          []string{"fip-admins"},
+			userGroups:      []string{"system:authenticated"},
+			fipAnnotations:  annotated,
+			expectedMessage: "the rancher.k8s.binbash.org/quota-override annotation may only be set by members of the groups: fip-admins",
+		},
+		{
+			name:                 "annotation of the namespace",
+			groups:               []string{"fip-admins"},
+			userGroups:           []string{"system:authenticated"},
+			namespaceAnnotations: annotated,
+			namespaceOverrides:   true,
+			expectedOverride:     "INC-1234",
+		},
+		{
+			name:                 "annotation of the namespace without namespace overrides",
+			groups:               []string{"fip-admins"},
+			userGroups:           []string{"system:authenticated"},
+			namespaceAnnotations: annotated,
+		},
+		{
+			name:       "no annotation",
+			groups:     []string{"fip-admins"},
+			userGroups: []string{"fip-admins"},
+		},
+		{
+			name:           "unchanged IP",
+			groups:         []string{"fip-admins"},
+			userGroups:     []string{"system:authenticated"},
+			fipAnnotations: annotated,
+			update:         true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				clientset: kubefake.NewSimpleClientset(&corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: tc.namespaceAnnotations},
+				}),
+				options: Options{QuotaOverrideGroups: tc.groups, NamespaceOverrides: tc.namespaceOverrides},
+			}
+			ip := "10.0.0.50"
+			fip := &rfmv2.FloatingIP{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default", Annotations: tc.fipAnnotations},
+				Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool", IPAddr: &ip},
+			}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{
+					UserInfo: authenticationv1.UserInfo{Username: "test-user", Groups: tc.userGroups},
+				},
+				Log: log.NewEntry(log.StandardLogger()),
+				FIP: fip,
+			}
+			if tc.update {
+				req.OldFIP = fip.DeepCopy()
+				req.OldFIP.Status.IPAddr = ip
+			}
+
+			err := (&QuotaOverride{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedOverride, req.QuotaOverride)
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/metrics"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// rateLimitSweepInterval is how often the buckets which are full again are
+// removed, so the buckets of users and projects which stopped creating
+// FloatingIPs don't pile up.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimitError is returned by the RateLimit validator when a user or a
+// project creates FloatingIPs faster than the rate limit. These requests are
+// denied with a 429 status, which tells the client to retry.
+type RateLimitError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter is a token bucket per key, every bucket holds up to burst
+// tokens and is refilled with rate tokens per second. It only covers the
+// requests which are handled by this replica. A nil limiter doesn't limit.
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter returns a limiter which allows perMinute requests per minute
+// with bursts of burst requests, the burst is perMinute when it is 0.
+func newRateLimiter(perMinute int, burst int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = perMinute
+	}
+
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token of every key, or none when one of the buckets is empty.
+// It returns the key of the empty bucket and the time until it has a token
+// again.
+func (l *rateLimiter) Allow(now time.Time, keys ...string) (string, time.Duration, bool) {
+	if l == nil {
+		return "", 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	buckets := make([]*tokenBucket, 0, len(keys))
+	for _, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &tokenBucket{tokens: l.burst, updated: now}
+			l.buckets[key] = b
+		}
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+		b.updated = now
+		if b.tokens < 1 {
+			wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+			return key, wait, false
+		}
+		buckets = append(buckets, b)
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+
+	return "", 0, true
+}
+
+// sweep removes the buckets which are full again, they are equal to a new
+// bucket. The lock must be held.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimit denies new FloatingIPs when the user or the project of the
+// request creates FloatingIPs faster than the RateLimit option allows, to
+// protect the API server and the pools against runaway automation. Updates
+// are not limited.
+type RateLimit struct{}
+
+func (v *RateLimit) Name() string { return "RateLimit" }
+
+func (v *RateLimit) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if h.rateLimits == nil || req.Request.Operation != admissionv1.Create {
+		return nil
+	}
+
+	keys := []string{"user/" + req.Request.UserInfo.Username}
+	if projectID := req.FIP.Labels[ProjectNameLabel]; projectID != "" {
+		keys = append(keys, "project/"+projectID)
+	}
+
+	key, retryAfter, ok := h.rateLimits.Allow(time.Now(), keys...)
+	if ok {
+		return nil
+	}
+
+	scope, name, _ := strings.Cut(key, "/")
+	req.Log.Warnf("rate limiting FloatingIPs of %s %s", scope, name)
+	metrics.RateLimitedRequests.WithLabelValues(scope).Inc()
+	return &RateLimitError{
+		Err:        fmt.Errorf("%s %s creates FloatingIPs faster than %d per minute", scope, name, h.options.RateLimit),
+		RetryAfter: retryAfter,
+	}
+}
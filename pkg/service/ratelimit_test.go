@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRateLimiter(t *testing.T) {
+	// a nil limiter doesn't limit
+	var nilLimiter *rateLimiter
+	_, _, ok := nilLimiter.Allow(time.Now(), "user/alice")
+	assert.True(t, ok)
+	assert.Nil(t, newRateLimiter(0, 10))
+
+	// 6 per minute is a token every 10 seconds
+	l := newRateLimiter(6, 2)
+	now := time.Now()
+
+	_, _, ok = l.Allow(now, "user/alice")
+	assert.True(t, ok)
+	_, _, ok = l.Allow(now, "user/alice")
+	assert.True(t, ok)
+	key, retryAfter, ok := l.Allow(now, "user/alice")
+	assert.False(t, ok)
+	assert.Equal(t, "user/alice", key)
+	assert.Equal(t, 10*time.Second, retryAfter)
+
+	// other keys have their own bucket
+	_, _, ok = l.Allow(now, "user/bob")
+	assert.True(t, ok)
+
+	// the bucket is refilled over time
+	_, _, ok = l.Allow(now.Add(5*time.Second), "user/alice")
+	assert.False(t, ok)
+	_, _, ok = l.Allow(now.Add(10*time.Second), "user/alice")
+	assert.True(t, ok)
+
+	// no token is taken when one of the buckets is empty
+	_, _, ok = l.Allow(now.Add(10*time.Second), "user/bob", "project/p-abcde")
+	assert.True(t, ok)
+	key, _, ok = l.Allow(now.Add(10*time.Second), "user/carol", "project/p-abcde")
+	assert.True(t, ok, key)
+	key, _, ok = l.Allow(now.Add(10*time.Second), "user/dave", "project/p-abcde")
+	assert.False(t, ok)
+	assert.Equal(t, "project/p-abcde", key)
+	assert.Equal(t, float64(2), l.buckets["user/dave"].tokens)
+
+	// full buckets are removed
+	l.Allow(now.Add(time.Hour), "user/erin")
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestRateLimitResponse(t *testing.T) {
+	h := &Handler{
+		options:       Options{RateLimit: 1},
+		rateLimits:    newRateLimiter(1, 1),
+		fipValidators: []FloatingIPValidator{&RateLimit{}},
+	}
+	fip := &rfmv2.FloatingIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-fip",
+			Namespace: "default",
+			Labels:    map[string]string{ProjectNameLabel: "p-abcde"},
+		},
+		Spec: rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool"},
+	}
+	ar := &admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "test-uid",
+			Operation: admissionv1.Create,
+			UserInfo:  authenticationv1.UserInfo{Username: "automation"},
+		},
+	}
+
+	response := h.validateFloatingIP(context.Background(), requestLogger(ar.Request), ar, fip, nil)
+	assert.True(t, response.Allowed)
+
+	response = h.validateFloatingIP(context.Background(), requestLogger(ar.Request), ar, fip, nil)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "user automation creates FloatingIPs faster than 1 per minute, try again later", response.Result.Message)
+	assert.Equal(t, int32(http.StatusTooManyRequests), response.Result.Code)
+	assert.Equal(t, metav1.StatusReasonTooManyRequests, response.Result.Reason)
+	assert.Greater(t, response.Result.Details.RetryAfterSeconds, int32(0))
+	assert.Equal(t, "rate-limited", response.AuditAnnotations["failure"])
+	assert.Equal(t, "RateLimit", response.AuditAnnotations["denied-by"])
+
+	// updates are not limited
+	ar.Request.Operation = admissionv1.Update
+	response = h.validateFloatingIP(context.Background(), requestLogger(ar.Request), ar, fip, fip)
+	assert.True(t, response.Allowed)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+const (
+	// RequiredLabelsAnnotation is the FloatingIPPool annotation with the
+	// labels which FloatingIPs in the pool require, in addition to the
+	// RequiredLabels option. It is a semicolon separated list of rules, for
+	// example:
+	//
+	//	cost-center=cc-[0-9]+;owner
+	RequiredLabelsAnnotation = "rancher.k8s.binbash.org/required-labels"
+	// RequiredAnnotationsAnnotation is the FloatingIPPool annotation with the
+	// annotations which FloatingIPs in the pool require, in addition to the
+	// RequiredAnnotations option. It has the format of the
+	// RequiredLabelsAnnotation.
+	RequiredAnnotationsAnnotation = "rancher.k8s.binbash.org/required-annotations"
+)
+
+// poolMetadataRules returns the rules of the RequiredLabelsAnnotation and the
+// RequiredAnnotationsAnnotation of the pool.
+func poolMetadataRules(pool *rfmv2.FloatingIPPool) (labels []validator.MetadataRule, annotations []validator.MetadataRule, err error) {
+	labels, err = validator.ParseMetadataRules(pool.Annotations[RequiredLabelsAnnotation])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s annotation: %s", RequiredLabelsAnnotation, err)
+	}
+	annotations, err = validator.ParseMetadataRules(pool.Annotations[RequiredAnnotationsAnnotation])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s annotation: %s", RequiredAnnotationsAnnotation, err)
+	}
+
+	return labels, annotations, nil
+}
+
+// RequiredMetadata denies FloatingIPs without the labels and annotations of
+// the RequiredLabels and RequiredAnnotations options and of the pool, so
+// every allocation can be attributed to an owner. Updates which don't change
+// the labels and annotations are not checked, so existing FloatingIPs keep
+// working when a rule is added.
+type RequiredMetadata struct{}
+
+func (v *RequiredMetadata) Name() string { return "RequiredMetadata" }
+
+func (v *RequiredMetadata) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if req.IsUpdate() &&
+		equality.Semantic.DeepEqual(req.OldFIP.Labels, req.FIP.Labels) &&
+		equality.Semantic.DeepEqual(req.OldFIP.Annotations, req.FIP.Annotations) {
+		return nil
+	}
+
+	labels, annotations, err := poolMetadataRules(req.Pool)
+	if err != nil {
+		req.Log.Errorf("floatingippool %s has invalid metadata rules: %s", req.Pool.Name, err)
+		return fmt.Errorf("floatingippool %s has an %s", req.Pool.Name, err)
+	}
+	labels = append(append([]validator.MetadataRule{}, h.options.RequiredLabels...), labels...)
+	annotations = append(append([]validator.MetadataRule{}, h.options.RequiredAnnotations...), annotations...)
+
+	problems := validator.CheckMetadata("label", req.FIP.Labels, labels)
+	problems = append(problems, validator.CheckMetadata("annotation", req.FIP.Annotations, annotations)...)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("FloatingIPs in floatingippool %s require attributable labels and annotations: %s",
+		req.Pool.Name, strings.Join(problems, "; "))
+}
+
+// RequiredMetadataValid checks the rules of the RequiredLabelsAnnotation and
+// the RequiredAnnotationsAnnotation of the pool.
+type RequiredMetadataValid struct{}
+
+func (v *RequiredMetadataValid) Name() string { return "RequiredMetadataValid" }
+
+func (v *RequiredMetadataValid) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	_, _, err := poolMetadataRules(req.Pool)
+	return err
+}
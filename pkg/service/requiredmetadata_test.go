@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRequiredMetadata(t *testing.T) {
+	requiredLabels, err := validator.ParseMetadataRules("cost-center=cc-[0-9]+")
+	assert.NoError(t, err)
+
+	newFIP := func(labels map[string]string, annotations map[string]string) *rfmv2.FloatingIP {
+		return &rfmv2.FloatingIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-fip",
+				Namespace:   "default",
+				Labels:      labels,
+				Annotations: annotations,
+			},
+			Spec: rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool"},
+		}
+	}
+	newPool := func(annotations map[string]string) *rfmv2.FloatingIPPool {
+		return &rfmv2.FloatingIPPool{ObjectMeta: metav1.ObjectMeta{Name: "test-pool", Annotations: annotations}}
+	}
+	attributed := map[string]string{"cost-center": "cc-1234"}
+
+	testCases := []struct {
+		name            string
+		options         Options
+		fip             *rfmv2.FloatingIP
+		oldFIP          *rfmv2.FloatingIP
+		pool            *rfmv2.FloatingIPPool
+		expectedMessage string
+	}{
+		{
+			name: "no rules",
+			fip:  newFIP(nil, nil),
+			pool: newPool(nil),
+		},
+		{
+			name:    "global rule met",
+			options: Options{RequiredLabels: requiredLabels},
+			fip:     newFIP(attributed, nil),
+			pool:    newPool(nil),
+		},
+		{
+			name:            "global rule not met",
+			options:         Options{RequiredLabels: requiredLabels},
+			fip:             newFIP(map[string]string{"cost-center": "marketing"}, nil),
+			pool:            newPool(nil),
+			expectedMessage: "FloatingIPs in floatingippool test-pool require attributable labels and annotations: label cost-center=marketing doesn't match the pattern cc-[0-9]+",
+		},
+		{
+			name:    "global and pool rules",
+			options: Options{RequiredLabels: requiredLabels},
+			fip:     newFIP(nil, nil),
+			pool: newPool(map[string]string{
+				RequiredLabelsAnnotation:      "owner",
+				RequiredAnnotationsAnnotation: "ticket=INC-[0-9]+",
+			}),
+			expectedMessage: "FloatingIPs in floatingippool test-pool require attributable labels and annotations: missing label cost-center; missing label owner; missing annotation ticket",
+		},
+		{
+			name:    "pool rules met",
+			options: Options{RequiredLabels: requiredLabels},
+			fip:     newFIP(map[string]string{"cost-center": "cc-1234", "owner": "team-a"}, map[string]string{"ticket": "INC-42"}),
+			pool: newPool(map[string]string{
+				RequiredLabelsAnnotation:      "owner",
+				RequiredAnnotationsAnnotation: "ticket=INC-[0-9]+",
+			}),
+		},
+		{
+			name:            "invalid pool rules",
+			fip:             newFIP(attributed, nil),
+			pool:            newPool(map[string]string{RequiredLabelsAnnotation: "owner=("}),
+			expectedMessage: "floatingippool test-pool has an invalid rancher.k8s.binbash.org/required-labels annotation: rule \"owner=(\" has an invalid pattern: error parsing regexp: missing closing ): `^(?:()$`",
+		},
+		{
+			name:    "update without metadata change",
+			options: Options{RequiredLabels: requiredLabels},
+			fip:     newFIP(nil, nil),
+			oldFIP:  newFIP(nil, nil),
+			pool:    newPool(nil),
+		},
+		{
+			name:            "update which removes a required label",
+			options:         Options{RequiredLabels: requiredLabels},
+			fip:             newFIP(nil, nil),
+			oldFIP:          newFIP(attributed, nil),
+			pool:            newPool(nil),
+			expectedMessage: "FloatingIPs in floatingippool test-pool require attributable labels and annotations: missing label cost-center",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{options: tc.options}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP:     tc.fip,
+				OldFIP:  tc.oldFIP,
+				Pool:    tc.pool,
+			}
+
+			err := (&RequiredMetadata{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRequiredMetadataValid(t *testing.T) {
+	req := &FloatingIPPoolRequest{
+		Request: &admissionv1.AdmissionRequest{},
+		Log:     log.NewEntry(log.StandardLogger()),
+		Pool: &rfmv2.FloatingIPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-pool",
+				Annotations: map[string]string{RequiredLabelsAnnotation: "cost-center=cc-[0-9]+;owner"},
+			},
+		},
+	}
+	assert.NoError(t, (&RequiredMetadataValid{}).Validate(context.Background(), &Handler{}, req))
+
+	req.Pool.Annotations[RequiredAnnotationsAnnotation] = "=INC-[0-9]+"
+	assert.EqualError(t, (&RequiredMetadataValid{}).Validate(context.Background(), &Handler{}, req),
+		`invalid rancher.k8s.binbash.org/required-annotations annotation: rule "=INC-[0-9]+" has no key`)
+}
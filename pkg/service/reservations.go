@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ReservationsAnnotation is the FloatingIPPool annotation which holds the IPs
+// reserved by admitted FloatingIPs. The webhook adds a reservation when it
+// allows a FloatingIP with a requested IP, the controller removes it when
+// the IP is allocated in the pool status. Expired reservations are ignored.
+//
+// The value is a JSON object keyed by IP address, for example:
+//
+//	{"192.168.100.10":{"owner":"default/my-fip","expires":"2026-01-01T00:00:00Z"}}
+const ReservationsAnnotation = "rancher.k8s.binbash.org/ip-reservations"
+
+// DefaultReservationTTL is how long a reservation is valid when the controller
+// doesn't consume it.
+const DefaultReservationTTL = 5 * time.Minute
+
+// reservationRetries is the number of attempts to write a reservation when
+// the pool is updated concurrently.
+const reservationRetries = 3
+
+// Reservation is a single IP reservation in the ReservationsAnnotation.
+type Reservation struct {
+	// Owner is the namespace/name of the FloatingIP which reserved the IP.
+	Owner string `json:"owner"`
+	// Expires is the time after which the reservation is ignored.
+	Expires metav1.Time `json:"expires"`
+}
+
+// poolReservations returns the unexpired reservations from the annotations of the pool.
+func poolReservations(pool string, annotations map[string]string, now time.Time) (map[string]Reservation, error) {
+	reservations := make(map[string]Reservation)
+
+	value, ok := annotations[ReservationsAnnotation]
+	if !ok || value == "" {
+		return reservations, nil
+	}
+	if err := json.Unmarshal([]byte(value), &reservations); err != nil {
+		return nil, fmt.Errorf("cannot parse the %s annotation of floatingippool %s: %s", ReservationsAnnotation, pool, err)
+	}
+
+	for ip, reservation := range reservations {
+		if !now.Before(reservation.Expires.Time) {
+			delete(reservations, ip)
+		}
+	}
+
+	return reservations, nil
+}
+
+// Reserve reserves the requested IP in the pool, so other webhook replicas and
+// the controller see the IP as taken until it is allocated. Requests which are
+// reserved by another FloatingIP are denied. Dry-run requests are only checked.
+type Reserve struct{}
+
+func (v *Reserve) Name() string { return "Reserve" }
+
+func (v *Reserve) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if !h.options.Reservations || req.FIP.Spec.IPAddr == nil || req.IPUnchanged() {
+		return nil
+	}
+	ip := *req.FIP.Spec.IPAddr
+	owner := req.FIP.Namespace + "/" + req.FIP.Name
+	dryRun := req.Request.DryRun != nil && *req.Request.DryRun
+
+	for attempt := 0; attempt < reservationRetries; attempt++ {
+		unstructuredPool, err := h.dynamic.Resource(h.apiResources().FloatingIPPoolGVR()).Get(ctx, req.PoolName(), metav1.GetOptions{})
+		if err != nil {
+			req.Log.Errorf("failed to get floatingippool %s to reserve IP %s: %s", req.PoolName(), ip, err)
+			return fmt.Errorf("internal server error: cannot reserve IP %s", ip)
+		}
+
+		pool := unstructuredPool.GetName()
+		annotations := unstructuredPool.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+
+		now := time.Now()
+		reservations, err := poolReservations(pool, annotations, now)
+		if err != nil {
+			req.Log.Errorf("%s", err)
+			return fmt.Errorf("internal server error: cannot reserve IP %s", ip)
+		}
+
+		if existing, ok := reservations[ip]; ok && existing.Owner != owner {
+			return fmt.Errorf("requested IP %s is reserved by FloatingIP %s until %s", ip, existing.Owner, existing.Expires.UTC().Format(time.RFC3339))
+		}
+		if dryRun {
+			return nil
+		}
+
+		reservations[ip] = Reservation{
+			Owner:   owner,
+			Expires: metav1.NewTime(now.Add(h.options.ReservationTTL).Truncate(time.Second)),
+		}
+		value, err := json.Marshal(reservations)
+		if err != nil {
+			req.Log.Errorf("failed to encode the reservations of floatingippool %s: %s", pool, err)
+			return fmt.Errorf("internal server error: cannot reserve IP %s", ip)
+		}
+
+		annotations[ReservationsAnnotation] = string(value)
+		unstructuredPool.SetAnnotations(annotations)
+
+		_, err = h.dynamic.Resource(h.apiResources().FloatingIPPoolGVR()).Update(ctx, unstructuredPool, metav1.UpdateOptions{})
+		if err == nil {
+			req.Log.Debugf("reserved IP %s in floatingippool %s for %s", ip, pool, owner)
+			return nil
+		}
+		if !apierrors.IsConflict(err) {
+			req.Log.Errorf("failed to reserve IP %s in floatingippool %s: %s", ip, pool, err)
+			return fmt.Errorf("internal server error: cannot reserve IP %s", ip)
+		}
+	}
+
+	return fmt.Errorf("internal server error: cannot reserve IP %s, floatingippool %s is updated concurrently", ip, req.PoolName())
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestPoolReservations(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	annotations := map[string]string{
+		ReservationsAnnotation: `{"10.0.0.1":{"owner":"default/fip-a","expires":"2026-01-01T00:05:00Z"},"10.0.0.2":{"owner":"default/fip-b","expires":"2025-12-31T23:55:00Z"}}`,
+	}
+
+	reservations, err := poolReservations("pool", annotations, now)
+	assert.NoError(t, err)
+	assert.Len(t, reservations, 1)
+	assert.Equal(t, "default/fip-a", reservations["10.0.0.1"].Owner)
+
+	_, err = poolReservations("pool", map[string]string{ReservationsAnnotation: "{"}, now)
+	assert.ErrorContains(t, err, "cannot parse the rancher.k8s.binbash.org/ip-reservations annotation of floatingippool pool")
+}
+
+func TestReserve(t *testing.T) {
+	pool := &rfmv2.FloatingIPPool{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPPool"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pool"},
+	}
+	objects, err := getUnstructuredList([]runtime.Object{pool})
+	assert.NoError(t, err)
+	h := &Handler{
+		dynamic: fake.NewSimpleDynamicClient(runtime.NewScheme(), objects...),
+		options: Options{Reservations: true, ReservationTTL: time.Minute},
+	}
+
+	ipAddr := "10.0.0.1"
+	request := func(name string, dryRun bool) *FloatingIPRequest {
+		return &FloatingIPRequest{
+			Request: &admissionv1.AdmissionRequest{DryRun: &dryRun},
+			Log:     log.NewEntry(log.StandardLogger()),
+			FIP: &rfmv2.FloatingIP{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool", IPAddr: &ipAddr},
+			},
+			Pool: pool,
+		}
+	}
+	reservations := func() map[string]Reservation {
+		unstructuredPool, err := h.dynamic.Resource(schema.GroupVersionResource{
+			Group:    "rancher.k8s.binbash.org",
+			Version:  "v1beta2",
+			Resource: "floatingippools",
+		}).Get(context.Background(), "test-pool", metav1.GetOptions{})
+		assert.NoError(t, err)
+
+		reservations := make(map[string]Reservation)
+		if value, ok := unstructuredPool.GetAnnotations()[ReservationsAnnotation]; ok {
+			assert.NoError(t, json.Unmarshal([]byte(value), &reservations))
+		}
+		return reservations
+	}
+
+	// dry-run requests don't write a reservation
+	assert.NoError(t, (&Reserve{}).Validate(context.Background(), h, request("fip-a", true)))
+	assert.Empty(t, reservations())
+
+	assert.NoError(t, (&Reserve{}).Validate(context.Background(), h, request("fip-a", false)))
+	assert.Equal(t, "default/fip-a", reservations()["10.0.0.1"].Owner)
+
+	// the owner can retry its request
+	assert.NoError(t, (&Reserve{}).Validate(context.Background(), h, request("fip-a", false)))
+
+	err = (&Reserve{}).Validate(context.Background(), h, request("fip-b", true))
+	assert.ErrorContains(t, err, "requested IP 10.0.0.1 is reserved by FloatingIP default/fip-a until")
+
+	// reservations are only written when enabled
+	h.options.Reservations = false
+	assert.NoError(t, (&Reserve{}).Validate(context.Background(), h, request("fip-b", false)))
+}
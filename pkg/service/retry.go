@@ -0,0 +1,50 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// lookupBackoff is the backoff of the lookups which failed with a transient
+// error. It is short, the API server waits for the webhook.
+var lookupBackoff = wait.Backoff{
+	Steps:    3,
+	Duration: 100 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+}
+
+// TransientError is returned by validators when a lookup failed with an error
+// which may go away when the request is retried, like a timeout of the API
+// server. These requests are denied with a retryable status or allowed,
+// depending on the InternalFailurePolicy.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// isTransient returns true if the error of an API call may go away when the
+// call is retried.
+func isTransient(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsInternalError(err) ||
+		apierrors.IsUnexpectedServerError(err) ||
+		utilnet.IsConnectionRefused(err) ||
+		utilnet.IsConnectionReset(err) ||
+		utilnet.IsProbableEOF(err)
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestGetProjectQuotaRetries(t *testing.T) {
+	quota := &rfmv2.FloatingIPProjectQuota{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPProjectQuota"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test-project"},
+	}
+	objects, err := getUnstructuredList([]runtime.Object{quota})
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		err           error
+		failures      int
+		expectedCalls int
+		expectedError bool
+	}{
+		{
+			name:          "transient error",
+			err:           apierrors.NewServiceUnavailable("etcd is unavailable"),
+			failures:      2,
+			expectedCalls: 3,
+		},
+		{
+			name:          "transient error which persists",
+			err:           apierrors.NewTimeoutError("request timed out", 1),
+			failures:      10,
+			expectedCalls: 3,
+			expectedError: true,
+		},
+		{
+			name:          "permanent error",
+			err:           apierrors.NewForbidden(rfmv2.Resource("floatingipprojectquotas"), "test-project", nil),
+			failures:      10,
+			expectedCalls: 1,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dynamicClient := fake.NewSimpleDynamicClient(runtime.NewScheme(), objects...)
+			calls := 0
+			dynamicClient.PrependReactor("get", "floatingipprojectquotas", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				calls++
+				if calls <= tc.failures {
+					return true, nil, tc.err
+				}
+				return false, nil, nil
+			})
+			h := &Handler{dynamic: dynamicClient}
+
+			result, err := h.getProjectQuota(context.Background(), "test-project")
+			assert.Equal(t, tc.expectedCalls, calls)
+			if tc.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "test-project", result.Name)
+		})
+	}
+}
+
+func TestValidateFloatingIPTransientError(t *testing.T) {
+	testCases := []struct {
+		name            string
+		failurePolicy   admregv1.FailurePolicyType
+		expectedAllowed bool
+	}{
+		{
+			name: "denied with a retryable status",
+		},
+		{
+			name:            "allowed by the failure policy",
+			failurePolicy:   admregv1.Ignore,
+			expectedAllowed: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dynamicClient := fake.NewSimpleDynamicClient(runtime.NewScheme())
+			dynamicClient.PrependReactor("get", "floatingippools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewServiceUnavailable("etcd is unavailable")
+			})
+			h := &Handler{
+				dynamic:       dynamicClient,
+				options:       Options{InternalFailurePolicy: tc.failurePolicy},
+				fipValidators: []FloatingIPValidator{&PoolExists{}},
+			}
+			ar := &admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{UID: "test-uid"},
+			}
+			fip := &rfmv2.FloatingIP{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default"},
+				Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool"},
+			}
+
+			response := h.validateFloatingIP(context.Background(), log.NewEntry(log.StandardLogger()), ar, fip, nil)
+
+			assert.Equal(t, tc.expectedAllowed, response.Allowed)
+			assert.Equal(t, "transient", response.AuditAnnotations["failure"])
+			if tc.expectedAllowed {
+				assert.Len(t, response.Warnings, 1)
+				return
+			}
+			assert.Equal(t, "failed to get floatingippool test-pool, try again later", response.Result.Message)
+			assert.Equal(t, int32(http.StatusServiceUnavailable), response.Result.Code)
+			assert.Equal(t, metav1.StatusReasonServiceUnavailable, response.Result.Reason)
+			assert.Equal(t, "PoolExists", response.AuditAnnotations["denied-by"])
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	selfTestNamespace = "selftest"
+	selfTestPool      = "selftest-pool"
+	selfTestProject   = "selftest-project"
+)
+
+// selfTestCase is a synthetic FloatingIP request and the decision the
+// validators have to make.
+type selfTestCase struct {
+	name    string
+	fip     *rfmv2.FloatingIP
+	allowed bool
+}
+
+// SelfTestResult is the outcome of a single synthetic request.
+type SelfTestResult struct {
+	Name     string `json:"name"`
+	Expected string `json:"expected"`
+	Decision string `json:"decision"`
+	Message  string `json:"message,omitempty"`
+	Passed   bool   `json:"passed"`
+}
+
+// SelfTestReport is served on the /selftest endpoint.
+type SelfTestReport struct {
+	Passed  bool             `json:"passed"`
+	Results []SelfTestResult `json:"results"`
+}
+
+func selfTestCases(resources util.APIResources) []selfTestCase {
+	fip := func(name string, ip string, labels map[string]string) *rfmv2.FloatingIP {
+		return &rfmv2.FloatingIP{
+			TypeMeta:   metav1.TypeMeta{APIVersion: resources.GroupVersion().String(), Kind: "FloatingIP"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: selfTestNamespace, Labels: labels},
+			Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: selfTestPool, IPAddr: &ip},
+		}
+	}
+	projectLabel := map[string]string{ProjectNameLabel: selfTestProject}
+
+	return []selfTestCase{
+		{name: "FloatingIP within the quota", fip: fip("selftest-allowed", "10.99.0.20", projectLabel), allowed: true},
+		{name: "IP outside the pool range", fip: fip("selftest-out-of-range", "10.99.0.200", projectLabel)},
+		{name: "FloatingIP without a project", fip: fip("selftest-no-project", "10.99.0.21", nil)},
+	}
+}
+
+// selfTestHandler returns a copy of the handler with the same validators and
+// options, which looks up a synthetic pool and quota instead of the cluster.
+// The options which the synthetic requests can't satisfy are disabled.
+// Nothing is written to the cluster, reservations end up in the fake client.
+func (h *Handler) selfTestHandler() (*Handler, error) {
+	resources := h.apiResources()
+	fipPool := &rfmv2.FloatingIPPool{
+		TypeMeta:   metav1.TypeMeta{APIVersion: resources.GroupVersion().String(), Kind: "FloatingIPPool"},
+		ObjectMeta: metav1.ObjectMeta{Name: selfTestPool},
+		Spec: rfmv2.FloatingIPPoolSpec{
+			IPConfig: &rfmv2.IPConfig{
+				Subnet: "10.99.0.0/24",
+				Pool:   rfmv2.Pool{Start: "10.99.0.10", End: "10.99.0.100"},
+			},
+		},
+		Status: rfmv2.FloatingIPPoolStatus{Available: 91},
+	}
+	quota := &rfmv2.FloatingIPProjectQuota{
+		TypeMeta:   metav1.TypeMeta{APIVersion: resources.GroupVersion().String(), Kind: "FloatingIPProjectQuota"},
+		ObjectMeta: metav1.ObjectMeta{Name: selfTestProject},
+		Spec: rfmv2.FloatingIPProjectQuotaSpec{
+			FloatingIPQuota: map[string]int{selfTestPool: 10},
+		},
+	}
+
+	var objects []runtime.Object
+	for _, obj := range []runtime.Object{fipPool, quota} {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert %T to unstructured: %v", obj, err)
+		}
+		objects = append(objects, &unstructured.Unstructured{Object: u})
+	}
+
+	listKinds := map[schema.GroupVersionResource]string{
+		resources.FloatingIPGVR():                                            "FloatingIPList",
+		resources.FloatingIPPoolGVR():                                        "FloatingIPPoolList",
+		resources.FloatingIPProjectQuotaGVR():                                "FloatingIPProjectQuotaList",
+		{Group: "management.cattle.io", Version: "v3", Resource: "clusters"}: "ClusterList",
+	}
+
+	options := h.options
+	// a denied synthetic request must not be allowed by the audit mode
+	options.AuditMode = nil
+	// the synthetic IPs must not be probed on the network
+	options.Prober = nil
+	// the synthetic FloatingIPs don't carry the labels and annotations of the
+	// cluster policy
+	options.RequiredLabels = nil
+	options.RequiredAnnotations = nil
+	// the synthetic namespace is not in a rancher project
+	options.ValidateProject = false
+
+	th := &Handler{
+		ctx:               h.ctx,
+		clientset:         kubefake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: selfTestNamespace}}),
+		dynamic:           dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...),
+		options:           options,
+		fipValidators:     h.fipValidators,
+		fipPoolValidators: h.fipPoolValidators,
+		quotaValidators:   h.quotaValidators,
+		claims:            newClaimTable(DefaultClaimTTL),
+	}
+	th.RegisterKind("FloatingIP", WebhookFloatingIP, th.admitFloatingIP)
+
+	return th, nil
+}
+
+// SelfTest runs synthetic FloatingIP AdmissionReviews through the validators
+// and reports if they made the expected decisions, so the decision path can be
+// verified after an upgrade without creating FloatingIPs.
+func (h *Handler) SelfTest(ctx context.Context) (*SelfTestReport, error) {
+	th, err := h.selfTestHandler()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SelfTestReport{Passed: true}
+	for i, tc := range selfTestCases(h.apiResources()) {
+		raw, err := json.Marshal(tc.fip)
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal the FloatingIP of %q: %v", tc.name, err)
+		}
+		ar := &admissionv1.AdmissionReview{
+			Request: &admissionv1.AdmissionRequest{
+				UID:       types.UID(fmt.Sprintf("selftest-%d", i)),
+				Kind:      metav1.GroupVersionKind{Group: h.apiResources().Group, Version: h.apiResources().Version, Kind: "FloatingIP"},
+				Name:      tc.fip.Name,
+				Namespace: tc.fip.Namespace,
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		}
+
+		result := SelfTestResult{Name: tc.name, Expected: selfTestDecision(tc.allowed)}
+		response, err := th.admit(ctx, th.kinds["FloatingIP"], requestLogger(ar.Request), ar)
+		if err != nil {
+			result.Decision = "error"
+			result.Message = err.Error()
+		} else {
+			result.Decision = selfTestDecision(response.Allowed)
+			if response.Result != nil {
+				result.Message = response.Result.Message
+			}
+			result.Passed = response.Allowed == tc.allowed
+		}
+		report.Passed = report.Passed && result.Passed
+		report.Results = append(report.Results, result)
+	}
+
+	return report, nil
+}
+
+func selfTestDecision(allowed bool) string {
+	if allowed {
+		return "allowed"
+	}
+	return "denied"
+}
+
+// selfTest serves the report of SelfTest, with status 500 when a synthetic
+// request got an unexpected decision. The callers are authenticated and
+// limited like the callers of the admission endpoints, so the endpoint can't
+// be used to load the webhook.
+func (h *Handler) selfTest(w http.ResponseWriter, r *http.Request) {
+	if status, err := h.authenticate(r.Context(), r); err != nil {
+		log.Warnf("(selfTest) rejected request from %s: %s", r.RemoteAddr, err)
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+
+	if _, retryAfter, ok := h.rateLimits.Allow(time.Now(), "selftest"); !ok {
+		log.Warnf("(selfTest) rate limiting the self-test")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, "the self-test runs more often than %d per minute", h.options.RateLimit)
+		return
+	}
+
+	if !h.inflight.Acquire(r.Context()) {
+		log.Warnf("(selfTest) rejecting request, the maximum number of requests in flight is reached")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, "the webhook is overloaded, try again later")
+		return
+	}
+	report, err := h.SelfTest(r.Context())
+	h.inflight.Release()
+	if err != nil {
+		log.Errorf("(selfTest) cannot run the self-test: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Passed {
+		log.Errorf("(selfTest) self-test failed: %+v", report.Results)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Errorf("(selfTest) cannot encode the self-test report: %s", err)
+	}
+}
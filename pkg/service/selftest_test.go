@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// rejectAll is a validator of a downstream build which denies every FloatingIP.
+type rejectAll struct{}
+
+func (v *rejectAll) Name() string { return "RejectAll" }
+
+func (v *rejectAll) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	return errors.New("all FloatingIPs are rejected")
+}
+
+func TestSelfTest(t *testing.T) {
+	h := &Handler{
+		options:       Options{AuditMode: map[string]bool{WebhookFloatingIP: true}},
+		fipValidators: DefaultFloatingIPValidators(),
+	}
+
+	rec := httptest.NewRecorder()
+	h.selfTest(rec, httptest.NewRequest(http.MethodGet, "/selftest", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	report := &SelfTestReport{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(report))
+	assert.True(t, report.Passed)
+	assert.Len(t, report.Results, 3)
+	for _, result := range report.Results {
+		assert.True(t, result.Passed, result.Name)
+		assert.Equal(t, result.Expected, result.Decision, result.Name)
+	}
+	assert.Equal(t, "denied", report.Results[1].Decision)
+	assert.NotEmpty(t, report.Results[1].Message)
+
+	// a validator which denies every request fails the self-test
+	h.RegisterFloatingIPValidator(&rejectAll{})
+	rec = httptest.NewRecorder()
+	h.selfTest(rec, httptest.NewRequest(http.MethodGet, "/selftest", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	report = &SelfTestReport{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(report))
+	assert.False(t, report.Passed)
+	assert.False(t, report.Results[0].Passed)
+	assert.Equal(t, "all FloatingIPs are rejected", report.Results[0].Message)
+}
+
+func TestSelfTestPolicyOptions(t *testing.T) {
+	labels, err := validator.ParseMetadataRules("team")
+	assert.NoError(t, err)
+	annotations, err := validator.ParseMetadataRules("owner")
+	assert.NoError(t, err)
+	h := &Handler{
+		options:       Options{RequiredLabels: labels, RequiredAnnotations: annotations, ValidateProject: true},
+		fipValidators: DefaultFloatingIPValidators(),
+	}
+
+	// the synthetic FloatingIPs don't have to satisfy the cluster policy and
+	// don't have to be in a rancher project
+	report, err := h.SelfTest(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, report.Passed, report.Results)
+	assert.Equal(t, labels, h.options.RequiredLabels)
+}
+
+func TestSelfTestEndpoint(t *testing.T) {
+	serve := func(h *Handler, authorization string) *httptest.ResponseRecorder {
+		mux := http.NewServeMux()
+		h.registerHandlers(mux.Handle)
+		r := httptest.NewRequest(http.MethodGet, "/selftest", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, r)
+		return rec
+	}
+
+	// the endpoint is not served by default
+	h := &Handler{fipValidators: DefaultFloatingIPValidators()}
+	assert.Equal(t, http.StatusNotFound, serve(h, "").Code)
+
+	clientset := kubefake.NewSimpleClientset()
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		review.Status = authenticationv1.TokenReviewStatus{
+			Authenticated: true,
+			User:          authenticationv1.UserInfo{Username: "jane"},
+		}
+		return true, review, nil
+	})
+	h = &Handler{
+		clientset:     clientset,
+		options:       Options{SelfTest: true, AuthenticateRequests: true, RateLimit: 1},
+		tokens:        newTokenCache(authenticationCacheTTL),
+		inflight:      newInflightLimiter(1, 0),
+		rateLimits:    newRateLimiter(1, 1),
+		fipValidators: DefaultFloatingIPValidators(),
+	}
+
+	// the callers are authenticated like the callers of the admission endpoints
+	rec := serve(h, "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "no bearer token", rec.Body.String())
+
+	// the runs count against the requests in flight
+	assert.True(t, h.inflight.Acquire(context.Background()))
+	rec = serve(h, "Bearer user-token")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "the webhook is overloaded, try again later", rec.Body.String())
+	h.inflight.Release()
+
+	// and are rate limited
+	rec = serve(h, "Bearer user-token")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	assert.Equal(t, "the self-test runs more often than 1 per minute", rec.Body.String())
+}
@@ -1,520 +1,764 @@
 package service
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
 	"net"
 	"net/http"
-	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/health"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/metrics"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/version"
 	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
 	log "github.com/sirupsen/logrus"
 	admissionv1 "k8s.io/api/admission/v1"
+	admregv1 "k8s.io/api/admissionregistration/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 )
 
+// DefaultAddress is the address the webhook server listens on.
+const DefaultAddress = ":8443"
+
+// DefaultReadTimeout and DefaultWriteTimeout are the timeouts of the webhook
+// server for reading a request and writing its response.
+const (
+	DefaultReadTimeout  = 10 * time.Second
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+// DefaultMaxRequestBytes is the maximum size of an AdmissionReview. The API
+// server limits objects to 3 MiB and an AdmissionReview of an UPDATE carries
+// the object and the old object.
+const DefaultMaxRequestBytes = 8 << 20
+
+// HealthComponent is the name of the HTTP server in the liveness checks.
+const HealthComponent = "http-server"
+
+const (
+	WebhookFloatingIP             = "floatingip"
+	WebhookFloatingIPPool         = "floatingippool"
+	WebhookFloatingIPProjectQuota = "floatingipprojectquota"
+)
+
+// Options holds the runtime settings of the admission service.
+type Options struct {
+	// Address is the address the webhook server listens on, DefaultAddress
+	// is used when it is empty.
+	Address string
+	// Server is the server which serves the endpoints, ServerDefault or
+	// ServerControllerRuntime.
+	Server string
+	// CertDir is the directory with the tls.crt and tls.key files of the
+	// controller-runtime server, which are reloaded when they change. The
+	// certificate of GetCertificate is served when it is empty.
+	CertDir string
+	// AuditMode contains the webhooks which evaluate all rules but always
+	// allow the request, only logging and counting what would have been denied.
+	AuditMode map[string]bool
+	// ProjectFromNamespace resolves the project from the Rancher project
+	// annotation of the namespace when the project-name label is missing.
+	ProjectFromNamespace bool
+	// ClaimTTL is how long a requested IP is protected against concurrent
+	// requests for the same IP, DefaultClaimTTL is used when it is 0.
+	ClaimTTL time.Duration
+	// Reservations writes a reservation for every admitted IP to the pool,
+	// which the controller removes once the IP is allocated.
+	Reservations bool
+	// ReservationTTL is how long a reservation is valid, DefaultReservationTTL
+	// is used when it is 0.
+	ReservationTTL time.Duration
+	// QuotaUnknownPoolsWarn allows FloatingIPProjectQuotas with quotas for
+	// FloatingIPPools which don't exist with a warning instead of denying them.
+	QuotaUnknownPoolsWarn bool
+	// MaxPoolSize is the maximum number of IP addresses in the range of a
+	// FloatingIPPool, the size is not limited when it is 0.
+	MaxPoolSize int64
+	// ApprovalPoolSize is the number of IP addresses in the range of a
+	// FloatingIPPool above which the pool needs the approval of a member of
+	// the ApprovalGroups, pools don't need approval when it is 0.
+	ApprovalPoolSize int64
+	// ApprovalGroups are the groups whose members may approve FloatingIPPools.
+	ApprovalGroups []string
+	// MaxFloatingIPs is the maximum number of FloatingIPs in the cluster, the
+	// number is not limited when it is 0.
+	MaxFloatingIPs int
+	// HierarchicalQuotas evaluates the cluster limit, the project quota and
+	// the namespace quota together, so a FloatingIP is denied with the
+	// tightest exceeded limit and project quotas cannot exceed the cluster
+	// limit.
+	HierarchicalQuotas bool
+	// ValidateTargetCluster denies FloatingIPPools whose target cluster is not
+	// a Rancher cluster.
+	ValidateTargetCluster bool
+	// ValidateProject denies FloatingIPs whose project-name label is not the
+	// Rancher project of their namespace or not an existing Rancher project.
+	ValidateProject bool
+	// ForbiddenRanges are the ranges which FloatingIPPools and requested IPs
+	// must not overlap with, validator.DefaultForbiddenRanges is used when it
+	// is nil. An empty list disables the check.
+	ForbiddenRanges []*net.IPNet
+	// AddressSpace is the address space of FloatingIPPools, one of
+	// validator.AddressSpacePrivate or validator.AddressSpacePublic. Pools
+	// in any address space are allowed when it is empty.
+	AddressSpace string
+	// ProjectAddressSpaces overrides AddressSpace for the pools which carry
+	// the project-name label of a project in the map.
+	ProjectAddressSpaces map[string]string
+	// ClusterCIDRs are the Pod and Service networks of the cluster, which
+	// FloatingIPPool ranges must not overlap with.
+	ClusterCIDRs []*net.IPNet
+	// ValidateNodeConflicts denies FloatingIPPools whose range overlaps with
+	// the Pod networks or contains the addresses of the nodes.
+	ValidateNodeConflicts bool
+	// LoadBalancerConflicts checks FloatingIPPools for overlaps with the
+	// MetalLB and kube-vip address pools, it is LoadBalancerConflictsWarn
+	// or LoadBalancerConflictsDeny. The check is disabled when it is empty.
+	LoadBalancerConflicts string
+	// Prober probes the requested IPs of FloatingIPs before they are
+	// allowed, they are not probed when it is nil.
+	Prober Prober
+	// ProbeMode is ProbeModeWarn to allow FloatingIPs whose requested IP
+	// responds with a warning, they are denied otherwise.
+	ProbeMode string
+	// ProbeTimeout is the time a probe waits for a response,
+	// DefaultProbeTimeout is used when it is 0.
+	ProbeTimeout time.Duration
+	// UtilizationThresholds are the pool utilization percentages which new
+	// FloatingIPs are warned about, DefaultUtilizationThresholds is used when
+	// it is nil. An empty list disables the warnings.
+	UtilizationThresholds []int
+	// MissingQuotaPolicy handles FloatingIPs of projects without a
+	// FloatingIPProjectQuota, MissingQuotaAllow doesn't limit them and
+	// MissingQuotaLimit limits them to MissingQuotaLimit FloatingIPs per
+	// pool. They are denied when it is empty.
+	MissingQuotaPolicy string
+	// MissingQuotaLimit is the quota per pool of the MissingQuotaLimit policy.
+	MissingQuotaLimit int
+	// QuotaOverrideGroups are the groups whose members may bypass the quotas
+	// with the QuotaOverrideAnnotation. Overrides are disabled when it is
+	// empty.
+	QuotaOverrideGroups []string
+	// NamespaceOverrides also honors the QuotaOverrideAnnotation on the
+	// namespace of a FloatingIP. The annotation of a namespace is not checked
+	// against the QuotaOverrideGroups, so everyone who may edit the namespace,
+	// like the owners of its Rancher project, can bypass the quotas.
+	NamespaceOverrides bool
+	// RequiredLabels and RequiredAnnotations are the labels and annotations
+	// which every FloatingIP requires, in addition to the rules of the
+	// RequiredLabelsAnnotation and RequiredAnnotationsAnnotation of its pool.
+	RequiredLabels      []validator.MetadataRule
+	RequiredAnnotations []validator.MetadataRule
+	// MaxLease is the maximum lease of the ExpiresAfterAnnotation of
+	// FloatingIPs, the lease is not limited when it is 0.
+	MaxLease time.Duration
+	// ProjectMaxLeases overrides MaxLease for the FloatingIPs of the
+	// projects in the map, 0 doesn't limit the lease of the project.
+	ProjectMaxLeases map[string]time.Duration
+	// BlocklistConfigMap is the ConfigMap with the projects and namespaces
+	// which may not create FloatingIPs, nothing is blocked when its name is
+	// empty.
+	BlocklistConfigMap types.NamespacedName
+	// BreakGlassConfigMap is the ConfigMap which enables the break-glass mode,
+	// which allows every request, at runtime. The mode cannot be enabled when
+	// its name is empty.
+	BreakGlassConfigMap types.NamespacedName
+	// ControllerUsers are the users which may change the status of
+	// FloatingIPs and FloatingIPPools, which holds the allocations. Every
+	// user may change it when it is empty.
+	ControllerUsers []string
+	// MaintenanceWindows are the windows in which FloatingIPPools may be
+	// created, changed and deleted, pools are not restricted when it is empty.
+	MaintenanceWindows []*validator.MaintenanceWindow
+	// MaintenanceLocation is the time zone of the MaintenanceWindows, UTC is
+	// used when it is nil.
+	MaintenanceLocation *time.Location
+	// MaintenanceGroups are the groups whose members may change
+	// FloatingIPPools outside the MaintenanceWindows.
+	MaintenanceGroups []string
+	// DeleteConfirmation requires the ConfirmDeleteAnnotation on
+	// FloatingIPPools before they can be deleted.
+	DeleteConfirmation bool
+	// DeleteConfirmGroups are the groups whose members may delete
+	// FloatingIPPools without confirmation.
+	DeleteConfirmGroups []string
+	// MaxRequestBytes is the maximum size of an AdmissionReview request body,
+	// DefaultMaxRequestBytes is used when it is 0.
+	MaxRequestBytes int64
+	// ReadTimeout and WriteTimeout are the timeouts of the webhook server,
+	// DefaultReadTimeout and DefaultWriteTimeout are used when they are 0.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// IdleTimeout and ReadHeaderTimeout are the keep-alive and header
+	// timeouts of the webhook server, the ReadTimeout is used when they are 0.
+	IdleTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	// DisableHTTP2 makes the webhook server only serve HTTP/1.1, for proxies
+	// which don't support HTTP/2.
+	DisableHTTP2 bool
+	// AuthenticateRequests rejects admission requests without a bearer token
+	// which is authenticated by a TokenReview, for API servers which are
+	// configured to authenticate to admission webhooks.
+	AuthenticateRequests bool
+	// AuthenticatedUsers are the users which may call the webhook when
+	// AuthenticateRequests is set, every authenticated user may call it when
+	// it is empty.
+	AuthenticatedUsers []string
+	// PreviewAPI serves the usage of quotas and pools on /preview/quota/{project}
+	// and /preview/pool/{pool} to users who may get the object.
+	PreviewAPI bool
+	// SelfTest serves a run of synthetic FloatingIP requests through the
+	// validators on /selftest.
+	SelfTest bool
+	// APIResources are the API group, version and resource names of the
+	// FloatingIP CRDs, the DefaultAPIResources are used when the group is empty.
+	APIResources util.APIResources
+	// Conversion serves the conversion of the FloatingIP CRDs between their
+	// API versions on /convert.
+	Conversion bool
+	// RateLimit is the number of FloatingIPs which a user and a project may
+	// create per minute, the rate is not limited when it is 0.
+	RateLimit int
+	// RateLimitBurst is the number of FloatingIPs which may be created at
+	// once before the RateLimit applies, the RateLimit is used when it is 0.
+	RateLimitBurst int
+	// MaxInFlight is the maximum number of admission requests which are
+	// validated concurrently, the number is not limited when it is 0.
+	MaxInFlight int
+	// MaxQueued is the number of admission requests which wait for a slot
+	// when MaxInFlight is reached, further requests are rejected.
+	MaxQueued int
+	// AccessLog logs every admission request with its latency and decision.
+	AccessLog bool
+	// RequestBudget is the percentage of the webhook timeout which is spent
+	// on validating a request, so a decision is returned before the API
+	// server gives up. DefaultRequestBudget is used when it is 0.
+	RequestBudget int
+	// InternalFailurePolicy decides if a request is allowed (Ignore) or
+	// denied (Fail) when a validator panics or a FloatingIP cannot be
+	// validated because of a transient error, Fail is used when it is empty.
+	InternalFailurePolicy admregv1.FailurePolicyType
+	// BreakerFailures is the number of consecutive transient failures
+	// of the pool and quota lookups which open the circuit breaker, the
+	// breaker is disabled when it is 0.
+	BreakerFailures int
+	// BreakerTimeout is the time the circuit breaker stays open before
+	// a lookup probes the API server, DefaultCircuitBreakerTimeout is used
+	// when it is 0.
+	BreakerTimeout time.Duration
+	// DegradedPolicy decides if a FloatingIP which cannot be validated while
+	// the circuit breaker is open is allowed with a warning (Ignore) or
+	// denied with a retryable status (Fail), the InternalFailurePolicy is
+	// used when it is empty.
+	DegradedPolicy admregv1.FailurePolicyType
+	// PoolIndex watches the FloatingIPPools and keeps a bitmap of the free
+	// IPs of every pool, so the free IPs are checked without scanning the
+	// excludes of the pool.
+	PoolIndex bool
+	// ValidateServices validates the FloatingIP annotations of core v1
+	// Services, see ServiceAnnotations.
+	ValidateServices bool
+	// TargetWarnOnly allows FloatingIPs whose target or target-vm annotation
+	// references a missing object or an object of another project with a
+	// warning, for FloatingIPs which are created before their target.
+	TargetWarnOnly bool
+	// DecisionCacheTTL is how long the response of an admission request is
+	// returned to the retries of the API server with the same request UID,
+	// the responses are not cached when it is 0.
+	DecisionCacheTTL time.Duration
+	// GetCertificate returns the serving certificate for every TLS handshake,
+	// so a renewed certificate is used without reading files.
+	GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
 type Handler struct {
-	ctx        context.Context
-	httpServer *http.Server
-	clientset  kubernetes.Interface
-	dynamic    dynamic.Interface
+	ctx               context.Context
+	httpServer        *http.Server
+	serverMu          sync.Mutex
+	serverCancel      context.CancelFunc
+	serverDone        chan struct{}
+	clientset         kubernetes.Interface
+	dynamic           dynamic.Interface
+	options           Options
+	fipValidators     []FloatingIPValidator
+	fipPoolValidators []FloatingIPPoolValidator
+	quotaValidators   []FloatingIPProjectQuotaValidator
+	kinds             map[string]kindHandler
+	annotations       map[string]AnnotationSchema
+	converters        map[string]Converter
+	claims            *claimTable
+	tokens            *tokenCache
+	inflight          *inflightLimiter
+	rateLimits        *rateLimiter
+	decisions         *decisionCache
+	pools             *poolIndex
+	breaker           *circuitBreaker
+	blocklist         *blocklistCache
+	breakGlass        atomic.Pointer[breakGlassState]
 }
 
-func Register(ctx context.Context) *Handler {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		log.Fatalf("Failed to get in-cluster config: %v", err)
+// Register creates the admission service which uses the given clients to
+// look up the FloatingIP resources.
+func Register(ctx context.Context, clients *util.Clients, options Options) *Handler {
+	if options.Address == "" {
+		options.Address = DefaultAddress
 	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Fatalf("Failed to create clientset: %v", err)
+	if options.ClaimTTL <= 0 {
+		options.ClaimTTL = DefaultClaimTTL
 	}
-	dynamicClient, err := dynamic.NewForConfig(config)
-	if err != nil {
-		log.Fatalf("Failed to create dynamic client: %v", err)
+	if options.ReservationTTL <= 0 {
+		options.ReservationTTL = DefaultReservationTTL
+	}
+	if options.ReadTimeout <= 0 {
+		options.ReadTimeout = DefaultReadTimeout
+	}
+	if options.WriteTimeout <= 0 {
+		options.WriteTimeout = DefaultWriteTimeout
+	}
+	if options.UtilizationThresholds == nil {
+		options.UtilizationThresholds = DefaultUtilizationThresholds()
+	}
+	if options.ForbiddenRanges == nil {
+		options.ForbiddenRanges = validator.DefaultForbiddenRanges()
+	}
+
+	h := &Handler{
+		ctx:               ctx,
+		clientset:         clients.Clientset,
+		dynamic:           clients.Dynamic,
+		options:           options,
+		fipValidators:     DefaultFloatingIPValidators(),
+		fipPoolValidators: DefaultFloatingIPPoolValidators(),
+		quotaValidators:   DefaultFloatingIPProjectQuotaValidators(),
+		claims:            newClaimTable(options.ClaimTTL),
+		tokens:            newTokenCache(authenticationCacheTTL),
+		inflight:          newInflightLimiter(options.MaxInFlight, options.MaxQueued),
+		rateLimits:        newRateLimiter(options.RateLimit, options.RateLimitBurst),
+		decisions:         newDecisionCache(options.DecisionCacheTTL),
+		pools:             newPoolIndex(options.PoolIndex),
+		breaker:           newCircuitBreaker(options.BreakerFailures, options.BreakerTimeout),
+		blocklist:         newBlocklistCache(blocklistRefreshInterval),
 	}
-	return &Handler{
-		ctx:       ctx,
-		clientset: clientset,
-		dynamic:   dynamicClient,
+	h.RegisterKind("FloatingIP", WebhookFloatingIP, h.admitFloatingIP)
+	h.RegisterKind("FloatingIPPool", WebhookFloatingIPPool, h.admitFloatingIPPool)
+	h.RegisterKind("FloatingIPProjectQuota", WebhookFloatingIPProjectQuota, h.admitFloatingIPProjectQuota)
+	if options.ValidateServices {
+		h.RegisterKind("Service", WebhookService, h.admitService)
 	}
+
+	return h
 }
 
-func validateFloatingIP(ctx context.Context, dynamic dynamic.Interface, ar *admissionv1.AdmissionReview, fip *rfmv2.FloatingIP, oldFIP *rfmv2.FloatingIP, h *Handler) *admissionv1.AdmissionResponse {
-	// Determine if this is an UPDATE operation
-	isUpdate := oldFIP != nil
+// AdmitFunc decodes the object of an admission request and validates it. A
+// returned error means the request could not be processed at all.
+type AdmitFunc func(ctx context.Context, logger *log.Entry, ar *admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error)
 
-	// 1. Check if the specified FloatingIPPool exists.
-	fipGVR := schema.GroupVersionResource{
-		Group:    "rancher.k8s.binbash.org",
-		Version:  "v1beta2",
-		Resource: "floatingippools",
+type kindHandler struct {
+	webhook string
+	admit   AdmitFunc
+}
+
+// RegisterKind registers the admit function which validates requests of the
+// given kind on the /validate endpoint. The webhook name is used for the audit
+// mode, logging and metrics.
+func (h *Handler) RegisterKind(kind string, webhook string, admit AdmitFunc) {
+	if h.kinds == nil {
+		h.kinds = make(map[string]kindHandler)
+	}
+	h.kinds[kind] = kindHandler{
+		webhook: webhook,
+		admit:   admit,
 	}
+}
 
-	unstructuredFIPPool, err := dynamic.Resource(fipGVR).Get(ctx, fip.Spec.FloatingIPPool, metav1.GetOptions{})
+func (h *Handler) admitFloatingIP(ctx context.Context, logger *log.Entry, ar *admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+	fip, oldFIP, err := decodeObjects[rfmv2.FloatingIP](h, ar.Request, "FloatingIP")
 	if err != nil {
-		return &admissionv1.AdmissionResponse{
-			UID:     ar.Request.UID,
-			Allowed: false,
-			Result: &metav1.Status{
-				Message: fmt.Sprintf("the specified floatingippool %s does not exist", fip.Spec.FloatingIPPool),
-			},
-		}
+		return nil, err
 	}
 
-	var fipPool rfmv2.FloatingIPPool
-	err = runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredFIPPool.Object, &fipPool)
-	if err != nil {
-		log.Errorf("failed to convert unstructured FloatingIPPool to typed: %s", err)
-		return &admissionv1.AdmissionResponse{
-			UID:     ar.Request.UID,
-			Allowed: false,
-			Result: &metav1.Status{
-				Message: "internal server error: failed to process floatingippool",
-			},
-		}
+	if ar.Request.SubResource == "status" {
+		return h.validateFloatingIPStatus(logger, ar, fip, oldFIP), nil
 	}
 
-	// 2. IP Availability
-	if fip.Spec.IPAddr != nil {
-		requestedIP := net.ParseIP(*fip.Spec.IPAddr)
-		if requestedIP == nil {
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("invalid IP address format: %s", *fip.Spec.IPAddr),
-				},
-			}
-		}
+	return h.validateFloatingIP(ctx, logger, ar, fip, oldFIP), nil
+}
 
-		// Check if the IP is within the subnet
-		_, subnet, err := net.ParseCIDR(fipPool.Spec.IPConfig.Subnet)
-		if err != nil {
-			log.Errorf("failed to parse subnet %s: %s", fipPool.Spec.IPConfig.Subnet, err)
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: "internal server error: invalid subnet configuration in floatingippool",
-				},
-			}
-		}
-		if !subnet.Contains(requestedIP) {
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("requested IP %s is not in the subnet range %s", *fip.Spec.IPAddr, fipPool.Spec.IPConfig.Subnet),
-				},
-			}
-		}
+func (h *Handler) admitFloatingIPPool(ctx context.Context, logger *log.Entry, ar *admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+	fipPool, oldPool, err := decodeObjects[rfmv2.FloatingIPPool](h, ar.Request, "FloatingIPPool")
+	if err != nil {
+		return nil, err
+	}
 
-		// Check if the IP is within the fipPool.Spec.IPConfig.Pool.Start and fipPool.Spec.IPConfig.Pool.End range
-		startIP := net.ParseIP(fipPool.Spec.IPConfig.Pool.Start)
-		if startIP == nil {
-			log.Errorf("failed to parse start IP %s from floatingippool %s", fipPool.Spec.IPConfig.Pool.Start, fip.Spec.FloatingIPPool)
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("internal server error: invalid start ip configuration in floatingippool %s", fip.Spec.FloatingIPPool),
-				},
-			}
-		}
+	return h.validateFloatingIPPool(ctx, logger, ar, fipPool, oldPool), nil
+}
 
-		endIP := net.ParseIP(fipPool.Spec.IPConfig.Pool.End)
-		if endIP == nil {
-			log.Errorf("failed to parse end IP %s from floatingippool %s", fipPool.Spec.IPConfig.Pool.End, fip.Spec.FloatingIPPool)
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("internal server error: invalid end ip configuration in floatingippool %s", fip.Spec.FloatingIPPool),
-				},
-			}
-		}
+func (h *Handler) admitFloatingIPProjectQuota(ctx context.Context, logger *log.Entry, ar *admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+	quota, oldQuota, err := decodeObjects[rfmv2.FloatingIPProjectQuota](h, ar.Request, "FloatingIPProjectQuota")
+	if err != nil {
+		return nil, err
+	}
 
-		if reqIP4, startIP4, endIP4 := requestedIP.To4(), startIP.To4(), endIP.To4(); reqIP4 != nil && startIP4 != nil && endIP4 != nil {
-			// All are IPv4, compare them.
-			if bytes.Compare(reqIP4, startIP4) < 0 || bytes.Compare(reqIP4, endIP4) > 0 {
-				return &admissionv1.AdmissionResponse{
-					UID:     ar.Request.UID,
-					Allowed: false,
-					Result: &metav1.Status{
-						Message: fmt.Sprintf("requested IP %s is not in the pool range [%s, %s]",
-							*fip.Spec.IPAddr, fipPool.Spec.IPConfig.Pool.Start, fipPool.Spec.IPConfig.Pool.End),
-					},
-				}
-			}
-		} else {
-			// Compare as-is, assuming IPv6 or consistent representation from ParseIP
-			if bytes.Compare(requestedIP, startIP) < 0 || bytes.Compare(requestedIP, endIP) > 0 {
-				return &admissionv1.AdmissionResponse{
-					UID:     ar.Request.UID,
-					Allowed: false,
-					Result: &metav1.Status{
-						Message: fmt.Sprintf("requested IP %s is not in the pool range [%s, %s]",
-							*fip.Spec.IPAddr, fipPool.Spec.IPConfig.Pool.Start, fipPool.Spec.IPConfig.Pool.End),
-					},
-				}
-			}
-		}
+	return h.validateFloatingIPProjectQuota(ctx, logger, ar, quota, oldQuota), nil
+}
 
-		// Check if the IP is in the exclude list
-		for _, excludedIP := range fipPool.Spec.IPConfig.Pool.Exclude {
-			if *fip.Spec.IPAddr == excludedIP {
-				return &admissionv1.AdmissionResponse{
-					UID:     ar.Request.UID,
-					Allowed: false,
-					Result: &metav1.Status{
-						Message: fmt.Sprintf("requested IP %s is in the exclude list", *fip.Spec.IPAddr),
-					},
-				}
-			}
+// decodeReview authenticates the request and decodes its body, which is a
+// review of the API server with the given name, like an AdmissionReview, into
+// review. If it returns false the request is rejected and the response is
+// written.
+func (h *Handler) decodeReview(w http.ResponseWriter, r *http.Request, caller string, name string, review interface{}) bool {
+	if status, err := h.authenticate(r.Context(), r); err != nil {
+		log.Warnf("(%s) rejected request from %s: %s", caller, r.RemoteAddr, err)
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
 		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "%s", err)
+		return false
+	}
 
-		// Check if the IP is already allocated
-		// For UPDATE operations, skip this check if the IP is the same as the old one
-		allocatedIP := *fip.Spec.IPAddr
-		if isUpdate && oldFIP != nil && oldFIP.Status.IPAddr == allocatedIP {
-			// The IP hasn't changed, skip the allocated check
-		} else if _, ok := fipPool.Status.Allocated[allocatedIP]; ok {
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("requested IP %s is already allocated", *fip.Spec.IPAddr),
-				},
-			}
-		}
-	} else {
-		// if no ip is requested, check if there are available ips in the pool
-		if fipPool.Status.Available <= 0 {
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("no available IPs in floatingippool %s", fip.Spec.FloatingIPPool),
-				},
-			}
-		}
+	// the API server always sends JSON
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		log.Warnf("(%s) unsupported content type %q", caller, r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		fmt.Fprintf(w, "unsupported content type %q, expected application/json", r.Header.Get("Content-Type"))
+		return false
 	}
 
-	// Skip quota check if the IP address hasn't changed during an update
-	// For auto-assignment (IPAddr is nil), we still need to check quota
-	shouldCheckQuota := true
-	if isUpdate && oldFIP != nil && fip.Spec.IPAddr != nil {
-		allocatedIP := *fip.Spec.IPAddr
-		if oldFIP.Status.IPAddr == allocatedIP {
-			shouldCheckQuota = false
+	maxBytes := h.options.MaxRequestBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxRequestBytes
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			log.Warnf("(%s) %s exceeds the limit of %d bytes", caller, name, maxBytesErr.Limit)
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			fmt.Fprintf(w, "%s exceeds the limit of %d bytes", name, maxBytesErr.Limit)
+			return false
 		}
+		log.Errorf("cannot decode %s to json: %s", name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "cannot decode %s to json: %s", name, err)
+		return false
 	}
 
-	if shouldCheckQuota {
-		// 3. Project Quota Enforcement
+	return true
+}
 
-		// This sleep prevents Quota usage race conditions when creating multiple FloatingIPs in a short period of time
-		time.Sleep(2 * time.Second)
+// serveAdmission decodes the AdmissionReview, runs the admit function which is
+// registered for the kind and writes the response. If kind is empty the kind
+// of the admission request is used.
+func (h *Handler) serveAdmission(w http.ResponseWriter, r *http.Request, kind string) {
+	ar := &admissionv1.AdmissionReview{}
+	if !h.decodeReview(w, r, "serveAdmission", "AdmissionReview", ar) {
+		return
+	}
+	if ar.Request == nil {
+		log.Errorf("(serveAdmission) AdmissionReview contains no request")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "AdmissionReview contains no request")
+		return
+	}
 
-		projectID := fip.ObjectMeta.Labels["rancher.k8s.binbash.org/project-name"]
+	logger := requestLogger(ar.Request)
+	setAccessLogRequest(r.Context(), ar.Request)
 
-		plbcGVR := schema.GroupVersionResource{
-			Group:    "rancher.k8s.binbash.org",
-			Version:  "v1beta2",
-			Resource: "floatingipprojectquotas",
-		}
-
-		unstructuredPLBC, err := dynamic.Resource(plbcGVR).Get(ctx, projectID, metav1.GetOptions{})
+	if kind == "" {
+		kind = ar.Request.Kind.Kind
+	}
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
+	kh, ok := h.kinds[kind]
+	// a retry of the API server gets the decision of the first call
+	var cached, decision *admissionv1.AdmissionResponse
+	if ok {
+		var release func(*admissionv1.AdmissionResponse)
+		cached, release = h.decisions.Acquire(ctx, ar.Request.UID)
+		defer func() { release(decision) }()
+	}
+	switch {
+	case !ok:
+		logger.Warnf("(serveAdmission) no validator registered for kind %s", kind)
+		ar.Response = denied(ar, fmt.Sprintf("no validator registered for kind %s", kind))
+	case cached != nil:
+		logger.Debugf("(serveAdmission) returning the decision of a previous call of the request")
+		ar.Response = cached
+	case !h.inflight.Acquire(ctx):
+		logger.Warnf("(serveAdmission) rejecting request, the maximum number of requests in flight is reached")
+		metrics.OverloadRejections.WithLabelValues(kh.webhook).Inc()
+		ar.Response = overloaded(ar)
+	default:
+		metrics.InFlightRequests.Inc()
+		admitCtx, claims := withDeniedClaims(ctx)
+		response, err := h.admit(admitCtx, kh, logger, ar)
+		metrics.InFlightRequests.Dec()
+		h.inflight.Release()
 		if err != nil {
-			log.Errorf("failed to get floatingipprojectquota for project %s: %s", projectID, err)
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("failed to get floatingipprojectquota for project %s", projectID),
-				},
-			}
+			h.releaseDeniedClaims(*claims)
+			logger.Errorf("%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%s", err)
+			return
 		}
-
-		var plbc rfmv2.FloatingIPProjectQuota
-		err = runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredPLBC.Object, &plbc)
-		if err != nil {
-			log.Errorf("failed to convert unstructured FloatingIPProjectQuota to typed: %s", err)
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: "internal server error: failed to process floatingipprojectquota",
-				},
-			}
+		ar.Response = response
+		h.recordDecision(kh.webhook, logger, ar.Response)
+		// the IPs claimed by a request which is allowed by the audit mode or
+		// the break-glass mode stay claimed
+		if !ar.Response.Allowed {
+			h.releaseDeniedClaims(*claims)
 		}
-
-		// Check the quota for the specified FloatingIPPool
-		quota, ok := plbc.Spec.FloatingIPQuota[fip.Spec.FloatingIPPool]
-		if !ok {
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("no quota defined for floatingippool %s in project %s", fip.Spec.FloatingIPPool, projectID),
-				},
-			}
+		// responses of failures are not cached, a retry is validated again
+		if _, failed := ar.Response.AuditAnnotations["failure"]; !failed {
+			decision = ar.Response
 		}
+	}
+	setAccessLogDecision(r.Context(), ar.Response)
 
-		// Check the current usage for that pool
-		usage := 0
-		if fipInfo, ok := plbc.Status.FloatingIPs[fip.Spec.FloatingIPPool]; ok {
-			usage = fipInfo.Used
-		}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&ar)
+}
 
-		if usage >= quota {
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("quota exceeded for floatingippool %s in project %s. Quota: %d, Used: %d", fip.Spec.FloatingIPPool, projectID, quota, usage),
-				},
-			}
+// admit runs the admit function of the kind. A panic is recovered and answered
+// according to the InternalFailurePolicy, so the API server gets a well-formed
+// response instead of a dropped connection.
+func (h *Handler) admit(ctx context.Context, kh kindHandler, logger *log.Entry, ar *admissionv1.AdmissionReview) (response *admissionv1.AdmissionResponse, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Errorf("(admit) recovered from a panic in the %s webhook: %v\n%s", kh.webhook, p, debug.Stack())
+			metrics.Panics.WithLabelValues(kh.webhook).Inc()
+			response, err = h.panicResponse(ar), nil
 		}
-	}
+	}()
 
-	return &admissionv1.AdmissionResponse{
-		UID:     ar.Request.UID,
-		Allowed: true,
-	}
+	return kh.admit(ctx, logger, ar)
 }
 
-func validateFloatingIPPool(ctx context.Context, ar *admissionv1.AdmissionReview, fipPool *rfmv2.FloatingIPPool) *admissionv1.AdmissionResponse {
-	// Check if the subnet is valid
-	_, subnet, err := net.ParseCIDR(fipPool.Spec.IPConfig.Subnet)
-	if err != nil {
-		return &admissionv1.AdmissionResponse{
-			UID:     ar.Request.UID,
-			Allowed: false,
-			Result: &metav1.Status{
-				Message: fmt.Sprintf("invalid subnet format: %s", fipPool.Spec.IPConfig.Subnet),
-			},
-		}
+// Review validates the request of an AdmissionReview with the validators of
+// its kind, like the admission endpoints but without the limits of the HTTP
+// server and without applying the audit mode.
+func (h *Handler) Review(ctx context.Context, ar *admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+	if ar.Request == nil {
+		return nil, fmt.Errorf("AdmissionReview contains no request")
 	}
 
-	// Check if the start address is valid and within the subnet
-	startIP := net.ParseIP(fipPool.Spec.IPConfig.Pool.Start)
-	if startIP == nil {
-		return &admissionv1.AdmissionResponse{
-			UID:     ar.Request.UID,
-			Allowed: false,
-			Result: &metav1.Status{
-				Message: fmt.Sprintf("invalid start IP address format: %s", fipPool.Spec.IPConfig.Pool.Start),
-			},
-		}
-	}
-	if !subnet.Contains(startIP) {
-		return &admissionv1.AdmissionResponse{
-			UID:     ar.Request.UID,
-			Allowed: false,
-			Result: &metav1.Status{
-				Message: fmt.Sprintf("start IP address %s is not within the subnet %s", fipPool.Spec.IPConfig.Pool.Start, fipPool.Spec.IPConfig.Subnet),
-			},
-		}
+	kh, ok := h.kinds[ar.Request.Kind.Kind]
+	if !ok {
+		return denied(ar, fmt.Sprintf("no validator registered for kind %s", ar.Request.Kind.Kind)), nil
 	}
 
-	// Check if the end address is valid and within the subnet
-	endIP := net.ParseIP(fipPool.Spec.IPConfig.Pool.End)
-	if endIP == nil {
-		return &admissionv1.AdmissionResponse{
-			UID:     ar.Request.UID,
-			Allowed: false,
-			Result: &metav1.Status{
-				Message: fmt.Sprintf("invalid end IP address format: %s", fipPool.Spec.IPConfig.Pool.End),
-			},
-		}
-	}
-	if !subnet.Contains(endIP) {
-		return &admissionv1.AdmissionResponse{
-			UID:     ar.Request.UID,
-			Allowed: false,
-			Result: &metav1.Status{
-				Message: fmt.Sprintf("end IP address %s is not within the subnet %s", fipPool.Spec.IPConfig.Pool.End, fipPool.Spec.IPConfig.Subnet),
-			},
-		}
-	}
+	return h.admit(ctx, kh, requestLogger(ar.Request), ar)
+}
 
-	// Check that start <= end
-	if startIP4, endIP4 := startIP.To4(), endIP.To4(); startIP4 != nil && endIP4 != nil {
-		// Both are IPv4, compare them
-		if bytes.Compare(startIP4, endIP4) > 0 {
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("start IP address %s must be less than or equal to end IP address %s", fipPool.Spec.IPConfig.Pool.Start, fipPool.Spec.IPConfig.Pool.End),
-				},
-			}
-		}
-	} else {
-		// Compare as-is, assuming IPv6 or consistent representation from ParseIP
-		if bytes.Compare(startIP, endIP) > 0 {
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("start IP address %s must be less than or equal to end IP address %s", fipPool.Spec.IPConfig.Pool.Start, fipPool.Spec.IPConfig.Pool.End),
-				},
-			}
-		}
-	}
+// overloaded returns the response for a request which is rejected because the
+// maximum number of requests in flight is reached.
+func overloaded(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	response := denied(ar, "the webhook is overloaded, try again later")
+	response.Result.Code = http.StatusTooManyRequests
+	response.Result.Reason = metav1.StatusReasonTooManyRequests
+	response.AuditAnnotations = map[string]string{"decision": "denied", "failure": "overloaded"}
+	return response
+}
 
-	// Check if exclude IPs are valid, within the subnet and between the start and end IP
-	for _, excludedIPStr := range fipPool.Spec.IPConfig.Pool.Exclude {
-		excludedIP := net.ParseIP(excludedIPStr)
-		if excludedIP == nil {
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("invalid excluded IP address format: %s", excludedIPStr),
-				},
-			}
-		}
-		if !subnet.Contains(excludedIP) {
-			return &admissionv1.AdmissionResponse{
-				UID:     ar.Request.UID,
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("excluded IP address %s is not within the subnet %s", excludedIPStr, fipPool.Spec.IPConfig.Subnet),
-				},
-			}
-		}
-		// Check if excluded IP is outside the pool range [startIP, endIP]
-		if startIP4, endIP4, excludedIP4 := startIP.To4(), endIP.To4(), excludedIP.To4(); startIP4 != nil && endIP4 != nil && excludedIP4 != nil {
-			// All are IPv4, compare them
-			if bytes.Compare(excludedIP4, startIP4) < 0 || bytes.Compare(excludedIP4, endIP4) > 0 {
-				return &admissionv1.AdmissionResponse{
-					UID:     ar.Request.UID,
-					Allowed: false,
-					Result: &metav1.Status{
-						Message: fmt.Sprintf("excluded IP address %s is not within the pool range [%s, %s]", excludedIPStr, fipPool.Spec.IPConfig.Pool.Start, fipPool.Spec.IPConfig.Pool.End),
-					},
-				}
-			}
-		} else {
-			// Compare as-is, assuming IPv6 or consistent representation from ParseIP
-			if bytes.Compare(excludedIP, startIP) < 0 || bytes.Compare(excludedIP, endIP) > 0 {
-				return &admissionv1.AdmissionResponse{
-					UID:     ar.Request.UID,
-					Allowed: false,
-					Result: &metav1.Status{
-						Message: fmt.Sprintf("excluded IP address %s is not within the pool range [%s, %s]", excludedIPStr, fipPool.Spec.IPConfig.Pool.Start, fipPool.Spec.IPConfig.Pool.End),
-					},
-				}
-			}
-		}
+// panicResponse returns the response for a request whose validation panicked.
+func (h *Handler) panicResponse(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	if h.options.InternalFailurePolicy == admregv1.Ignore {
+		response := allowed(ar)
+		response.Warnings = []string{"the webhook failed to validate the request, it is allowed by the internal failure policy"}
+		response.AuditAnnotations = map[string]string{"decision": "allowed", "failure": "panic"}
+		return response
 	}
 
-	return &admissionv1.AdmissionResponse{
-		UID:     ar.Request.UID,
-		Allowed: true,
-	}
+	response := denied(ar, "internal server error: the webhook failed to validate the request")
+	response.AuditAnnotations = map[string]string{"decision": "denied", "failure": "panic"}
+	return response
+}
+
+func (h *Handler) validateAdmission(w http.ResponseWriter, r *http.Request) {
+	h.serveAdmission(w, r, "")
 }
 
 func (h *Handler) validateFloatingIPAdmission(w http.ResponseWriter, r *http.Request) {
-	ar := &admissionv1.AdmissionReview{}
-	if err := json.NewDecoder(r.Body).Decode(&ar); err != nil {
-		log.Errorf("cannot decode AdmissionReview to json: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "cannot decode AdmissionReview to json: %s", err)
-		return
-	}
+	h.serveAdmission(w, r, "FloatingIP")
+}
+
+func (h *Handler) validateFloatingIPPoolAdmission(w http.ResponseWriter, r *http.Request) {
+	h.serveAdmission(w, r, "FloatingIPPool")
+}
 
-	fip := &rfmv2.FloatingIP{}
-	if err := json.Unmarshal(ar.Request.Object.Raw, &fip); err != nil {
-		log.Errorf("cannot unmarshal json to FloatingIP: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "cannot unmarshal json to FloatingIP: %s", err)
+// requestLogger returns a logger which carries the identity of the admission request as fields.
+func requestLogger(req *admissionv1.AdmissionRequest) *log.Entry {
+	return log.WithFields(log.Fields{
+		"uid":       req.UID,
+		"kind":      req.Kind.Kind,
+		"resource":  req.Resource.Resource,
+		"namespace": req.Namespace,
+		"name":      req.Name,
+		"operation": req.Operation,
+		"user":      req.UserInfo.Username,
+	})
+}
+
+// recordDecision logs and counts the admission decision of the webhook. If the
+// webhook runs in audit mode, a denied response is turned into an allowed
+// response which carries the denial reason as a warning.
+func (h *Handler) recordDecision(webhook string, logger *log.Entry, response *admissionv1.AdmissionResponse) {
+	logger = logger.WithField("webhook", webhook)
+
+	if response.Allowed {
+		logger.WithField("decision", "allowed").Debugf("(recordDecision) request allowed")
+		metrics.AdmissionRequests.WithLabelValues(webhook, "allowed").Inc()
 		return
 	}
 
-	// Handle UPDATE operations by extracting the old object
-	var oldFIP *rfmv2.FloatingIP
-	if ar.Request.Operation == admissionv1.Update && ar.Request.OldObject.Raw != nil {
-		oldFIP = &rfmv2.FloatingIP{}
-		if err := json.Unmarshal(ar.Request.OldObject.Raw, oldFIP); err != nil {
-			log.Errorf("cannot unmarshal json to old FloatingIP: %s", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "cannot unmarshal json to old FloatingIP: %s", err)
-			return
-		}
+	if state := h.breakGlass.Load(); state != nil {
+		logger.WithField("decision", "break-glass").Warnf("(recordDecision) break-glass mode is enabled, allowing request which is not allowed: %s", response.Result.Message)
+		metrics.AdmissionRequests.WithLabelValues(webhook, "break-glass").Inc()
+		metrics.BreakGlassDenials.WithLabelValues(webhook).Inc()
+		allowDenied(response, "break-glass", "break-glass mode")
+		return
 	}
 
-	ar.Response = validateFloatingIP(r.Context(), h.dynamic, ar, fip, oldFIP, h)
-	if !ar.Response.Allowed {
-		log.Warnf("(validateFloatingIPAdmission) request not allowed: %s", ar.Response.Result.Message)
+	if !h.options.AuditMode[webhook] {
+		logger.WithField("decision", "denied").Warnf("(recordDecision) request not allowed: %s", response.Result.Message)
+		metrics.AdmissionRequests.WithLabelValues(webhook, "denied").Inc()
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(&ar)
+	logger.WithField("decision", "audited").Warnf("(recordDecision) audit mode is enabled, allowing request which is not allowed: %s", response.Result.Message)
+	metrics.AdmissionRequests.WithLabelValues(webhook, "audited").Inc()
+	metrics.AuditDenials.WithLabelValues(webhook).Inc()
+	allowDenied(response, "audited", "audit mode")
 }
 
-func (h *Handler) validateFloatingIPPoolAdmission(w http.ResponseWriter, r *http.Request) {
-	ar := &admissionv1.AdmissionReview{}
-	if err := json.NewDecoder(r.Body).Decode(&ar); err != nil {
-		log.Errorf("cannot decode AdmissionReview to json: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "cannot decode AdmissionReview to json: %s", err)
-		return
+// allowDenied turns a denied response into an allowed response with a warning
+// which contains the denial.
+func allowDenied(response *admissionv1.AdmissionResponse, decision string, mode string) {
+	response.Allowed = true
+	if response.AuditAnnotations != nil {
+		response.AuditAnnotations["decision"] = decision
 	}
+	response.Warnings = append(response.Warnings, fmt.Sprintf("%s: request would have been denied: %s", mode, response.Result.Message))
+	response.Result = nil
+}
 
-	fipPool := &rfmv2.FloatingIPPool{}
-	if err := json.Unmarshal(ar.Request.Object.Raw, &fipPool); err != nil {
-		log.Errorf("cannot unmarshal json to FloatingIPPool: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "cannot unmarshal json to FloatingIPPool: %s", err)
-		return
+// Watch starts the background watches of the handler which run until the
+// context of the handler is done. It is called once, before Run, so the
+// watches are not started again when the webhook server is restarted.
+func (h *Handler) Watch() {
+	if h.options.BreakGlassConfigMap.Name != "" {
+		go h.watchBreakGlass(h.ctx, breakGlassRefreshInterval)
 	}
-
-	ar.Response = validateFloatingIPPool(r.Context(), ar, fipPool)
-	if !ar.Response.Allowed {
-		log.Warnf("(validateFloatingIPPoolAdmission) request not allowed: %s", ar.Response.Result.Message)
+	if h.pools != nil {
+		go h.watchPools(h.ctx)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(&ar)
 }
 
 func (h *Handler) Run() {
-	homedir := os.Getenv("HOME")
-	keyPath := fmt.Sprintf("%s/tls.key", homedir)
-	certPath := fmt.Sprintf("%s/tls.crt", homedir)
+	if h.options.Server == ServerControllerRuntime {
+		h.runWebhookServer()
+		return
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) { w.Write([]byte("ok")) })
-	mux.HandleFunc("/validate-floatingip", h.validateFloatingIPAdmission)
-	mux.HandleFunc("/validate-floatingippool", h.validateFloatingIPPoolAdmission)
+	h.registerHandlers(mux.Handle)
 
-	h.httpServer = &http.Server{
-		Addr:           ":8443",
-		Handler:        mux,
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
-		MaxHeaderBytes: 1 << 20, // 1048576
-	}
+	h.httpServer = h.newHTTPServer(mux)
 
-	if err := h.httpServer.ListenAndServeTLS(certPath, keyPath); err != nil {
+	health.Beat(HealthComponent, 0)
+	if err := h.httpServer.ListenAndServeTLS("", ""); err != nil {
 		if err != http.ErrServerClosed {
 			log.Errorf("HTTP server error: %v", err)
+			health.Fail(HealthComponent, err)
 		}
 	}
 }
 
+// registerHandlers registers the endpoints of the webhook with the handle
+// function of the server.
+func (h *Handler) registerHandlers(handle func(pattern string, handler http.Handler)) {
+	handle("/readyz", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { w.Write([]byte("ok")) }))
+	handle("/livez", http.HandlerFunc(livez))
+	handle("/metrics", metrics.Handler())
+	handle("/version", http.HandlerFunc(versionInfo))
+	handle("/validate", h.accessLog(h.validateAdmission))
+	handle("/validate-floatingip", h.accessLog(h.validateFloatingIPAdmission))
+	handle("/validate-floatingippool", h.accessLog(h.validateFloatingIPPoolAdmission))
+	if h.options.Conversion {
+		handle("/convert", h.accessLog(h.serveConversion))
+	}
+	if h.options.PreviewAPI {
+		handle("GET /preview/quota/{project}", h.accessLog(h.previewQuota))
+		handle("GET /preview/pool/{pool}", h.accessLog(h.previewPool))
+	}
+	if h.options.SelfTest {
+		handle("/selftest", h.accessLog(h.selfTest))
+	}
+}
+
+// newHTTPServer returns the webhook server with the timeouts and protocols of
+// the options.
+func (h *Handler) newHTTPServer(handler http.Handler) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(!h.options.DisableHTTP2)
+
+	return &http.Server{
+		Addr:              h.options.Address,
+		Handler:           handler,
+		ReadTimeout:       h.options.ReadTimeout,
+		WriteTimeout:      h.options.WriteTimeout,
+		IdleTimeout:       h.options.IdleTimeout,
+		ReadHeaderTimeout: h.options.ReadHeaderTimeout,
+		MaxHeaderBytes:    1 << 20, // 1048576
+		Protocols:         protocols,
+		TLSConfig: &tls.Config{
+			GetCertificate: h.options.GetCertificate,
+		},
+	}
+}
+
+// livez reports if all long running components are alive, so a wedged
+// webhook pod is restarted by Kubernetes.
+func livez(w http.ResponseWriter, req *http.Request) {
+	if err := health.Check(); err != nil {
+		log.Errorf("(livez) liveness check failed: %s", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
+
+// versionInfo serves the build information of the webhook.
+func versionInfo(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(version.Get()); err != nil {
+		log.Errorf("(versionInfo) cannot encode version info: %s", err)
+	}
+}
+
 func (h *Handler) Stop() error {
+	if h.options.Server == ServerControllerRuntime {
+		return h.stopWebhookServer()
+	}
+
 	return h.httpServer.Shutdown(h.ctx)
 }
@@ -1,16 +1,35 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/version"
 	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	admissionv1 "k8s.io/api/admission/v1"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestValidateFloatingIP(t *testing.T) {
@@ -82,6 +101,7 @@ func TestValidateFloatingIP(t *testing.T) {
 		existingFIPs    []runtime.Object
 		expectedAllowed bool
 		expectedMessage string
+		expectedQuota   string
 	}{
 		{
 			name:            "pool does not exist",
@@ -91,6 +111,25 @@ func TestValidateFloatingIP(t *testing.T) {
 			expectedAllowed: false,
 			expectedMessage: "the specified floatingippool test-pool does not exist",
 		},
+		{
+			name: "pool is being deleted",
+			fip:  fip,
+			existingPools: []runtime.Object{
+				&rfmv2.FloatingIPPool{
+					TypeMeta: fipPool.TypeMeta,
+					ObjectMeta: metav1.ObjectMeta{
+						Name:              "test-pool",
+						DeletionTimestamp: &metav1.Time{Time: time.Now()},
+						Finalizers:        []string{"rancher.k8s.binbash.org/finalizer"},
+					},
+					Spec:   fipPool.Spec,
+					Status: fipPool.Status,
+				},
+			},
+			existingPLBCs:   []runtime.Object{plbc},
+			expectedAllowed: false,
+			expectedMessage: "floatingippool test-pool is being decommissioned and doesn't accept new FloatingIPs",
+		},
 		{
 			name: "invalid ip address",
 			fip: &rfmv2.FloatingIP{
@@ -119,6 +158,20 @@ func TestValidateFloatingIP(t *testing.T) {
 			expectedAllowed: false,
 			expectedMessage: "requested IP 192.168.2.1 is not in the subnet range 192.168.1.0/24",
 		},
+		{
+			name: "ip of another family",
+			fip: &rfmv2.FloatingIP{
+				ObjectMeta: fip.ObjectMeta,
+				Spec: rfmv2.FloatingIPSpec{
+					FloatingIPPool: "test-pool",
+					IPAddr:         func() *string { s := "2001:db8::1"; return &s }(),
+				},
+			},
+			existingPools:   []runtime.Object{fipPool},
+			existingPLBCs:   []runtime.Object{plbc},
+			expectedAllowed: false,
+			expectedMessage: "floatingippool test-pool is IPv4, requested address 2001:db8::1 is IPv6",
+		},
 		{
 			name: "ip in exclude list",
 			fip: &rfmv2.FloatingIP{
@@ -203,6 +256,7 @@ func TestValidateFloatingIP(t *testing.T) {
 			},
 			expectedAllowed: false,
 			expectedMessage: "quota exceeded for floatingippool test-pool in project test-project. Quota: 1, Used: 1",
+			expectedQuota:   "1",
 		},
 		{
 			name: "valid request",
@@ -233,12 +287,25 @@ func TestValidateFloatingIP(t *testing.T) {
 			allObjects := append(unstructuredPools, unstructuredPLBCs...)
 			allObjects = append(allObjects, unstructuredFIPs...)
 			dynamicClient := fake.NewSimpleDynamicClient(runtime.NewScheme(), allObjects...)
+			h := &Handler{
+				dynamic:       dynamicClient,
+				fipValidators: DefaultFloatingIPValidators(),
+			}
 
-			response := validateFloatingIP(context.Background(), dynamicClient, ar, tc.fip, nil, nil)
+			response := h.validateFloatingIP(context.Background(), requestLogger(ar.Request), ar, tc.fip, nil)
 
 			assert.Equal(t, tc.expectedAllowed, response.Allowed)
+			assert.Equal(t, "test-pool", response.AuditAnnotations["pool"])
 			if !tc.expectedAllowed {
 				assert.Equal(t, tc.expectedMessage, response.Result.Message)
+				assert.Equal(t, "denied", response.AuditAnnotations["decision"])
+				// the quota is only recorded when the pool has a quota
+				assert.Equal(t, tc.expectedQuota, response.AuditAnnotations["quota"])
+			} else {
+				assert.Equal(t, "allowed", response.AuditAnnotations["decision"])
+				assert.Equal(t, "test-project", response.AuditAnnotations["project"])
+				assert.Equal(t, "1", response.AuditAnnotations["quota"])
+				assert.Equal(t, "0", response.AuditAnnotations["quota-used"])
 			}
 		})
 	}
@@ -457,6 +524,25 @@ func TestValidateFloatingIPPool(t *testing.T) {
 			expectedAllowed: false,
 			expectedMessage: "excluded IP address 192.168.1.25 is not within the pool range [192.168.1.10, 192.168.1.20]",
 		},
+		{
+			name: "duplicate excluded IP",
+			fipPool: &rfmv2.FloatingIPPool{
+				TypeMeta:   validFipPool.TypeMeta,
+				ObjectMeta: validFipPool.ObjectMeta,
+				Spec: rfmv2.FloatingIPPoolSpec{
+					IPConfig: &rfmv2.IPConfig{
+						Subnet: "192.168.1.0/24",
+						Pool: rfmv2.Pool{
+							Start:   "192.168.1.10",
+							End:     "192.168.1.20",
+							Exclude: []string{"192.168.1.15", "192.168.1.18", "192.168.1.15"},
+						},
+					},
+				},
+			},
+			expectedAllowed: false,
+			expectedMessage: "excluded IP address 192.168.1.15 is listed more than once",
+		},
 		{
 			name:            "valid request",
 			fipPool:         validFipPool,
@@ -526,7 +612,11 @@ func TestValidateFloatingIPPool(t *testing.T) {
 				},
 			}
 
-			response := validateFloatingIPPool(context.Background(), ar, tc.fipPool)
+			h := &Handler{
+				fipPoolValidators: DefaultFloatingIPPoolValidators(),
+			}
+
+			response := h.validateFloatingIPPool(context.Background(), requestLogger(ar.Request), ar, tc.fipPool, nil)
 
 			assert.Equal(t, tc.expectedAllowed, response.Allowed)
 			if !tc.expectedAllowed {
@@ -536,6 +626,317 @@ func TestValidateFloatingIPPool(t *testing.T) {
 	}
 }
 
+type denyAllValidator struct{}
+
+func (v *denyAllValidator) Name() string { return "DenyAll" }
+
+func (v *denyAllValidator) Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error {
+	return fmt.Errorf("denied by custom validator")
+}
+
+func TestValidatorRegistration(t *testing.T) {
+	h := &Handler{
+		fipValidators:     DefaultFloatingIPValidators(),
+		fipPoolValidators: DefaultFloatingIPPoolValidators(),
+	}
+	ar := &admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID: "test-uid",
+		},
+	}
+	fipPool := &rfmv2.FloatingIPPool{
+		Spec: rfmv2.FloatingIPPoolSpec{
+			IPConfig: &rfmv2.IPConfig{
+				Subnet: "192.168.1.0/24",
+				Pool: rfmv2.Pool{
+					Start: "192.168.1.10",
+					End:   "192.168.1.20",
+				},
+			},
+		},
+	}
+
+	h.RegisterFloatingIPPoolValidator(&denyAllValidator{})
+	response := h.validateFloatingIPPool(context.Background(), requestLogger(ar.Request), ar, fipPool, nil)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "denied by custom validator", response.Result.Message)
+
+	h.DisableValidator("DenyAll")
+	response = h.validateFloatingIPPool(context.Background(), requestLogger(ar.Request), ar, fipPool, nil)
+	assert.True(t, response.Allowed)
+
+	h.DisableValidator("QuotaCheck")
+	for _, v := range h.fipValidators {
+		assert.NotEqual(t, "QuotaCheck", v.Name())
+	}
+	assert.Len(t, h.fipValidators, len(DefaultFloatingIPValidators())-1)
+}
+
+func TestRecordDecisionAuditMode(t *testing.T) {
+	h := &Handler{
+		options: Options{
+			AuditMode: map[string]bool{WebhookFloatingIP: true},
+		},
+	}
+
+	response := &admissionv1.AdmissionResponse{
+		UID:     "test-uid",
+		Allowed: false,
+		Result:  &metav1.Status{Message: "quota exceeded"},
+	}
+	h.recordDecision(WebhookFloatingIP, log.NewEntry(log.StandardLogger()), response)
+	assert.True(t, response.Allowed)
+	assert.Nil(t, response.Result)
+	assert.Equal(t, []string{"audit mode: request would have been denied: quota exceeded"}, response.Warnings)
+
+	response = &admissionv1.AdmissionResponse{
+		UID:     "test-uid",
+		Allowed: false,
+		Result:  &metav1.Status{Message: "invalid subnet format: foo"},
+	}
+	h.recordDecision(WebhookFloatingIPPool, log.NewEntry(log.StandardLogger()), response)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "invalid subnet format: foo", response.Result.Message)
+}
+
+func TestValidateAdmissionDispatch(t *testing.T) {
+	h := &Handler{
+		fipPoolValidators: DefaultFloatingIPPoolValidators(),
+	}
+	h.RegisterKind("FloatingIPPool", WebhookFloatingIPPool, h.admitFloatingIPPool)
+
+	fipPool := &rfmv2.FloatingIPPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pool",
+		},
+		Spec: rfmv2.FloatingIPPoolSpec{
+			IPConfig: &rfmv2.IPConfig{
+				Subnet: "192.168.1.0/24",
+				Pool: rfmv2.Pool{
+					Start: "192.168.1.20",
+					End:   "192.168.1.10",
+				},
+			},
+		},
+	}
+	raw, err := json.Marshal(fipPool)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name            string
+		kind            string
+		expectedMessage string
+	}{
+		{
+			name:            "registered kind",
+			kind:            "FloatingIPPool",
+			expectedMessage: "start IP address 192.168.1.20 must be less than or equal to end IP address 192.168.1.10",
+		},
+		{
+			name:            "unregistered kind",
+			kind:            "Service",
+			expectedMessage: "no validator registered for kind Service",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ar := &admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					UID:    "test-uid",
+					Kind:   metav1.GroupVersionKind{Kind: tc.kind},
+					Object: runtime.RawExtension{Raw: raw},
+				},
+			}
+			body, err := json.Marshal(ar)
+			assert.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			h.validateAdmission(w, req)
+
+			response := &admissionv1.AdmissionReview{}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(response))
+			assert.False(t, response.Response.Allowed)
+			assert.Equal(t, tc.expectedMessage, response.Response.Result.Message)
+		})
+	}
+}
+
+func TestServeAdmissionMalformedRequests(t *testing.T) {
+	h := &Handler{options: Options{MaxRequestBytes: 64}}
+	h.RegisterKind("FloatingIPPool", WebhookFloatingIPPool, h.admitFloatingIPPool)
+
+	testCases := []struct {
+		name           string
+		contentType    string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "missing content type",
+			body:           `{}`,
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "wrong content type",
+			contentType:    "application/yaml",
+			body:           `{}`,
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "body too large",
+			contentType:    "application/json",
+			body:           `{"request":{"uid":"` + strings.Repeat("a", 64) + `"}}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "invalid json",
+			contentType:    "application/json; charset=utf-8",
+			body:           `{"request":`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "missing request",
+			contentType:    "application/json",
+			body:           `{}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			w := httptest.NewRecorder()
+			h.validateAdmission(w, req)
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestReview(t *testing.T) {
+	h := &Handler{
+		options:           Options{AuditMode: map[string]bool{WebhookFloatingIPPool: true}},
+		fipPoolValidators: DefaultFloatingIPPoolValidators(),
+	}
+	h.RegisterKind("FloatingIPPool", WebhookFloatingIPPool, h.admitFloatingIPPool)
+
+	raw, err := json.Marshal(&rfmv2.FloatingIPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pool"},
+		Spec: rfmv2.FloatingIPPoolSpec{
+			IPConfig: &rfmv2.IPConfig{
+				Subnet: "192.168.1.0/24",
+				Pool:   rfmv2.Pool{Start: "192.168.1.20", End: "192.168.1.10"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	ar := &admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:    "test-uid",
+			Kind:   metav1.GroupVersionKind{Kind: "FloatingIPPool"},
+			Object: runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	// the audit mode is not applied
+	response, err := h.Review(context.Background(), ar)
+	assert.NoError(t, err)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "PoolRangeValid", response.AuditAnnotations["denied-by"])
+
+	ar.Request.Kind.Kind = "Cluster"
+	response, err = h.Review(context.Background(), ar)
+	assert.NoError(t, err)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "no validator registered for kind Cluster", response.Result.Message)
+
+	_, err = h.Review(context.Background(), &admissionv1.AdmissionReview{})
+	assert.EqualError(t, err, "AdmissionReview contains no request")
+}
+
+func TestServeAdmissionRecoversPanics(t *testing.T) {
+	testCases := []struct {
+		name            string
+		failurePolicy   admregv1.FailurePolicyType
+		expectedAllowed bool
+	}{
+		{
+			name: "default failure policy",
+		},
+		{
+			name:          "fail",
+			failurePolicy: admregv1.Fail,
+		},
+		{
+			name:            "ignore",
+			failurePolicy:   admregv1.Ignore,
+			expectedAllowed: true,
+		},
+	}
+
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:  "test-uid",
+			Kind: metav1.GroupVersionKind{Kind: "FloatingIP"},
+		},
+	})
+	assert.NoError(t, err)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{options: Options{InternalFailurePolicy: tc.failurePolicy}}
+			h.RegisterKind("FloatingIP", WebhookFloatingIP, func(ctx context.Context, logger *log.Entry, ar *admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+				panic("validator bug")
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			h.validateAdmission(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			response := &admissionv1.AdmissionReview{}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(response))
+			assert.Equal(t, types.UID("test-uid"), response.Response.UID)
+			assert.Equal(t, tc.expectedAllowed, response.Response.Allowed)
+			assert.Equal(t, "panic", response.Response.AuditAnnotations["failure"])
+			if !tc.expectedAllowed {
+				assert.Equal(t, "internal server error: the webhook failed to validate the request", response.Response.Result.Message)
+			}
+		})
+	}
+}
+
+// testPool returns a FloatingIPPool named test-pool with the given range.
+func testPool(subnet string, start string, end string, exclude ...string) *rfmv2.FloatingIPPool {
+	return &rfmv2.FloatingIPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pool"},
+		Spec: rfmv2.FloatingIPPoolSpec{
+			IPConfig: &rfmv2.IPConfig{
+				Subnet: subnet,
+				Pool:   rfmv2.Pool{Start: start, End: end, Exclude: exclude},
+			},
+		},
+	}
+}
+
+// testNamespace returns a namespace with the given field.cattle.io/projectId
+// annotation, of the form "cluster:project". The namespace is not in a project
+// when it is empty.
+func testNamespace(name string, projectID string) *corev1.Namespace {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if projectID != "" {
+		ns.Annotations = map[string]string{ProjectIDAnnotation: projectID}
+	}
+	return ns
+}
+
 func getUnstructuredList(objects []runtime.Object) ([]runtime.Object, error) {
 	unstructuredList := []runtime.Object{}
 	for _, obj := range objects {
@@ -547,3 +948,749 @@ func getUnstructuredList(objects []runtime.Object) ([]runtime.Object, error) {
 	}
 	return unstructuredList, nil
 }
+
+func TestVersionInfo(t *testing.T) {
+	rec := httptest.NewRecorder()
+	versionInfo(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var info version.Info
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, version.Get(), info)
+}
+
+func TestProjectLabel(t *testing.T) {
+	clientset := kubefake.NewSimpleClientset(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "team-a",
+			Annotations: map[string]string{ProjectIDAnnotation: "c-abcde:p-fghij"},
+		},
+	})
+	testCases := []struct {
+		name              string
+		fromNamespace     bool
+		namespace         string
+		labels            map[string]string
+		expectedProjectID string
+		expectedError     string
+	}{
+		{
+			name:              "label present",
+			namespace:         "team-a",
+			labels:            map[string]string{ProjectNameLabel: "p-fghij"},
+			expectedProjectID: "p-fghij",
+		},
+		{
+			name:          "label missing suggests the project of the namespace",
+			namespace:     "team-a",
+			expectedError: "FloatingIP must carry the rancher.k8s.binbash.org/project-name label, the project of namespace team-a is p-fghij",
+		},
+		{
+			name:          "label missing in a namespace without project",
+			namespace:     "unknown",
+			expectedError: "FloatingIP must carry the rancher.k8s.binbash.org/project-name label",
+		},
+		{
+			name:              "label missing resolves the project of the namespace",
+			fromNamespace:     true,
+			namespace:         "team-a",
+			expectedProjectID: "p-fghij",
+		},
+		{
+			name:          "label missing in a namespace without project is denied when resolving",
+			fromNamespace: true,
+			namespace:     "unknown",
+			expectedError: "FloatingIP must carry the rancher.k8s.binbash.org/project-name label",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				clientset: clientset,
+				options:   Options{ProjectFromNamespace: tc.fromNamespace},
+			}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP: &rfmv2.FloatingIP{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: tc.namespace, Labels: tc.labels},
+				},
+			}
+
+			err := (&ProjectLabel{}).Validate(context.Background(), h, req)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedProjectID, req.ProjectID)
+		})
+	}
+}
+
+func TestIPImmutable(t *testing.T) {
+	ipAddr := "2001:db8::10"
+	sameIPAddr := "2001:0db8::10"
+	otherIPAddr := "2001:db8::11"
+	fip := func(ip *string) *rfmv2.FloatingIP {
+		return &rfmv2.FloatingIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default"},
+			Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool", IPAddr: ip},
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		fip           *rfmv2.FloatingIP
+		oldFIP        *rfmv2.FloatingIP
+		expectedError string
+	}{
+		{
+			name: "create",
+			fip:  fip(&ipAddr),
+		},
+		{
+			name:   "ip is set on update",
+			fip:    fip(&ipAddr),
+			oldFIP: fip(nil),
+		},
+		{
+			name:   "ip is unchanged in another notation",
+			fip:    fip(&sameIPAddr),
+			oldFIP: fip(&ipAddr),
+		},
+		{
+			name:          "ip is changed",
+			fip:           fip(&otherIPAddr),
+			oldFIP:        fip(&ipAddr),
+			expectedError: "spec.ipAddr of FloatingIP default/test-fip cannot be changed from 2001:db8::10 to 2001:db8::11, delete and recreate the FloatingIP to use another IP",
+		},
+		{
+			name:          "ip is removed",
+			fip:           fip(nil),
+			oldFIP:        fip(&ipAddr),
+			expectedError: "spec.ipAddr of FloatingIP default/test-fip cannot be removed, delete and recreate the FloatingIP to release IP 2001:db8::10",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP:     tc.fip,
+				OldFIP:  tc.oldFIP,
+			}
+
+			err := (&IPImmutable{}).Validate(context.Background(), &Handler{}, req)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPoolCapacityConsistent(t *testing.T) {
+	allocatedPool := func(start string, end string, exclude []string, available int) *rfmv2.FloatingIPPool {
+		pool := testPool("192.168.1.0/24", start, end, exclude...)
+		pool.Status = rfmv2.FloatingIPPoolStatus{
+			Allocated: map[string]string{
+				"192.168.1.12": "p-12345 [Project]",
+				"192.168.1.15": "excluded",
+			},
+			Available: available,
+		}
+		return pool
+	}
+	oldPool := allocatedPool("192.168.1.10", "192.168.1.20", []string{"192.168.1.15"}, 9)
+
+	testCases := []struct {
+		name             string
+		pool             *rfmv2.FloatingIPPool
+		oldPool          *rfmv2.FloatingIPPool
+		expectedAllowed  bool
+		expectedMessage  string
+		expectedWarnings []string
+	}{
+		{
+			name:            "create",
+			pool:            allocatedPool("192.168.1.13", "192.168.1.20", nil, 0),
+			expectedAllowed: true,
+		},
+		{
+			name:            "range is extended",
+			pool:            allocatedPool("192.168.1.10", "192.168.1.30", []string{"192.168.1.15"}, 9),
+			oldPool:         oldPool,
+			expectedAllowed: true,
+		},
+		{
+			name:            "allocated IP is outside the new range",
+			pool:            allocatedPool("192.168.1.13", "192.168.1.20", nil, 9),
+			oldPool:         oldPool,
+			expectedMessage: "allocated IP 192.168.1.12 (p-12345 [Project]) would not be within the pool range [192.168.1.13, 192.168.1.20], release it first",
+		},
+		{
+			name:            "allocated IP is excluded",
+			pool:            allocatedPool("192.168.1.10", "192.168.1.20", []string{"192.168.1.12", "192.168.1.15"}, 9),
+			oldPool:         oldPool,
+			expectedMessage: "allocated IP 192.168.1.12 (p-12345 [Project]) cannot be excluded, release it first",
+		},
+		{
+			name:            "available count is inconsistent",
+			pool:            allocatedPool("192.168.1.10", "192.168.1.20", []string{"192.168.1.15"}, 10),
+			oldPool:         allocatedPool("192.168.1.10", "192.168.1.20", []string{"192.168.1.15"}, 10),
+			expectedAllowed: true,
+			expectedWarnings: []string{
+				"status of floatingippool test-pool reports 10 available IPs, but only 9 of 10 IPs can be free with 1 allocated IPs",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				fipPoolValidators: DefaultFloatingIPPoolValidators(),
+			}
+			ar := &admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					UID: "test-uid",
+				},
+			}
+
+			response := h.validateFloatingIPPool(context.Background(), requestLogger(ar.Request), ar, tc.pool, tc.oldPool)
+
+			assert.Equal(t, tc.expectedAllowed, response.Allowed)
+			if !tc.expectedAllowed {
+				assert.Equal(t, tc.expectedMessage, response.Result.Message)
+				assert.Equal(t, "PoolCapacityConsistent", response.AuditAnnotations["denied-by"])
+				return
+			}
+			assert.Equal(t, tc.expectedWarnings, response.Warnings)
+		})
+	}
+}
+
+func TestPoolSizeLimit(t *testing.T) {
+	testCases := []struct {
+		name            string
+		maxPoolSize     int64
+		pool            *rfmv2.FloatingIPPool
+		oldPool         *rfmv2.FloatingIPPool
+		expectedMessage string
+	}{
+		{
+			name: "not limited",
+			pool: testPool("10.0.0.0/8", "10.0.0.0", "10.255.255.255"),
+		},
+		{
+			name:        "within the limit",
+			maxPoolSize: 256,
+			pool:        testPool("10.0.0.0/8", "10.0.0.0", "10.0.0.255"),
+		},
+		{
+			name:            "exceeds the limit",
+			maxPoolSize:     256,
+			pool:            testPool("10.0.0.0/8", "10.0.0.0", "10.255.255.255"),
+			expectedMessage: "pool range [10.0.0.0, 10.255.255.255] contains 16777216 IP addresses, which exceeds the maximum pool size of 256",
+		},
+		{
+			name:        "existing range is unchanged",
+			maxPoolSize: 256,
+			pool:        testPool("10.0.0.0/8", "10.0.0.0", "10.255.255.255"),
+			oldPool:     testPool("10.0.0.0/8", "10.0.0.0", "10.255.255.255"),
+		},
+		{
+			name:            "existing range is extended",
+			maxPoolSize:     256,
+			pool:            testPool("10.0.0.0/8", "10.0.0.0", "10.0.1.255"),
+			oldPool:         testPool("10.0.0.0/8", "10.0.0.0", "10.0.0.255"),
+			expectedMessage: "pool range [10.0.0.0, 10.0.1.255] contains 512 IP addresses, which exceeds the maximum pool size of 256",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				options: Options{MaxPoolSize: tc.maxPoolSize},
+			}
+			req := &FloatingIPPoolRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				Pool:    tc.pool,
+				OldPool: tc.oldPool,
+			}
+
+			err := (&PoolSizeLimit{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPoolNotForbidden(t *testing.T) {
+	testCases := []struct {
+		name            string
+		forbiddenRanges []*net.IPNet
+		pool            *rfmv2.FloatingIPPool
+		oldPool         *rfmv2.FloatingIPPool
+		expectedMessage string
+	}{
+		{
+			name:            "documentation range",
+			forbiddenRanges: validator.DefaultForbiddenRanges(),
+			pool:            testPool("192.0.2.0/24", "192.0.2.10", "192.0.2.20"),
+			expectedMessage: "pool range [192.0.2.10, 192.0.2.20] overlaps with the special-use range 192.0.2.0/24",
+		},
+		{
+			name:            "check disabled",
+			forbiddenRanges: []*net.IPNet{},
+			pool:            testPool("192.0.2.0/24", "192.0.2.10", "192.0.2.20"),
+		},
+		{
+			name:            "existing range is unchanged",
+			forbiddenRanges: validator.DefaultForbiddenRanges(),
+			pool:            testPool("192.0.2.0/24", "192.0.2.10", "192.0.2.20"),
+			oldPool:         testPool("192.0.2.0/24", "192.0.2.10", "192.0.2.20"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				options: Options{ForbiddenRanges: tc.forbiddenRanges},
+			}
+			req := &FloatingIPPoolRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				Pool:    tc.pool,
+				OldPool: tc.oldPool,
+			}
+
+			err := (&PoolNotForbidden{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPoolAddressSpace(t *testing.T) {
+	projectPool := func(start string, end string, projectID string) *rfmv2.FloatingIPPool {
+		pool := testPool("0.0.0.0/0", start, end)
+		if projectID != "" {
+			pool.ObjectMeta.Labels = map[string]string{ProjectNameLabel: projectID}
+		}
+		return pool
+	}
+	projectAddressSpaces := map[string]string{"p-public": validator.AddressSpacePublic}
+
+	testCases := []struct {
+		name            string
+		addressSpace    string
+		pool            *rfmv2.FloatingIPPool
+		oldPool         *rfmv2.FloatingIPPool
+		expectedMessage string
+	}{
+		{
+			name: "not limited",
+			pool: projectPool("8.8.8.10", "8.8.8.20", ""),
+		},
+		{
+			name:         "private pool",
+			addressSpace: validator.AddressSpacePrivate,
+			pool:         projectPool("10.0.0.10", "10.0.0.20", ""),
+		},
+		{
+			name:            "public pool",
+			addressSpace:    validator.AddressSpacePrivate,
+			pool:            projectPool("8.8.8.10", "8.8.8.20", ""),
+			expectedMessage: "pool range [8.8.8.10, 8.8.8.20] is not within a private address range, only private pools are allowed",
+		},
+		{
+			name:         "public pool of a public project",
+			addressSpace: validator.AddressSpacePrivate,
+			pool:         projectPool("8.8.8.10", "8.8.8.20", "p-public"),
+		},
+		{
+			name:            "private pool of a public project",
+			addressSpace:    validator.AddressSpacePrivate,
+			pool:            projectPool("10.0.0.10", "10.0.0.20", "p-public"),
+			expectedMessage: "pool range [10.0.0.10, 10.0.0.20] overlaps with the private range 10.0.0.0/8, only public pools are allowed",
+		},
+		{
+			name:         "existing pool is unchanged",
+			addressSpace: validator.AddressSpacePrivate,
+			pool:         projectPool("8.8.8.10", "8.8.8.20", ""),
+			oldPool:      projectPool("8.8.8.10", "8.8.8.20", ""),
+		},
+		{
+			name:            "existing pool moves to another project",
+			addressSpace:    validator.AddressSpacePublic,
+			pool:            projectPool("10.0.0.10", "10.0.0.20", "p-public"),
+			oldPool:         projectPool("10.0.0.10", "10.0.0.20", ""),
+			expectedMessage: "pool range [10.0.0.10, 10.0.0.20] overlaps with the private range 10.0.0.0/8, only public pools are allowed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				options: Options{AddressSpace: tc.addressSpace, ProjectAddressSpaces: projectAddressSpaces},
+			}
+			req := &FloatingIPPoolRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				Pool:    tc.pool,
+				OldPool: tc.oldPool,
+			}
+
+			err := (&PoolAddressSpace{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPoolClusterConflict(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec:       corev1.NodeSpec{PodCIDRs: []string{"10.42.1.0/24"}},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeHostName, Address: "node-1"},
+			{Type: corev1.NodeInternalIP, Address: "192.168.1.15"},
+		}},
+	}
+	_, serviceCIDR, _ := net.ParseCIDR("10.43.0.0/16")
+	testCases := []struct {
+		name            string
+		nodeConflicts   bool
+		pool            *rfmv2.FloatingIPPool
+		oldPool         *rfmv2.FloatingIPPool
+		expectedMessage string
+	}{
+		{
+			name:          "no conflicts",
+			nodeConflicts: true,
+			pool:          testPool("192.168.1.0/24", "192.168.1.100", "192.168.1.200"),
+		},
+		{
+			name:            "service network",
+			pool:            testPool("10.43.0.0/16", "10.43.0.10", "10.43.0.20"),
+			expectedMessage: "pool range [10.43.0.10, 10.43.0.20] overlaps with the cluster network 10.43.0.0/16",
+		},
+		{
+			name: "nodes are not checked",
+			pool: testPool("10.42.1.0/24", "10.42.1.10", "10.42.1.20"),
+		},
+		{
+			name:            "pod network of a node",
+			nodeConflicts:   true,
+			pool:            testPool("10.42.1.0/24", "10.42.1.10", "10.42.1.20"),
+			expectedMessage: "pool range [10.42.1.10, 10.42.1.20] overlaps with the Pod network 10.42.1.0/24 of node node-1",
+		},
+		{
+			name:            "address of a node",
+			nodeConflicts:   true,
+			pool:            testPool("192.168.1.0/24", "192.168.1.10", "192.168.1.20"),
+			expectedMessage: "pool range [192.168.1.10, 192.168.1.20] contains the address 192.168.1.15 of node node-1",
+		},
+		{
+			name:          "excluded address of a node",
+			nodeConflicts: true,
+			pool:          testPool("192.168.1.0/24", "192.168.1.10", "192.168.1.20", "192.168.1.15"),
+		},
+		{
+			name:          "existing range is unchanged",
+			nodeConflicts: true,
+			pool:          testPool("192.168.1.0/24", "192.168.1.10", "192.168.1.20"),
+			oldPool:       testPool("192.168.1.0/24", "192.168.1.10", "192.168.1.20"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				clientset: kubefake.NewSimpleClientset(node),
+				options:   Options{ClusterCIDRs: []*net.IPNet{serviceCIDR}, ValidateNodeConflicts: tc.nodeConflicts},
+			}
+			req := &FloatingIPPoolRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				Pool:    tc.pool,
+				OldPool: tc.oldPool,
+			}
+
+			err := (&PoolClusterConflict{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestIPNotForbidden(t *testing.T) {
+	fip := func(ip string) *rfmv2.FloatingIP {
+		return &rfmv2.FloatingIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default"},
+			Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool", IPAddr: &ip},
+			Status:     rfmv2.FloatingIPStatus{IPAddr: ip},
+		}
+	}
+
+	testCases := []struct {
+		name            string
+		fip             *rfmv2.FloatingIP
+		oldFIP          *rfmv2.FloatingIP
+		expectedMessage string
+	}{
+		{
+			name: "regular IP",
+			fip:  fip("10.0.0.10"),
+		},
+		{
+			name:            "loopback IP",
+			fip:             fip("127.0.0.1"),
+			expectedMessage: "requested IP 127.0.0.1 is in the special-use range 127.0.0.0/8",
+		},
+		{
+			name:   "existing IP is unchanged",
+			fip:    fip("127.0.0.1"),
+			oldFIP: fip("127.0.0.1"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				options: Options{ForbiddenRanges: validator.DefaultForbiddenRanges()},
+			}
+			operation := admissionv1.Create
+			if tc.oldFIP != nil {
+				operation = admissionv1.Update
+			}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{Operation: operation},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP:     tc.fip,
+				OldFIP:  tc.oldFIP,
+			}
+
+			err := (&IPNotForbidden{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTargetClusterExists(t *testing.T) {
+	cluster := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "management.cattle.io/v3",
+		"kind":       "Cluster",
+		"metadata":   map[string]interface{}{"name": "c-m-abcd1234"},
+		"spec":       map[string]interface{}{"displayName": "rke2-bm-aio"},
+	}}
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Group: "management.cattle.io", Version: "v3", Resource: "clusters"}: "ClusterList",
+	}, cluster)
+	clusterPool := func(targetCluster string) *rfmv2.FloatingIPPool {
+		pool := testPool("", "", "")
+		pool.Spec.TargetCluster = targetCluster
+		return pool
+	}
+
+	testCases := []struct {
+		name            string
+		validate        bool
+		pool            *rfmv2.FloatingIPPool
+		oldPool         *rfmv2.FloatingIPPool
+		expectedMessage string
+	}{
+		{
+			name: "not validated",
+			pool: clusterPool("unknown"),
+		},
+		{
+			name:     "cluster id",
+			validate: true,
+			pool:     clusterPool("c-m-abcd1234"),
+		},
+		{
+			name:     "cluster display name",
+			validate: true,
+			pool:     clusterPool("rke2-bm-aio"),
+		},
+		{
+			name:            "unknown cluster",
+			validate:        true,
+			pool:            clusterPool("unknown"),
+			expectedMessage: "target cluster unknown of floatingippool test-pool does not exist",
+		},
+		{
+			name:     "existing target cluster is unchanged",
+			validate: true,
+			pool:     clusterPool("removed"),
+			oldPool:  clusterPool("removed"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{
+				dynamic: dynamicClient,
+				options: Options{ValidateTargetCluster: tc.validate},
+			}
+			req := &FloatingIPPoolRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				Pool:    tc.pool,
+				OldPool: tc.oldPool,
+			}
+
+			err := (&TargetClusterExists{}).Validate(context.Background(), h, req)
+			if tc.expectedMessage != "" {
+				assert.EqualError(t, err, tc.expectedMessage)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestLookupPoolAndQuota(t *testing.T) {
+	fipPool := &rfmv2.FloatingIPPool{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPPool"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pool"},
+	}
+	quota := &rfmv2.FloatingIPProjectQuota{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPProjectQuota"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test-project"},
+	}
+	objects, err := getUnstructuredList([]runtime.Object{fipPool, quota})
+	assert.NoError(t, err)
+	h := &Handler{dynamic: fake.NewSimpleDynamicClient(runtime.NewScheme(), objects...)}
+
+	req := &FloatingIPRequest{
+		Request: &admissionv1.AdmissionRequest{},
+		Log:     log.NewEntry(log.StandardLogger()),
+		FIP: &rfmv2.FloatingIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-fip",
+				Namespace: "default",
+				Labels:    map[string]string{ProjectNameLabel: "test-project"},
+			},
+			Spec: rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool"},
+		},
+	}
+	start := time.Now()
+	h.lookupPoolAndQuota(context.Background(), req)
+
+	// the pool doesn't wait for the settle delay of the quota
+	req.waitPool()
+	assert.Less(t, time.Since(start), quotaSettleDelay)
+	assert.Equal(t, "test-pool", req.Pool.Name)
+	assert.Nil(t, req.ProjectQuota)
+
+	req.waitQuota()
+	assert.GreaterOrEqual(t, time.Since(start), quotaSettleDelay)
+	assert.Equal(t, "test-project", req.ProjectQuota.Name)
+}
+
+func TestQuotaCheckLookupError(t *testing.T) {
+	testCases := []struct {
+		name            string
+		err             error
+		expectTransient bool
+		expectedGets    int
+		expectedMessage string
+	}{
+		{
+			name:            "missing quota",
+			err:             apierrors.NewNotFound(schema.GroupResource{Group: "rancher.k8s.binbash.org", Resource: "floatingipprojectquotas"}, "test-project"),
+			expectedGets:    1,
+			expectedMessage: "no floatingipprojectquota exists for project test-project",
+		},
+		{
+			name:            "transient error",
+			err:             apierrors.NewServiceUnavailable("etcd is unavailable"),
+			expectTransient: true,
+			expectedGets:    lookupBackoff.Steps,
+			expectedMessage: "failed to get floatingipprojectquota for project test-project",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dynamicClient := fake.NewSimpleDynamicClient(runtime.NewScheme())
+			gets := 0
+			dynamicClient.PrependReactor("get", "floatingipprojectquotas", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				gets++
+				return true, nil, tc.err
+			})
+			h := &Handler{dynamic: dynamicClient}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP: &rfmv2.FloatingIP{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test-fip",
+						Namespace: "default",
+						Labels:    map[string]string{ProjectNameLabel: "test-project"},
+					},
+				},
+			}
+			start := time.Now()
+			h.lookupPoolAndQuota(context.Background(), req)
+
+			// the error of the lookup is used, the quota is not fetched again
+			// after another settle delay
+			err := (&QuotaCheck{}).Validate(context.Background(), h, req)
+			assert.EqualError(t, err, tc.expectedMessage)
+			var transient *TransientError
+			assert.Equal(t, tc.expectTransient, errors.As(err, &transient))
+			assert.Less(t, time.Since(start), 2*quotaSettleDelay)
+			assert.Equal(t, tc.expectedGets, gets)
+		})
+	}
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	h := &Handler{options: Options{
+		Address:           ":9443",
+		ReadTimeout:       20 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		ReadHeaderTimeout: 5 * time.Second,
+	}}
+
+	server := h.newHTTPServer(http.NewServeMux())
+	assert.Equal(t, ":9443", server.Addr)
+	assert.Equal(t, 20*time.Second, server.ReadTimeout)
+	assert.Equal(t, 30*time.Second, server.WriteTimeout)
+	assert.Equal(t, 2*time.Minute, server.IdleTimeout)
+	assert.Equal(t, 5*time.Second, server.ReadHeaderTimeout)
+	assert.True(t, server.Protocols.HTTP1())
+	assert.True(t, server.Protocols.HTTP2())
+
+	h.options.DisableHTTP2 = true
+	server = h.newHTTPServer(http.NewServeMux())
+	assert.True(t, server.Protocols.HTTP1())
+	assert.False(t, server.Protocols.HTTP2())
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// FloatingIPAnnotation is the Service annotation which attaches the
+	// FloatingIP with the given name in the namespace of the Service.
+	FloatingIPAnnotation = "rancher.k8s.binbash.org/floatingip"
+	// FloatingIPPoolAnnotation is the Service annotation which allocates the
+	// IP of the Service from the FloatingIPPool with the given name.
+	FloatingIPPoolAnnotation = "rancher.k8s.binbash.org/floatingippool"
+)
+
+// WebhookService is the webhook name of the Service validation.
+const WebhookService = "service"
+
+// ServiceAnnotations returns the Service annotations which reference
+// FloatingIP resources, only Services carrying one of them are validated.
+func ServiceAnnotations() []string {
+	return []string{FloatingIPAnnotation, FloatingIPPoolAnnotation}
+}
+
+func (h *Handler) admitService(ctx context.Context, logger *log.Entry, ar *admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+	svc, oldSvc, err := decodeObjects[corev1.Service](h, ar.Request, "Service")
+	if err != nil {
+		return nil, err
+	}
+
+	return h.validateService(ctx, logger, ar, svc, oldSvc)
+}
+
+// validateService checks that the FloatingIP annotations of a Service
+// reference an existing FloatingIP and FloatingIPPool of the project of the
+// namespace of the Service. Updates which keep the annotations are allowed,
+// so existing Services stay editable. Failed lookups are returned as error,
+// the API server handles them with the failurePolicy of the webhook.
+func (h *Handler) validateService(ctx context.Context, logger *log.Entry, ar *admissionv1.AdmissionReview, svc *corev1.Service, oldSvc *corev1.Service) (*admissionv1.AdmissionResponse, error) {
+	fipName, hasFIP := svc.Annotations[FloatingIPAnnotation]
+	poolName, hasPool := svc.Annotations[FloatingIPPoolAnnotation]
+	if ar.Request.Operation == admissionv1.Delete || (!hasFIP && !hasPool) ||
+		(oldSvc != nil && oldSvc.Annotations[FloatingIPAnnotation] == fipName && oldSvc.Annotations[FloatingIPPoolAnnotation] == poolName) {
+		response := allowed(ar)
+		response.AuditAnnotations = map[string]string{"decision": "allowed"}
+		return response, nil
+	}
+
+	projectID, err := h.namespaceProject(ctx, svc.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the project of namespace %s: %s", svc.Namespace, err)
+	}
+
+	deny := func(format string, args ...interface{}) (*admissionv1.AdmissionResponse, error) {
+		message := fmt.Sprintf(format, args...)
+		logger.Infof("(validateService) denying service %s/%s: %s", svc.Namespace, svc.Name, message)
+		response := denied(ar, message)
+		response.AuditAnnotations = map[string]string{"decision": "denied", "project": projectID}
+		return response, nil
+	}
+
+	if hasFIP {
+		unstructuredFIP, err := h.dynamic.Resource(h.apiResources().FloatingIPGVR()).Namespace(svc.Namespace).Get(ctx, fipName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return deny("floatingip %s of the %s annotation does not exist in namespace %s", fipName, FloatingIPAnnotation, svc.Namespace)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get floatingip %s/%s: %s", svc.Namespace, fipName, err)
+		}
+		var fip rfmv2.FloatingIP
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredFIP.Object, &fip); err != nil {
+			return nil, fmt.Errorf("failed to convert unstructured FloatingIP to typed: %s", err)
+		}
+
+		if fipProject := fip.Labels[ProjectNameLabel]; projectID != "" && fipProject != projectID {
+			return deny("floatingip %s belongs to project %q, not to project %s of namespace %s", fipName, fipProject, projectID, svc.Namespace)
+		}
+		if hasPool && fip.Spec.FloatingIPPool != poolName {
+			return deny("floatingip %s is in floatingippool %s, not in floatingippool %s of the %s annotation", fipName, fip.Spec.FloatingIPPool, poolName, FloatingIPPoolAnnotation)
+		}
+	}
+
+	if hasPool {
+		pool, err := h.getFloatingIPPool(ctx, poolName)
+		if apierrors.IsNotFound(err) {
+			return deny("floatingippool %s of the %s annotation does not exist", poolName, FloatingIPPoolAnnotation)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get floatingippool %s: %s", poolName, err)
+		}
+
+		if poolProject, ok := pool.Labels[ProjectNameLabel]; ok && projectID != "" && poolProject != projectID {
+			return deny("floatingippool %s belongs to project %s, not to project %s of namespace %s", poolName, poolProject, projectID, svc.Namespace)
+		}
+		if projectID != "" {
+			quota, err := h.getProjectQuota(ctx, projectID)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to get floatingipprojectquota for project %s: %s", projectID, err)
+			}
+			if quota != nil {
+				if _, ok := quota.Spec.FloatingIPQuota[poolName]; !ok {
+					return deny("project %s has no quota for floatingippool %s", projectID, poolName)
+				}
+				if pools, ok := allowedPools(quota); ok && !pools[poolName] {
+					return deny("floatingippool %s is not in the allowed pools of project %s", poolName, projectID)
+				}
+			}
+		}
+	}
+
+	response := allowed(ar)
+	response.AuditAnnotations = map[string]string{"decision": "allowed", "project": projectID}
+	return response, nil
+}
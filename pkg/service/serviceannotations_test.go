@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateService(t *testing.T) {
+	pool := func(name string, labels map[string]string) *rfmv2.FloatingIPPool {
+		return &rfmv2.FloatingIPPool{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPPool"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		}
+	}
+	fip := func(name string, project string, pool string) *rfmv2.FloatingIP {
+		return &rfmv2.FloatingIP{
+			TypeMeta: metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIP"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "team-a",
+				Labels:    map[string]string{ProjectNameLabel: project},
+			},
+			Spec: rfmv2.FloatingIPSpec{FloatingIPPool: pool},
+		}
+	}
+	quota := &rfmv2.FloatingIPProjectQuota{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPProjectQuota"},
+		ObjectMeta: metav1.ObjectMeta{Name: "p-fghij", Annotations: map[string]string{AllowedPoolsAnnotation: "public"}},
+		Spec:       rfmv2.FloatingIPProjectQuotaSpec{FloatingIPQuota: map[string]int{"public": 5, "private": 5}},
+	}
+
+	objects, err := getUnstructuredList([]runtime.Object{
+		pool("public", nil),
+		pool("private", nil),
+		pool("internal", nil),
+		pool("dedicated", map[string]string{ProjectNameLabel: "p-other"}),
+		fip("web", "p-fghij", "public"),
+		fip("stolen", "p-other", "public"),
+		quota,
+	})
+	assert.NoError(t, err)
+	h := &Handler{
+		clientset: kubefake.NewSimpleClientset(&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "team-a",
+				Annotations: map[string]string{ProjectIDAnnotation: "c-abcde:p-fghij"},
+			},
+		}),
+		dynamic: fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingips"}:             "FloatingIPList",
+			{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingippools"}:         "FloatingIPPoolList",
+			{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingipprojectquotas"}: "FloatingIPProjectQuotaList",
+		}, objects...),
+	}
+
+	testCases := []struct {
+		name           string
+		annotations    map[string]string
+		oldAnnotations map[string]string
+		expectedError  string
+	}{
+		{
+			name: "service without annotations",
+		},
+		{
+			name:        "existing floatingip and pool of the project",
+			annotations: map[string]string{FloatingIPAnnotation: "web", FloatingIPPoolAnnotation: "public"},
+		},
+		{
+			name:          "floatingip does not exist",
+			annotations:   map[string]string{FloatingIPAnnotation: "wbe"},
+			expectedError: "floatingip wbe of the rancher.k8s.binbash.org/floatingip annotation does not exist in namespace team-a",
+		},
+		{
+			name:          "floatingip of another project",
+			annotations:   map[string]string{FloatingIPAnnotation: "stolen"},
+			expectedError: `floatingip stolen belongs to project "p-other", not to project p-fghij of namespace team-a`,
+		},
+		{
+			name:          "floatingip in another pool",
+			annotations:   map[string]string{FloatingIPAnnotation: "web", FloatingIPPoolAnnotation: "private"},
+			expectedError: "floatingip web is in floatingippool public, not in floatingippool private of the rancher.k8s.binbash.org/floatingippool annotation",
+		},
+		{
+			name:          "pool does not exist",
+			annotations:   map[string]string{FloatingIPPoolAnnotation: "pubilc"},
+			expectedError: "floatingippool pubilc of the rancher.k8s.binbash.org/floatingippool annotation does not exist",
+		},
+		{
+			name:          "pool of another project",
+			annotations:   map[string]string{FloatingIPPoolAnnotation: "dedicated"},
+			expectedError: "floatingippool dedicated belongs to project p-other, not to project p-fghij of namespace team-a",
+		},
+		{
+			name:          "pool without quota",
+			annotations:   map[string]string{FloatingIPPoolAnnotation: "internal"},
+			expectedError: "project p-fghij has no quota for floatingippool internal",
+		},
+		{
+			name:          "pool which is not allowed",
+			annotations:   map[string]string{FloatingIPPoolAnnotation: "private"},
+			expectedError: "floatingippool private is not in the allowed pools of project p-fghij",
+		},
+		{
+			name:           "update which keeps the annotations",
+			annotations:    map[string]string{FloatingIPAnnotation: "wbe"},
+			oldAnnotations: map[string]string{FloatingIPAnnotation: "wbe"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team-a", Annotations: tc.annotations}}
+			var oldSvc *corev1.Service
+			operation := admissionv1.Create
+			if tc.oldAnnotations != nil {
+				oldSvc = svc.DeepCopy()
+				oldSvc.Annotations = tc.oldAnnotations
+				operation = admissionv1.Update
+			}
+			ar := &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{UID: "test-uid", Operation: operation}}
+
+			response, err := h.validateService(context.Background(), log.NewEntry(log.StandardLogger()), ar, svc, oldSvc)
+			assert.NoError(t, err)
+			if tc.expectedError != "" {
+				assert.False(t, response.Allowed)
+				assert.Equal(t, tc.expectedError, response.Result.Message)
+				return
+			}
+			assert.True(t, response.Allowed)
+		})
+	}
+}
+
+func TestServiceKindRegistration(t *testing.T) {
+	clients := &util.Clients{Clientset: kubefake.NewSimpleClientset()}
+	svc, err := json.Marshal(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team-a"}})
+	assert.NoError(t, err)
+	ar := &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+		UID:       "test-uid",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Service"},
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: svc},
+	}}
+
+	response, err := Register(context.Background(), clients, Options{}).Review(context.Background(), ar)
+	assert.NoError(t, err)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "no validator registered for kind Service", response.Result.Message)
+
+	response, err = Register(context.Background(), clients, Options{ValidateServices: true}).Review(context.Background(), ar)
+	assert.NoError(t, err)
+	assert.True(t, response.Allowed)
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// TargetAnnotation is the FloatingIP annotation which references the
+// LoadBalancer Service or the workload the IP is bound to, of the form
+// "kind/name" for an object in the namespace of the FloatingIP or
+// "kind/namespace/name", for example "service/web" or "deployment/team-a/web".
+const TargetAnnotation = "rancher.k8s.binbash.org/target"
+
+// targetKinds are the kinds which can be referenced by the TargetAnnotation.
+var targetKinds = []string{"service", "deployment", "statefulset", "daemonset"}
+
+// parseTarget returns the kind, namespace and name of a TargetAnnotation. The
+// namespace is empty when the reference has no namespace.
+func parseTarget(value string) (string, string, string, error) {
+	kind, ref, ok := strings.Cut(value, "/")
+	if !ok {
+		return "", "", "", fmt.Errorf("must be a reference of the form kind/name or kind/namespace/name")
+	}
+	if err := validator.ValidateEnum(kind, targetKinds...); err != nil {
+		return "", "", "", fmt.Errorf("invalid kind %q: %s", kind, err)
+	}
+	if !strings.Contains(ref, "/") {
+		if errs := validation.IsDNS1123Subdomain(ref); len(errs) > 0 {
+			return "", "", "", fmt.Errorf("invalid name %q: %s", ref, strings.Join(errs, ", "))
+		}
+		return kind, "", ref, nil
+	}
+	namespace, name, err := validator.ParseObjectReference(ref)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return kind, namespace, name, nil
+}
+
+// validateTarget checks the value of the TargetAnnotation.
+func validateTarget(value string) error {
+	_, _, _, err := parseTarget(value)
+	return err
+}
+
+// TargetExists checks that the Service or workload of the TargetAnnotation
+// exists and is in the namespace of the FloatingIP or in another namespace of
+// its project. A referenced Service must be of type LoadBalancer. Updates
+// which keep the annotation are not checked.
+type TargetExists struct{}
+
+func (v *TargetExists) Name() string { return "TargetExists" }
+
+func (v *TargetExists) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	value, ok := req.FIP.Annotations[TargetAnnotation]
+	if !ok || (req.IsUpdate() && req.OldFIP.Annotations[TargetAnnotation] == value) {
+		return nil
+	}
+
+	// the format is checked by the AnnotationsValid validator, which can be disabled
+	kind, namespace, name, err := parseTarget(value)
+	if err != nil {
+		return fmt.Errorf("invalid annotation %s=%q: %s", TargetAnnotation, value, err)
+	}
+	if namespace == "" {
+		namespace = req.FIP.Namespace
+	}
+
+	if h.clientset == nil {
+		return fmt.Errorf("internal server error: no clientset available")
+	}
+	switch kind {
+	case "service":
+		var svc *corev1.Service
+		svc, err = h.clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err == nil && svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			if err := h.targetProblem(req, fmt.Errorf("service %s/%s of the %s annotation is not of type LoadBalancer", namespace, name, TargetAnnotation)); err != nil {
+				return err
+			}
+		}
+	case "deployment":
+		_, err = h.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "statefulset":
+		_, err = h.clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "daemonset":
+		_, err = h.clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	if apierrors.IsNotFound(err) {
+		return h.targetProblem(req, fmt.Errorf("%s %s/%s of the %s annotation does not exist", kind, namespace, name, TargetAnnotation))
+	}
+	if err != nil {
+		req.Log.Errorf("failed to get %s %s/%s: %s", kind, namespace, name, err)
+		if isTransient(err) {
+			return &TransientError{Err: fmt.Errorf("failed to get %s %s/%s", kind, namespace, name)}
+		}
+		return fmt.Errorf("internal server error: failed to get %s %s/%s", kind, namespace, name)
+	}
+
+	return h.checkTargetProject(ctx, req, TargetAnnotation, fmt.Sprintf("%s %s/%s", kind, namespace, name), namespace)
+}
+
+// checkTargetProject checks that the namespace of the target of an annotation
+// is the namespace of the FloatingIP or another namespace of its project.
+func (h *Handler) checkTargetProject(ctx context.Context, req *FloatingIPRequest, annotation string, target string, namespace string) error {
+	if namespace == req.FIP.Namespace {
+		return nil
+	}
+
+	projectID := req.projectID()
+	targetProject, err := h.requestNamespaceProject(ctx, req, namespace)
+	if err != nil {
+		req.Log.Errorf("failed to get the project of namespace %s: %s", namespace, err)
+		if isTransient(err) {
+			return &TransientError{Err: fmt.Errorf("failed to get the project of namespace %s", namespace)}
+		}
+		return fmt.Errorf("internal server error: failed to get the project of namespace %s", namespace)
+	}
+	if targetProject == "" || targetProject != projectID {
+		return h.targetProblem(req, fmt.Errorf("%s of the %s annotation is not in project %s of the floatingip", target, annotation, projectID))
+	}
+
+	return nil
+}
+
+// targetProblem returns the error of a dangling target reference, or adds it
+// as warning when the TargetWarnOnly option is set, so FloatingIPs can be
+// created before their target.
+func (h *Handler) targetProblem(req *FloatingIPRequest, err error) error {
+	if !h.options.TargetWarnOnly {
+		return err
+	}
+	req.Warnings = append(req.Warnings, err.Error())
+
+	return nil
+}
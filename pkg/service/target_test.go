@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseTarget(t *testing.T) {
+	kind, namespace, name, err := parseTarget("service/web")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"service", "", "web"}, []string{kind, namespace, name})
+
+	kind, namespace, name, err = parseTarget("deployment/team-a/web")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"deployment", "team-a", "web"}, []string{kind, namespace, name})
+
+	assert.EqualError(t, validateTarget("web"), "must be a reference of the form kind/name or kind/namespace/name")
+	assert.EqualError(t, validateTarget("pod/web"), `invalid kind "pod": must be one of: service, deployment, statefulset, daemonset`)
+	assert.Error(t, validateTarget("service/Web"))
+	assert.Error(t, validateTarget("service/team-a/"))
+}
+
+func TestTargetExists(t *testing.T) {
+	clientset := kubefake.NewSimpleClientset(
+		testNamespace("team-a", "c-abcde:p-fghij"),
+		testNamespace("team-a-backend", "c-abcde:p-fghij"),
+		testNamespace("team-b", "c-abcde:p-other"),
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team-a"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "internal", Namespace: "team-a"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+		},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "team-a-backend"}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "team-b"}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "team-a"}},
+	)
+
+	testCases := []struct {
+		name             string
+		target           string
+		oldTarget        string
+		warnOnly         bool
+		expectedError    string
+		expectedWarnings []string
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:   "loadbalancer service in the namespace of the floatingip",
+			target: "service/web",
+		},
+		{
+			name:   "daemonset in the namespace of the floatingip",
+			target: "daemonset/agent",
+		},
+		{
+			name:   "deployment in another namespace of the project",
+			target: "deployment/team-a-backend/api",
+		},
+		{
+			name:          "service does not exist",
+			target:        "service/wbe",
+			expectedError: "service team-a/wbe of the rancher.k8s.binbash.org/target annotation does not exist",
+		},
+		{
+			name:          "service which is not a loadbalancer",
+			target:        "service/internal",
+			expectedError: "service team-a/internal of the rancher.k8s.binbash.org/target annotation is not of type LoadBalancer",
+		},
+		{
+			name:          "statefulset of another project",
+			target:        "statefulset/team-b/db",
+			expectedError: "statefulset team-b/db of the rancher.k8s.binbash.org/target annotation is not in project p-fghij of the floatingip",
+		},
+		{
+			name:             "missing target is a warning when pre-created floatingips are allowed",
+			target:           "deployment/web",
+			warnOnly:         true,
+			expectedWarnings: []string{"deployment team-a/web of the rancher.k8s.binbash.org/target annotation does not exist"},
+		},
+		{
+			name:     "target of another project is a warning when pre-created floatingips are allowed",
+			target:   "statefulset/team-b/db",
+			warnOnly: true,
+			expectedWarnings: []string{
+				"statefulset team-b/db of the rancher.k8s.binbash.org/target annotation is not in project p-fghij of the floatingip",
+			},
+		},
+		{
+			name:      "update which keeps the annotation",
+			target:    "service/wbe",
+			oldTarget: "service/wbe",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{clientset: clientset, options: Options{TargetWarnOnly: tc.warnOnly}}
+			fip := &rfmv2.FloatingIP{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-fip",
+				Namespace: "team-a",
+				Labels:    map[string]string{ProjectNameLabel: "p-fghij"},
+			}}
+			if tc.target != "" {
+				fip.Annotations = map[string]string{TargetAnnotation: tc.target}
+			}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{Operation: admissionv1.Create},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP:     fip,
+			}
+			if tc.oldTarget != "" {
+				req.OldFIP = fip.DeepCopy()
+				req.OldFIP.Annotations[TargetAnnotation] = tc.oldTarget
+			}
+
+			err := (&TargetExists{}).Validate(context.Background(), h, req)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedWarnings, req.Warnings)
+		})
+	}
+}
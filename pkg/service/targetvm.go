@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// TargetVMAnnotation is the FloatingIP annotation which references the
+// Harvester or KubeVirt VirtualMachine the IP is attached to, of the form
+// "namespace/name".
+const TargetVMAnnotation = "rancher.k8s.binbash.org/target-vm"
+
+var virtualMachineGVR = schema.GroupVersionResource{
+	Group:    "kubevirt.io",
+	Version:  "v1",
+	Resource: "virtualmachines",
+}
+
+// TargetVMExists checks that the VirtualMachine of the TargetVMAnnotation
+// exists and is in a namespace of the project of the FloatingIP, so a
+// dangling reference is denied instead of being retried by the controller.
+// Updates which keep the annotation are not checked, the TargetWarnOnly
+// option allows dangling references with a warning.
+type TargetVMExists struct{}
+
+func (v *TargetVMExists) Name() string { return "TargetVMExists" }
+
+func (v *TargetVMExists) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	value, ok := req.FIP.Annotations[TargetVMAnnotation]
+	if !ok || (req.IsUpdate() && req.OldFIP.Annotations[TargetVMAnnotation] == value) {
+		return nil
+	}
+
+	// the format is checked by the AnnotationsValid validator, which can be disabled
+	namespace, name, err := validator.ParseObjectReference(value)
+	if err != nil {
+		return fmt.Errorf("invalid annotation %s=%q: %s", TargetVMAnnotation, value, err)
+	}
+
+	_, err = h.dynamic.Resource(virtualMachineGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return h.targetProblem(req, fmt.Errorf("virtualmachine %s/%s of the %s annotation does not exist", namespace, name, TargetVMAnnotation))
+	}
+	if err != nil {
+		req.Log.Errorf("failed to get virtualmachine %s/%s: %s", namespace, name, err)
+		if isTransient(err) {
+			return &TransientError{Err: fmt.Errorf("failed to get virtualmachine %s/%s", namespace, name)}
+		}
+		return fmt.Errorf("internal server error: failed to get virtualmachine %s/%s", namespace, name)
+	}
+
+	return h.checkTargetProject(ctx, req, TargetVMAnnotation, fmt.Sprintf("virtualmachine %s/%s", namespace, name), namespace)
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTargetVMExists(t *testing.T) {
+	vm := func(namespace string, name string) runtime.Object {
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion("kubevirt.io/v1")
+		u.SetKind("VirtualMachine")
+		u.SetNamespace(namespace)
+		u.SetName(name)
+		return u
+	}
+	h := &Handler{
+		clientset: kubefake.NewSimpleClientset(
+			testNamespace("team-a", "c-abcde:p-fghij"),
+			testNamespace("team-a-vms", "c-abcde:p-fghij"),
+			testNamespace("team-b", "c-abcde:p-other"),
+		),
+		dynamic: fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			virtualMachineGVR: "VirtualMachineList",
+		}, vm("team-a", "web"), vm("team-a-vms", "db"), vm("team-b", "other")),
+	}
+
+	testCases := []struct {
+		name          string
+		target        string
+		oldTarget     string
+		expectedError string
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:   "virtualmachine in the namespace of the floatingip",
+			target: "team-a/web",
+		},
+		{
+			name:   "virtualmachine in another namespace of the project",
+			target: "team-a-vms/db",
+		},
+		{
+			name:          "virtualmachine does not exist",
+			target:        "team-a/wbe",
+			expectedError: "virtualmachine team-a/wbe of the rancher.k8s.binbash.org/target-vm annotation does not exist",
+		},
+		{
+			name:          "virtualmachine of another project",
+			target:        "team-b/other",
+			expectedError: "virtualmachine team-b/other of the rancher.k8s.binbash.org/target-vm annotation is not in project p-fghij of the floatingip",
+		},
+		{
+			name:          "invalid reference",
+			target:        "web",
+			expectedError: `invalid annotation rancher.k8s.binbash.org/target-vm="web": must be a reference of the form namespace/name`,
+		},
+		{
+			name:      "update which keeps the annotation",
+			target:    "team-a/wbe",
+			oldTarget: "team-a/wbe",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fip := &rfmv2.FloatingIP{ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-fip",
+				Namespace: "team-a",
+				Labels:    map[string]string{ProjectNameLabel: "p-fghij"},
+			}}
+			if tc.target != "" {
+				fip.Annotations = map[string]string{TargetVMAnnotation: tc.target}
+			}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{Operation: admissionv1.Create},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP:     fip,
+			}
+			if tc.oldTarget != "" {
+				req.OldFIP = fip.DeepCopy()
+				req.OldFIP.Annotations[TargetVMAnnotation] = tc.oldTarget
+			}
+
+			err := (&TargetVMExists{}).Validate(context.Background(), h, req)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
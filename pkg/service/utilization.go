@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/metrics"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/validator"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// utilizationEventNamespace is the namespace of the events of the
+	// cluster-scoped FloatingIPPools.
+	utilizationEventNamespace = metav1.NamespaceDefault
+	// utilizationEventReason is the reason of the event which is emitted when
+	// a pool crosses a utilization threshold.
+	utilizationEventReason = "UtilizationThresholdCrossed"
+	// eventSourceComponent is the source component of the events of the webhook.
+	eventSourceComponent = "rancher-fip-manager-webhook"
+)
+
+// DefaultUtilizationThresholds returns the pool utilization percentages which
+// are warned about when the UtilizationThresholds option is nil.
+func DefaultUtilizationThresholds() []int {
+	return []int{80, 95}
+}
+
+// poolUsage returns the number of IPs in the range of the pool which are not
+// excluded and the number of them which are not available.
+func poolUsage(pool *rfmv2.FloatingIPPool) (capacity int, used int) {
+	if pool.Spec.IPConfig == nil {
+		return 0, 0
+	}
+	capacity = validator.PoolCapacity(pool.Spec.IPConfig)
+	used = capacity - pool.Status.Available
+	if used < 0 {
+		used = 0
+	}
+
+	return capacity, used
+}
+
+// PoolUtilization warns when a new FloatingIP fills its pool to one of the
+// UtilizationThresholds, so teams notice a pool running full before FloatingIPs
+// are denied. When the FloatingIP crosses a threshold the crossing is counted
+// and an event is emitted on the pool, dry-run requests only get the warning.
+// It never denies a request.
+type PoolUtilization struct{}
+
+func (v *PoolUtilization) Name() string { return "PoolUtilization" }
+
+func (v *PoolUtilization) Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error {
+	if len(h.options.UtilizationThresholds) == 0 || req.Pool == nil || req.IsUpdate() {
+		return nil
+	}
+
+	capacity, used := poolUsage(req.Pool)
+	if capacity <= 0 || capacity == math.MaxInt {
+		return nil
+	}
+
+	thresholds := append([]int(nil), h.options.UtilizationThresholds...)
+	sort.Sort(sort.Reverse(sort.IntSlice(thresholds)))
+	for _, threshold := range thresholds {
+		if int64(used+1)*100 < int64(threshold)*int64(capacity) {
+			continue
+		}
+
+		pool := req.PoolName()
+		msg := fmt.Sprintf("floatingippool %s is %d%% utilized (%d of %d IPs), which is above the threshold of %d%%",
+			pool, (used+1)*100/capacity, used+1, capacity, threshold)
+		req.Warnings = append(req.Warnings, msg)
+
+		dryRun := req.Request.DryRun != nil && *req.Request.DryRun
+		if int64(used)*100 < int64(threshold)*int64(capacity) && !dryRun {
+			metrics.PoolUtilizationCrossings.WithLabelValues(pool, strconv.Itoa(threshold)).Inc()
+			if err := h.poolEvent(ctx, req, utilizationEventReason, msg); err != nil {
+				req.Log.Warnf("failed to create the %s event of floatingippool %s: %s", utilizationEventReason, pool, err)
+			}
+		}
+		return nil
+	}
+
+	return nil
+}
+
+// poolEvent emits a warning event on the FloatingIPPool of the request.
+func (h *Handler) poolEvent(ctx context.Context, req *FloatingIPRequest, reason string, message string) error {
+	now := metav1.NewTime(time.Now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", req.PoolName(), now.UnixNano()),
+			Namespace: utilizationEventNamespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: h.apiResources().GroupVersion().String(),
+			Kind:       "FloatingIPPool",
+			Name:       req.PoolName(),
+			UID:        req.Pool.UID,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: eventSourceComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	_, err := h.clientset.CoreV1().Events(utilizationEventNamespace).Create(ctx, event, metav1.CreateOptions{})
+	return err
+}
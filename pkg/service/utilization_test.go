@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPoolUtilization(t *testing.T) {
+	// the pool holds 100 IPs
+	pool := func(available int) *rfmv2.FloatingIPPool {
+		return &rfmv2.FloatingIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pool"},
+			Spec: rfmv2.FloatingIPPoolSpec{
+				IPConfig: &rfmv2.IPConfig{
+					Subnet: "10.0.0.0/24",
+					Pool:   rfmv2.Pool{Start: "10.0.0.1", End: "10.0.0.100"},
+				},
+			},
+			Status: rfmv2.FloatingIPPoolStatus{Available: available},
+		}
+	}
+
+	testCases := []struct {
+		name             string
+		thresholds       []int
+		pool             *rfmv2.FloatingIPPool
+		dryRun           bool
+		update           bool
+		expectedWarnings []string
+		expectedEvents   int
+	}{
+		{
+			name:       "below the thresholds",
+			thresholds: DefaultUtilizationThresholds(),
+			pool:       pool(50),
+		},
+		{
+			name:             "crosses the first threshold",
+			thresholds:       DefaultUtilizationThresholds(),
+			pool:             pool(21),
+			expectedWarnings: []string{"floatingippool test-pool is 80% utilized (80 of 100 IPs), which is above the threshold of 80%"},
+			expectedEvents:   1,
+		},
+		{
+			name:             "above the first threshold",
+			thresholds:       DefaultUtilizationThresholds(),
+			pool:             pool(10),
+			expectedWarnings: []string{"floatingippool test-pool is 91% utilized (91 of 100 IPs), which is above the threshold of 80%"},
+		},
+		{
+			name:             "crosses the second threshold",
+			thresholds:       DefaultUtilizationThresholds(),
+			pool:             pool(6),
+			expectedWarnings: []string{"floatingippool test-pool is 95% utilized (95 of 100 IPs), which is above the threshold of 95%"},
+			expectedEvents:   1,
+		},
+		{
+			name:             "dry-run",
+			thresholds:       DefaultUtilizationThresholds(),
+			pool:             pool(21),
+			dryRun:           true,
+			expectedWarnings: []string{"floatingippool test-pool is 80% utilized (80 of 100 IPs), which is above the threshold of 80%"},
+		},
+		{
+			name:       "update",
+			thresholds: DefaultUtilizationThresholds(),
+			pool:       pool(21),
+			update:     true,
+		},
+		{
+			name:       "disabled",
+			thresholds: []int{},
+			pool:       pool(1),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := kubefake.NewSimpleClientset()
+			h := &Handler{
+				clientset: clientset,
+				options:   Options{UtilizationThresholds: tc.thresholds},
+			}
+			ip := "10.0.0.50"
+			fip := &rfmv2.FloatingIP{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-fip", Namespace: "default"},
+				Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "test-pool", IPAddr: &ip},
+			}
+			req := &FloatingIPRequest{
+				Request: &admissionv1.AdmissionRequest{DryRun: &tc.dryRun},
+				Log:     log.NewEntry(log.StandardLogger()),
+				FIP:     fip,
+				Pool:    tc.pool,
+			}
+			if tc.update {
+				req.OldFIP = fip
+			}
+
+			assert.NoError(t, (&PoolUtilization{}).Validate(context.Background(), h, req))
+			assert.Equal(t, tc.expectedWarnings, req.Warnings)
+
+			events, err := clientset.CoreV1().Events(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+			assert.NoError(t, err)
+			assert.Len(t, events.Items, tc.expectedEvents)
+			for _, event := range events.Items {
+				assert.Equal(t, "FloatingIPPool", event.InvolvedObject.Kind)
+				assert.Equal(t, "test-pool", event.InvolvedObject.Name)
+				assert.Equal(t, "UtilizationThresholdCrossed", event.Reason)
+			}
+		})
+	}
+}
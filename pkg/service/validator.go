@@ -0,0 +1,506 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FloatingIPRequest holds the state of a single FloatingIP admission request
+// which is shared between the validators in the pipeline. Validators earlier
+// in the pipeline can store data (like the fetched pool) for later validators.
+type FloatingIPRequest struct {
+	Request *admissionv1.AdmissionRequest
+	Log     *log.Entry
+	FIP     *rfmv2.FloatingIP
+	OldFIP  *rfmv2.FloatingIP
+	Pool    *rfmv2.FloatingIPPool
+
+	// ProjectID is set by the ProjectLabel validator, Quota and QuotaUsed
+	// are set by the QuotaCheck validator. ProjectQuota is fetched before
+	// the validators run or by the QuotaCheck validator.
+	ProjectID    string
+	ProjectQuota *rfmv2.FloatingIPProjectQuota
+	Quota        int
+	QuotaUsed    int
+
+	// QuotaOverride is the reason of the quota override, it is set by the
+	// QuotaOverride validator.
+	QuotaOverride string
+
+	// quotaKnown is set when Quota and QuotaUsed are set, they are only
+	// recorded in the audit annotations when the quota of the pool is known.
+	quotaKnown bool
+
+	// QuotaLimits are the limits which are recorded by the quota validators
+	// when the HierarchicalQuotas option is set.
+	QuotaLimits []QuotaLimit
+
+	// Warnings are returned to the user when the request is allowed.
+	Warnings []string
+
+	// lookups are the pool and quota lookups which run concurrently with
+	// the validators, see waitPool and waitQuota.
+	lookups *lookups
+
+	// namespaces are the namespaces which are fetched by the validators to
+	// look up their project, see requestNamespaceClusterProject.
+	namespaces map[string]*corev1.Namespace
+
+	// claim is the IP claimed by the NotClaimed validator, it is released
+	// when the request is denied in the end.
+	claim      string
+	claimOwner string
+}
+
+// IsUpdate returns true if the request is an UPDATE of an existing FloatingIP.
+func (r *FloatingIPRequest) IsUpdate() bool {
+	return r.OldFIP != nil
+}
+
+// IPUnchanged returns true if this is an UPDATE which keeps the IP address
+// that is already assigned to the FloatingIP.
+func (r *FloatingIPRequest) IPUnchanged() bool {
+	return r.IsUpdate() && r.FIP.Spec.IPAddr != nil && r.OldFIP.Status.IPAddr == *r.FIP.Spec.IPAddr
+}
+
+// projectID returns the project of the request, which is the project stored
+// by the ProjectLabel validator or the project-name label of the FloatingIP,
+// since the ProjectLabel validator can be disabled.
+func (r *FloatingIPRequest) projectID() string {
+	if r.ProjectID != "" {
+		return r.ProjectID
+	}
+
+	return r.FIP.Labels[ProjectNameLabel]
+}
+
+// PoolName returns the name of the FloatingIPPool of the request, which is
+// the pool resolved by the PoolSelector validator when spec.floatingIPPool
+// is empty.
+func (r *FloatingIPRequest) PoolName() string {
+	if r.FIP.Spec.FloatingIPPool == "" && r.Pool != nil {
+		return r.Pool.Name
+	}
+
+	return r.FIP.Spec.FloatingIPPool
+}
+
+// FloatingIPValidator is a single check in the FloatingIP validation pipeline.
+// A returned error denies the request, the error message is returned to the user.
+type FloatingIPValidator interface {
+	Name() string
+	Validate(ctx context.Context, h *Handler, req *FloatingIPRequest) error
+}
+
+// FloatingIPPoolRequest holds the state of a single FloatingIPPool admission request.
+type FloatingIPPoolRequest struct {
+	Request *admissionv1.AdmissionRequest
+	Log     *log.Entry
+	Pool    *rfmv2.FloatingIPPool
+	OldPool *rfmv2.FloatingIPPool
+
+	// Warnings are returned to the user when the request is allowed.
+	Warnings []string
+}
+
+// IsUpdate returns true if the request is an UPDATE of an existing FloatingIPPool.
+func (r *FloatingIPPoolRequest) IsUpdate() bool {
+	return r.OldPool != nil
+}
+
+// IsDelete returns true if the request deletes the FloatingIPPool, the Pool
+// of the request is the deleted pool.
+func (r *FloatingIPPoolRequest) IsDelete() bool {
+	return r.Request.Operation == admissionv1.Delete
+}
+
+// IsStatus returns true if the request updates the status subresource of the
+// FloatingIPPool, which is written by the controller.
+func (r *FloatingIPPoolRequest) IsStatus() bool {
+	return r.Request.SubResource == "status"
+}
+
+// FloatingIPPoolValidator is a single check in the FloatingIPPool validation pipeline.
+// A returned error denies the request, the error message is returned to the user.
+type FloatingIPPoolValidator interface {
+	Name() string
+	Validate(ctx context.Context, h *Handler, req *FloatingIPPoolRequest) error
+}
+
+// FloatingIPPoolDeleteValidator is implemented by the FloatingIPPool
+// validators which also check DELETE requests, the other validators only run
+// for CREATE and UPDATE requests. FloatingIPPool deletes are only sent to the
+// webhook when a maintenance window or the delete confirmation is configured.
+type FloatingIPPoolDeleteValidator interface {
+	FloatingIPPoolValidator
+	ValidatesDelete()
+}
+
+// FloatingIPPoolStatusValidator is implemented by the FloatingIPPool
+// validators which also check updates of the status subresource, the other
+// validators don't run for them, so the status writes of the controller are
+// not subject to the checks of the spec. Status updates are only sent to the
+// webhook when the ControllerUsers are configured.
+type FloatingIPPoolStatusValidator interface {
+	FloatingIPPoolValidator
+	ValidatesStatus()
+}
+
+// FloatingIPProjectQuotaRequest holds the state of a single FloatingIPProjectQuota admission request.
+type FloatingIPProjectQuotaRequest struct {
+	Request  *admissionv1.AdmissionRequest
+	Log      *log.Entry
+	Quota    *rfmv2.FloatingIPProjectQuota
+	OldQuota *rfmv2.FloatingIPProjectQuota
+
+	// Pools contains the existing FloatingIPPools of the quota, it is set by
+	// the QuotaPoolsExist validator.
+	Pools map[string]*rfmv2.FloatingIPPool
+	// Warnings are returned to the user when the request is allowed.
+	Warnings []string
+}
+
+// IsUpdate returns true if the request is an UPDATE of an existing FloatingIPProjectQuota.
+func (r *FloatingIPProjectQuotaRequest) IsUpdate() bool {
+	return r.OldQuota != nil
+}
+
+// IsStatus returns true if the request updates the status subresource of the
+// FloatingIPProjectQuota.
+func (r *FloatingIPProjectQuotaRequest) IsStatus() bool {
+	return r.Request.SubResource == "status"
+}
+
+// IsDelete returns true if the request deletes the FloatingIPProjectQuota.
+func (r *FloatingIPProjectQuotaRequest) IsDelete() bool {
+	return r.Request.Operation == admissionv1.Delete
+}
+
+// FloatingIPProjectQuotaValidator is a single check in the FloatingIPProjectQuota validation pipeline.
+// A returned error denies the request, the error message is returned to the user.
+type FloatingIPProjectQuotaValidator interface {
+	Name() string
+	Validate(ctx context.Context, h *Handler, req *FloatingIPProjectQuotaRequest) error
+}
+
+// DefaultFloatingIPValidators returns the built-in FloatingIP validators in the order they are run.
+func DefaultFloatingIPValidators() []FloatingIPValidator {
+	return []FloatingIPValidator{
+		&IPImmutable{},
+		&RateLimit{},
+		&AnnotationsValid{},
+		&PoolSelector{},
+		&PoolExists{},
+		&PoolNotTerminating{},
+		&PoolApproved{},
+		&IPInRange{},
+		&IPNotForbidden{},
+		&NotExcluded{},
+		&NotAllocated{},
+		&NotAllocatedElsewhere{},
+		&NotClaimed{},
+		&PoolHasCapacity{},
+		&ProjectLabel{},
+		&ProjectExists{},
+		&TargetVMExists{},
+		&TargetExists{},
+		&RequiredMetadata{},
+		&NotBlocked{},
+		&PoolAllowed{},
+		&LeaseValid{},
+		&QuotaOverride{},
+		&QuotaCheck{},
+		&NamespaceQuotaCheck{},
+		&ClusterLimitCheck{},
+		&QuotaHierarchy{},
+		&IPNotLive{},
+		&Reserve{},
+		&PoolUtilization{},
+	}
+}
+
+// DefaultFloatingIPPoolValidators returns the built-in FloatingIPPool validators in the order they are run.
+func DefaultFloatingIPPoolValidators() []FloatingIPPoolValidator {
+	return []FloatingIPPoolValidator{
+		&PoolStatusProtected{},
+		&PoolMaintenanceWindow{},
+		&PoolDeleteConfirmed{},
+		&PoolRangeValid{},
+		&PoolNotForbidden{},
+		&PoolAddressSpace{},
+		&PoolClusterConflict{},
+		&PoolLoadBalancerConflict{},
+		&PoolSizeLimit{},
+		&PoolApproval{},
+		&ExcludesValid{},
+		&GatewayValid{},
+		&RequiredMetadataValid{},
+		&TargetClusterExists{},
+		&PoolCapacityConsistent{},
+	}
+}
+
+// DefaultFloatingIPProjectQuotaValidators returns the built-in FloatingIPProjectQuota validators in the order they are run.
+func DefaultFloatingIPProjectQuotaValidators() []FloatingIPProjectQuotaValidator {
+	return []FloatingIPProjectQuotaValidator{
+		&QuotaNotNegative{},
+		&QuotaPoolsExist{},
+		&QuotaPoolsAllowed{},
+		&QuotaWithinCapacity{},
+		&QuotaWithinClusterLimit{},
+		&QuotaNotInUse{},
+	}
+}
+
+// RegisterFloatingIPValidator appends a validator to the end of the FloatingIP pipeline.
+func (h *Handler) RegisterFloatingIPValidator(v FloatingIPValidator) {
+	h.fipValidators = append(h.fipValidators, v)
+}
+
+// RegisterFloatingIPPoolValidator appends a validator to the end of the FloatingIPPool pipeline.
+func (h *Handler) RegisterFloatingIPPoolValidator(v FloatingIPPoolValidator) {
+	h.fipPoolValidators = append(h.fipPoolValidators, v)
+}
+
+// RegisterFloatingIPProjectQuotaValidator appends a validator to the end of the FloatingIPProjectQuota pipeline.
+func (h *Handler) RegisterFloatingIPProjectQuotaValidator(v FloatingIPProjectQuotaValidator) {
+	h.quotaValidators = append(h.quotaValidators, v)
+}
+
+// DisableValidator removes the validator with the given name from all pipelines.
+func (h *Handler) DisableValidator(name string) {
+	fipValidators := h.fipValidators[:0]
+	for _, v := range h.fipValidators {
+		if v.Name() != name {
+			fipValidators = append(fipValidators, v)
+		}
+	}
+	h.fipValidators = fipValidators
+
+	fipPoolValidators := h.fipPoolValidators[:0]
+	for _, v := range h.fipPoolValidators {
+		if v.Name() != name {
+			fipPoolValidators = append(fipPoolValidators, v)
+		}
+	}
+	h.fipPoolValidators = fipPoolValidators
+
+	quotaValidators := h.quotaValidators[:0]
+	for _, v := range h.quotaValidators {
+		if v.Name() != name {
+			quotaValidators = append(quotaValidators, v)
+		}
+	}
+	h.quotaValidators = quotaValidators
+}
+
+func (h *Handler) validateFloatingIP(ctx context.Context, logger *log.Entry, ar *admissionv1.AdmissionReview, fip *rfmv2.FloatingIP, oldFIP *rfmv2.FloatingIP) (response *admissionv1.AdmissionResponse) {
+	req := &FloatingIPRequest{
+		Request: ar.Request,
+		Log:     logger,
+		FIP:     fip,
+		OldFIP:  oldFIP,
+	}
+	// the IP claimed by a request which is denied is free again
+	defer func() {
+		if req.claim != "" && response != nil && !response.Allowed {
+			h.releaseClaim(ctx, req.claim, req.claimOwner)
+		}
+	}()
+
+	// the lookups are cancelled when a validator denies the request early
+	lookupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	h.lookupPoolAndQuota(lookupCtx, req)
+
+	for _, v := range h.fipValidators {
+		if err := v.Validate(ctx, h, req); err != nil {
+			// the lookups fail when the budget of the request is spent
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return h.transientResponse(ar, req, v.Name(), &TransientError{Err: fmt.Errorf("the request could not be validated in time")})
+			}
+			var transient *TransientError
+			if errors.As(err, &transient) {
+				return h.transientResponse(ar, req, v.Name(), transient)
+			}
+			var rateLimited *RateLimitError
+			if errors.As(err, &rateLimited) {
+				return rateLimitedResponse(ar, req, v.Name(), rateLimited)
+			}
+			response = denied(ar, err.Error())
+			response.AuditAnnotations = req.auditAnnotations("denied", v.Name())
+			return response
+		}
+	}
+
+	response = allowed(ar)
+	response.Warnings = req.Warnings
+	response.AuditAnnotations = req.auditAnnotations("allowed", "")
+	return response
+}
+
+// transientResponse returns the response for a request which could not be
+// validated because of a transient error. It is allowed with a warning when
+// the InternalFailurePolicy is Ignore, otherwise it is denied with a status
+// which tells the client to retry. While the circuit breaker is open the
+// DegradedPolicy is used instead.
+func (h *Handler) transientResponse(ar *admissionv1.AdmissionReview, req *FloatingIPRequest, validator string, err *TransientError) *admissionv1.AdmissionResponse {
+	policy, failure := h.options.InternalFailurePolicy, "transient"
+	if h.breaker.Degraded() {
+		failure = "degraded"
+		if h.options.DegradedPolicy != "" {
+			policy = h.options.DegradedPolicy
+		}
+	}
+
+	if policy == admregv1.Ignore {
+		req.Log.Warnf("(transientResponse) allowing request which %s could not validate: %s", validator, err)
+		response := allowed(ar)
+		response.Warnings = []string{fmt.Sprintf("the request was not fully validated, it is allowed by the internal failure policy: %s", err)}
+		response.AuditAnnotations = req.auditAnnotations("allowed", "")
+		response.AuditAnnotations["failure"] = failure
+		return response
+	}
+
+	response := denied(ar, fmt.Sprintf("%s, try again later", err))
+	response.Result.Code = http.StatusServiceUnavailable
+	response.Result.Reason = metav1.StatusReasonServiceUnavailable
+	response.AuditAnnotations = req.auditAnnotations("denied", validator)
+	response.AuditAnnotations["failure"] = failure
+	return response
+}
+
+// rateLimitedResponse returns the response for a request which was denied by
+// the rate limit, with a status which tells the client to retry.
+func rateLimitedResponse(ar *admissionv1.AdmissionReview, req *FloatingIPRequest, validator string, err *RateLimitError) *admissionv1.AdmissionResponse {
+	response := denied(ar, fmt.Sprintf("%s, try again later", err))
+	response.Result.Code = http.StatusTooManyRequests
+	response.Result.Reason = metav1.StatusReasonTooManyRequests
+	response.Result.Details = &metav1.StatusDetails{RetryAfterSeconds: int32(math.Ceil(err.RetryAfter.Seconds()))}
+	response.AuditAnnotations = req.auditAnnotations("denied", validator)
+	response.AuditAnnotations["failure"] = "rate-limited"
+	return response
+}
+
+// auditAnnotations returns the audit annotations describing the decision of the request.
+func (r *FloatingIPRequest) auditAnnotations(decision string, deniedBy string) map[string]string {
+	annotations := map[string]string{
+		"decision": decision,
+		"pool":     r.PoolName(),
+	}
+	if deniedBy != "" {
+		annotations["denied-by"] = deniedBy
+	}
+	if r.FIP.Spec.IPAddr != nil {
+		annotations["requested-ip"] = *r.FIP.Spec.IPAddr
+	}
+	if r.QuotaOverride != "" {
+		annotations["quota-override"] = r.QuotaOverride
+	}
+	if r.ProjectID != "" {
+		annotations["project"] = r.ProjectID
+	}
+	if r.quotaKnown {
+		annotations["quota"] = strconv.Itoa(r.Quota)
+		annotations["quota-used"] = strconv.Itoa(r.QuotaUsed)
+	}
+
+	return annotations
+}
+
+func (h *Handler) validateFloatingIPPool(ctx context.Context, logger *log.Entry, ar *admissionv1.AdmissionReview, fipPool *rfmv2.FloatingIPPool, oldPool *rfmv2.FloatingIPPool) *admissionv1.AdmissionResponse {
+	req := &FloatingIPPoolRequest{
+		Request: ar.Request,
+		Log:     logger,
+		Pool:    fipPool,
+		OldPool: oldPool,
+	}
+
+	for _, v := range h.fipPoolValidators {
+		if _, ok := v.(FloatingIPPoolDeleteValidator); req.IsDelete() && !ok {
+			continue
+		}
+		if _, ok := v.(FloatingIPPoolStatusValidator); req.IsStatus() && !ok {
+			continue
+		}
+		if err := v.Validate(ctx, h, req); err != nil {
+			response := denied(ar, err.Error())
+			response.AuditAnnotations = map[string]string{
+				"decision":  "denied",
+				"denied-by": v.Name(),
+				"pool":      fipPool.Name,
+			}
+			return response
+		}
+	}
+
+	response := allowed(ar)
+	response.Warnings = req.Warnings
+	response.AuditAnnotations = map[string]string{
+		"decision": "allowed",
+		"pool":     fipPool.Name,
+	}
+	return response
+}
+
+func (h *Handler) validateFloatingIPProjectQuota(ctx context.Context, logger *log.Entry, ar *admissionv1.AdmissionReview, quota *rfmv2.FloatingIPProjectQuota, oldQuota *rfmv2.FloatingIPProjectQuota) *admissionv1.AdmissionResponse {
+	req := &FloatingIPProjectQuotaRequest{
+		Request:  ar.Request,
+		Log:      logger,
+		Quota:    quota,
+		OldQuota: oldQuota,
+	}
+
+	// the quota validators only check the spec, status updates are allowed
+	validators := h.quotaValidators
+	if req.IsStatus() {
+		validators = nil
+	}
+	for _, v := range validators {
+		if err := v.Validate(ctx, h, req); err != nil {
+			response := denied(ar, err.Error())
+			response.AuditAnnotations = map[string]string{
+				"decision":  "denied",
+				"denied-by": v.Name(),
+				"project":   quota.Name,
+			}
+			return response
+		}
+	}
+
+	response := allowed(ar)
+	response.Warnings = req.Warnings
+	response.AuditAnnotations = map[string]string{
+		"decision": "allowed",
+		"project":  quota.Name,
+	}
+	return response
+}
+
+func allowed(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		UID:     ar.Request.UID,
+		Allowed: true,
+	}
+}
+
+func denied(ar *admissionv1.AdmissionReview, message string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		UID:     ar.Request.UID,
+		Allowed: false,
+		Result: &metav1.Status{
+			Message: message,
+		},
+	}
+}
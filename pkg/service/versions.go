@@ -0,0 +1,71 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// apiResources returns the API group, version and resource names of the
+// FloatingIP CRDs. The resources are read in the version of the rfmv2 types,
+// which the validators work on.
+func (h *Handler) apiResources() util.APIResources {
+	if h.options.APIResources.Group != "" {
+		return h.options.APIResources
+	}
+
+	return util.DefaultAPIResources()
+}
+
+// decodeObject decodes an object of an admission request into the rfmv2 type
+// into. The API server sends the object in the version of the matched rule,
+// objects of another version are converted to the version of the rfmv2 types
+// with the converter of their kind first.
+func (h *Handler) decodeObject(raw []byte, into interface{}) error {
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(raw, &typeMeta); err != nil {
+		return err
+	}
+
+	apiVersion := h.apiResources().GroupVersion().String()
+	if typeMeta.APIVersion != "" && typeMeta.APIVersion != apiVersion {
+		converted, err := h.convertObject(runtime.RawExtension{Raw: raw}, apiVersion)
+		if err != nil {
+			return err
+		}
+		raw = converted.Raw
+	}
+
+	return json.Unmarshal(raw, into)
+}
+
+// decodeObjects decodes the object and the old object of an admission request
+// into the rfmv2 type T. The object of a DELETE request is the old object, the
+// old object is only returned for UPDATE requests, so validators can compare
+// both versions of an updated object.
+func decodeObjects[T any](h *Handler, req *admissionv1.AdmissionRequest, kind string) (*T, *T, error) {
+	raw := req.Object.Raw
+	if req.Operation == admissionv1.Delete {
+		raw = req.OldObject.Raw
+	}
+
+	obj := new(T)
+	if err := h.decodeObject(raw, obj); err != nil {
+		return nil, nil, fmt.Errorf("cannot unmarshal json to %s: %s", kind, err)
+	}
+
+	if req.Operation != admissionv1.Update || req.OldObject.Raw == nil {
+		return obj, nil, nil
+	}
+
+	old := new(T)
+	if err := h.decodeObject(req.OldObject.Raw, old); err != nil {
+		return nil, nil, fmt.Errorf("cannot unmarshal json to old %s: %s", kind, err)
+	}
+
+	return obj, old, nil
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestDecodeObject(t *testing.T) {
+	h := &Handler{}
+	// the v1 FloatingIPPool renames the targetCluster field
+	h.RegisterConverter("FloatingIPPool", func(obj *unstructured.Unstructured, toVersion string) error {
+		if obj.GetAPIVersion() == "rancher.k8s.binbash.org/v1" {
+			cluster, _, _ := unstructured.NestedString(obj.Object, "spec", "cluster")
+			unstructured.RemoveNestedField(obj.Object, "spec", "cluster")
+			return unstructured.SetNestedField(obj.Object, cluster, "spec", "targetCluster")
+		}
+		return nil
+	})
+
+	for _, tc := range []struct {
+		name     string
+		raw      string
+		expected string
+		err      string
+	}{
+		{
+			name:     "version of the rfmv2 types",
+			raw:      `{"apiVersion":"rancher.k8s.binbash.org/v1beta2","kind":"FloatingIPPool","metadata":{"name":"test"},"spec":{"targetCluster":"c-abcde"}}`,
+			expected: "c-abcde",
+		},
+		{
+			name:     "older version with the same schema",
+			raw:      `{"apiVersion":"rancher.k8s.binbash.org/v1beta1","kind":"FloatingIPPool","metadata":{"name":"test"},"spec":{"targetCluster":"c-abcde"}}`,
+			expected: "c-abcde",
+		},
+		{
+			name:     "newer version is converted",
+			raw:      `{"apiVersion":"rancher.k8s.binbash.org/v1","kind":"FloatingIPPool","metadata":{"name":"test"},"spec":{"cluster":"c-abcde"}}`,
+			expected: "c-abcde",
+		},
+		{
+			name:     "object without apiVersion",
+			raw:      `{"metadata":{"name":"test"},"spec":{"targetCluster":"c-abcde"}}`,
+			expected: "c-abcde",
+		},
+		{
+			name: "other group",
+			raw:  `{"apiVersion":"example.com/v1","kind":"FloatingIPPool","metadata":{"name":"test"}}`,
+			err:  "cannot convert FloatingIPPool test from example.com/v1 to rancher.k8s.binbash.org/v1beta2, only the rancher.k8s.binbash.org group is served",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pool := &rfmv2.FloatingIPPool{}
+			err := h.decodeObject([]byte(tc.raw), pool)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "test", pool.Name)
+			assert.Equal(t, tc.expected, pool.Spec.TargetCluster)
+		})
+	}
+}
+
+func TestDecodeObjects(t *testing.T) {
+	h := &Handler{}
+	quota := func(name string, limit int) runtime.RawExtension {
+		return runtime.RawExtension{Raw: []byte(fmt.Sprintf(`{"apiVersion":"rancher.k8s.binbash.org/v1beta2","kind":"FloatingIPProjectQuota","metadata":{"name":%q},"spec":{"floatingIPQuota":{"pool":%d}}}`, name, limit))}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		request   *admissionv1.AdmissionRequest
+		expected  int
+		expectOld bool
+		oldLimit  int
+		err       string
+	}{
+		{
+			name:     "create",
+			request:  &admissionv1.AdmissionRequest{Operation: admissionv1.Create, Object: quota("p-abcde", 5)},
+			expected: 5,
+		},
+		{
+			name:      "update",
+			request:   &admissionv1.AdmissionRequest{Operation: admissionv1.Update, Object: quota("p-abcde", 5), OldObject: quota("p-abcde", 10)},
+			expected:  5,
+			expectOld: true,
+			oldLimit:  10,
+		},
+		{
+			name:     "update without old object",
+			request:  &admissionv1.AdmissionRequest{Operation: admissionv1.Update, Object: quota("p-abcde", 5)},
+			expected: 5,
+		},
+		{
+			name:     "delete decodes the old object",
+			request:  &admissionv1.AdmissionRequest{Operation: admissionv1.Delete, OldObject: quota("p-abcde", 10)},
+			expected: 10,
+		},
+		{
+			name:    "invalid old object",
+			request: &admissionv1.AdmissionRequest{Operation: admissionv1.Update, Object: quota("p-abcde", 5), OldObject: runtime.RawExtension{Raw: []byte("{")}},
+			err:     "cannot unmarshal json to old FloatingIPProjectQuota: unexpected end of JSON input",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			obj, old, err := decodeObjects[rfmv2.FloatingIPProjectQuota](h, tc.request, "FloatingIPProjectQuota")
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, obj.Spec.FloatingIPQuota["pool"])
+			if !tc.expectOld {
+				assert.Nil(t, old)
+				return
+			}
+			assert.Equal(t, tc.oldLimit, old.Spec.FloatingIPQuota["pool"])
+		})
+	}
+}
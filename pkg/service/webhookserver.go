@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/health"
+	log "github.com/sirupsen/logrus"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+const (
+	// ServerDefault serves the endpoints with a net/http server.
+	ServerDefault = "default"
+	// ServerControllerRuntime serves the endpoints with the webhook server of
+	// controller-runtime.
+	ServerControllerRuntime = "controller-runtime"
+)
+
+var setControllerRuntimeLogger sync.Once
+
+// webhookServerOptions returns the options of the controller-runtime webhook
+// server. Without a CertDir the certificate of GetCertificate is served, so
+// the CSR based certificate management keeps working.
+func (h *Handler) webhookServerOptions() (webhook.Options, error) {
+	host, port, err := net.SplitHostPort(h.options.Address)
+	if err != nil {
+		return webhook.Options{}, fmt.Errorf("invalid address %s: %s", h.options.Address, err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return webhook.Options{}, fmt.Errorf("invalid port in address %s: %s", h.options.Address, err)
+	}
+
+	options := webhook.Options{
+		Host:    host,
+		Port:    portNumber,
+		CertDir: h.options.CertDir,
+	}
+	if h.options.DisableHTTP2 {
+		options.TLSOpts = append(options.TLSOpts, func(cfg *tls.Config) { cfg.NextProtos = []string{"http/1.1"} })
+	}
+	if h.options.CertDir == "" {
+		options.TLSOpts = append(options.TLSOpts, func(cfg *tls.Config) { cfg.GetCertificate = h.options.GetCertificate })
+	}
+
+	return options, nil
+}
+
+// runWebhookServer serves the endpoints with the webhook server of
+// controller-runtime until Stop is called. The endpoints are the same as
+// with the default server, the timeouts of the options are not applied.
+func (h *Handler) runWebhookServer() {
+	setControllerRuntimeLogger.Do(func() {
+		ctrllog.SetLogger(funcr.New(func(prefix, args string) {
+			log.Debugf("(webhookServer) %s %s", prefix, args)
+		}, funcr.Options{}))
+	})
+
+	options, err := h.webhookServerOptions()
+	if err != nil {
+		log.Errorf("HTTP server error: %v", err)
+		health.Fail(HealthComponent, err)
+		return
+	}
+	server := webhook.NewServer(options)
+	h.registerHandlers(server.Register)
+
+	ctx, cancel := context.WithCancel(h.ctx)
+	done := make(chan struct{})
+	h.serverMu.Lock()
+	h.serverCancel, h.serverDone = cancel, done
+	h.serverMu.Unlock()
+	defer close(done)
+
+	health.Beat(HealthComponent, 0)
+	if err := server.Start(ctx); err != nil {
+		log.Errorf("HTTP server error: %v", err)
+		health.Fail(HealthComponent, err)
+	}
+}
+
+// stopWebhookServer stops the controller-runtime webhook server and waits
+// until it is shut down.
+func (h *Handler) stopWebhookServer() error {
+	h.serverMu.Lock()
+	cancel, done := h.serverCancel, h.serverDone
+	h.serverMu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	<-done
+
+	return nil
+}
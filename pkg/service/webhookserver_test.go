@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func selfSignedCertificate(t *testing.T) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestWebhookServerOptions(t *testing.T) {
+	cert := selfSignedCertificate(t)
+	h := &Handler{options: Options{
+		Address:        "127.0.0.1:9443",
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return cert, nil },
+	}}
+
+	options, err := h.webhookServerOptions()
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", options.Host)
+	assert.Equal(t, 9443, options.Port)
+	cfg := &tls.Config{}
+	for _, opt := range options.TLSOpts {
+		opt(cfg)
+	}
+	served, err := cfg.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, cert, served)
+	assert.Empty(t, cfg.NextProtos)
+
+	// the certificate of the cert dir is reloaded by controller-runtime
+	h.options.CertDir = "/tmp/serving-certs"
+	h.options.DisableHTTP2 = true
+	options, err = h.webhookServerOptions()
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/serving-certs", options.CertDir)
+	cfg = &tls.Config{}
+	for _, opt := range options.TLSOpts {
+		opt(cfg)
+	}
+	assert.Nil(t, cfg.GetCertificate)
+	assert.Equal(t, []string{"http/1.1"}, cfg.NextProtos)
+
+	h.options.Address = "localhost"
+	_, err = h.webhookServerOptions()
+	assert.Error(t, err)
+}
+
+func TestRunWebhookServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	cert := selfSignedCertificate(t)
+	h := &Handler{
+		ctx: context.Background(),
+		options: Options{
+			Address:        address,
+			Server:         ServerControllerRuntime,
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return cert, nil },
+		},
+	}
+	stopped := make(chan struct{})
+	go func() {
+		h.Run()
+		close(stopped)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get(fmt.Sprintf("https://%s/version", address))
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 10*time.Second, 100*time.Millisecond)
+
+	assert.NoError(t, h.Stop())
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the webhook server did not stop")
+	}
+}
@@ -0,0 +1,109 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// DefaultClientQPS and DefaultClientBurst are the rate limits of the clients
+// when ClientOptions doesn't set them. The client-go defaults of 5 and 10 are
+// too low for a webhook which looks up resources for every admission request.
+const (
+	DefaultClientQPS   = 20
+	DefaultClientBurst = 40
+)
+
+// DefaultClientTimeout is the timeout of a request of the clients when
+// ClientOptions doesn't set one, so a hanging API server doesn't block the
+// certificate management forever.
+const DefaultClientTimeout = 30 * time.Second
+
+// ClientOptions holds the settings of the Kubernetes clients.
+type ClientOptions struct {
+	// KubeConfig and KubeContext select the kubeconfig, the in-cluster config
+	// is used when the kubeconfig doesn't exist.
+	KubeConfig  string
+	KubeContext string
+	// QPS and Burst are the rate limits of the clients, DefaultClientQPS and
+	// DefaultClientBurst are used when they are 0.
+	QPS   float32
+	Burst int
+	// Timeout is the timeout of a request, DefaultClientTimeout is used when
+	// it is 0. Admission requests are also bounded by their own deadline.
+	Timeout time.Duration
+	// UserAgent is sent with every request, the client-go default is used
+	// when it is empty.
+	UserAgent string
+}
+
+// Clients holds the Kubernetes clients which are shared by the handlers, so
+// they all talk to the same cluster with the same settings.
+type Clients struct {
+	Config    *rest.Config
+	Clientset kubernetes.Interface
+	Dynamic   dynamic.Interface
+}
+
+// NewClients creates the clients for the kubeconfig of the options.
+func NewClients(options ClientOptions) (*Clients, error) {
+	config, err := GetKubeConfig(options.KubeConfig, options.KubeContext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubeconfig: %v", err)
+	}
+
+	config = rest.CopyConfig(config)
+	config.QPS = options.QPS
+	if config.QPS <= 0 {
+		config.QPS = DefaultClientQPS
+	}
+	config.Burst = options.Burst
+	if config.Burst <= 0 {
+		config.Burst = DefaultClientBurst
+	}
+	config.Timeout = options.Timeout
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultClientTimeout
+	}
+	if options.UserAgent != "" {
+		config.UserAgent = options.UserAgent
+	}
+
+	return NewClientsForConfig(config)
+}
+
+// NewClientsForConfig creates the clients for the given rest config. The
+// clientset uses protobuf, which the API server encodes and decodes with less
+// CPU than JSON. The dynamic client keeps using JSON, the CRDs of the
+// FloatingIP resources are only served as JSON.
+func NewClientsForConfig(config *rest.Config) (*Clients, error) {
+	clientset, err := kubernetes.NewForConfig(protobufConfig(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clientset: %v", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %v", err)
+	}
+
+	return &Clients{
+		Config:    config,
+		Clientset: clientset,
+		Dynamic:   dynamicClient,
+	}, nil
+}
+
+// protobufConfig returns a copy of the config which prefers protobuf, JSON is
+// still accepted for the resources which are not served as protobuf.
+func protobufConfig(config *rest.Config) *rest.Config {
+	config = rest.CopyConfig(config)
+	config.ContentType = runtime.ContentTypeProtobuf
+	config.AcceptContentTypes = strings.Join([]string{runtime.ContentTypeProtobuf, runtime.ContentTypeJSON}, ",")
+
+	return config
+}
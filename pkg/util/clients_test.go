@@ -0,0 +1,86 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/rest"
+)
+
+const testKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    token: test
+`
+
+func TestNewClients(t *testing.T) {
+	kubeConfig := filepath.Join(t.TempDir(), "config")
+	assert.NoError(t, os.WriteFile(kubeConfig, []byte(testKubeConfig), 0600))
+
+	tests := []struct {
+		name              string
+		options           ClientOptions
+		expectedQPS       float32
+		expectedBurst     int
+		expectedTimeout   time.Duration
+		expectedUserAgent string
+	}{
+		{
+			name:            "default values",
+			options:         ClientOptions{KubeConfig: kubeConfig},
+			expectedQPS:     DefaultClientQPS,
+			expectedBurst:   DefaultClientBurst,
+			expectedTimeout: DefaultClientTimeout,
+		},
+		{
+			name:              "custom values",
+			options:           ClientOptions{KubeConfig: kubeConfig, KubeContext: "test", QPS: 50, Burst: 100, Timeout: 5 * time.Second, UserAgent: "webhook/v1.0.0"},
+			expectedQPS:       50,
+			expectedBurst:     100,
+			expectedTimeout:   5 * time.Second,
+			expectedUserAgent: "webhook/v1.0.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients, err := NewClients(tt.options)
+			assert.NoError(t, err)
+			assert.Equal(t, "https://127.0.0.1:6443", clients.Config.Host)
+			assert.Equal(t, tt.expectedQPS, clients.Config.QPS)
+			assert.Equal(t, tt.expectedBurst, clients.Config.Burst)
+			assert.Equal(t, tt.expectedTimeout, clients.Config.Timeout)
+			assert.Equal(t, tt.expectedUserAgent, clients.Config.UserAgent)
+			assert.NotNil(t, clients.Clientset)
+			assert.NotNil(t, clients.Dynamic)
+		})
+	}
+}
+
+func TestProtobufConfig(t *testing.T) {
+	config := &rest.Config{Host: "https://127.0.0.1:6443"}
+
+	protobuf := protobufConfig(config)
+	assert.Equal(t, "application/vnd.kubernetes.protobuf", protobuf.ContentType)
+	assert.Equal(t, "application/vnd.kubernetes.protobuf,application/json", protobuf.AcceptContentTypes)
+	assert.Equal(t, config.Host, protobuf.Host)
+
+	// the config of the dynamic client is not changed
+	assert.Empty(t, config.ContentType)
+	assert.Empty(t, config.AcceptContentTypes)
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ManagedByLabel marks the resources which are created by the webhook, so
+	// they can be found and removed after an uninstall.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ComponentLabel describes the role of the resource within the webhook.
+	ComponentLabel = "app.kubernetes.io/component"
+)
+
+// ManagedLabels returns the labels which are set on every resource the webhook creates.
+func ManagedLabels(webhookName string, component string) map[string]string {
+	return map[string]string{
+		ManagedByLabel: webhookName,
+		ComponentLabel: component,
+	}
+}
+
+// ManagedSelector returns the label selector which matches all resources created by the webhook.
+func ManagedSelector(webhookName string) string {
+	return fmt.Sprintf("%s=%s", ManagedByLabel, webhookName)
+}
+
+// ExtraMetadata holds the labels and annotations which are set on every
+// resource the webhook creates besides the managed labels, for example to let
+// GitOps tooling recognize and ignore the resources.
+type ExtraMetadata struct {
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// Apply sets the extra labels and annotations on the metadata of a resource.
+// The managed labels are never overwritten.
+func (m ExtraMetadata) Apply(meta *metav1.ObjectMeta) {
+	for key, value := range m.Labels {
+		if key == ManagedByLabel || key == ComponentLabel {
+			continue
+		}
+		if meta.Labels == nil {
+			meta.Labels = make(map[string]string)
+		}
+		meta.Labels[key] = value
+	}
+	for key, value := range m.Annotations {
+		if meta.Annotations == nil {
+			meta.Annotations = make(map[string]string)
+		}
+		meta.Annotations[key] = value
+	}
+}
@@ -0,0 +1,29 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExtraMetadataApply(t *testing.T) {
+	meta := metav1.ObjectMeta{Labels: ManagedLabels("my-webhook", "webhook-configuration")}
+	ExtraMetadata{}.Apply(&meta)
+	assert.Equal(t, ManagedLabels("my-webhook", "webhook-configuration"), meta.Labels)
+	assert.Nil(t, meta.Annotations)
+
+	ExtraMetadata{
+		Labels: map[string]string{
+			"team":         "network",
+			ManagedByLabel: "argocd",
+		},
+		Annotations: map[string]string{"argocd.argoproj.io/compare-options": "IgnoreExtraneous"},
+	}.Apply(&meta)
+	assert.Equal(t, map[string]string{
+		ManagedByLabel: "my-webhook",
+		ComponentLabel: "webhook-configuration",
+		"team":         "network",
+	}, meta.Labels)
+	assert.Equal(t, map[string]string{"argocd.argoproj.io/compare-options": "IgnoreExtraneous"}, meta.Annotations)
+}
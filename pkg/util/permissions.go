@@ -0,0 +1,74 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Permission is an action on a resource which the webhook needs to be allowed
+// to do. An empty Namespace is a cluster-wide permission, an empty Name is a
+// permission on all resources of the type.
+type Permission struct {
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+	Namespace   string
+	Name        string
+}
+
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource = fmt.Sprintf("%s.%s", resource, p.Group)
+	}
+	if p.Subresource != "" {
+		resource = fmt.Sprintf("%s/%s", resource, p.Subresource)
+	}
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "%s %s", p.Verb, resource)
+	if p.Name != "" {
+		fmt.Fprintf(&s, " named %s", p.Name)
+	}
+	if p.Namespace != "" {
+		fmt.Fprintf(&s, " in namespace %s", p.Namespace)
+	}
+
+	return s.String()
+}
+
+// MissingPermissions runs a SelfSubjectAccessReview for every permission and
+// returns the permissions which are not allowed.
+func MissingPermissions(ctx context.Context, clientset kubernetes.Interface, permissions []Permission) ([]Permission, error) {
+	var missing []Permission
+	for _, p := range permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Verb:        p.Verb,
+					Group:       p.Group,
+					Resource:    p.Resource,
+					Subresource: p.Subresource,
+					Namespace:   p.Namespace,
+					Name:        p.Name,
+				},
+			},
+		}
+
+		result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("cannot check permission to %s: %v", p, err)
+		}
+		if !result.Status.Allowed {
+			missing = append(missing, p)
+		}
+	}
+
+	return missing, nil
+}
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestPermissionString(t *testing.T) {
+	tests := []struct {
+		name       string
+		permission Permission
+		want       string
+	}{
+		{
+			name:       "core resource in a namespace",
+			permission: Permission{Verb: "get", Resource: "secrets", Namespace: "rancher-fip-manager"},
+			want:       "get secrets in namespace rancher-fip-manager",
+		},
+		{
+			name:       "named subresource",
+			permission: Permission{Verb: "update", Group: "certificates.k8s.io", Resource: "certificatesigningrequests", Subresource: "approval"},
+			want:       "update certificatesigningrequests.certificates.k8s.io/approval",
+		},
+		{
+			name:       "named resource",
+			permission: Permission{Verb: "delete", Group: "admissionregistration.k8s.io", Resource: "validatingwebhookconfigurations", Name: "rancher-fip-manager-validator"},
+			want:       "delete validatingwebhookconfigurations.admissionregistration.k8s.io named rancher-fip-manager-validator",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.permission.String())
+		})
+	}
+}
+
+func TestMissingPermissions(t *testing.T) {
+	permissions := []Permission{
+		{Verb: "get", Resource: "secrets", Namespace: "rancher-fip-manager"},
+		{Verb: "delete", Resource: "secrets", Namespace: "rancher-fip-manager"},
+		{Verb: "list", Group: "rancher.k8s.binbash.org", Resource: "floatingippools"},
+	}
+
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Verb != "delete"
+		return true, review, nil
+	})
+
+	missing, err := MissingPermissions(context.Background(), clientset, permissions)
+	assert.NoError(t, err)
+	assert.Equal(t, []Permission{permissions[1]}, missing)
+
+	clientset = fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+
+	_, err = MissingPermissions(context.Background(), clientset, permissions)
+	assert.EqualError(t, err, "cannot check permission to get secrets in namespace rancher-fip-manager: connection refused")
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// APIResources holds the API group, version and resource names of the
+// FloatingIP CRDs, so forks which serve the CRDs under another group or with
+// other resource names don't need source edits.
+type APIResources struct {
+	// Group is the API group of the CRDs.
+	Group string
+	// Version is the API version in which the webhook reads the resources.
+	Version string
+	// FloatingIPs, FloatingIPPools and FloatingIPProjectQuotas are the
+	// plural resource names of the CRDs.
+	FloatingIPs             string
+	FloatingIPPools         string
+	FloatingIPProjectQuotas string
+}
+
+// DefaultAPIResources returns the API group, version and resource names of
+// the CRDs of rancher-fip-manager.
+func DefaultAPIResources() APIResources {
+	return APIResources{
+		Group:                   "rancher.k8s.binbash.org",
+		Version:                 "v1beta2",
+		FloatingIPs:             "floatingips",
+		FloatingIPPools:         "floatingippools",
+		FloatingIPProjectQuotas: "floatingipprojectquotas",
+	}
+}
+
+// GroupVersion returns the group and the version in which the webhook reads
+// the resources.
+func (r APIResources) GroupVersion() schema.GroupVersion {
+	return schema.GroupVersion{Group: r.Group, Version: r.Version}
+}
+
+// FloatingIPGVR returns the GroupVersionResource of the FloatingIPs.
+func (r APIResources) FloatingIPGVR() schema.GroupVersionResource {
+	return r.GroupVersion().WithResource(r.FloatingIPs)
+}
+
+// FloatingIPPoolGVR returns the GroupVersionResource of the FloatingIPPools.
+func (r APIResources) FloatingIPPoolGVR() schema.GroupVersionResource {
+	return r.GroupVersion().WithResource(r.FloatingIPPools)
+}
+
+// FloatingIPProjectQuotaGVR returns the GroupVersionResource of the
+// FloatingIPProjectQuotas.
+func (r APIResources) FloatingIPProjectQuotaGVR() schema.GroupVersionResource {
+	return r.GroupVersion().WithResource(r.FloatingIPProjectQuotas)
+}
+
+// CRDNames returns the names of the CustomResourceDefinitions of the resources.
+func (r APIResources) CRDNames() []string {
+	return []string{
+		fmt.Sprintf("%s.%s", r.FloatingIPs, r.Group),
+		fmt.Sprintf("%s.%s", r.FloatingIPPools, r.Group),
+		fmt.Sprintf("%s.%s", r.FloatingIPProjectQuotas, r.Group),
+	}
+}
@@ -0,0 +1,30 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestAPIResources(t *testing.T) {
+	resources := DefaultAPIResources()
+	assert.Equal(t, schema.GroupVersionResource{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingips"}, resources.FloatingIPGVR())
+	assert.Equal(t, []string{
+		"floatingips.rancher.k8s.binbash.org",
+		"floatingippools.rancher.k8s.binbash.org",
+		"floatingipprojectquotas.rancher.k8s.binbash.org",
+	}, resources.CRDNames())
+
+	resources.Group = "fip.example.com"
+	resources.Version = "v1"
+	resources.FloatingIPPools = "ippools"
+	assert.Equal(t, "fip.example.com/v1", resources.GroupVersion().String())
+	assert.Equal(t, schema.GroupVersionResource{Group: "fip.example.com", Version: "v1", Resource: "ippools"}, resources.FloatingIPPoolGVR())
+	assert.Equal(t, schema.GroupVersionResource{Group: "fip.example.com", Version: "v1", Resource: "floatingipprojectquotas"}, resources.FloatingIPProjectQuotaGVR())
+	assert.Equal(t, []string{
+		"floatingips.fip.example.com",
+		"ippools.fip.example.com",
+		"floatingipprojectquotas.fip.example.com",
+	}, resources.CRDNames())
+}
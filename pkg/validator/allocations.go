@@ -0,0 +1,157 @@
+package validator
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"net"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+)
+
+// MaxIndexedPoolSize is the largest pool range which is indexed by
+// PoolAllocations, larger ranges (like most IPv6 ranges) are not indexed.
+const MaxIndexedPoolSize = 1 << 20
+
+// PoolAllocations is a bitmap of the excluded and allocated IP addresses in
+// the range of a pool, which answers if an address is free and how many
+// addresses are free in constant time.
+type PoolAllocations struct {
+	start     *big.Int
+	ipv4      bool
+	size      int
+	excluded  []uint64
+	allocated []uint64
+	free      int
+	// first is the offset of the first free address, or size when the range
+	// is full
+	first int
+}
+
+// NewPoolAllocations returns the allocations of the pool range of ipConfig
+// with the allocated addresses of the pool status. Addresses outside of the
+// range are ignored. The pool range must be valid and not larger than the
+// MaxIndexedPoolSize.
+func NewPoolAllocations(ipConfig *rfmv2.IPConfig, allocated map[string]string) (*PoolAllocations, error) {
+	startIP := net.ParseIP(ipConfig.Pool.Start)
+	endIP := net.ParseIP(ipConfig.Pool.End)
+	if startIP == nil || endIP == nil {
+		return nil, fmt.Errorf("invalid pool range [%s, %s]", ipConfig.Pool.Start, ipConfig.Pool.End)
+	}
+	size := RangeSize(startIP, endIP)
+	if size.Sign() == 0 {
+		return nil, fmt.Errorf("empty pool range [%s, %s]", ipConfig.Pool.Start, ipConfig.Pool.End)
+	}
+	if size.Cmp(big.NewInt(MaxIndexedPoolSize)) > 0 {
+		return nil, fmt.Errorf("pool range [%s, %s] has more than %d addresses", ipConfig.Pool.Start, ipConfig.Pool.End, MaxIndexedPoolSize)
+	}
+
+	ipv4 := startIP.To4() != nil
+	if ipv4 {
+		startIP = startIP.To4()
+	}
+	words := (int(size.Int64()) + 63) / 64
+	a := &PoolAllocations{
+		start:     new(big.Int).SetBytes(startIP),
+		ipv4:      ipv4,
+		size:      int(size.Int64()),
+		excluded:  make([]uint64, words),
+		allocated: make([]uint64, words),
+	}
+	for _, ip := range ipConfig.Pool.Exclude {
+		if offset, ok := a.offset(net.ParseIP(ip)); ok {
+			a.excluded[offset/64] |= 1 << (offset % 64)
+		}
+	}
+	for ip := range allocated {
+		if offset, ok := a.offset(net.ParseIP(ip)); ok {
+			a.allocated[offset/64] |= 1 << (offset % 64)
+		}
+	}
+
+	a.first = a.size
+	for i := range a.excluded {
+		used := a.excluded[i] | a.allocated[i]
+		// the bits after the end of the range are not free
+		if rest := a.size - i*64; rest < 64 {
+			used |= ^uint64(0) << rest
+		}
+		a.free += 64 - bits.OnesCount64(used)
+		if a.first == a.size && used != ^uint64(0) {
+			a.first = i*64 + bits.TrailingZeros64(^used)
+		}
+	}
+
+	return a, nil
+}
+
+// offset returns the position of the IP address in the range.
+func (a *PoolAllocations) offset(ip net.IP) (int, bool) {
+	if ip == nil {
+		return 0, false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		if !a.ipv4 {
+			return 0, false
+		}
+		ip = ip4
+	} else if a.ipv4 {
+		return 0, false
+	}
+
+	offset := new(big.Int).Sub(new(big.Int).SetBytes(ip), a.start)
+	if offset.Sign() < 0 || offset.Cmp(big.NewInt(int64(a.size))) >= 0 {
+		return 0, false
+	}
+
+	return int(offset.Int64()), true
+}
+
+// Contains returns true if the IP address is in the pool range.
+func (a *PoolAllocations) Contains(ip net.IP) bool {
+	_, ok := a.offset(ip)
+	return ok
+}
+
+// IsExcluded returns true if the IP address is in the exclude list of the pool.
+func (a *PoolAllocations) IsExcluded(ip net.IP) bool {
+	offset, ok := a.offset(ip)
+	return ok && a.excluded[offset/64]&(1<<(offset%64)) != 0
+}
+
+// IsAllocated returns true if the IP address is allocated in the pool status.
+func (a *PoolAllocations) IsAllocated(ip net.IP) bool {
+	offset, ok := a.offset(ip)
+	return ok && a.allocated[offset/64]&(1<<(offset%64)) != 0
+}
+
+// IsFree returns true if the IP address is in the pool range and neither
+// excluded nor allocated.
+func (a *PoolAllocations) IsFree(ip net.IP) bool {
+	offset, ok := a.offset(ip)
+	return ok && (a.excluded[offset/64]|a.allocated[offset/64])&(1<<(offset%64)) == 0
+}
+
+// Free returns the number of free IP addresses in the pool range.
+func (a *PoolAllocations) Free() int {
+	return a.free
+}
+
+// NextFree returns the lowest free IP address of the pool range, or nil when
+// every address is excluded or allocated.
+func (a *PoolAllocations) NextFree() net.IP {
+	if a.first >= a.size {
+		return nil
+	}
+
+	ip := new(big.Int).Add(a.start, big.NewInt(int64(a.first))).Bytes()
+	length := net.IPv6len
+	if a.ipv4 {
+		length = net.IPv4len
+	}
+	// the leading zero bytes are dropped by Bytes
+	result := make(net.IP, length)
+	copy(result[length-len(ip):], ip)
+
+	return result
+}
@@ -0,0 +1,76 @@
+package validator
+
+import (
+	"net"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolAllocations(t *testing.T) {
+	ipConfig := &rfmv2.IPConfig{
+		Subnet: "192.168.1.0/24",
+		Pool: rfmv2.Pool{
+			Start:   "192.168.1.10",
+			End:     "192.168.1.80",
+			Exclude: []string{"192.168.1.10", "192.168.1.200"},
+		},
+	}
+	a, err := NewPoolAllocations(ipConfig, map[string]string{
+		"192.168.1.11": "default/fip-1",
+		"192.168.1.75": "default/fip-2",
+		"10.0.0.1":     "default/fip-3",
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 68, a.Free())
+	assert.Equal(t, net.ParseIP("192.168.1.12").To4(), a.NextFree())
+	assert.True(t, a.Contains(net.ParseIP("192.168.1.80")))
+	assert.False(t, a.Contains(net.ParseIP("192.168.1.81")))
+	assert.False(t, a.Contains(net.ParseIP("192.168.1.9")))
+	assert.False(t, a.Contains(net.ParseIP("2001:db8::1")))
+	assert.True(t, a.IsExcluded(net.ParseIP("192.168.1.10")))
+	assert.True(t, a.IsAllocated(net.ParseIP("192.168.1.75")))
+	assert.False(t, a.IsFree(net.ParseIP("192.168.1.11")))
+	assert.True(t, a.IsFree(net.ParseIP("192.168.1.12")))
+	// the IPv4-mapped IPv6 notation is the same address
+	assert.True(t, a.IsFree(net.ParseIP("::ffff:192.168.1.12")))
+	assert.False(t, a.IsFree(net.ParseIP("192.168.1.81")))
+	assert.False(t, a.IsFree(nil))
+}
+
+func TestPoolAllocationsFull(t *testing.T) {
+	ipConfig := &rfmv2.IPConfig{
+		Subnet: "2001:db8::/64",
+		Pool:   rfmv2.Pool{Start: "2001:db8::ff", End: "2001:db8::101", Exclude: []string{"2001:db8::100"}},
+	}
+	a, err := NewPoolAllocations(ipConfig, map[string]string{"2001:db8::ff": "default/fip-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, a.Free())
+	assert.Equal(t, net.ParseIP("2001:db8::101"), a.NextFree())
+	assert.False(t, a.Contains(net.ParseIP("192.168.1.1")))
+
+	a, err = NewPoolAllocations(ipConfig, map[string]string{"2001:db8::ff": "default/fip-1", "2001:db8::101": "default/fip-2"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, a.Free())
+	assert.Nil(t, a.NextFree())
+}
+
+func TestNewPoolAllocationsErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		start string
+		end   string
+		err   string
+	}{
+		{name: "invalid start", start: "192.168.1", end: "192.168.1.10", err: "invalid pool range [192.168.1, 192.168.1.10]"},
+		{name: "empty range", start: "192.168.1.10", end: "192.168.1.1", err: "empty pool range [192.168.1.10, 192.168.1.1]"},
+		{name: "too large", start: "2001:db8::", end: "2001:db8::ffff:ffff", err: "pool range [2001:db8::, 2001:db8::ffff:ffff] has more than 1048576 addresses"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewPoolAllocations(&rfmv2.IPConfig{Pool: rfmv2.Pool{Start: tc.start, End: tc.end}}, nil)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
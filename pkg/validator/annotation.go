@@ -0,0 +1,96 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ValidateEnum checks that the value is one of the allowed values.
+func ValidateEnum(value string, allowed ...string) error {
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("must be one of: %s", strings.Join(allowed, ", "))
+}
+
+// ValidateFQDN checks that the value is a fully qualified, lowercase domain
+// name like "web.example.com", an optional trailing dot is allowed.
+func ValidateFQDN(value string) error {
+	name := strings.TrimSuffix(value, ".")
+	if !strings.Contains(name, ".") {
+		return fmt.Errorf("must be a fully qualified domain name like web.example.com")
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("must be a fully qualified domain name like web.example.com: %s", strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// ParseObjectReference parses a reference to a namespaced object of the form
+// "namespace/name".
+func ParseObjectReference(value string) (string, string, error) {
+	namespace, name, ok := strings.Cut(value, "/")
+	if !ok || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("must be a reference of the form namespace/name")
+	}
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return "", "", fmt.Errorf("invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return "", "", fmt.Errorf("invalid name %q: %s", name, strings.Join(errs, ", "))
+	}
+
+	return namespace, name, nil
+}
+
+// ValidateObjectReference checks that the value is a reference of the form
+// "namespace/name".
+func ValidateObjectReference(value string) error {
+	_, _, err := ParseObjectReference(value)
+	return err
+}
+
+// ClosestKey returns the known key which is closest to the key, for
+// suggestions of misspelled keys. It returns an empty string when no key is
+// within a few edits.
+func ClosestKey(key string, known []string) string {
+	const maxEdits = 3
+
+	closest, closestEdits := "", maxEdits+1
+	for _, k := range known {
+		if edits := editDistance(key, k); edits < closestEdits {
+			closest, closestEdits = k, edits
+		}
+	}
+
+	return closest
+}
+
+// editDistance returns the Levenshtein distance of a and b.
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
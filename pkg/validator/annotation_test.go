@@ -0,0 +1,62 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEnum(t *testing.T) {
+	assert.NoError(t, ValidateEnum("true", "true", "false"))
+	assert.EqualError(t, ValidateEnum("yes", "true", "false"), "must be one of: true, false")
+	assert.Error(t, ValidateEnum("True", "true", "false"))
+}
+
+func TestValidateFQDN(t *testing.T) {
+	testCases := []struct {
+		name        string
+		value       string
+		expectError bool
+	}{
+		{name: "fqdn", value: "web.example.com"},
+		{name: "trailing dot", value: "web.example.com."},
+		{name: "hyphens", value: "web-01.prod-eu.example.com"},
+		{name: "single label", value: "web", expectError: true},
+		{name: "empty", value: "", expectError: true},
+		{name: "uppercase", value: "Web.Example.com", expectError: true},
+		{name: "underscore", value: "web_01.example.com", expectError: true},
+		{name: "empty label", value: "web..example.com", expectError: true},
+		{name: "url", value: "https://web.example.com", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateFQDN(tc.value)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestParseObjectReference(t *testing.T) {
+	namespace, name, err := ParseObjectReference("team-a/web-01")
+	assert.NoError(t, err)
+	assert.Equal(t, "team-a", namespace)
+	assert.Equal(t, "web-01", name)
+
+	for _, value := range []string{"", "web-01", "team-a/", "/web-01", "team-a/web/01", "Team-A/web-01", "team-a/Web_01"} {
+		assert.Error(t, ValidateObjectReference(value), value)
+	}
+}
+
+func TestClosestKey(t *testing.T) {
+	known := []string{"rancher.k8s.binbash.org/dns-name", "rancher.k8s.binbash.org/reverse-dns", "rancher.k8s.binbash.org/static-arp"}
+
+	assert.Equal(t, "rancher.k8s.binbash.org/dns-name", ClosestKey("rancher.k8s.binbash.org/dnsname", known))
+	assert.Equal(t, "rancher.k8s.binbash.org/static-arp", ClosestKey("rancher.k8s.binbash.org/staticarp", known))
+	assert.Equal(t, "rancher.k8s.binbash.org/reverse-dns", ClosestKey("rancher.k8s.binbash.org/revers-dns", known))
+	assert.Equal(t, "", ClosestKey("rancher.k8s.binbash.org/owner", known))
+}
@@ -0,0 +1,50 @@
+package validator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// day is the unit of the d suffix of lease durations.
+	day = 24 * time.Hour
+	// maxLeaseDays is the largest number of days which fits in a duration.
+	maxLeaseDays = int64(1<<63-1) / int64(day)
+)
+
+// ParseLease parses a lease duration, which is a Go duration like "12h" or a
+// number of days like "30d".
+func ParseLease(lease string) (time.Duration, error) {
+	lease = strings.TrimSpace(lease)
+
+	var duration time.Duration
+	if days, ok := strings.CutSuffix(lease, "d"); ok {
+		n, err := strconv.ParseInt(days, 10, 64)
+		if err != nil || n > maxLeaseDays {
+			return 0, fmt.Errorf("invalid lease %q, expected a duration like 12h or 30d", lease)
+		}
+		duration = time.Duration(n) * day
+	} else {
+		var err error
+		duration, err = time.ParseDuration(lease)
+		if err != nil {
+			return 0, fmt.Errorf("invalid lease %q, expected a duration like 12h or 30d", lease)
+		}
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("lease %q must be positive", lease)
+	}
+
+	return duration, nil
+}
+
+// FormatLease formats a lease duration, whole days are formatted as days.
+func FormatLease(lease time.Duration) string {
+	if lease > 0 && lease%day == 0 {
+		return fmt.Sprintf("%dd", lease/day)
+	}
+
+	return lease.String()
+}
@@ -0,0 +1,47 @@
+package validator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLease(t *testing.T) {
+	testCases := []struct {
+		name        string
+		lease       string
+		expected    time.Duration
+		expectError bool
+	}{
+		{name: "hours", lease: "12h", expected: 12 * time.Hour},
+		{name: "hours and minutes", lease: " 1h30m ", expected: 90 * time.Minute},
+		{name: "days", lease: "30d", expected: 30 * 24 * time.Hour},
+		{name: "empty", lease: "", expectError: true},
+		{name: "zero", lease: "0s", expectError: true},
+		{name: "zero days", lease: "0d", expectError: true},
+		{name: "negative", lease: "-1h", expectError: true},
+		{name: "fractional days", lease: "1.5d", expectError: true},
+		{name: "weeks", lease: "2w", expectError: true},
+		{name: "too many days", lease: "999999999d", expectError: true},
+		{name: "timestamp", lease: "2026-01-01T00:00:00Z", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lease, err := ParseLease(tc.lease)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, lease)
+		})
+	}
+}
+
+func TestFormatLease(t *testing.T) {
+	assert.Equal(t, "30d", FormatLease(30*24*time.Hour))
+	assert.Equal(t, "36h0m0s", FormatLease(36*time.Hour))
+	assert.Equal(t, "30m0s", FormatLease(30*time.Minute))
+}
@@ -0,0 +1,74 @@
+package validator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MetadataRule requires a label or annotation whose value matches a pattern.
+type MetadataRule struct {
+	Key string
+	// Pattern must match the whole value, a non-empty value is required when
+	// it is empty.
+	Pattern string
+
+	re *regexp.Regexp
+}
+
+// String returns the rule in the form it is parsed from.
+func (r MetadataRule) String() string {
+	if r.Pattern == "" {
+		return r.Key
+	}
+
+	return r.Key + "=" + r.Pattern
+}
+
+// ParseMetadataRules parses a semicolon separated list of rules of the form
+// "<key>=<pattern>", for example "cost-center=cc-[0-9]+;owner". The pattern
+// must match the whole value, a key without a pattern requires a non-empty
+// value.
+func ParseMetadataRules(rules string) ([]MetadataRule, error) {
+	var parsed []MetadataRule
+
+	for _, rule := range strings.Split(rules, ";") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		key, pattern, _ := strings.Cut(rule, "=")
+		if key = strings.TrimSpace(key); key == "" {
+			return nil, fmt.Errorf("rule %q has no key", rule)
+		}
+
+		r := MetadataRule{Key: key, Pattern: strings.TrimSpace(pattern)}
+		if r.Pattern != "" {
+			re, err := regexp.Compile("^(?:" + r.Pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("rule %q has an invalid pattern: %s", rule, err)
+			}
+			r.re = re
+		}
+		parsed = append(parsed, r)
+	}
+
+	return parsed, nil
+}
+
+// CheckMetadata returns the problems of the values with the rules, in the
+// order of the rules. The kind is "label" or "annotation".
+func CheckMetadata(kind string, values map[string]string, rules []MetadataRule) []string {
+	var problems []string
+
+	for _, rule := range rules {
+		value := values[rule.Key]
+		switch {
+		case value == "":
+			problems = append(problems, fmt.Sprintf("missing %s %s", kind, rule.Key))
+		case rule.re != nil && !rule.re.MatchString(value):
+			problems = append(problems, fmt.Sprintf("%s %s=%s doesn't match the pattern %s", kind, rule.Key, value, rule.Pattern))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,74 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMetadataRules(t *testing.T) {
+	testCases := []struct {
+		name        string
+		rules       string
+		expected    []string
+		expectError bool
+	}{
+		{name: "empty", rules: "", expected: nil},
+		{name: "keys and patterns", rules: "cost-center=cc-[0-9]{4,6}; owner ;ticket = [A-Z]+-[0-9]+;", expected: []string{"cost-center=cc-[0-9]{4,6}", "owner", "ticket=[A-Z]+-[0-9]+"}},
+		{name: "empty pattern", rules: "owner=", expected: []string{"owner"}},
+		{name: "pattern with equal sign", rules: "selector=a=b", expected: []string{"selector=a=b"}},
+		{name: "missing key", rules: "=cc-[0-9]+", expectError: true},
+		{name: "invalid pattern", rules: "owner=(", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := ParseMetadataRules(tc.rules)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			var parsed []string
+			for _, rule := range rules {
+				parsed = append(parsed, rule.String())
+			}
+			assert.Equal(t, tc.expected, parsed)
+		})
+	}
+}
+
+func TestCheckMetadata(t *testing.T) {
+	rules, err := ParseMetadataRules("cost-center=cc-[0-9]+;owner;ticket=INC-[0-9]+|CHG-[0-9]+")
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		values   map[string]string
+		expected []string
+	}{
+		{
+			name:   "all rules met",
+			values: map[string]string{"cost-center": "cc-1234", "owner": "team-a", "ticket": "CHG-1"},
+		},
+		{
+			name:     "missing values",
+			values:   map[string]string{"cost-center": "cc-1234", "owner": ""},
+			expected: []string{"missing label owner", "missing label ticket"},
+		},
+		{
+			name:   "pattern must match the whole value",
+			values: map[string]string{"cost-center": "xcc-1234", "owner": "team-a", "ticket": "INC-1x"},
+			expected: []string{
+				"label cost-center=xcc-1234 doesn't match the pattern cc-[0-9]+",
+				"label ticket=INC-1x doesn't match the pattern INC-[0-9]+|CHG-[0-9]+",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, CheckMetadata("label", tc.values, rules))
+		})
+	}
+}
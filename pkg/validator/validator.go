@@ -0,0 +1,481 @@
+// Package validator contains the validation logic of the FloatingIP resources
+// without any coupling to HTTP or AdmissionReviews, so it can be shared with
+// the rancher-fip-manager controller and CLI tools.
+package validator
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"strings"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+)
+
+// CompareIPs compares two IP addresses and returns -1, 0 or 1. IPv4 addresses
+// are compared in their 4-byte representation.
+func CompareIPs(a net.IP, b net.IP) int {
+	if a4, b4 := a.To4(), b.To4(); a4 != nil && b4 != nil {
+		return bytes.Compare(a4, b4)
+	}
+
+	// Compare as-is, assuming IPv6 or consistent representation from ParseIP
+	return bytes.Compare(a, b)
+}
+
+// InRange returns true if the IP address is within the range [start, end].
+func InRange(ip net.IP, start net.IP, end net.IP) bool {
+	return CompareIPs(ip, start) >= 0 && CompareIPs(ip, end) <= 0
+}
+
+// IsExcluded returns true if the IP address is in the exclude list. The
+// addresses are compared by value, so another notation of an excluded address,
+// like 2001:0db8::15 for 2001:db8::15, is excluded as well.
+func IsExcluded(ip string, exclude []string) bool {
+	parsedIP := net.ParseIP(ip)
+	for _, excludedIP := range exclude {
+		if ip == excludedIP {
+			return true
+		}
+		if parsedIP != nil && parsedIP.Equal(net.ParseIP(excludedIP)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The IP families of the family field of a pool.
+const (
+	IPv4 = "IPv4"
+	IPv6 = "IPv6"
+)
+
+// IPFamily returns IPv4 or IPv6 for the IP address. IPv4-mapped IPv6
+// addresses are IPv4 addresses.
+func IPFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return IPv4
+	}
+
+	return IPv6
+}
+
+// PoolFamily returns the IP family of the pool, which is the family field
+// when the pool declares one, or the family of its subnet. It returns an
+// empty string when neither is valid.
+func PoolFamily(ipConfig *rfmv2.IPConfig) string {
+	if ipConfig == nil {
+		return ""
+	}
+	switch {
+	case strings.EqualFold(ipConfig.Family, IPv4):
+		return IPv4
+	case strings.EqualFold(ipConfig.Family, IPv6):
+		return IPv6
+	}
+
+	subnetIP, _, err := net.ParseCIDR(ipConfig.Subnet)
+	if err != nil {
+		return ""
+	}
+
+	return IPFamily(subnetIP)
+}
+
+// ValidatePoolRange checks if the family, subnet, start and end addresses of
+// the pool are valid. The family is optional, when it is set it must match
+// the subnet.
+func ValidatePoolRange(ipConfig *rfmv2.IPConfig) error {
+	if ipConfig == nil {
+		return fmt.Errorf("ipConfig is required")
+	}
+
+	// Check if the subnet is valid
+	subnetIP, subnet, err := net.ParseCIDR(ipConfig.Subnet)
+	if err != nil {
+		return fmt.Errorf("invalid subnet format: %s", ipConfig.Subnet)
+	}
+
+	// Check if the family is valid and matches the subnet
+	if ipConfig.Family != "" {
+		if !strings.EqualFold(ipConfig.Family, IPv4) && !strings.EqualFold(ipConfig.Family, IPv6) {
+			return fmt.Errorf("invalid family %s, must be %s or %s", ipConfig.Family, IPv4, IPv6)
+		}
+		if family := PoolFamily(ipConfig); family != IPFamily(subnetIP) {
+			return fmt.Errorf("family %s doesn't match the %s subnet %s", family, IPFamily(subnetIP), ipConfig.Subnet)
+		}
+	}
+
+	// Check if the start address is valid and within the subnet
+	startIP := net.ParseIP(ipConfig.Pool.Start)
+	if startIP == nil {
+		return fmt.Errorf("invalid start IP address format: %s", ipConfig.Pool.Start)
+	}
+	if !subnet.Contains(startIP) {
+		return fmt.Errorf("start IP address %s is not within the subnet %s", ipConfig.Pool.Start, ipConfig.Subnet)
+	}
+
+	// Check if the end address is valid and within the subnet
+	endIP := net.ParseIP(ipConfig.Pool.End)
+	if endIP == nil {
+		return fmt.Errorf("invalid end IP address format: %s", ipConfig.Pool.End)
+	}
+	if !subnet.Contains(endIP) {
+		return fmt.Errorf("end IP address %s is not within the subnet %s", ipConfig.Pool.End, ipConfig.Subnet)
+	}
+
+	// Check that start <= end
+	if CompareIPs(startIP, endIP) > 0 {
+		return fmt.Errorf("start IP address %s must be less than or equal to end IP address %s", ipConfig.Pool.Start, ipConfig.Pool.End)
+	}
+
+	return nil
+}
+
+// ValidatePoolBlocks checks if every subnet, start and end block of a pool
+// is valid and that the ranges of the blocks don't overlap.
+func ValidatePoolBlocks(blocks []*rfmv2.IPConfig) error {
+	if len(blocks) == 0 {
+		return fmt.Errorf("ipConfig is required")
+	}
+
+	for i, block := range blocks {
+		if err := ValidatePoolRange(block); err != nil {
+			return err
+		}
+		start, end := net.ParseIP(block.Pool.Start), net.ParseIP(block.Pool.End)
+		for _, other := range blocks[:i] {
+			if RangesOverlap(start, end, net.ParseIP(other.Pool.Start), net.ParseIP(other.Pool.End)) {
+				return fmt.Errorf("pool range [%s, %s] overlaps with pool range [%s, %s]",
+					block.Pool.Start, block.Pool.End, other.Pool.Start, other.Pool.End)
+			}
+		}
+	}
+
+	return nil
+}
+
+// BlockOf returns the block whose subnet contains the IP address, or nil if
+// none does.
+func BlockOf(ip net.IP, blocks []*rfmv2.IPConfig) *rfmv2.IPConfig {
+	for _, block := range blocks {
+		if block == nil {
+			continue
+		}
+		if _, subnet, err := net.ParseCIDR(block.Subnet); err == nil && subnet.Contains(ip) {
+			return block
+		}
+	}
+
+	return nil
+}
+
+// ValidateExcludes checks if the exclude IPs are valid, within the subnet,
+// between the start and end IP and listed only once. Different notations of
+// the same address, like 2001:db8::1 and 2001:0db8:0::1, count as duplicates.
+// The pool range must be valid.
+func ValidateExcludes(ipConfig *rfmv2.IPConfig) error {
+	_, subnet, err := net.ParseCIDR(ipConfig.Subnet)
+	if err != nil {
+		return fmt.Errorf("invalid subnet format: %s", ipConfig.Subnet)
+	}
+	startIP := net.ParseIP(ipConfig.Pool.Start)
+	endIP := net.ParseIP(ipConfig.Pool.End)
+
+	seen := make(map[string]string, len(ipConfig.Pool.Exclude))
+	for _, excludedIPStr := range ipConfig.Pool.Exclude {
+		excludedIP := net.ParseIP(excludedIPStr)
+		if excludedIP == nil {
+			return fmt.Errorf("invalid excluded IP address format: %s", excludedIPStr)
+		}
+		if !subnet.Contains(excludedIP) {
+			return fmt.Errorf("excluded IP address %s is not within the subnet %s", excludedIPStr, ipConfig.Subnet)
+		}
+		// Check if excluded IP is outside the pool range [startIP, endIP]
+		if !InRange(excludedIP, startIP, endIP) {
+			return fmt.Errorf("excluded IP address %s is not within the pool range [%s, %s]", excludedIPStr, ipConfig.Pool.Start, ipConfig.Pool.End)
+		}
+		// Duplicates are compared in their canonical notation
+		normalized := excludedIP.String()
+		if first, ok := seen[normalized]; ok {
+			if first == excludedIPStr {
+				return fmt.Errorf("excluded IP address %s is listed more than once", excludedIPStr)
+			}
+			return fmt.Errorf("excluded IP address %s is a duplicate of %s", excludedIPStr, first)
+		}
+		seen[normalized] = excludedIPStr
+	}
+
+	return nil
+}
+
+// ValidateGateway checks that the gateway is a valid address within the
+// subnet which can never be allocated from the pool: it must not be the start
+// or end address and must be excluded when it is within the pool range.
+// The pool range must be valid.
+func ValidateGateway(ipConfig *rfmv2.IPConfig, gateway string) error {
+	_, subnet, err := net.ParseCIDR(ipConfig.Subnet)
+	if err != nil {
+		return fmt.Errorf("invalid subnet format: %s", ipConfig.Subnet)
+	}
+	gatewayIP := net.ParseIP(gateway)
+	if gatewayIP == nil {
+		return fmt.Errorf("invalid gateway IP address format: %s", gateway)
+	}
+	if !subnet.Contains(gatewayIP) {
+		return fmt.Errorf("gateway IP address %s is not within the subnet %s", gateway, ipConfig.Subnet)
+	}
+
+	startIP := net.ParseIP(ipConfig.Pool.Start)
+	endIP := net.ParseIP(ipConfig.Pool.End)
+	if gatewayIP.Equal(startIP) || gatewayIP.Equal(endIP) {
+		return fmt.Errorf("gateway IP address %s must not be the start or end IP address of the pool range [%s, %s]", gateway, ipConfig.Pool.Start, ipConfig.Pool.End)
+	}
+	if InRange(gatewayIP, startIP, endIP) && !IsExcluded(gateway, ipConfig.Pool.Exclude) {
+		return fmt.Errorf("gateway IP address %s is within the pool range [%s, %s] and must be excluded", gateway, ipConfig.Pool.Start, ipConfig.Pool.End)
+	}
+
+	return nil
+}
+
+// DefaultForbiddenRanges returns the special-use ranges which can never be
+// routed as FloatingIPs: loopback, link-local, multicast and the
+// documentation ranges of IPv4 and IPv6.
+func DefaultForbiddenRanges() []*net.IPNet {
+	var ranges []*net.IPNet
+	for _, cidr := range []string{
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"224.0.0.0/4",
+		"192.0.2.0/24",
+		"198.51.100.0/24",
+		"203.0.113.0/24",
+		"::1/128",
+		"fe80::/10",
+		"ff00::/8",
+		"2001:db8::/32",
+	} {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		ranges = append(ranges, ipNet)
+	}
+
+	return ranges
+}
+
+// ForbiddenRange returns the forbidden range which contains the IP address,
+// or nil if the address is not in a forbidden range.
+func ForbiddenRange(ip net.IP, forbidden []*net.IPNet) *net.IPNet {
+	for _, ipNet := range forbidden {
+		if ipNet.Contains(ip) {
+			return ipNet
+		}
+	}
+
+	return nil
+}
+
+// ValidateNotForbidden checks that the pool range doesn't overlap with any of
+// the forbidden ranges. The pool range must be valid.
+func ValidateNotForbidden(ipConfig *rfmv2.IPConfig, forbidden []*net.IPNet) error {
+	start := net.ParseIP(ipConfig.Pool.Start)
+	end := net.ParseIP(ipConfig.Pool.End)
+	if ipNet := overlappingRange(start, end, forbidden); ipNet != nil {
+		return fmt.Errorf("pool range [%s, %s] overlaps with the special-use range %s", ipConfig.Pool.Start, ipConfig.Pool.End, ipNet)
+	}
+
+	return nil
+}
+
+// ValidateNoClusterOverlap checks that the pool range doesn't overlap with
+// any of the Pod or Service networks of the cluster. The pool range must be
+// valid.
+func ValidateNoClusterOverlap(ipConfig *rfmv2.IPConfig, clusterCIDRs []*net.IPNet) error {
+	start := net.ParseIP(ipConfig.Pool.Start)
+	end := net.ParseIP(ipConfig.Pool.End)
+	if ipNet := overlappingRange(start, end, clusterCIDRs); ipNet != nil {
+		return fmt.Errorf("pool range [%s, %s] overlaps with the cluster network %s", ipConfig.Pool.Start, ipConfig.Pool.End, ipNet)
+	}
+
+	return nil
+}
+
+const (
+	// AddressSpaceAny allows pools in private and public address space.
+	AddressSpaceAny = "any"
+	// AddressSpacePrivate only allows pools in private address space.
+	AddressSpacePrivate = "private"
+	// AddressSpacePublic only allows pools in public address space.
+	AddressSpacePublic = "public"
+)
+
+// PrivateRanges returns the private address ranges, the RFC 1918 ranges for
+// IPv4 and the RFC 4193 unique local addresses for IPv6.
+func PrivateRanges() []*net.IPNet {
+	var ranges []*net.IPNet
+	for _, cidr := range []string{
+		"10.0.0.0/8",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"fc00::/7",
+	} {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		ranges = append(ranges, ipNet)
+	}
+
+	return ranges
+}
+
+// ValidateAddressSpace checks that the pool range is in the given address
+// space: within a single private range for AddressSpacePrivate, or without
+// overlapping a private range for AddressSpacePublic. Any other address space
+// allows every range. The pool range must be valid.
+func ValidateAddressSpace(ipConfig *rfmv2.IPConfig, addressSpace string) error {
+	start := net.ParseIP(ipConfig.Pool.Start)
+	end := net.ParseIP(ipConfig.Pool.End)
+
+	switch addressSpace {
+	case AddressSpacePrivate:
+		for _, ipNet := range PrivateRanges() {
+			if ipNet.Contains(start) && ipNet.Contains(end) {
+				return nil
+			}
+		}
+		return fmt.Errorf("pool range [%s, %s] is not within a private address range, only private pools are allowed", ipConfig.Pool.Start, ipConfig.Pool.End)
+	case AddressSpacePublic:
+		if ipNet := overlappingRange(start, end, PrivateRanges()); ipNet != nil {
+			return fmt.Errorf("pool range [%s, %s] overlaps with the private range %s, only public pools are allowed", ipConfig.Pool.Start, ipConfig.Pool.End, ipNet)
+		}
+	}
+
+	return nil
+}
+
+// overlappingRange returns the first network which overlaps with the range
+// [start, end], or nil if none does. Networks of the other address family
+// are skipped.
+func overlappingRange(start net.IP, end net.IP, networks []*net.IPNet) *net.IPNet {
+	for _, ipNet := range networks {
+		if RangesOverlap(start, end, ipNet.IP, lastIP(ipNet)) {
+			return ipNet
+		}
+	}
+
+	return nil
+}
+
+// RangesOverlap returns true if the ranges [aStart, aEnd] and [bStart, bEnd]
+// have an address in common. Ranges of different address families never
+// overlap.
+func RangesOverlap(aStart net.IP, aEnd net.IP, bStart net.IP, bEnd net.IP) bool {
+	if (aStart.To4() != nil) != (bStart.To4() != nil) {
+		return false
+	}
+
+	return CompareIPs(aStart, bEnd) <= 0 && CompareIPs(bStart, aEnd) <= 0
+}
+
+// ParseAddressRange parses an address range in CIDR notation or as
+// "start-end", the notations of the MetalLB and kube-vip address pools, and
+// returns its first and last address.
+func ParseAddressRange(addresses string) (net.IP, net.IP, error) {
+	addresses = strings.TrimSpace(addresses)
+	if first, last, found := strings.Cut(addresses, "-"); found {
+		start := net.ParseIP(strings.TrimSpace(first))
+		end := net.ParseIP(strings.TrimSpace(last))
+		if start == nil || end == nil || (start.To4() != nil) != (end.To4() != nil) || CompareIPs(start, end) > 0 {
+			return nil, nil, fmt.Errorf("invalid address range %s", addresses)
+		}
+		return start, end, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(addresses)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid address range %s", addresses)
+	}
+
+	return ipNet.IP, lastIP(ipNet), nil
+}
+
+// lastIP returns the last IP address of the network.
+func lastIP(ipNet *net.IPNet) net.IP {
+	ip := make(net.IP, len(ipNet.IP))
+	for i := range ipNet.IP {
+		ip[i] = ipNet.IP[i] | ^ipNet.Mask[i]
+	}
+
+	return ip
+}
+
+// RangeSize returns the number of IP addresses in the range [start, end], or
+// 0 if start is after end.
+func RangeSize(start net.IP, end net.IP) *big.Int {
+	if CompareIPs(start, end) > 0 {
+		return new(big.Int)
+	}
+	if start4, end4 := start.To4(), end.To4(); start4 != nil && end4 != nil {
+		start, end = start4, end4
+	}
+
+	size := new(big.Int).Sub(new(big.Int).SetBytes(end), new(big.Int).SetBytes(start))
+
+	return size.Add(size, big.NewInt(1))
+}
+
+// PoolCapacity returns the number of IP addresses in the pool range which are
+// not excluded. Capacities which don't fit in an int, like large IPv6 ranges,
+// are returned as math.MaxInt. The pool range must be valid.
+func PoolCapacity(ipConfig *rfmv2.IPConfig) int {
+	startIP := net.ParseIP(ipConfig.Pool.Start)
+	endIP := net.ParseIP(ipConfig.Pool.End)
+	if startIP == nil || endIP == nil {
+		return 0
+	}
+	size := RangeSize(startIP, endIP)
+	if size.Sign() == 0 {
+		return 0
+	}
+
+	// every address is only excluded once, even if it is listed more than once
+	excluded := make(map[string]bool, len(ipConfig.Pool.Exclude))
+	for _, excludedIPStr := range ipConfig.Pool.Exclude {
+		excludedIP := net.ParseIP(excludedIPStr)
+		if excludedIP != nil && InRange(excludedIP, startIP, endIP) {
+			excluded[excludedIP.String()] = true
+		}
+	}
+	size.Sub(size, big.NewInt(int64(len(excluded))))
+
+	if !size.IsInt64() || size.Int64() > math.MaxInt {
+		return math.MaxInt
+	}
+
+	return int(size.Int64())
+}
+
+// WildcardPool is the key in spec.floatingIPQuota of a FloatingIPProjectQuota
+// whose quota applies to every pool which is not listed explicitly. Every
+// pool gets the full wildcard quota, it is not shared between the pools.
+const WildcardPool = "*"
+
+// QuotaUsage returns the quota and the current usage of the pool in the project
+// quota, the WildcardPool quota is used for pools which are not listed. The
+// defined return value is false if there is no quota for the pool.
+func QuotaUsage(projectQuota *rfmv2.FloatingIPProjectQuota, pool string) (quota int, used int, defined bool) {
+	quota, defined = projectQuota.Spec.FloatingIPQuota[pool]
+	if !defined {
+		quota, defined = projectQuota.Spec.FloatingIPQuota[WildcardPool]
+	}
+	if fipInfo, ok := projectQuota.Status.FloatingIPs[pool]; ok && fipInfo != nil {
+		used = fipInfo.Used
+	}
+
+	return
+}
+
+// QuotaExceeded returns true if no more FloatingIPs can be allocated within the quota.
+func QuotaExceeded(quota int, used int) bool {
+	return used >= quota
+}
@@ -0,0 +1,381 @@
+package validator
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"testing"
+
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInRange(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ip       string
+		start    string
+		end      string
+		expected bool
+	}{
+		{
+			name:     "ipv4 within range",
+			ip:       "192.168.1.15",
+			start:    "192.168.1.10",
+			end:      "192.168.1.20",
+			expected: true,
+		},
+		{
+			name:     "ipv4 equal to start",
+			ip:       "192.168.1.10",
+			start:    "192.168.1.10",
+			end:      "192.168.1.20",
+			expected: true,
+		},
+		{
+			name:     "ipv4 after end",
+			ip:       "192.168.1.21",
+			start:    "192.168.1.10",
+			end:      "192.168.1.20",
+			expected: false,
+		},
+		{
+			name:     "ipv6 within range",
+			ip:       "2001:db8::15",
+			start:    "2001:db8::10",
+			end:      "2001:db8::20",
+			expected: true,
+		},
+		{
+			name:     "ipv6 before start",
+			ip:       "2001:db8::9",
+			start:    "2001:db8::10",
+			end:      "2001:db8::20",
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, InRange(net.ParseIP(tc.ip), net.ParseIP(tc.start), net.ParseIP(tc.end)))
+		})
+	}
+}
+
+func TestIsExcluded(t *testing.T) {
+	exclude := []string{"192.168.1.15", "2001:db8::15"}
+
+	assert.True(t, IsExcluded("192.168.1.15", exclude))
+	assert.True(t, IsExcluded("2001:db8::15", exclude))
+	assert.False(t, IsExcluded("192.168.1.16", exclude))
+
+	// other notations of an excluded address
+	assert.True(t, IsExcluded("2001:0db8:0000::15", exclude))
+	assert.True(t, IsExcluded("::ffff:192.168.1.15", exclude))
+	assert.False(t, IsExcluded("2001:db8::16", exclude))
+	assert.False(t, IsExcluded("invalid", exclude))
+}
+
+func TestValidatePoolRange(t *testing.T) {
+	assert.EqualError(t, ValidatePoolRange(nil), "ipConfig is required")
+	assert.NoError(t, ValidatePoolRange(&rfmv2.IPConfig{
+		Subnet: "192.168.1.0/24",
+		Pool: rfmv2.Pool{
+			Start: "192.168.1.10",
+			End:   "192.168.1.20",
+		},
+	}))
+	assert.EqualError(t, ValidatePoolRange(&rfmv2.IPConfig{
+		Subnet: "192.168.1.0/24",
+		Pool: rfmv2.Pool{
+			Start: "192.168.1.20",
+			End:   "192.168.1.10",
+		},
+	}), "start IP address 192.168.1.20 must be less than or equal to end IP address 192.168.1.10")
+}
+
+func TestValidatePoolRangeFamily(t *testing.T) {
+	ipConfig := func(family string) *rfmv2.IPConfig {
+		return &rfmv2.IPConfig{
+			Family: family,
+			Subnet: "2001:db8::/64",
+			Pool:   rfmv2.Pool{Start: "2001:db8::10", End: "2001:db8::20"},
+		}
+	}
+
+	assert.NoError(t, ValidatePoolRange(ipConfig("")))
+	assert.NoError(t, ValidatePoolRange(ipConfig("IPv6")))
+	assert.NoError(t, ValidatePoolRange(ipConfig("ipv6")))
+	assert.EqualError(t, ValidatePoolRange(ipConfig("IPv4")), "family IPv4 doesn't match the IPv6 subnet 2001:db8::/64")
+	assert.EqualError(t, ValidatePoolRange(ipConfig("dual")), "invalid family dual, must be IPv4 or IPv6")
+}
+
+func TestValidatePoolBlocks(t *testing.T) {
+	block := func(subnet string, start string, end string) *rfmv2.IPConfig {
+		return &rfmv2.IPConfig{Subnet: subnet, Pool: rfmv2.Pool{Start: start, End: end}}
+	}
+
+	assert.EqualError(t, ValidatePoolBlocks(nil), "ipConfig is required")
+	assert.NoError(t, ValidatePoolBlocks([]*rfmv2.IPConfig{
+		block("192.168.1.0/24", "192.168.1.10", "192.168.1.20"),
+		block("192.168.1.0/24", "192.168.1.30", "192.168.1.40"),
+		block("2001:db8::/64", "2001:db8::10", "2001:db8::20"),
+	}))
+	assert.EqualError(t, ValidatePoolBlocks([]*rfmv2.IPConfig{
+		block("192.168.1.0/24", "192.168.1.10", "192.168.1.20"),
+		block("192.168.1.0/24", "192.168.1.20", "192.168.1.10"),
+	}), "start IP address 192.168.1.20 must be less than or equal to end IP address 192.168.1.10")
+	assert.EqualError(t, ValidatePoolBlocks([]*rfmv2.IPConfig{
+		block("192.168.1.0/24", "192.168.1.10", "192.168.1.20"),
+		block("192.168.1.0/28", "192.168.1.1", "192.168.1.14"),
+	}), "pool range [192.168.1.1, 192.168.1.14] overlaps with pool range [192.168.1.10, 192.168.1.20]")
+}
+
+func TestBlockOf(t *testing.T) {
+	blocks := []*rfmv2.IPConfig{
+		{Subnet: "192.168.1.0/24"},
+		{Subnet: "2001:db8::/64"},
+	}
+
+	assert.Equal(t, blocks[0], BlockOf(net.ParseIP("192.168.1.5"), blocks))
+	assert.Equal(t, blocks[1], BlockOf(net.ParseIP("2001:db8::5"), blocks))
+	assert.Nil(t, BlockOf(net.ParseIP("192.168.2.5"), blocks))
+}
+
+func TestPoolFamily(t *testing.T) {
+	assert.Equal(t, "", PoolFamily(nil))
+	assert.Equal(t, IPv4, PoolFamily(&rfmv2.IPConfig{Subnet: "192.168.1.0/24"}))
+	assert.Equal(t, IPv6, PoolFamily(&rfmv2.IPConfig{Subnet: "2001:db8::/64"}))
+	assert.Equal(t, IPv6, PoolFamily(&rfmv2.IPConfig{Family: "ipv6", Subnet: "invalid"}))
+	assert.Equal(t, "", PoolFamily(&rfmv2.IPConfig{Subnet: "invalid"}))
+
+	assert.Equal(t, IPv4, IPFamily(net.ParseIP("::ffff:192.168.1.1")))
+	assert.Equal(t, IPv6, IPFamily(net.ParseIP("2001:db8::1")))
+}
+
+func TestValidateExcludes(t *testing.T) {
+	ipConfig := func(exclude ...string) *rfmv2.IPConfig {
+		return &rfmv2.IPConfig{
+			Subnet: "2001:db8::/64",
+			Pool: rfmv2.Pool{
+				Start:   "2001:db8::10",
+				End:     "2001:db8::20",
+				Exclude: exclude,
+			},
+		}
+	}
+
+	assert.NoError(t, ValidateExcludes(ipConfig("2001:db8::15", "2001:db8::16")))
+	assert.EqualError(t, ValidateExcludes(ipConfig("2001:db8::15", "2001:db8::15")),
+		"excluded IP address 2001:db8::15 is listed more than once")
+	assert.EqualError(t, ValidateExcludes(ipConfig("2001:db8::15", "2001:0db8:0::15")),
+		"excluded IP address 2001:0db8:0::15 is a duplicate of 2001:db8::15")
+	assert.EqualError(t, ValidateExcludes(ipConfig("2001:db8::/124")),
+		"invalid excluded IP address format: 2001:db8::/124")
+}
+
+func TestValidateGateway(t *testing.T) {
+	ipConfig := &rfmv2.IPConfig{
+		Subnet: "192.168.1.0/24",
+		Pool: rfmv2.Pool{
+			Start:   "192.168.1.10",
+			End:     "192.168.1.20",
+			Exclude: []string{"192.168.1.15"},
+		},
+	}
+
+	assert.NoError(t, ValidateGateway(ipConfig, "192.168.1.1"))
+	assert.NoError(t, ValidateGateway(ipConfig, "192.168.1.15"))
+	assert.EqualError(t, ValidateGateway(ipConfig, "192.168.1"),
+		"invalid gateway IP address format: 192.168.1")
+	assert.EqualError(t, ValidateGateway(ipConfig, "192.168.2.1"),
+		"gateway IP address 192.168.2.1 is not within the subnet 192.168.1.0/24")
+	assert.EqualError(t, ValidateGateway(ipConfig, "192.168.1.10"),
+		"gateway IP address 192.168.1.10 must not be the start or end IP address of the pool range [192.168.1.10, 192.168.1.20]")
+	assert.EqualError(t, ValidateGateway(ipConfig, "192.168.1.12"),
+		"gateway IP address 192.168.1.12 is within the pool range [192.168.1.10, 192.168.1.20] and must be excluded")
+}
+
+func TestForbiddenRange(t *testing.T) {
+	forbidden := DefaultForbiddenRanges()
+
+	assert.Equal(t, "127.0.0.0/8", ForbiddenRange(net.ParseIP("127.0.0.1"), forbidden).String())
+	assert.Equal(t, "169.254.0.0/16", ForbiddenRange(net.ParseIP("169.254.169.254"), forbidden).String())
+	assert.Equal(t, "ff00::/8", ForbiddenRange(net.ParseIP("ff02::1"), forbidden).String())
+	assert.Nil(t, ForbiddenRange(net.ParseIP("192.168.1.10"), forbidden))
+	assert.Nil(t, ForbiddenRange(net.ParseIP("2a01:4f8::1"), forbidden))
+	assert.Nil(t, ForbiddenRange(net.ParseIP("127.0.0.1"), nil))
+}
+
+func TestValidateNotForbidden(t *testing.T) {
+	forbidden := DefaultForbiddenRanges()
+	ipConfig := func(subnet string, start string, end string) *rfmv2.IPConfig {
+		return &rfmv2.IPConfig{Subnet: subnet, Pool: rfmv2.Pool{Start: start, End: end}}
+	}
+
+	assert.NoError(t, ValidateNotForbidden(ipConfig("192.168.1.0/24", "192.168.1.10", "192.168.1.20"), forbidden))
+	assert.NoError(t, ValidateNotForbidden(ipConfig("2a01:4f8::/64", "2a01:4f8::10", "2a01:4f8::20"), forbidden))
+	assert.NoError(t, ValidateNotForbidden(ipConfig("192.0.2.0/24", "192.0.2.10", "192.0.2.20"), nil))
+	assert.EqualError(t, ValidateNotForbidden(ipConfig("192.0.2.0/24", "192.0.2.10", "192.0.2.20"), forbidden),
+		"pool range [192.0.2.10, 192.0.2.20] overlaps with the special-use range 192.0.2.0/24")
+	// a range which starts before and ends after a forbidden range
+	assert.EqualError(t, ValidateNotForbidden(ipConfig("0.0.0.0/0", "126.0.0.1", "128.0.0.1"), forbidden),
+		"pool range [126.0.0.1, 128.0.0.1] overlaps with the special-use range 127.0.0.0/8")
+	assert.EqualError(t, ValidateNotForbidden(ipConfig("fe80::/64", "fe80::10", "fe80::20"), forbidden),
+		"pool range [fe80::10, fe80::20] overlaps with the special-use range fe80::/10")
+}
+
+func TestValidateNoClusterOverlap(t *testing.T) {
+	_, podCIDR, _ := net.ParseCIDR("10.42.0.0/16")
+	_, serviceCIDR, _ := net.ParseCIDR("fd43::/112")
+	clusterCIDRs := []*net.IPNet{podCIDR, serviceCIDR}
+	ipConfig := func(subnet string, start string, end string) *rfmv2.IPConfig {
+		return &rfmv2.IPConfig{Subnet: subnet, Pool: rfmv2.Pool{Start: start, End: end}}
+	}
+
+	assert.NoError(t, ValidateNoClusterOverlap(ipConfig("10.43.0.0/16", "10.43.0.10", "10.43.0.20"), clusterCIDRs))
+	assert.NoError(t, ValidateNoClusterOverlap(ipConfig("10.42.0.0/16", "10.42.0.10", "10.42.0.20"), nil))
+	assert.EqualError(t, ValidateNoClusterOverlap(ipConfig("10.42.0.0/16", "10.42.0.10", "10.42.0.20"), clusterCIDRs),
+		"pool range [10.42.0.10, 10.42.0.20] overlaps with the cluster network 10.42.0.0/16")
+	assert.EqualError(t, ValidateNoClusterOverlap(ipConfig("fd43::/64", "fd43::10", "fd43::20"), clusterCIDRs),
+		"pool range [fd43::10, fd43::20] overlaps with the cluster network fd43::/112")
+}
+
+func TestRangesOverlap(t *testing.T) {
+	ip := net.ParseIP
+
+	assert.True(t, RangesOverlap(ip("10.0.0.10"), ip("10.0.0.20"), ip("10.0.0.20"), ip("10.0.0.30")))
+	assert.True(t, RangesOverlap(ip("10.0.0.10"), ip("10.0.0.20"), ip("10.0.0.0"), ip("10.0.0.255")))
+	assert.False(t, RangesOverlap(ip("10.0.0.10"), ip("10.0.0.20"), ip("10.0.0.21"), ip("10.0.0.30")))
+	assert.False(t, RangesOverlap(ip("10.0.0.10"), ip("10.0.0.20"), ip("::"), ip("ffff::")))
+}
+
+func TestParseAddressRange(t *testing.T) {
+	start, end, err := ParseAddressRange("192.168.10.0/24")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.10.0", start.String())
+	assert.Equal(t, "192.168.10.255", end.String())
+
+	start, end, err = ParseAddressRange("192.168.10.10 - 192.168.10.20")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.10.10", start.String())
+	assert.Equal(t, "192.168.10.20", end.String())
+
+	start, end, err = ParseAddressRange("fd00::10-fd00::20")
+	assert.NoError(t, err)
+	assert.Equal(t, "fd00::10", start.String())
+	assert.Equal(t, "fd00::20", end.String())
+
+	_, _, err = ParseAddressRange("192.168.10.20-192.168.10.10")
+	assert.EqualError(t, err, "invalid address range 192.168.10.20-192.168.10.10")
+	_, _, err = ParseAddressRange("192.168.10.10-fd00::20")
+	assert.EqualError(t, err, "invalid address range 192.168.10.10-fd00::20")
+	_, _, err = ParseAddressRange("invalid")
+	assert.EqualError(t, err, "invalid address range invalid")
+}
+
+func TestValidateAddressSpace(t *testing.T) {
+	ipConfig := func(subnet string, start string, end string) *rfmv2.IPConfig {
+		return &rfmv2.IPConfig{Subnet: subnet, Pool: rfmv2.Pool{Start: start, End: end}}
+	}
+
+	assert.NoError(t, ValidateAddressSpace(ipConfig("8.8.8.0/24", "8.8.8.10", "8.8.8.20"), AddressSpaceAny))
+	assert.NoError(t, ValidateAddressSpace(ipConfig("10.0.0.0/24", "10.0.0.10", "10.0.0.20"), AddressSpacePrivate))
+	assert.NoError(t, ValidateAddressSpace(ipConfig("fd00::/64", "fd00::10", "fd00::20"), AddressSpacePrivate))
+	assert.EqualError(t, ValidateAddressSpace(ipConfig("8.8.8.0/24", "8.8.8.10", "8.8.8.20"), AddressSpacePrivate),
+		"pool range [8.8.8.10, 8.8.8.20] is not within a private address range, only private pools are allowed")
+	// a range which starts in a private range and ends outside of it
+	assert.EqualError(t, ValidateAddressSpace(ipConfig("0.0.0.0/0", "10.255.255.250", "11.0.0.5"), AddressSpacePrivate),
+		"pool range [10.255.255.250, 11.0.0.5] is not within a private address range, only private pools are allowed")
+	assert.NoError(t, ValidateAddressSpace(ipConfig("2a01:4f8::/64", "2a01:4f8::10", "2a01:4f8::20"), AddressSpacePublic))
+	assert.EqualError(t, ValidateAddressSpace(ipConfig("0.0.0.0/0", "9.255.255.250", "10.0.0.5"), AddressSpacePublic),
+		"pool range [9.255.255.250, 10.0.0.5] overlaps with the private range 10.0.0.0/8, only public pools are allowed")
+	assert.EqualError(t, ValidateAddressSpace(ipConfig("fd00::/64", "fd00::10", "fd00::20"), AddressSpacePublic),
+		"pool range [fd00::10, fd00::20] overlaps with the private range fc00::/7, only public pools are allowed")
+}
+
+func TestRangeSize(t *testing.T) {
+	assert.Equal(t, "11", RangeSize(net.ParseIP("192.168.1.10"), net.ParseIP("192.168.1.20")).String())
+	assert.Equal(t, "16777216", RangeSize(net.ParseIP("10.0.0.0"), net.ParseIP("10.255.255.255")).String())
+	assert.Equal(t, "18446744073709551616", RangeSize(net.ParseIP("2001:db8::"), net.ParseIP("2001:db8::ffff:ffff:ffff:ffff")).String())
+	assert.Equal(t, "0", RangeSize(net.ParseIP("192.168.1.20"), net.ParseIP("192.168.1.10")).String())
+}
+
+func TestPoolCapacity(t *testing.T) {
+	assert.Equal(t, 9, PoolCapacity(&rfmv2.IPConfig{
+		Subnet: "192.168.1.0/24",
+		Pool: rfmv2.Pool{
+			Start:   "192.168.1.10",
+			End:     "192.168.1.20",
+			Exclude: []string{"192.168.1.15", "192.168.1.15", "192.168.1.18"},
+		},
+	}))
+	assert.Equal(t, 256, PoolCapacity(&rfmv2.IPConfig{
+		Subnet: "2001:db8::/64",
+		Pool:   rfmv2.Pool{Start: "2001:db8::", End: "2001:db8::ff"},
+	}))
+	assert.Equal(t, math.MaxInt, PoolCapacity(&rfmv2.IPConfig{
+		Subnet: "2001:db8::/64",
+		Pool:   rfmv2.Pool{Start: "2001:db8::", End: "2001:db8::ffff:ffff:ffff:ffff"},
+	}))
+}
+
+func TestQuotaUsage(t *testing.T) {
+	projectQuota := &rfmv2.FloatingIPProjectQuota{
+		Spec: rfmv2.FloatingIPProjectQuotaSpec{
+			FloatingIPQuota: map[string]int{
+				"test-pool": 2,
+			},
+		},
+		Status: rfmv2.FloatingIPProjectQuotaStatus{
+			FloatingIPs: map[string]*rfmv2.FipInfo{
+				"test-pool": {
+					Used: 2,
+				},
+			},
+		},
+	}
+
+	quota, used, defined := QuotaUsage(projectQuota, "test-pool")
+	assert.True(t, defined)
+	assert.Equal(t, 2, quota)
+	assert.Equal(t, 2, used)
+	assert.True(t, QuotaExceeded(quota, used))
+
+	_, used, defined = QuotaUsage(projectQuota, "other-pool")
+	assert.False(t, defined)
+	assert.Equal(t, 0, used)
+
+	// the wildcard quota applies to every pool which is not listed
+	projectQuota.Spec.FloatingIPQuota[WildcardPool] = 5
+	projectQuota.Status.FloatingIPs["other-pool"] = &rfmv2.FipInfo{Used: 1}
+	quota, used, defined = QuotaUsage(projectQuota, "other-pool")
+	assert.True(t, defined)
+	assert.Equal(t, 5, quota)
+	assert.Equal(t, 1, used)
+
+	quota, _, _ = QuotaUsage(projectQuota, "test-pool")
+	assert.Equal(t, 2, quota)
+}
+
+func BenchmarkInRange(b *testing.B) {
+	ip := net.ParseIP("10.0.100.100")
+	start := net.ParseIP("10.0.0.10")
+	end := net.ParseIP("10.0.255.200")
+
+	for i := 0; i < b.N; i++ {
+		InRange(ip, start, end)
+	}
+}
+
+func BenchmarkIsExcluded(b *testing.B) {
+	exclude := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		exclude = append(exclude, fmt.Sprintf("10.0.0.%d", i))
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		IsExcluded("10.0.1.1", exclude)
+	}
+}
@@ -0,0 +1,156 @@
+package validator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxWindowDuration is the longest maintenance window, it limits the number of
+// minutes which are checked for the start of a window.
+const maxWindowDuration = 7 * 24 * time.Hour
+
+// MaintenanceWindow is a recurring time window, defined by a cron schedule of
+// its start and its duration.
+type MaintenanceWindow struct {
+	spec     string
+	minutes  [60]bool
+	hours    [24]bool
+	days     [32]bool
+	months   [13]bool
+	weekdays [7]bool
+	// anyDay and anyWeekday are set when the field is *, the day of the month
+	// and the day of the week are combined like cron does.
+	anyDay     bool
+	anyWeekday bool
+	duration   time.Duration
+}
+
+// ParseMaintenanceWindow parses a maintenance window of the form
+// "<minute> <hour> <day of month> <month> <day of week> <duration>", for
+// example "0 22 * * 1-5 4h" for 22:00 to 02:00 starting on weekdays. The cron
+// fields support *, numbers, ranges, lists and steps, Sunday is 0 or 7.
+func ParseMaintenanceWindow(spec string) (*MaintenanceWindow, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("maintenance window %q must have 5 cron fields and a duration", spec)
+	}
+
+	w := &MaintenanceWindow{
+		spec:       strings.Join(fields, " "),
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}
+	var weekdays [8]bool
+	for i, field := range []struct {
+		values []bool
+		min    int
+	}{
+		{w.minutes[:], 0},
+		{w.hours[:], 0},
+		{w.days[:], 1},
+		{w.months[:], 1},
+		{weekdays[:], 0},
+	} {
+		if err := parseCronField(fields[i], field.values, field.min); err != nil {
+			return nil, fmt.Errorf("maintenance window %q: %s", spec, err)
+		}
+	}
+	copy(w.weekdays[:], weekdays[:7])
+	w.weekdays[0] = w.weekdays[0] || weekdays[7]
+
+	duration, err := time.ParseDuration(fields[5])
+	if err != nil || duration <= 0 || duration > maxWindowDuration {
+		return nil, fmt.Errorf("maintenance window %q: duration must be between 1m and %s", spec, maxWindowDuration)
+	}
+	w.duration = duration
+
+	return w, nil
+}
+
+// parseCronField sets the values of a comma separated list of *, numbers,
+// ranges and steps. The values are indexed from 0, min is the lowest value of
+// the field.
+func parseCronField(field string, values []bool, min int) error {
+	max := len(values) - 1
+	for _, part := range strings.Split(field, ",") {
+		expr, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			expr = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		start, end := min, max
+		switch {
+		case expr == "*":
+		case strings.Contains(expr, "-"):
+			bounds := strings.SplitN(expr, "-", 2)
+			var err1, err2 error
+			start, err1 = strconv.Atoi(bounds[0])
+			end, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			var err error
+			start, err = strconv.Atoi(expr)
+			if err != nil {
+				return fmt.Errorf("invalid value %q", part)
+			}
+			end = start
+			if step > 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+
+	return nil
+}
+
+// String returns the normalized spec of the window.
+func (w *MaintenanceWindow) String() string {
+	return w.spec
+}
+
+// starts returns true if the window starts in the minute of t.
+func (w *MaintenanceWindow) starts(t time.Time) bool {
+	if !w.minutes[t.Minute()] || !w.hours[t.Hour()] || !w.months[t.Month()] {
+		return false
+	}
+
+	day, weekday := w.days[t.Day()], w.weekdays[t.Weekday()]
+	switch {
+	case w.anyDay && w.anyWeekday:
+		return true
+	case w.anyDay:
+		return weekday
+	case w.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+// Active returns true if t is within the window, in the location of t.
+func (w *MaintenanceWindow) Active(t time.Time) bool {
+	t = t.Truncate(time.Minute)
+	for start := t; t.Sub(start) < w.duration; start = start.Add(-time.Minute) {
+		if w.starts(start) {
+			return true
+		}
+	}
+
+	return false
+}
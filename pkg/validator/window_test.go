@@ -0,0 +1,79 @@
+package validator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	testCases := []struct {
+		name        string
+		spec        string
+		expected    string
+		expectError bool
+	}{
+		{name: "every minute", spec: "* * * * * 1m", expected: "* * * * * 1m"},
+		{name: "normalized spaces", spec: " 0  22 * * 1-5   4h ", expected: "0 22 * * 1-5 4h"},
+		{name: "lists and steps", spec: "0,30 */2 1-15/7 1,6 0,7 30m", expected: "0,30 */2 1-15/7 1,6 0,7 30m"},
+		{name: "missing duration", spec: "0 22 * * 1-5", expectError: true},
+		{name: "invalid duration", spec: "0 22 * * 1-5 4x", expectError: true},
+		{name: "zero duration", spec: "0 22 * * 1-5 0s", expectError: true},
+		{name: "duration longer than a week", spec: "0 22 * * 1-5 169h", expectError: true},
+		{name: "minute out of range", spec: "60 22 * * * 1h", expectError: true},
+		{name: "day of month out of range", spec: "0 22 0 * * 1h", expectError: true},
+		{name: "day of week out of range", spec: "0 22 * * 8 1h", expectError: true},
+		{name: "reversed range", spec: "0 22 * * 5-1 1h", expectError: true},
+		{name: "invalid step", spec: "*/0 22 * * * 1h", expectError: true},
+		{name: "invalid value", spec: "0 x * * * 1h", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := ParseMaintenanceWindow(tc.spec)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, w.String())
+		})
+	}
+}
+
+func TestMaintenanceWindowActive(t *testing.T) {
+	// 2026-03-06 is a Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 3, day, hour, minute, 30, 0, time.UTC)
+	}
+
+	testCases := []struct {
+		name     string
+		spec     string
+		t        time.Time
+		expected bool
+	}{
+		{name: "at the start", spec: "0 22 * * 1-5 4h", t: at(6, 22, 0), expected: true},
+		{name: "before the start", spec: "0 22 * * 1-5 4h", t: at(6, 21, 59), expected: false},
+		{name: "after midnight", spec: "0 22 * * 1-5 4h", t: at(7, 1, 59), expected: true},
+		{name: "at the end", spec: "0 22 * * 1-5 4h", t: at(7, 2, 0), expected: false},
+		{name: "not on the weekday", spec: "0 22 * * 1-5 4h", t: at(7, 22, 30), expected: false},
+		{name: "sunday as 7", spec: "0 2 * * 7 1h", t: at(8, 2, 15), expected: true},
+		{name: "hour step", spec: "0 */6 * * * 30m", t: at(6, 12, 10), expected: true},
+		{name: "outside hour step", spec: "0 */6 * * * 30m", t: at(6, 13, 10), expected: false},
+		{name: "day of month or day of week", spec: "0 3 1 * 5 1h", t: at(6, 3, 0), expected: true},
+		{name: "day of month", spec: "0 3 1 * 5 1h", t: at(1, 3, 0), expected: true},
+		{name: "neither day of month nor day of week", spec: "0 3 1 * 5 1h", t: at(7, 3, 0), expected: false},
+		{name: "other month", spec: "0 0 * 4 * 24h", t: at(6, 12, 0), expected: false},
+		{name: "week long window", spec: "0 0 * * 1 168h", t: at(8, 23, 59), expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := ParseMaintenanceWindow(tc.spec)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, w.Active(tc.t))
+		})
+	}
+}
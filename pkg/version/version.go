@@ -0,0 +1,52 @@
+// Package version holds the build information of the webhook. The variables
+// are set at build time with -ldflags "-X".
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+var (
+	// Version is the released version of the webhook.
+	Version = "dev"
+	// GitCommit is the git commit the webhook was built from.
+	GitCommit = "unknown"
+	// BuildDate is the date on which the webhook was built.
+	BuildDate = "unknown"
+)
+
+// Info is the build information which is served on the /version endpoint.
+type Info struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// String returns the build information in a single line.
+func String() string {
+	return fmt.Sprintf("version %s, git commit %s, build date %s, %s", Version, GitCommit, BuildDate, runtime.Version())
+}
+
+// UserAgent returns the User-Agent of the requests to the API server in the
+// format of client-go, so the API server audit logs and the API priority and
+// fairness metrics can attribute the requests to the webhook.
+func UserAgent(component string) string {
+	commit := GitCommit
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+
+	return fmt.Sprintf("%s/%s (%s/%s) %s", component, Version, runtime.GOOS, runtime.GOARCH, commit)
+}
@@ -0,0 +1,22 @@
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserAgent(t *testing.T) {
+	defer func(version string, gitCommit string) {
+		Version, GitCommit = version, gitCommit
+	}(Version, GitCommit)
+
+	Version = "v1.2.3"
+	GitCommit = "0123456789abcdef"
+	assert.Equal(t, fmt.Sprintf("webhook/v1.2.3 (%s/%s) 0123456", runtime.GOOS, runtime.GOARCH), UserAgent("webhook"))
+
+	GitCommit = "unknown"
+	assert.Equal(t, fmt.Sprintf("webhook/v1.2.3 (%s/%s) unknown", runtime.GOOS, runtime.GOARCH), UserAgent("webhook"))
+}
@@ -0,0 +1,287 @@
+//go:build integration
+
+// Package integration runs the webhook against a real kube-apiserver started
+// by envtest, so registration, TLS serving and AdmissionReview round-trips are
+// covered. Run it with:
+//
+//	KUBEBUILDER_ASSETS=$(setup-envtest use -p path) go test -tags integration ./test/integration/...
+package integration
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/admission"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/service"
+	"github.com/joeyloman/rancher-fip-manager-webhook/pkg/util"
+	rfmv2 "github.com/joeyloman/rancher-fip-manager/pkg/apis/rancher.k8s.binbash.org/v1beta2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+var (
+	poolGVR  = schema.GroupVersionResource{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingippools"}
+	quotaGVR = schema.GroupVersionResource{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingipprojectquotas"}
+	fipGVR   = schema.GroupVersionResource{Group: "rancher.k8s.binbash.org", Version: "v1beta2", Resource: "floatingips"}
+
+	client dynamic.Interface
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		fmt.Println("skipping integration tests, KUBEBUILDER_ASSETS is not set")
+		os.Exit(0)
+	}
+
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	crdDir, err := crdDirectory()
+	if err != nil {
+		log.Errorf("cannot find the CRDs: %s", err)
+		return 1
+	}
+
+	vwc, err := webhookConfiguration()
+	if err != nil {
+		log.Errorf("cannot build the webhook configuration: %s", err)
+		return 1
+	}
+
+	// envtest rewrites the service reference of the webhooks to its local
+	// serving address and injects its own CA in the caBundle
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths:     []string{crdDir},
+		ErrorIfCRDPathMissing: true,
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			ValidatingWebhooks: []*admregv1.ValidatingWebhookConfiguration{vwc},
+		},
+	}
+	cfg, err := testEnv.Start()
+	if err != nil {
+		log.Errorf("cannot start envtest: %s", err)
+		return 1
+	}
+	defer testEnv.Stop()
+
+	webhookOptions := testEnv.WebhookInstallOptions
+	certificate, err := tls.LoadX509KeyPair(
+		filepath.Join(webhookOptions.LocalServingCertDir, "tls.crt"),
+		filepath.Join(webhookOptions.LocalServingCertDir, "tls.key"),
+	)
+	if err != nil {
+		log.Errorf("cannot load the serving certificate: %s", err)
+		return 1
+	}
+
+	address := net.JoinHostPort(webhookOptions.LocalServingHost, strconv.Itoa(webhookOptions.LocalServingPort))
+	clients, err := util.NewClientsForConfig(cfg)
+	if err != nil {
+		log.Errorf("cannot create the clients: %s", err)
+		return 1
+	}
+	serviceHandler := service.Register(ctx, clients, service.Options{
+		Address: address,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &certificate, nil
+		},
+	})
+	go serviceHandler.Run()
+	defer serviceHandler.Stop()
+
+	if err := waitForServer(address, 10*time.Second); err != nil {
+		log.Errorf("%s", err)
+		return 1
+	}
+
+	client = clients.Dynamic
+
+	return m.Run()
+}
+
+// crdDirectory returns the CRDs which are shipped with the rancher-fip-manager module.
+func crdDirectory() (string, error) {
+	if dir := os.Getenv("CRD_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "github.com/joeyloman/rancher-fip-manager").Output()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(strings.TrimSpace(string(out)), "config", "crd"), nil
+}
+
+// webhookConfiguration returns the webhook configuration which the webhook
+// registers in the cluster. The caBundle is replaced by envtest.
+func webhookConfiguration() (*admregv1.ValidatingWebhookConfiguration, error) {
+	caFile := filepath.Join(os.TempDir(), "rancher-fip-manager-webhook-integration-ca.crt")
+	if err := os.WriteFile(caFile, []byte("replaced by envtest"), 0600); err != nil {
+		return nil, err
+	}
+
+	h := admission.Register(
+		context.Background(),
+		nil,
+		nil,
+		"rancher-fip-manager-webhook",
+		"rancher-fip-manager",
+		"rancher-fip-manager-validator",
+		admission.Options{
+			CABundle: admission.CABundleSource{Type: admission.CABundleSourceFile, File: caFile},
+		},
+	)
+
+	return h.ValidatingWebhookConfiguration()
+}
+
+func waitForServer(address string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true})
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("webhook server on %s did not start within %s", address, timeout)
+}
+
+func create(t *testing.T, gvr schema.GroupVersionResource, namespace string, obj runtime.Object) error {
+	t.Helper()
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	assert.NoError(t, err)
+
+	_, err = client.Resource(gvr).Namespace(namespace).Create(context.Background(), &unstructured.Unstructured{Object: content}, metav1.CreateOptions{})
+
+	return err
+}
+
+func TestFloatingIPPoolAdmission(t *testing.T) {
+	invalid := &rfmv2.FloatingIPPool{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPPool"},
+		ObjectMeta: metav1.ObjectMeta{Name: "invalid-pool"},
+		Spec: rfmv2.FloatingIPPoolSpec{
+			IPConfig: &rfmv2.IPConfig{
+				Family: "IPv4",
+				Subnet: "192.168.100.0/24",
+				Pool:   rfmv2.Pool{Start: "192.168.100.20", End: "192.168.100.10"},
+			},
+		},
+	}
+	err := create(t, poolGVR, "", invalid)
+	assert.ErrorContains(t, err, "start IP address 192.168.100.20 must be less than or equal to end IP address 192.168.100.10")
+}
+
+func TestFloatingIPProjectQuotaAdmission(t *testing.T) {
+	quota := &rfmv2.FloatingIPProjectQuota{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPProjectQuota"},
+		ObjectMeta: metav1.ObjectMeta{Name: "p-invalid"},
+		Spec: rfmv2.FloatingIPProjectQuotaSpec{
+			FloatingIPQuota: map[string]int{"unknown": -1},
+		},
+	}
+	err := create(t, quotaGVR, "", quota)
+	assert.ErrorContains(t, err, "quota for floatingippool unknown must not be negative: -1")
+}
+
+func TestFloatingIPAdmission(t *testing.T) {
+	pool := &rfmv2.FloatingIPPool{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPPool"},
+		ObjectMeta: metav1.ObjectMeta{Name: "public"},
+		Spec: rfmv2.FloatingIPPoolSpec{
+			IPConfig: &rfmv2.IPConfig{
+				Family: "IPv4",
+				Subnet: "192.168.100.0/24",
+				Pool: rfmv2.Pool{
+					Start:   "192.168.100.10",
+					End:     "192.168.100.20",
+					Exclude: []string{"192.168.100.15"},
+				},
+			},
+			TargetCluster:          "cluster",
+			TargetNetwork:          "network",
+			TargetNetworkInterface: "eth0",
+		},
+	}
+	assert.NoError(t, create(t, poolGVR, "", pool))
+
+	quota := &rfmv2.FloatingIPProjectQuota{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIPProjectQuota"},
+		ObjectMeta: metav1.ObjectMeta{Name: "p-12345"},
+		Spec: rfmv2.FloatingIPProjectQuotaSpec{
+			FloatingIPQuota: map[string]int{"public": 1},
+		},
+	}
+	assert.NoError(t, create(t, quotaGVR, "", quota))
+
+	floatingIP := func(name string, ipAddr string, labels map[string]string) *rfmv2.FloatingIP {
+		return &rfmv2.FloatingIP{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rancher.k8s.binbash.org/v1beta2", Kind: "FloatingIP"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+			Spec:       rfmv2.FloatingIPSpec{FloatingIPPool: "public", IPAddr: &ipAddr},
+		}
+	}
+	projectLabel := map[string]string{service.ProjectNameLabel: "p-12345"}
+
+	testCases := []struct {
+		name          string
+		fip           *rfmv2.FloatingIP
+		expectedError string
+	}{
+		{
+			name:          "excluded ip",
+			fip:           floatingIP("excluded", "192.168.100.15", projectLabel),
+			expectedError: "requested IP 192.168.100.15 is in the exclude list",
+		},
+		{
+			name:          "ip outside the pool",
+			fip:           floatingIP("outside", "192.168.100.30", projectLabel),
+			expectedError: "requested IP 192.168.100.30 is not in the pool range [192.168.100.10, 192.168.100.20]",
+		},
+		{
+			name:          "missing project label",
+			fip:           floatingIP("unlabeled", "192.168.100.11", nil),
+			expectedError: "FloatingIP must carry the rancher.k8s.binbash.org/project-name label",
+		},
+		{
+			name: "valid floatingip",
+			fip:  floatingIP("valid", "192.168.100.11", projectLabel),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := create(t, fipGVR, "default", tc.fip)
+			if tc.expectedError != "" {
+				assert.ErrorContains(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}